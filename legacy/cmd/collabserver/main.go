@@ -0,0 +1,98 @@
+// Command collabserver serves the editor's collaboration rooms over
+// WebSocket. It replaces server/server.js and speaks the same protocol,
+// so the React client connects to it unchanged:
+//
+//	go run ./cmd/collabserver -addr :1234 -data /var/lib/rule-rooms
+//
+// Without -data rooms live in memory only, as with the Node server. Unless
+// -validate=false, rooms are validated as they are edited and the
+// diagnostics published in their metadata map.
+//
+// With -drafts, the graph of a room whose metadata map names its question
+// (tenantId and questionId) is also saved as the question's draft, in
+// question rule documents kept under that directory:
+//
+//	go run ./cmd/collabserver -data /var/lib/rule-rooms -drafts /var/lib/rule-drafts
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"bitbucket.org/convin/go_services/rule_engine/internal/collab"
+	"bitbucket.org/convin/go_services/rule_engine/internal/lifecycle"
+	"go.uber.org/zap"
+)
+
+func main() {
+	addr := flag.String("addr", ":"+envOr("PORT", "1234"), "address to listen on")
+	data := flag.String("data", "", "directory to persist rooms in")
+	validate := flag.Bool("validate", true, "validate rooms as they are edited")
+	drafts := flag.String("drafts", "", "directory to save room graphs in as question drafts")
+	flag.Parse()
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		panic(err)
+	}
+	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
+
+	var hooks collab.Hooks
+	if *data != "" {
+		store, err := collab.NewFileStore(*data)
+		if err != nil {
+			zap.L().Fatal("opening room store", zap.Error(err))
+		}
+		hooks = store.Hooks()
+	}
+	if *drafts != "" && !*validate {
+		zap.L().Fatal("-drafts needs -validate")
+	}
+	if *validate {
+		// Without a lifecycle.Service the pipeline only publishes
+		// diagnostics.
+		var service *lifecycle.Service
+		if *drafts != "" {
+			repo, err := lifecycle.NewFileRepository(*drafts)
+			if err != nil {
+				zap.L().Fatal("opening draft store", zap.Error(err))
+			}
+			service = lifecycle.NewService(repo, nil)
+		} else {
+			zap.L().Info("rooms are validated but drafts are not saved")
+		}
+		hooks = collab.NewPipeline(service).Hooks(hooks)
+	}
+	server := &http.Server{
+		Addr:    *addr,
+		Handler: collab.NewServer(hooks),
+	}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		// Shutdown does not wait for hijacked WebSocket connections;
+		// clients reconnect to the next instance.
+		server.Shutdown(ctx)
+	}()
+
+	zap.L().Info("collaboration server listening", zap.String("addr", *addr))
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		zap.L().Fatal("collaboration server", zap.Error(err))
+	}
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,35 @@
+// Command graphschema writes the JSON Schema of the React Flow graph
+// payload. The client build runs it to check the graphs it produces against
+// the same contract the Go services decode:
+//
+//	go run ./cmd/graphschema -o ../client/src/graph.schema.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"bitbucket.org/convin/go_services/rule_engine/internal/schema"
+)
+
+func main() {
+	output := flag.String("o", "", "write the schema to this file instead of stdout")
+	flag.Parse()
+
+	data, err := json.MarshalIndent(schema.Generate(), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "graphschema:", err)
+		os.Exit(1)
+	}
+	data = append(data, '\n')
+	if *output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, "graphschema:", err)
+		os.Exit(1)
+	}
+}
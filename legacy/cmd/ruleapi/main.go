@@ -0,0 +1,69 @@
+// Command ruleapi serves the converter, the validator and rule metadata
+// over HTTP (see package httpapi). Moments are read from DynamoDB with the
+// app config, or, for local runs, from a JSON file of moments per tenant:
+//
+//	go run ./cmd/ruleapi -addr :8080 -moments moments.json
+//
+// where moments.json looks like {"tenant": [{"id": "...", "moment_id": "..."}]}
+// (see reactflow.LoadMomentStore).
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"bitbucket.org/convin/go_services/rule_engine/internal/httpapi"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+	"go.uber.org/zap"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	momentsFile := flag.String("moments", "", "serve moments from this JSON file instead of DynamoDB")
+	maxBody := flag.Int64("max-body", httpapi.DefaultMaxBodyBytes, "largest request body in bytes")
+	timeout := flag.Duration("timeout", httpapi.DefaultTimeout, "time limit of a request")
+	flag.Parse()
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		panic(err)
+	}
+	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
+
+	var moments reactflow.MomentStore = reactflow.DynamoMomentStore()
+	if *momentsFile != "" {
+		store, err := reactflow.LoadMomentStore(*momentsFile)
+		if err != nil {
+			zap.L().Fatal("loading moments", zap.Error(err))
+		}
+		moments = store
+	}
+
+	// The handlers' timeout starts once the body is read, so ReadTimeout
+	// bounds reading it.
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           httpapi.NewServer(moments, httpapi.Options{MaxBodyBytes: *maxBody, Timeout: *timeout}),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       *timeout,
+	}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout+time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	zap.L().Info("rule API listening", zap.String("addr", *addr))
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		zap.L().Fatal("rule API", zap.Error(err))
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/lifecycle"
+	"bitbucket.org/convin/go_services/rule_engine/internal/ruleexpr"
+	"bitbucket.org/convin/go_services/rule_engine/internal/ruleyaml"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// input is a rule file: a question rule document such as
+// tests/example_small.json, a graph, a rule chain, a list of graphs or
+// rule chains, a graph in the YAML of package ruleyaml, or rules in the
+// expression language of package ruleexpr in a .rules file.
+type input struct {
+	name     string
+	document *reactFlowTypes.QuestionRule
+	graphs   []reactFlowTypes.Graph
+	chains   []types.RuleChain
+	tenantID string
+	// list is set when the file is a JSON list.
+	list bool
+}
+
+// load reads a rule file, or stdin when path is "-".
+func load(path string) (*input, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, ".rules") {
+		graph, err := ruleexpr.Compile(string(data), ruleexpr.Options{})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return &input{name: path, graphs: []reactFlowTypes.Graph{graph}}, nil
+	}
+	in, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	in.name = path
+	return in, nil
+}
+
+// parse tells the shapes of rule files apart by their keys. Files that
+// are not JSON are read as YAML graphs.
+func parse(data []byte) (*input, error) {
+	if !json.Valid(data) {
+		graph, err := ruleyaml.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		return &input{graphs: []reactFlowTypes.Graph{graph}}, nil
+	}
+	var list []map[string]json.RawMessage
+	if json.Unmarshal(data, &list) == nil {
+		in := &input{list: true}
+		if len(list) == 0 {
+			return in, nil
+		}
+		if isChain(list[0]) {
+			return in, json.Unmarshal(data, &in.chains)
+		}
+		return in, json.Unmarshal(data, &in.graphs)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("decoding rule file: %w", err)
+	}
+	switch {
+	case isDocument(fields):
+		var document reactFlowTypes.QuestionRule
+		if err := json.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf("decoding question rule: %w", err)
+		}
+		return &input{document: &document, tenantID: document.TenantID}, nil
+	case isChain(fields):
+		var chain types.RuleChain
+		if err := json.Unmarshal(data, &chain); err != nil {
+			return nil, fmt.Errorf("decoding rule chain: %w", err)
+		}
+		return &input{chains: []types.RuleChain{chain}, tenantID: chain.RuleChain.TenantID}, nil
+	case fields["nodes"] != nil:
+		var graph reactFlowTypes.Graph
+		if err := json.Unmarshal(data, &graph); err != nil {
+			return nil, fmt.Errorf("decoding graph: %w", err)
+		}
+		return &input{graphs: []reactFlowTypes.Graph{graph}}, nil
+	}
+	return nil, fmt.Errorf("not a question rule, graph or rule chain")
+}
+
+func isDocument(fields map[string]json.RawMessage) bool {
+	for _, key := range []string{"config", "draft_config", "internal_config", "question_id"} {
+		if _, ok := fields[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isChain(fields map[string]json.RawMessage) bool {
+	_, chain := fields["ruleChain"]
+	_, metadata := fields["metadata"]
+	_, nodes := fields["nodes"]
+	return chain || (metadata && !nodes)
+}
+
+// source selects what of a rule file a subcommand works on.
+type source struct {
+	// draft picks the draft_config of documents instead of their config.
+	draft bool
+	// tenantID overrides the tenant of the file.
+	tenantID string
+}
+
+func (s source) tenant(in *input) string {
+	if s.tenantID != "" {
+		return s.tenantID
+	}
+	return in.tenantID
+}
+
+// graphs returns the graphs of a rule file.
+func (s source) graphs(in *input) ([]reactFlowTypes.Graph, error) {
+	if in.document != nil {
+		if s.draft {
+			return in.document.DraftConfig, nil
+		}
+		return in.document.Config, nil
+	}
+	if in.chains != nil {
+		return nil, fmt.Errorf("%s holds rule chains, not graphs", in.name)
+	}
+	return in.graphs, nil
+}
+
+// chains returns the rule chains of a rule file. A document answers with
+// the internal_config it was published with, or with its draft converted
+// when draft is set; graphs are converted.
+func (s source) chains(in *input) ([]types.RuleChain, error) {
+	if in.chains != nil {
+		return in.chains, nil
+	}
+	if in.document != nil && !s.draft && len(in.document.InternalConfig) > 0 {
+		return in.document.InternalConfig, nil
+	}
+	graphs, err := s.graphs(in)
+	if err != nil {
+		return nil, err
+	}
+	return lifecycle.ConvertGraphs(graphs, s.tenant(in))
+}
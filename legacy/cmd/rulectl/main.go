@@ -0,0 +1,913 @@
+// Command rulectl works with rule files from the command line: question
+// rule documents shaped like tests/example_small.json, React Flow graphs and
+// converted rule chains, read from a file or from stdin ("-" or no file).
+// Graphs may also be written in the YAML of package ruleyaml, or as rules
+// in the expression language of package ruleexpr in a .rules file.
+//
+//	rulectl convert  [-draft] [-tenant t] [file]       rule chain JSON
+//	rulectl validate [-draft] [-tenant t] [-json] [file]
+//	rulectl lint     [-draft] [-config lint.json] [-json] [file]
+//	rulectl diff     [-draft] [-chains] [-layout] [-json] a b
+//	rulectl render   [-draft] [-format text|dot|mermaid] [-graph] [file]
+//	rulectl metadata [-draft] [-moments m.json] [-parameter id] [file]
+//	rulectl eval     -facts facts.json [-draft] [-tz zone] [file]
+//	rulectl yaml     [-draft] [file]                   graphs as YAML
+//	rulectl layout   [-draft] [-force] [file]          graphs with positions
+//	rulectl normalize [-w] [-tenant t] file...         legacy node shapes
+//	rulectl migrate  [-to version] [-w] [-json] file...  schema versions
+//	rulectl simplify [-draft] [-tenant t] [-verify] [-facts f.json] [-n count] [file]
+//
+// A document stands for its config, or its draft_config with -draft; render,
+// metadata and eval use its internal_config unless -draft is given. layout
+// only places graphs without positions unless -force is given. normalize
+// lists the rewrites that bring JSON rule files to the canonical node shape
+// of reactflow.NormalizeGraph, and makes them with -w, in bulk; it also
+// reports graphs that would convert differently once normalized. migrate
+// reports what moving documents to a schema version, the latest by
+// default, would do, and does it with -w. lint reports the findings of
+// package lint, adjusted by a lint.Config file, and fails only on those
+// the config makes errors. simplify prints the rule chains with the
+// redundancy of package simplify folded away and lists the changes on
+// stderr; -verify checks each against its original with the evaluator,
+// over generated facts and those of the -facts file. The moments file of
+// metadata has the shape ruleapi reads (see reactflow.LoadMomentStore);
+// without one moments are looked up in DynamoDB. The facts file is an
+// evaluator.Facts.
+//
+// rulectl exits with 0 on success, 1 when the outcome is negative (a graph
+// is invalid or has lint errors, files differ, no rule chain matched,
+// files need normalizing, documents cannot be migrated, a simplified chain
+// answers differently) and 2 on any error.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/diff"
+	"bitbucket.org/convin/go_services/rule_engine/internal/evaluator"
+	"bitbucket.org/convin/go_services/rule_engine/internal/layout"
+	"bitbucket.org/convin/go_services/rule_engine/internal/lint"
+	"bitbucket.org/convin/go_services/rule_engine/internal/migrate"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+	"bitbucket.org/convin/go_services/rule_engine/internal/render"
+	"bitbucket.org/convin/go_services/rule_engine/internal/ruleyaml"
+	"bitbucket.org/convin/go_services/rule_engine/internal/simplify"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+	"go.uber.org/zap"
+)
+
+const (
+	exitOK       = 0
+	exitNegative = 1
+	exitError    = 2
+)
+
+type command struct {
+	run     func(args []string) (int, error)
+	summary string
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"convert":   {convertCommand, "convert graphs to rule chain JSON"},
+		"validate":  {validateCommand, "check graphs and print their diagnostics"},
+		"lint":      {lintCommand, "warn about rules that likely do not do what was meant"},
+		"diff":      {diffCommand, "compare two rule files"},
+		"render":    {renderCommand, "draw rule chains as text"},
+		"metadata":  {metadataCommand, "compute the dependencies of rule chains"},
+		"eval":      {evalCommand, "evaluate rule chains against call facts"},
+		"yaml":      {yamlCommand, "write graphs as YAML"},
+		"layout":    {layoutCommand, "lay out graphs without positions"},
+		"normalize": {normalizeCommand, "rewrite legacy node shapes into the canonical one"},
+		"migrate":   {migrateCommand, "move documents between schema versions"},
+		"simplify":  {simplifyCommand, "fold redundant nodes out of rule chains"},
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: rulectl <command> [flags] [file]")
+	for _, name := range []string{"convert", "validate", "lint", "diff", "render", "metadata", "eval", "yaml", "layout", "normalize", "migrate", "simplify"} {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", name, commands[name].summary)
+	}
+}
+
+func main() {
+	// Library code logs through zap; keep it off the output.
+	zap.ReplaceGlobals(zap.NewNop())
+	os.Exit(run(os.Args[1:]))
+}
+
+// run runs the command named by args[0] and returns the exit code.
+func run(args []string) int {
+	if len(args) < 1 {
+		usage()
+		return exitError
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "rulectl: unknown command %q\n", args[0])
+		usage()
+		return exitError
+	}
+	code, err := cmd.run(args[1:])
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "rulectl %s: %v\n", args[0], err)
+		}
+		return exitError
+	}
+	return code
+}
+
+// flags returns the flag set of a subcommand with the flags selecting the
+// source of the rules.
+func flags(name string, src *source) *flag.FlagSet {
+	fs := flag.NewFlagSet("rulectl "+name, flag.ContinueOnError)
+	fs.BoolVar(&src.draft, "draft", false, "use the draft_config of documents")
+	fs.StringVar(&src.tenantID, "tenant", "", "tenant to convert for, instead of the one in the file")
+	return fs
+}
+
+// file returns the single file argument, stdin by default.
+func file(fs *flag.FlagSet) (string, error) {
+	switch fs.NArg() {
+	case 0:
+		return "-", nil
+	case 1:
+		return fs.Arg(0), nil
+	}
+	return "", fmt.Errorf("expected one file, got %d", fs.NArg())
+}
+
+func loadArg(fs *flag.FlagSet) (*input, error) {
+	path, err := file(fs)
+	if err != nil {
+		return nil, err
+	}
+	return load(path)
+}
+
+func writeJSON(w io.Writer, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func convertCommand(args []string) (int, error) {
+	var src source
+	fs := flags("convert", &src)
+	if err := fs.Parse(args); err != nil {
+		return exitError, err
+	}
+	in, err := loadArg(fs)
+	if err != nil {
+		return exitError, err
+	}
+	graphs, err := src.graphs(in)
+	if err != nil {
+		return exitError, err
+	}
+	tenantID := src.tenant(in)
+	chains := make([]types.RuleChain, 0, len(graphs))
+	code := exitOK
+	for i, graph := range graphs {
+		diagnostics := reactflow.ValidateGraph(graph, tenantID)
+		printDiagnostics(os.Stderr, i, len(graphs), diagnostics)
+		if reactflow.HasErrors(diagnostics) {
+			code = exitNegative
+			continue
+		}
+		chain, err := reactflow.ConvertFlowToRuleEngineDSL(graph, tenantID)
+		if err == nil {
+			err = reactflow.CompileChainComparisons(&chain)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "graph %d: %v\n", i, err)
+			code = exitNegative
+			continue
+		}
+		chains = append(chains, chain)
+	}
+	if code != exitOK {
+		return code, nil
+	}
+	// A single graph converts to a rule chain, several to a list of them.
+	if len(chains) == 1 {
+		return exitOK, writeJSON(os.Stdout, chains[0])
+	}
+	return exitOK, writeJSON(os.Stdout, chains)
+}
+
+func printDiagnostics(w io.Writer, graph, graphs int, diagnostics []reactflow.Diagnostic) {
+	for _, diagnostic := range diagnostics {
+		location := diagnostic.NodeID
+		if location == "" {
+			location = diagnostic.Path
+		}
+		if graphs > 1 {
+			location = fmt.Sprintf("graph %d %s", graph, location)
+		}
+		if location != "" {
+			location += ": "
+		}
+		severity := diagnostic.Severity
+		if diagnostic.Code != "" {
+			severity += " " + diagnostic.Code
+		}
+		fmt.Fprintf(w, "%s: %s%s\n", severity, location, diagnostic.Message)
+	}
+}
+
+func validateCommand(args []string) (int, error) {
+	var src source
+	fs := flags("validate", &src)
+	asJSON := fs.Bool("json", false, "print the diagnostics of each graph as JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitError, err
+	}
+	in, err := loadArg(fs)
+	if err != nil {
+		return exitError, err
+	}
+	graphs, err := src.graphs(in)
+	if err != nil {
+		return exitError, err
+	}
+	code := exitOK
+	all := make([][]reactflow.Diagnostic, 0, len(graphs))
+	for i, graph := range graphs {
+		diagnostics := reactflow.ValidateGraph(graph, src.tenant(in))
+		if diagnostics == nil {
+			diagnostics = []reactflow.Diagnostic{}
+		}
+		if reactflow.HasErrors(diagnostics) {
+			code = exitNegative
+		}
+		all = append(all, diagnostics)
+		if !*asJSON {
+			printDiagnostics(os.Stdout, i, len(graphs), diagnostics)
+		}
+	}
+	if *asJSON {
+		return code, writeJSON(os.Stdout, all)
+	}
+	if code == exitOK {
+		fmt.Printf("%d graphs valid\n", len(graphs))
+	}
+	return code, nil
+}
+
+func lintCommand(args []string) (int, error) {
+	var src source
+	fs := flags("lint", &src)
+	configFile := fs.String("config", "", "JSON lint.Config adjusting the severity of rules")
+	asJSON := fs.Bool("json", false, "print the findings of each graph as JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitError, err
+	}
+	var config lint.Config
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return exitError, err
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return exitError, fmt.Errorf("%s: %w", *configFile, err)
+		}
+		if err := config.Validate(); err != nil {
+			return exitError, fmt.Errorf("%s: %w", *configFile, err)
+		}
+	}
+	in, err := loadArg(fs)
+	if err != nil {
+		return exitError, err
+	}
+
+	var all [][]reactflow.Diagnostic
+	if in.chains != nil {
+		for _, chain := range in.chains {
+			all = append(all, lint.Chain(chain, config))
+		}
+	} else {
+		graphs, err := src.graphs(in)
+		if err != nil {
+			return exitError, err
+		}
+		for _, graph := range graphs {
+			all = append(all, lint.Graph(graph, src.tenant(in), config))
+		}
+	}
+
+	code := exitOK
+	findings := 0
+	for i, diagnostics := range all {
+		if diagnostics == nil {
+			all[i] = []reactflow.Diagnostic{}
+		}
+		if reactflow.HasErrors(diagnostics) {
+			code = exitNegative
+		}
+		findings += len(diagnostics)
+		if !*asJSON {
+			printDiagnostics(os.Stdout, i, len(all), diagnostics)
+		}
+	}
+	if *asJSON {
+		return code, writeJSON(os.Stdout, all)
+	}
+	if findings == 0 {
+		fmt.Printf("no findings in %d graphs\n", len(all))
+	}
+	return code, nil
+}
+
+func diffCommand(args []string) (int, error) {
+	var src source
+	fs := flags("diff", &src)
+	chains := fs.Bool("chains", false, "compare the rule chains instead of the graphs")
+	layout := fs.Bool("layout", false, "report position and size changes of graphs")
+	asJSON := fs.Bool("json", false, "print the differences as JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitError, err
+	}
+	if fs.NArg() != 2 {
+		return exitError, fmt.Errorf("expected two files, got %d", fs.NArg())
+	}
+	a, err := load(fs.Arg(0))
+	if err != nil {
+		return exitError, err
+	}
+	b, err := load(fs.Arg(1))
+	if err != nil {
+		return exitError, err
+	}
+	// Files holding rule chains can only be compared as rule chains.
+	if a.chains != nil || b.chains != nil {
+		*chains = true
+	}
+
+	var diffs []comparison
+	var m, n int
+	if *chains {
+		x, err := src.chains(a)
+		if err != nil {
+			return exitError, err
+		}
+		y, err := src.chains(b)
+		if err != nil {
+			return exitError, err
+		}
+		m, n = len(x), len(y)
+		for i := 0; i < m && i < n; i++ {
+			diffs = append(diffs, diff.RuleChains(x[i], y[i]))
+		}
+	} else {
+		x, err := src.graphs(a)
+		if err != nil {
+			return exitError, err
+		}
+		y, err := src.graphs(b)
+		if err != nil {
+			return exitError, err
+		}
+		m, n = len(x), len(y)
+		for i := 0; i < m && i < n; i++ {
+			diffs = append(diffs, diff.Graphs(x[i], y[i], diff.GraphOptions{IncludeLayout: *layout}))
+		}
+	}
+
+	code := exitOK
+	if m != n {
+		code = exitNegative
+	}
+	for _, d := range diffs {
+		if !d.Empty() {
+			code = exitNegative
+		}
+	}
+	if *asJSON {
+		return code, writeJSON(os.Stdout, diffs)
+	}
+	// Rules are paired by index; extra ones are only counted.
+	if m != n {
+		fmt.Printf("first file has %d rules, second has %d\n", m, n)
+	}
+	for i, d := range diffs {
+		if d.Empty() {
+			continue
+		}
+		if len(diffs) > 1 {
+			fmt.Printf("rule %d: ", i)
+		}
+		fmt.Print(d.Summary())
+	}
+	return code, nil
+}
+
+// comparison is a GraphDiff or a ChainDiff.
+type comparison interface {
+	Empty() bool
+	Summary() string
+}
+
+func renderCommand(args []string) (int, error) {
+	var src source
+	fs := flags("render", &src)
+	format := fs.String("format", "text", "output format: text, dot or mermaid")
+	graphs := fs.Bool("graph", false, "draw the graphs rather than their rule chains (dot and mermaid only)")
+	if err := fs.Parse(args); err != nil {
+		return exitError, err
+	}
+	if *graphs && *format == "text" {
+		return exitError, fmt.Errorf("graphs cannot be rendered as text")
+	}
+	var draw func(chain types.RuleChain) string
+	var drawGraph func(graph reactFlowTypes.Graph) string
+	switch *format {
+	case "text":
+		draw = render.Text
+	case "dot":
+		draw, drawGraph = render.RuleChainDOT, render.GraphDOT
+	case "mermaid":
+		draw, drawGraph = render.RuleChainMermaid, render.GraphMermaid
+	default:
+		return exitError, fmt.Errorf("unknown format %q", *format)
+	}
+	in, err := loadArg(fs)
+	if err != nil {
+		return exitError, err
+	}
+
+	var drawings []string
+	if *graphs {
+		list, err := src.graphs(in)
+		if err != nil {
+			return exitError, err
+		}
+		for _, graph := range list {
+			drawings = append(drawings, drawGraph(graph))
+		}
+	} else {
+		chains, err := src.chains(in)
+		if err != nil {
+			return exitError, err
+		}
+		for _, chain := range chains {
+			drawings = append(drawings, draw(chain))
+		}
+	}
+	for i, drawing := range drawings {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Print(drawing)
+	}
+	return exitOK, nil
+}
+
+func metadataCommand(args []string) (int, error) {
+	var src source
+	fs := flags("metadata", &src)
+	momentsFile := fs.String("moments", "", "look moments up in this JSON file instead of DynamoDB")
+	parameterID := fs.Int("parameter", 0, "parameter the rules score; the question_id of documents by default")
+	if err := fs.Parse(args); err != nil {
+		return exitError, err
+	}
+	in, err := loadArg(fs)
+	if err != nil {
+		return exitError, err
+	}
+	chains, err := src.chains(in)
+	if err != nil {
+		return exitError, err
+	}
+	var moments reactflow.MomentStore = reactflow.DynamoMomentStore()
+	if *momentsFile != "" {
+		store, err := reactflow.LoadMomentStore(*momentsFile)
+		if err != nil {
+			return exitError, err
+		}
+		moments = store
+	}
+	parameter := int32(*parameterID)
+	if parameter == 0 && in.document != nil {
+		parameter = in.document.QuestionID
+	}
+	tenantID := src.tenant(in)
+	if tenantID == "" {
+		return exitError, fmt.Errorf("the file names no tenant; pass -tenant")
+	}
+	metadata, err := reactflow.RuleMetadata(context.Background(), moments, chains, parameter, tenantID)
+	if err != nil {
+		return exitError, err
+	}
+	return exitOK, writeJSON(os.Stdout, metadata)
+}
+
+func evalCommand(args []string) (int, error) {
+	var src source
+	fs := flags("eval", &src)
+	factsFile := fs.String("facts", "", "JSON file of the call facts (required)")
+	zone := fs.String("tz", "UTC", "time zone relative dates are counted in")
+	if err := fs.Parse(args); err != nil {
+		return exitError, err
+	}
+	if *factsFile == "" {
+		return exitError, fmt.Errorf("-facts is required")
+	}
+	location, err := time.LoadLocation(*zone)
+	if err != nil {
+		return exitError, err
+	}
+	data, err := os.ReadFile(*factsFile)
+	if err != nil {
+		return exitError, err
+	}
+	var facts evaluator.Facts
+	if err := json.Unmarshal(data, &facts); err != nil {
+		return exitError, fmt.Errorf("%s: %w", *factsFile, err)
+	}
+	in, err := loadArg(fs)
+	if err != nil {
+		return exitError, err
+	}
+	chains, err := src.chains(in)
+	if err != nil {
+		return exitError, err
+	}
+
+	opts := evaluator.Options{Locations: &reactflow.TenantLocations{Default: location}}
+	results := make([]*evaluator.Result, 0, len(chains))
+	code := exitNegative
+	for i, chain := range chains {
+		result, err := evaluator.Evaluate(chain, facts, opts)
+		if err != nil {
+			return exitError, fmt.Errorf("rule %d: %w", i, err)
+		}
+		if result.Matched {
+			code = exitOK
+		}
+		results = append(results, result)
+	}
+	if len(results) == 1 {
+		return code, writeJSON(os.Stdout, results[0])
+	}
+	return code, writeJSON(os.Stdout, results)
+}
+
+func yamlCommand(args []string) (int, error) {
+	var src source
+	fs := flags("yaml", &src)
+	if err := fs.Parse(args); err != nil {
+		return exitError, err
+	}
+	in, err := loadArg(fs)
+	if err != nil {
+		return exitError, err
+	}
+	graphs, err := src.graphs(in)
+	if err != nil {
+		return exitError, err
+	}
+	// Several graphs are written as a stream of YAML documents.
+	for i, graph := range graphs {
+		data, err := ruleyaml.Marshal(graph)
+		if err != nil {
+			return exitError, fmt.Errorf("graph %d: %w", i, err)
+		}
+		if i > 0 {
+			fmt.Println("---")
+		}
+		os.Stdout.Write(data)
+	}
+	return exitOK, nil
+}
+
+func layoutCommand(args []string) (int, error) {
+	var src source
+	fs := flags("layout", &src)
+	force := fs.Bool("force", false, "lay out graphs that already have positions")
+	if err := fs.Parse(args); err != nil {
+		return exitError, err
+	}
+	in, err := loadArg(fs)
+	if err != nil {
+		return exitError, err
+	}
+	graphs, err := src.graphs(in)
+	if err != nil {
+		return exitError, err
+	}
+	for i, graph := range graphs {
+		if *force {
+			graphs[i] = layout.Apply(graph, layout.DefaultOptions)
+		} else {
+			graphs[i] = layout.Fill(graph, layout.DefaultOptions)
+		}
+	}
+	if len(graphs) == 1 {
+		return exitOK, writeJSON(os.Stdout, graphs[0])
+	}
+	return exitOK, writeJSON(os.Stdout, graphs)
+}
+
+func normalizeCommand(args []string) (int, error) {
+	fs := flag.NewFlagSet("rulectl normalize", flag.ContinueOnError)
+	write := fs.Bool("w", false, "write the normalized files in place")
+	tenantID := fs.String("tenant", "", "tenant to convert for, instead of the one in the file")
+	if err := fs.Parse(args); err != nil {
+		return exitError, err
+	}
+	if fs.NArg() == 0 {
+		return exitError, fmt.Errorf("expected files to normalize")
+	}
+	code := exitOK
+	for _, path := range fs.Args() {
+		result, err := normalizeFile(path, *tenantID)
+		if err != nil {
+			return exitError, err
+		}
+		for _, rewrite := range result.rewrites {
+			fmt.Printf("%s: %s\n", path, rewrite)
+		}
+		for _, change := range result.changes {
+			fmt.Printf("%s: %s\n", path, change)
+		}
+		if len(result.rewrites) == 0 {
+			continue
+		}
+		if !*write {
+			code = exitNegative
+			continue
+		}
+		if err := os.WriteFile(path, result.data, 0o644); err != nil {
+			return exitError, err
+		}
+	}
+	return code, nil
+}
+
+// normalized is a rule file brought to the canonical node shape.
+type normalized struct {
+	rewrites []reactflow.Rewrite
+	// changes notes the graphs that convert differently once normalized.
+	changes []string
+	data    []byte
+}
+
+// normalizeFile normalizes the graphs of a JSON rule file. Documents keep
+// every other field as it was.
+func normalizeFile(path, tenantID string) (*normalized, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("%s: only JSON files can be normalized", path)
+	}
+	in, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if in.chains != nil {
+		return nil, fmt.Errorf("%s holds rule chains, not graphs", path)
+	}
+	if tenantID == "" {
+		tenantID = in.tenantID
+	}
+
+	result := &normalized{}
+	if rule := in.document; rule != nil {
+		config := append([]reactFlowTypes.Graph(nil), rule.Config...)
+		draft := append([]reactFlowTypes.Graph(nil), rule.DraftConfig...)
+		result.rewrites = reactflow.NormalizeDocument(rule)
+		result.changes = append(conversionChanges("config[%d]", config, rule.Config, tenantID),
+			conversionChanges("draft_config[%d]", draft, rule.DraftConfig, tenantID)...)
+		// Only the graphs are replaced, so fields the document type does
+		// not model survive.
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		for key, graphs := range map[string][]reactFlowTypes.Graph{"config": rule.Config, "draft_config": rule.DraftConfig} {
+			if _, ok := fields[key]; !ok {
+				continue
+			}
+			if fields[key], err = json.Marshal(graphs); err != nil {
+				return nil, err
+			}
+		}
+		result.data, err = indentJSON(fields)
+		return result, err
+	}
+
+	format := "graph"
+	if in.list {
+		format = "[%d]"
+	}
+	graphs := make([]reactFlowTypes.Graph, len(in.graphs))
+	for i, graph := range in.graphs {
+		var rewrites []reactflow.Rewrite
+		graphs[i], rewrites = reactflow.NormalizeGraph(graph)
+		if in.list {
+			for j := range rewrites {
+				rewrites[j].Path = fmt.Sprintf("[%d].%s", i, rewrites[j].Path)
+			}
+		}
+		result.rewrites = append(result.rewrites, rewrites...)
+	}
+	result.changes = conversionChanges(format, in.graphs, graphs, tenantID)
+	if in.list {
+		result.data, err = indentJSON(graphs)
+	} else {
+		result.data, err = indentJSON(graphs[0])
+	}
+	return result, err
+}
+
+// conversionChanges describes the graphs that convert to a different rule
+// chain once normalized, naming each with format and its index. Graphs
+// that do not convert are left to validate.
+func conversionChanges(format string, before, after []reactFlowTypes.Graph, tenantID string) []string {
+	var changes []string
+	for i := range before {
+		a, err := reactflow.ConvertFlowToRuleEngineDSL(before[i], tenantID)
+		if err != nil {
+			continue
+		}
+		b, err := reactflow.ConvertFlowToRuleEngineDSL(after[i], tenantID)
+		if err != nil {
+			continue
+		}
+		if d := diff.RuleChains(withoutCopies(a), withoutCopies(b)); !d.Empty() {
+			name := format
+			if strings.Contains(format, "%d") {
+				name = fmt.Sprintf(format, i)
+			}
+			changes = append(changes, name+" converts differently once normalized:\n"+strings.TrimSuffix(d.Summary(), "\n"))
+		}
+	}
+	return changes
+}
+
+// withoutCopies drops the blocks and group members that containers carry
+// in their configuration as the graph held them. Rule chains run from
+// NodeIdList and the member nodes, so the copies do not change behaviour.
+func withoutCopies(chain types.RuleChain) types.RuleChain {
+	nodes := make([]*types.RuleNode, len(chain.Metadata.Nodes))
+	for i, node := range chain.Metadata.Nodes {
+		if node == nil {
+			continue
+		}
+		copied := *node
+		copied.Configuration = make(types.Configuration, len(node.Configuration))
+		for key, value := range node.Configuration {
+			if key != "blocks" && key != "nodes" {
+				copied.Configuration[key] = value
+			}
+		}
+		nodes[i] = &copied
+	}
+	chain.Metadata.Nodes = nodes
+	return chain
+}
+
+func indentJSON(value interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := writeJSON(&b, value); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func migrateCommand(args []string) (int, error) {
+	fs := flag.NewFlagSet("rulectl migrate", flag.ContinueOnError)
+	target := fs.Int("to", migrate.Default.Latest(), "schema version to migrate to")
+	write := fs.Bool("w", false, "write the migrated documents in place")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return exitError, err
+	}
+	if fs.NArg() == 0 {
+		return exitError, fmt.Errorf("expected documents to migrate")
+	}
+	var corpus []migrate.Entry
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return exitError, err
+		}
+		corpus = append(corpus, migrate.Entry{Name: path, Data: data})
+	}
+
+	report := migrate.Default.DryRun(corpus, *target)
+	if *asJSON {
+		if err := writeJSON(os.Stdout, report); err != nil {
+			return exitError, err
+		}
+	} else {
+		fmt.Print(report.Summary())
+	}
+	if *write {
+		for _, result := range report.Results {
+			if result.Error != "" || len(result.Steps) == 0 {
+				continue
+			}
+			var b bytes.Buffer
+			if err := json.Indent(&b, result.Migrated, "", "  "); err != nil {
+				return exitError, err
+			}
+			b.WriteByte('\n')
+			if err := os.WriteFile(result.Name, b.Bytes(), 0o644); err != nil {
+				return exitError, err
+			}
+		}
+	}
+	if report.Failed() > 0 {
+		return exitNegative, nil
+	}
+	return exitOK, nil
+}
+
+func simplifyCommand(args []string) (int, error) {
+	var src source
+	fs := flags("simplify", &src)
+	verify := fs.Bool("verify", false, "check that the simplified chains answer like the originals")
+	factsFile := fs.String("facts", "", "JSON file of call facts, or a list of them, to verify with too")
+	count := fs.Int("n", simplify.DefaultFacts, "number of facts to generate for -verify")
+	if err := fs.Parse(args); err != nil {
+		return exitError, err
+	}
+	var extra []evaluator.Facts
+	if *factsFile != "" {
+		data, err := os.ReadFile(*factsFile)
+		if err != nil {
+			return exitError, err
+		}
+		if err := json.Unmarshal(data, &extra); err != nil {
+			var facts evaluator.Facts
+			if err := json.Unmarshal(data, &facts); err != nil {
+				return exitError, fmt.Errorf("%s: %w", *factsFile, err)
+			}
+			extra = []evaluator.Facts{facts}
+		}
+	}
+	in, err := loadArg(fs)
+	if err != nil {
+		return exitError, err
+	}
+	chains, err := src.chains(in)
+	if err != nil {
+		return exitError, err
+	}
+
+	code := exitOK
+	simplified := make([]types.RuleChain, 0, len(chains))
+	for i, chain := range chains {
+		result, changes := simplify.Chain(chain)
+		prefix := ""
+		if len(chains) > 1 {
+			prefix = fmt.Sprintf("rule %d: ", i)
+		}
+		for _, change := range changes {
+			fmt.Fprintf(os.Stderr, "%s%s\n", prefix, change)
+		}
+		fmt.Fprintf(os.Stderr, "%s%d nodes, %d before\n", prefix, len(result.Metadata.Nodes), len(chain.Metadata.Nodes))
+		if *verify {
+			corpus := append(simplify.Facts(chain, *count), extra...)
+			err := simplify.Verify(chain, result, corpus, evaluator.Options{})
+			if mismatch, ok := err.(*simplify.Mismatch); ok {
+				facts, _ := json.Marshal(mismatch.Facts)
+				fmt.Fprintf(os.Stderr, "%s%v\n  facts: %s\n", prefix, mismatch, facts)
+				code = exitNegative
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "%s%v\n", prefix, err)
+				code = exitNegative
+			} else {
+				fmt.Fprintf(os.Stderr, "%sverified over %d facts\n", prefix, len(corpus))
+			}
+		}
+		simplified = append(simplified, result)
+	}
+	if code != exitOK {
+		return code, nil
+	}
+	if len(simplified) == 1 && !in.list {
+		return exitOK, writeJSON(os.Stdout, simplified[0])
+	}
+	return exitOK, writeJSON(os.Stdout, simplified)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const example = "../../tests/example_small.json"
+
+// runCaptured runs rulectl with args and returns its exit code and what it
+// wrote to stdout.
+func runCaptured(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	stdout, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	savedStdout, savedStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = stdout, devNull
+	code := run(args)
+	os.Stdout, os.Stderr = savedStdout, savedStderr
+
+	if _, err := stdout.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code, string(out)
+}
+
+// writeFile writes data to a file in a temporary directory and returns its
+// path.
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// changedExample returns a copy of the example document whose first draft
+// node has another ID.
+func changedExample(t *testing.T) string {
+	t.Helper()
+	data, err := os.ReadFile(example)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatal(err)
+	}
+	draft := document["draft_config"].([]interface{})
+	node := draft[0].(map[string]interface{})["nodes"].([]interface{})[0].(map[string]interface{})
+	node["id"] = "renamed"
+	changed, err := json.Marshal(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, "changed.json", string(changed))
+}
+
+func TestExitCodes(t *testing.T) {
+	invalid := writeFile(t, "invalid.json", `{"nodes":[]}`)
+	changed := changedExample(t)
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"convert", []string{"convert", example}, exitOK},
+		{"convert draft", []string{"convert", "-draft", example}, exitOK},
+		{"validate", []string{"validate", example}, exitOK},
+		{"validate draft", []string{"validate", "-draft", example}, exitOK},
+		{"lint", []string{"lint", "-draft", example}, exitOK},
+		{"render", []string{"render", example}, exitOK},
+		{"diff of the same file", []string{"diff", "-draft", example, example}, exitOK},
+		{"normalize", []string{"normalize", example}, exitOK},
+		{"simplify", []string{"simplify", "-verify", example}, exitOK},
+
+		{"invalid graph", []string{"validate", invalid}, exitNegative},
+		{"diff of changed files", []string{"diff", "-draft", example, changed}, exitNegative},
+
+		{"no command", nil, exitError},
+		{"unknown command", []string{"bogus"}, exitError},
+		{"unknown flag", []string{"convert", "-bogus", example}, exitError},
+		{"missing file", []string{"convert", filepath.Join(t.TempDir(), "missing.json")}, exitError},
+		{"two files", []string{"convert", example, example}, exitError},
+		{"malformed file", []string{"convert", writeFile(t, "malformed.json", "{")}, exitError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, out := runCaptured(t, tt.args...); code != tt.want {
+				t.Errorf("rulectl %s exited with %d, want %d; output:\n%s", strings.Join(tt.args, " "), code, tt.want, out)
+			}
+		})
+	}
+}
+
+func TestConvertPrintsRuleChain(t *testing.T) {
+	code, out := runCaptured(t, "convert", "-draft", example)
+	if code != exitOK {
+		t.Fatalf("exit code %d", code)
+	}
+	var chain struct {
+		RuleChain struct {
+			ID string `json:"id"`
+		} `json:"ruleChain"`
+	}
+	if err := json.Unmarshal([]byte(out), &chain); err != nil {
+		t.Fatalf("output is not a rule chain: %v\n%s", err, out)
+	}
+	if chain.RuleChain.ID == "" {
+		t.Errorf("printed a rule chain without an ID:\n%s", out)
+	}
+}
@@ -0,0 +1,143 @@
+package collab
+
+import (
+	"sort"
+	"time"
+)
+
+// awarenessTimeout is how long a client's presence lasts without being
+// renewed; clients renew it every 15 seconds.
+const awarenessTimeout = 30 * time.Second
+
+// awareness keeps the presence states of a room's clients as the JSON
+// they sent, following y-protocols/awareness: a state is replaced by a
+// higher clock, and "null" removes it. The clock of a removed client is
+// kept so stale updates for it are ignored.
+type awareness struct {
+	meta   map[uint64]*awarenessMeta
+	states map[uint64]string
+}
+
+type awarenessMeta struct {
+	clock   uint64
+	updated time.Time
+}
+
+// awarenessChange lists the clients an update touched.
+type awarenessChange struct {
+	added, updated, removed []uint64
+}
+
+func (c awarenessChange) clients() []uint64 {
+	return append(append(append([]uint64(nil), c.added...), c.updated...), c.removed...)
+}
+
+func newAwareness() *awareness {
+	return &awareness{
+		meta:   make(map[uint64]*awarenessMeta),
+		states: make(map[uint64]string),
+	}
+}
+
+// apply applies an awareness update, as applyAwarenessUpdate does.
+func (a *awareness) apply(update []byte, now time.Time) (awarenessChange, error) {
+	var change awarenessChange
+	r := &reader{data: update}
+	n, err := r.readVarUint()
+	if err != nil {
+		return change, err
+	}
+	for i := uint64(0); i < n; i++ {
+		client, err := r.readVarUint()
+		if err != nil {
+			return change, err
+		}
+		clock, err := r.readVarUint()
+		if err != nil {
+			return change, err
+		}
+		state, err := r.readVarString()
+		if err != nil {
+			return change, err
+		}
+		var current uint64
+		if meta, ok := a.meta[client]; ok {
+			current = meta.clock
+		}
+		_, had := a.states[client]
+		removed := state == "null"
+		if current >= clock && !(current == clock && removed && had) {
+			continue
+		}
+		if removed {
+			delete(a.states, client)
+		} else {
+			a.states[client] = state
+		}
+		a.meta[client] = &awarenessMeta{clock: clock, updated: now}
+		switch {
+		case !had && !removed:
+			change.added = append(change.added, client)
+		case had && !removed:
+			change.updated = append(change.updated, client)
+		case had && removed:
+			change.removed = append(change.removed, client)
+		}
+	}
+	return change, nil
+}
+
+// remove drops the states of clients, as removeAwarenessStates does, and
+// returns those that had one.
+func (a *awareness) remove(clients []uint64) []uint64 {
+	var removed []uint64
+	for _, client := range clients {
+		if _, ok := a.states[client]; ok {
+			delete(a.states, client)
+			removed = append(removed, client)
+		}
+	}
+	return removed
+}
+
+// outdated returns the clients whose state was not renewed in time.
+func (a *awareness) outdated(now time.Time) []uint64 {
+	var clients []uint64
+	for client := range a.states {
+		if now.Sub(a.meta[client].updated) >= awarenessTimeout {
+			clients = append(clients, client)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] < clients[j] })
+	return clients
+}
+
+// all returns the clients that have a state.
+func (a *awareness) all() []uint64 {
+	clients := make([]uint64, 0, len(a.states))
+	for client := range a.states {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] < clients[j] })
+	return clients
+}
+
+// encode encodes the states of clients as an update; clients without one
+// are written as removed.
+func (a *awareness) encode(clients []uint64) []byte {
+	update := appendVarUint(nil, uint64(len(clients)))
+	for _, client := range clients {
+		var clock uint64
+		if meta, ok := a.meta[client]; ok {
+			clock = meta.clock
+		}
+		state, ok := a.states[client]
+		if !ok {
+			state = "null"
+		}
+		update = appendVarUint(update, client)
+		update = appendVarUint(update, clock)
+		update = appendVarBytes(update, []byte(state))
+	}
+	return update
+}
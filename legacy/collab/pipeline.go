@@ -0,0 +1,253 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"bitbucket.org/convin/go_services/rule_engine/internal/lifecycle"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/yjs"
+	"go.uber.org/zap"
+)
+
+// Debounce defaults of the pipeline, the CALLBACK_DEBOUNCE_WAIT and
+// CALLBACK_DEBOUNCE_MAXWAIT of server/server.js.
+const (
+	DebounceWait    = 2 * time.Second
+	DebounceMaxWait = 10 * time.Second
+)
+
+// DiagnosticsKey is the metadata entry the pipeline publishes the
+// diagnostics of a room's graph in.
+const DiagnosticsKey = "diagnostics"
+
+// KeyFunc returns the question a room edits, if it is known.
+type KeyFunc func(room *Room) (lifecycle.Key, bool)
+
+// Pipeline processes rooms after their editors change them: it extracts
+// the graph, validates and converts it, saves it as the question's draft
+// and publishes the diagnostics in the room's metadata map, so editors see
+// them without converting by hand.
+//
+// A room is processed once it has gone Wait without changes, and at the
+// latest MaxWait after the first change that was not processed yet.
+type Pipeline struct {
+	Wait    time.Duration
+	MaxWait time.Duration
+	// Key maps a room to its question; rooms without one are validated
+	// but not saved. It defaults to MetadataKey.
+	Key KeyFunc
+
+	drafts *lifecycle.Service
+
+	mu    sync.Mutex
+	rooms map[*Room]*debounce
+}
+
+type debounce struct {
+	timer *time.Timer
+	// first is when the oldest change not processed yet was made; zero
+	// when there is none.
+	first time.Time
+	// running serializes the runs of a room.
+	running sync.Mutex
+}
+
+// NewPipeline returns a pipeline saving drafts with drafts. A nil service
+// only publishes diagnostics.
+func NewPipeline(drafts *lifecycle.Service) *Pipeline {
+	return &Pipeline{
+		Wait:    DebounceWait,
+		MaxWait: DebounceMaxWait,
+		Key:     MetadataKey,
+		drafts:  drafts,
+		rooms:   make(map[*Room]*debounce),
+	}
+}
+
+// MetadataKey reads the question of a room from the tenantId and
+// questionId entries of its metadata map.
+func MetadataKey(room *Room) (lifecycle.Key, bool) {
+	var key lifecycle.Key
+	var ok bool
+	room.View(func(doc *yjs.Doc) {
+		metadata := doc.Map(yjs.MetadataMap)
+		tenant, _ := metadata.Get("tenantId")
+		question, _ := metadata.Get("questionId")
+		key.TenantID, _ = tenant.(string)
+		var id int64
+		switch v := question.(type) {
+		case int64:
+			id = v
+		case float64:
+			id = int64(v)
+		case string:
+			id, _ = strconv.ParseInt(v, 10, 32)
+		}
+		key.QuestionID = int32(id)
+		ok = key.TenantID != "" && key.QuestionID > 0
+	})
+	return key, ok
+}
+
+// Hooks returns next with the pipeline added: changes made by clients
+// schedule a run, and a room that closes is processed before next.Close
+// runs. Changes the pipeline makes itself do not schedule one.
+func (p *Pipeline) Hooks(next Hooks) Hooks {
+	hooks := next
+	hooks.Update = func(room *Room, update []byte, local bool) {
+		if next.Update != nil {
+			next.Update(room, update, local)
+		}
+		if !local {
+			p.Changed(room)
+		}
+	}
+	// Without a Close hook rooms are never unloaded, and pending runs
+	// complete on their own.
+	if next.Close != nil {
+		hooks.Close = func(room *Room) error {
+			p.flush(room)
+			return next.Close(room)
+		}
+	}
+	return hooks
+}
+
+// Changed schedules a run for room.
+func (p *Pipeline) Changed(room *Room) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d, ok := p.rooms[room]
+	if !ok {
+		d = &debounce{}
+		p.rooms[room] = d
+	}
+	now := time.Now()
+	if d.first.IsZero() {
+		d.first = now
+	}
+	delay := p.Wait
+	if rest := d.first.Add(p.MaxWait).Sub(now); rest < delay {
+		delay = rest
+	}
+	if d.timer == nil {
+		d.timer = time.AfterFunc(delay, func() { p.fire(room, d) })
+	} else {
+		d.timer.Reset(delay)
+	}
+}
+
+func (p *Pipeline) fire(room *Room, d *debounce) {
+	p.mu.Lock()
+	pending := !d.first.IsZero()
+	d.first = time.Time{}
+	p.mu.Unlock()
+	if !pending {
+		return
+	}
+	d.running.Lock()
+	defer d.running.Unlock()
+	p.run(room)
+}
+
+// flush runs a room's pending changes now and forgets the room.
+func (p *Pipeline) flush(room *Room) {
+	p.mu.Lock()
+	d, ok := p.rooms[room]
+	delete(p.rooms, room)
+	var pending bool
+	if ok {
+		d.timer.Stop()
+		pending = !d.first.IsZero()
+		d.first = time.Time{}
+	}
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+	d.running.Lock()
+	defer d.running.Unlock()
+	if pending {
+		p.run(room)
+	}
+}
+
+// run processes room unless it failed, as its document may be
+// inconsistent.
+func (p *Pipeline) run(room *Room) {
+	if room.Failed() {
+		return
+	}
+	if _, err := p.Run(context.Background(), room); err != nil {
+		zap.L().Error("processing room", zap.String("room", room.Name()), zap.Error(err))
+	}
+}
+
+// Run processes room now and returns the diagnostics it published. A
+// graph that cannot be read from the room is published as an error
+// diagnostic, and no draft is saved.
+func (p *Pipeline) Run(ctx context.Context, room *Room) ([]reactflow.Diagnostic, error) {
+	graph, err := room.Graph()
+	if err != nil {
+		diagnostics := []reactflow.Diagnostic{{Severity: reactflow.SeverityError, Message: err.Error()}}
+		return diagnostics, room.Edit(func(doc *yjs.Doc) ([]byte, error) {
+			return publishDiagnostics(doc, diagnostics)
+		})
+	}
+	key, known := p.Key(room)
+	diagnostics := reactflow.ValidateGraph(graph, key.TenantID)
+	if !reactflow.HasErrors(diagnostics) {
+		// Validation converts the graph too, so this only fails on what
+		// the checks do not cover.
+		if _, err := lifecycle.ConvertGraphs([]reactFlowTypes.Graph{graph}, key.TenantID); err != nil {
+			diagnostics = append(diagnostics, reactflow.Diagnostic{Severity: reactflow.SeverityError, Message: err.Error()})
+		}
+	}
+	if diagnostics == nil {
+		diagnostics = []reactflow.Diagnostic{}
+	}
+	if known && p.drafts != nil {
+		if _, err := p.drafts.SaveDraft(ctx, key, []reactFlowTypes.Graph{graph}); err != nil {
+			return diagnostics, err
+		}
+	}
+	return diagnostics, room.Edit(func(doc *yjs.Doc) ([]byte, error) {
+		return publishDiagnostics(doc, diagnostics)
+	})
+}
+
+// publishDiagnostics sets the diagnostics entry of the metadata map,
+// unless it already holds the same diagnostics.
+func publishDiagnostics(doc *yjs.Doc, diagnostics []reactflow.Diagnostic) ([]byte, error) {
+	metadata := doc.Map(yjs.MetadataMap)
+	if current, ok := metadata.ToJSON()[DiagnosticsKey]; ok && sameJSON(current, diagnostics) {
+		return nil, nil
+	}
+	return metadata.Set(DiagnosticsKey, diagnostics)
+}
+
+// sameJSON compares a value read from a document with a Go value by their
+// JSON encoding; both go through a generic decode so key order and number
+// types do not matter.
+func sameJSON(current, value interface{}) bool {
+	x, err := json.Marshal(current)
+	if err != nil {
+		return false
+	}
+	y, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	var a, b interface{}
+	if json.Unmarshal(x, &a) != nil || json.Unmarshal(y, &b) != nil {
+		return false
+	}
+	x, _ = json.Marshal(a)
+	y, _ = json.Marshal(b)
+	return string(x) == string(y)
+}
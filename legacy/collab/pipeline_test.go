@@ -0,0 +1,125 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"bitbucket.org/convin/go_services/rule_engine/internal/lifecycle"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+	"bitbucket.org/convin/go_services/rule_engine/internal/yjs"
+)
+
+// exampleGraph is the first draft graph of the example document, decoded
+// as plain JSON.
+type exampleGraph struct {
+	ID    interface{}   `json:"id"`
+	Nodes []interface{} `json:"nodes"`
+	Edges []interface{} `json:"edges"`
+}
+
+// editedRoom returns a room holding graph as the editor keeps it, for the
+// question tenant/7.
+func editedRoom(t *testing.T, graph exampleGraph) *Room {
+	t.Helper()
+	room := newRoom(NewServer(Hooks{}), "room")
+	room.View(func(doc *yjs.Doc) {
+		for name, elements := range map[string][]interface{}{yjs.NodesMap: graph.Nodes, yjs.EdgesMap: graph.Edges} {
+			for _, element := range elements {
+				id, _ := element.(map[string]interface{})["id"].(string)
+				if _, err := doc.Map(name).Set(id, element); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		metadata := doc.Map(yjs.MetadataMap)
+		for key, value := range map[string]interface{}{"id": graph.ID, "tenantId": "tenant", "questionId": 7} {
+			if _, err := metadata.Set(key, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	return room
+}
+
+func example(t *testing.T) exampleGraph {
+	t.Helper()
+	data, err := os.ReadFile("../../tests/example_small.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document struct {
+		DraftConfig []exampleGraph `json:"draft_config"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatal(err)
+	}
+	return document.DraftConfig[0]
+}
+
+func publishedDiagnostics(t *testing.T, room *Room) []reactflow.Diagnostic {
+	t.Helper()
+	var diagnostics []reactflow.Diagnostic
+	room.View(func(doc *yjs.Doc) {
+		value, ok := doc.Map(yjs.MetadataMap).Get(DiagnosticsKey)
+		if !ok {
+			t.Fatal("no diagnostics were published")
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, &diagnostics); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return diagnostics
+}
+
+func TestPipelineSavesDrafts(t *testing.T) {
+	ctx := context.Background()
+	repo := lifecycle.NewMemoryRepository()
+	pipeline := NewPipeline(lifecycle.NewService(repo, nil))
+	graph := example(t)
+	room := editedRoom(t, graph)
+
+	diagnostics, err := pipeline.Run(ctx, room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reactflow.HasErrors(diagnostics) {
+		t.Errorf("the example graph has errors: %v", diagnostics)
+	}
+	if got := publishedDiagnostics(t, room); len(got) != len(diagnostics) {
+		t.Errorf("published %v, want %v", got, diagnostics)
+	}
+	rule, err := repo.Get(ctx, lifecycle.Key{TenantID: "tenant", QuestionID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rule.DraftConfig) != 1 || len(rule.DraftConfig[0].Nodes) != len(graph.Nodes) || len(rule.DraftConfig[0].Edges) != len(graph.Edges) {
+		t.Errorf("saved draft %+v, want the room's graph", rule.DraftConfig)
+	}
+}
+
+func TestPipelinePublishesUnreadableGraphs(t *testing.T) {
+	ctx := context.Background()
+	repo := lifecycle.NewMemoryRepository()
+	pipeline := NewPipeline(lifecycle.NewService(repo, nil))
+	room := editedRoom(t, exampleGraph{ID: "multiple", Nodes: []interface{}{map[string]interface{}{"id": "a", "position": "here"}}})
+
+	diagnostics, err := pipeline.Run(ctx, room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagnostics) != 1 || diagnostics[0].Severity != reactflow.SeverityError {
+		t.Fatalf("diagnostics %v, want one error", diagnostics)
+	}
+	if got := publishedDiagnostics(t, room); len(got) != 1 || got[0].Message != diagnostics[0].Message {
+		t.Errorf("published %v, want %v", got, diagnostics)
+	}
+	if _, err := repo.Get(ctx, lifecycle.Key{TenantID: "tenant", QuestionID: 7}); err != lifecycle.ErrNotFound {
+		t.Errorf("a draft was saved for an unreadable graph: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+// Package collab serves Yjs rooms over WebSocket with the y-websocket
+// protocol, so the React editor can use it in place of the Node server.
+//
+// Every room holds a yjs.Doc. Clients sync with the y-protocols sync
+// messages (step 1, step 2 and updates), which the server answers and
+// relays to the other clients of the room, and share presence with
+// awareness messages. Hooks let the rest of the service load rooms and
+// react to document changes.
+package collab
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Message types of the y-websocket protocol.
+const (
+	messageSync           = 0
+	messageAwareness      = 1
+	messageAuth           = 2
+	messageQueryAwareness = 3
+)
+
+// Sync message types of y-protocols/sync.
+const (
+	syncStep1  = 0
+	syncStep2  = 1
+	syncUpdate = 2
+)
+
+var errMalformed = errors.New("collab: malformed message")
+
+// reader reads the lib0 varuints and byte arrays messages are made of.
+type reader struct {
+	data []byte
+	pos  int
+}
+
+func (r *reader) readVarUint() (uint64, error) {
+	num, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, errMalformed
+	}
+	r.pos += n
+	return num, nil
+}
+
+func (r *reader) readVarBytes() ([]byte, error) {
+	n, err := r.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, errMalformed
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+func (r *reader) readVarString() (string, error) {
+	b, err := r.readVarBytes()
+	return string(b), err
+}
+
+func appendVarUint(buf []byte, num uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], num)]...)
+}
+
+func appendVarBytes(buf, b []byte) []byte {
+	return append(appendVarUint(buf, uint64(len(b))), b...)
+}
+
+func syncMessage(kind uint64, payload []byte) []byte {
+	msg := appendVarUint([]byte{messageSync}, kind)
+	return appendVarBytes(msg, payload)
+}
+
+func awarenessMessage(update []byte) []byte {
+	return appendVarBytes([]byte{messageAwareness}, update)
+}
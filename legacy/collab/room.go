@@ -0,0 +1,243 @@
+package collab
+
+import (
+	"sync"
+	"time"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/yjs"
+)
+
+// awarenessCheck is how often rooms drop outdated presence states.
+const awarenessCheck = awarenessTimeout / 10
+
+// Room is a shared document and the connections editing it.
+type Room struct {
+	name   string
+	server *Server
+
+	// ready is closed once the room is loaded; loadErr is the error of
+	// the Load hook.
+	ready   chan struct{}
+	loadErr error
+	done    chan struct{}
+
+	mu        sync.Mutex
+	doc       *yjs.Doc
+	awareness *awareness
+	conns     map[*conn]struct{}
+	// broken is set when handling a message panicked.
+	broken bool
+}
+
+func newRoom(s *Server, name string) *Room {
+	return &Room{
+		name:      name,
+		server:    s,
+		ready:     make(chan struct{}),
+		done:      make(chan struct{}),
+		doc:       yjs.NewDoc(),
+		awareness: newAwareness(),
+		conns:     make(map[*conn]struct{}),
+	}
+}
+
+// Name returns the room name, the path the client connected to.
+func (r *Room) Name() string {
+	return r.name
+}
+
+// View calls fn with the room's document while no update is applied to
+// it. fn must not keep the document.
+func (r *Room) View(fn func(doc *yjs.Doc)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(r.doc)
+}
+
+// Failed reports whether the room was unloaded because handling a message
+// panicked. Its document may be inconsistent and must not be persisted.
+func (r *Room) Failed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.broken
+}
+
+// Graph returns the graph the room's editors are working on.
+func (r *Room) Graph() (reactFlowTypes.Graph, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return yjs.ExtractGraph(r.doc)
+}
+
+// State returns the whole document encoded as an update.
+func (r *Room) State() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.doc.EncodeStateAsUpdate(nil)
+}
+
+// Connections returns the number of open connections to the room.
+func (r *Room) Connections() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// join adds c to the room and sends it the room's state vector and
+// presence states, as y-websocket does on connection.
+func (r *Room) join(c *conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conns[c] = struct{}{}
+	c.enqueue(syncMessage(syncStep1, r.doc.EncodeStateVector()))
+	if clients := r.awareness.all(); len(clients) > 0 {
+		c.enqueue(awarenessMessage(r.awareness.encode(clients)))
+	}
+}
+
+// leave removes c and the presence of the clients it announced, and
+// reports whether the room is now empty.
+func (r *Room) leave(c *conn) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.conns, c)
+	clients := make([]uint64, 0, len(c.clients))
+	for client := range c.clients {
+		clients = append(clients, client)
+	}
+	if removed := r.awareness.remove(clients); len(removed) > 0 {
+		r.broadcast(awarenessMessage(r.awareness.encode(removed)), nil)
+	}
+	return len(r.conns) == 0
+}
+
+// closeConns closes every connection of the room.
+func (r *Room) closeConns() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for c := range r.conns {
+		c.close()
+	}
+}
+
+// syncStep1 answers a client's state vector with what it is missing.
+func (r *Room) syncStep1(c *conn, stateVector []byte) error {
+	sv, err := yjs.DecodeStateVector(stateVector)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c.enqueue(syncMessage(syncStep2, r.doc.EncodeStateAsUpdate(sv)))
+	return nil
+}
+
+// applyUpdate applies an update sent by origin, relays it to the other
+// connections and runs the Update hook.
+func (r *Room) applyUpdate(origin *conn, update []byte) error {
+	if err := r.integrate(origin, update); err != nil {
+		return err
+	}
+	if hook := r.server.hooks.Update; hook != nil {
+		hook(r, update, false)
+	}
+	return nil
+}
+
+// integrate applies update and relays it under r.mu, which is released
+// even if applying panics.
+func (r *Room) integrate(origin *conn, update []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.doc.ApplyUpdate(update); err != nil {
+		return err
+	}
+	r.broadcast(syncMessage(syncUpdate, update), origin)
+	return nil
+}
+
+// Edit lets the server change the room's document: fn edits doc and
+// returns the update describing its change, which is sent to every
+// connection. A nil update means nothing changed.
+func (r *Room) Edit(fn func(doc *yjs.Doc) ([]byte, error)) error {
+	update, err := r.edit(fn)
+	if err != nil || update == nil {
+		return err
+	}
+	if hook := r.server.hooks.Update; hook != nil {
+		hook(r, update, true)
+	}
+	return nil
+}
+
+func (r *Room) edit(fn func(doc *yjs.Doc) ([]byte, error)) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	update, err := fn(r.doc)
+	if err != nil || update == nil {
+		return nil, err
+	}
+	r.broadcast(syncMessage(syncUpdate, update), nil)
+	return update, nil
+}
+
+// applyAwareness applies a presence update sent by origin and sends the
+// changed states to every connection, the sender included.
+func (r *Room) applyAwareness(origin *conn, update []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	change, err := r.awareness.apply(update, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, client := range change.added {
+		origin.clients[client] = struct{}{}
+	}
+	for _, client := range change.removed {
+		delete(origin.clients, client)
+	}
+	if clients := change.clients(); len(clients) > 0 {
+		r.broadcast(awarenessMessage(r.awareness.encode(clients)), nil)
+	}
+	return nil
+}
+
+// queryAwareness sends c every presence state.
+func (r *Room) queryAwareness(c *conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c.enqueue(awarenessMessage(r.awareness.encode(r.awareness.all())))
+}
+
+// broadcast sends msg to every connection other than except. The caller
+// holds r.mu.
+func (r *Room) broadcast(msg []byte, except *conn) {
+	for c := range r.conns {
+		if c != except {
+			c.enqueue(msg)
+		}
+	}
+}
+
+// sweep drops outdated presence states until the room is closed.
+func (r *Room) sweep() {
+	ticker := time.NewTicker(awarenessCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			r.expire(now)
+		}
+	}
+}
+
+func (r *Room) expire(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if removed := r.awareness.remove(r.awareness.outdated(now)); len(removed) > 0 {
+		r.broadcast(awarenessMessage(r.awareness.encode(removed)), nil)
+	}
+}
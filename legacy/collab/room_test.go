@@ -0,0 +1,127 @@
+package collab
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"bitbucket.org/convin/go_services/rule_engine/internal/yjs"
+)
+
+// unlocked fails the test unless the room's lock can be taken.
+func unlocked(t *testing.T, room *Room) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		room.State()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the room stays locked")
+	}
+}
+
+func TestRoomUnlocksAfterPanic(t *testing.T) {
+	room := newRoom(NewServer(Hooks{}), "room")
+	func() {
+		defer func() { recover() }()
+		room.Edit(func(doc *yjs.Doc) ([]byte, error) { panic("edit failed") })
+	}()
+	unlocked(t, room)
+}
+
+func TestApplyUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		update  []byte
+		wantErr bool
+	}{
+		{"map set", []byte{1, 1, 1, 0, 0x28, 1, 1, 'm', 1, 'k', 1, 0x77, 1, 'v', 0}, false},
+		{"truncated", []byte{1, 1, 1, 0, 0x28, 1}, true},
+		{"gc overlapping the state", []byte{1, 2, 1, 0, 0, 3, 0, 2, 0}, false},
+	}
+	room := newRoom(NewServer(Hooks{}), "room")
+	origin := newConn(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := room.applyUpdate(origin, tt.update)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("applyUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			unlocked(t, room)
+		})
+	}
+}
+
+func TestHandleRecoversPanics(t *testing.T) {
+	c := newConn(nil)
+	// Without a room, answering the query panics.
+	err := c.handle(nil, []byte{messageQueryAwareness})
+	var crash *messagePanic
+	if !errors.As(err, &crash) {
+		t.Fatalf("handle() error = %v, want a *messagePanic", err)
+	}
+}
+
+func TestFailedRoomReloads(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(store.Hooks())
+	a, b := newConn(nil), newConn(nil)
+	room, err := s.join("room", a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.join("room", b); err != nil {
+		t.Fatal(err)
+	}
+	set(t, store, room, "a", "1")
+	// An update the room applied but never logged, as one that panicked
+	// half way would be.
+	room.View(func(doc *yjs.Doc) {
+		if _, err := doc.Map("m").Set("b", "2"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	s.fail(room)
+	if !room.Failed() {
+		t.Error("the room is not marked failed")
+	}
+	if _, ok := s.Room("room"); ok {
+		t.Error("the failed room is still loaded")
+	}
+	for _, c := range []*conn{a, b} {
+		select {
+		case <-c.done:
+		default:
+			t.Error("a connection of the failed room is open")
+		}
+	}
+
+	// The room is loaded again once its connections have left, from what
+	// was stored before it failed.
+	joined := make(chan *Room)
+	go func() {
+		reloaded, err := s.join("room", newConn(nil))
+		if err != nil {
+			t.Error(err)
+		}
+		joined <- reloaded
+	}()
+	s.leave(room, a)
+	s.leave(room, b)
+	select {
+	case reloaded := <-joined:
+		if reloaded == room {
+			t.Fatal("joined the failed room")
+		}
+		sameKeys(t, reloaded, "a")
+	case <-time.After(time.Second):
+		t.Fatal("the room was not loaded again")
+	}
+}
@@ -0,0 +1,379 @@
+package collab
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/convin/go_services/rule_engine/internal/yjs"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultRoom is the room of clients that name none, as in
+	// server/server.js.
+	DefaultRoom = "default-room"
+
+	writeWait      = 10 * time.Second
+	pingInterval   = 30 * time.Second
+	maxMessageSize = 32 << 20
+	sendBuffer     = 256
+)
+
+// Hooks are called on room events. All are optional.
+type Hooks struct {
+	// Load fills the document of a room before its first connection is
+	// served. A failed load refuses the connection.
+	Load func(room string, doc *yjs.Doc) error
+	// Update is called after an update was applied to the room, outside
+	// the room's lock. local tells the updates made with Room.Edit from
+	// those sent by clients.
+	Update func(room *Room, update []byte, local bool)
+	// Close is called once the last connection of a room has left. When
+	// it is set the room is unloaded afterwards, as y-websocket does when
+	// it persists documents; otherwise rooms stay in memory. It is also
+	// called for a room that failed, whose document must not be persisted
+	// (see Room.Failed).
+	Close func(room *Room) error
+}
+
+// Server serves rooms to y-websocket clients. The room is the request
+// path, as the client's WebsocketProvider builds it, or the room query
+// parameter.
+type Server struct {
+	hooks    Hooks
+	upgrader websocket.Upgrader
+
+	mu    sync.Mutex
+	rooms map[string]*Room
+	// closing holds the rooms whose Close hook is running; they are not
+	// loaded again before it returns.
+	closing map[string]chan struct{}
+}
+
+func NewServer(hooks Hooks) *Server {
+	return &Server{
+		hooks: hooks,
+		upgrader: websocket.Upgrader{
+			// The editor is served from other origins, and the Node
+			// server accepted any.
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+		rooms:   make(map[string]*Room),
+		closing: make(map[string]chan struct{}),
+	}
+}
+
+// RoomName returns the room a request asks for.
+func RoomName(r *http.Request) string {
+	if name := strings.Trim(r.URL.Path, "/"); name != "" {
+		return name
+	}
+	if name := r.URL.Query().Get("room"); name != "" {
+		return name
+	}
+	return DefaultRoom
+}
+
+// Room returns a loaded room.
+func (s *Server) Room(name string) (*Room, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room, ok := s.rooms[name]
+	return room, ok
+}
+
+// Rooms returns the names of the loaded rooms.
+func (s *Server) Rooms() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.rooms))
+	for name := range s.rooms {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, "WebSocket server for Y.js is running")
+		return
+	}
+	name := RoomName(r)
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has replied with the error.
+		return
+	}
+	c := newConn(ws)
+	room, err := s.join(name, c)
+	if err != nil {
+		zap.L().Error("loading room", zap.String("room", name), zap.Error(err))
+		ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "room unavailable"),
+			time.Now().Add(writeWait))
+		ws.Close()
+		return
+	}
+	go c.writeLoop()
+	c.readLoop(room)
+	s.leave(room, c)
+}
+
+// join adds c to the room name, loading the room first if needed.
+func (s *Server) join(name string, c *conn) (*Room, error) {
+	s.mu.Lock()
+	for {
+		closing, ok := s.closing[name]
+		if !ok {
+			break
+		}
+		s.mu.Unlock()
+		<-closing
+		s.mu.Lock()
+	}
+	room, ok := s.rooms[name]
+	if !ok {
+		room = newRoom(s, name)
+		s.rooms[name] = room
+		s.mu.Unlock()
+		if s.hooks.Load != nil {
+			room.loadErr = s.hooks.Load(name, room.doc)
+		}
+		if room.loadErr != nil {
+			s.mu.Lock()
+			delete(s.rooms, name)
+			s.mu.Unlock()
+		} else {
+			go room.sweep()
+		}
+		close(room.ready)
+	} else {
+		s.mu.Unlock()
+	}
+	<-room.ready
+	if room.loadErr != nil {
+		return nil, room.loadErr
+	}
+
+	// Joining under the server lock keeps a room that is being unloaded
+	// from taking new connections.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rooms[name] != room {
+		return nil, fmt.Errorf("room %q was unloaded while loading", name)
+	}
+	room.join(c)
+	return room, nil
+}
+
+func (s *Server) leave(room *Room, c *conn) {
+	s.mu.Lock()
+	empty := room.leave(c)
+	var closing chan struct{}
+	if empty && s.hooks.Close != nil {
+		if room.Failed() {
+			// The room was unloaded when it failed.
+			closing = s.closing[room.name]
+		} else {
+			closing = s.unload(room)
+		}
+	}
+	s.mu.Unlock()
+	if closing != nil {
+		if err := s.hooks.Close(room); err != nil {
+			zap.L().Error("closing room", zap.String("room", room.name), zap.Error(err))
+		}
+		s.mu.Lock()
+		delete(s.closing, room.name)
+		s.mu.Unlock()
+		close(closing)
+	}
+}
+
+// unload removes room from the loaded rooms. With a Close hook, the
+// returned channel holds off loading the room again until it is closed.
+// The caller holds s.mu.
+func (s *Server) unload(room *Room) chan struct{} {
+	delete(s.rooms, room.name)
+	close(room.done)
+	if s.hooks.Close == nil {
+		return nil
+	}
+	closing := make(chan struct{})
+	s.closing[room.name] = closing
+	return closing
+}
+
+// fail unloads a room whose document can no longer be trusted and closes
+// its connections. Clients reconnect to the room as it was last stored.
+func (s *Server) fail(room *Room) {
+	s.mu.Lock()
+	if s.rooms[room.name] != room || room.Failed() {
+		s.mu.Unlock()
+		return
+	}
+	room.mu.Lock()
+	room.broken = true
+	room.mu.Unlock()
+	s.unload(room)
+	s.mu.Unlock()
+	room.closeConns()
+}
+
+// conn is a client connection. Messages to it are queued and written by
+// its own goroutine; a client that does not keep up is disconnected.
+type conn struct {
+	ws   *websocket.Conn
+	send chan []byte
+	done chan struct{}
+	once sync.Once
+	// clients are the awareness clients announced on this connection,
+	// removed when it closes. Guarded by the room's lock.
+	clients map[uint64]struct{}
+}
+
+func newConn(ws *websocket.Conn) *conn {
+	return &conn{
+		ws:      ws,
+		send:    make(chan []byte, sendBuffer),
+		done:    make(chan struct{}),
+		clients: make(map[uint64]struct{}),
+	}
+}
+
+func (c *conn) enqueue(msg []byte) {
+	select {
+	case c.send <- msg:
+	case <-c.done:
+	default:
+		zap.L().Warn("dropping slow collaboration client", zap.String("remote", c.ws.RemoteAddr().String()))
+		c.close()
+	}
+}
+
+func (c *conn) close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+func (c *conn) writeLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+	}()
+	for {
+		select {
+		case msg := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.ws.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+				c.close()
+				return
+			}
+		case <-ticker.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// readLoop handles the client's messages until the connection fails or
+// no pong arrives within a ping interval.
+func (c *conn) readLoop(room *Room) {
+	defer c.close()
+	c.ws.SetReadLimit(maxMessageSize)
+	deadline := func() { c.ws.SetReadDeadline(time.Now().Add(pingInterval + writeWait)) }
+	deadline()
+	c.ws.SetPongHandler(func(string) error {
+		deadline()
+		return nil
+	})
+	for {
+		_, msg, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		deadline()
+		if err := c.handle(room, msg); err != nil {
+			var crash *messagePanic
+			if errors.As(err, &crash) {
+				// The panic may have left the document half updated.
+				zap.L().Error("collaboration message, unloading the room", zap.String("room", room.name), zap.Error(err))
+				room.server.fail(room)
+				return
+			}
+			zap.L().Warn("collaboration message", zap.String("room", room.name), zap.Error(err))
+		}
+	}
+}
+
+// messagePanic is a panic raised while handling a message.
+type messagePanic struct {
+	value interface{}
+}
+
+func (p *messagePanic) Error() string {
+	return fmt.Sprintf("panic: %v", p.value)
+}
+
+// handle handles one message of the client. A panic is returned as a
+// *messagePanic, on which the room is failed rather than the process.
+func (c *conn) handle(room *Room, msg []byte) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = &messagePanic{value: p}
+		}
+	}()
+	return c.dispatch(room, msg)
+}
+
+func (c *conn) dispatch(room *Room, msg []byte) error {
+	r := &reader{data: msg}
+	kind, err := r.readVarUint()
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case messageSync:
+		step, err := r.readVarUint()
+		if err != nil {
+			return err
+		}
+		payload, err := r.readVarBytes()
+		if err != nil {
+			return err
+		}
+		switch step {
+		case syncStep1:
+			return room.syncStep1(c, payload)
+		case syncStep2, syncUpdate:
+			if err := room.applyUpdate(c, payload); err != nil {
+				return fmt.Errorf("applying update: %w", err)
+			}
+			return nil
+		}
+		return fmt.Errorf("unknown sync message type %d", step)
+	case messageAwareness:
+		update, err := r.readVarBytes()
+		if err != nil {
+			return err
+		}
+		return room.applyAwareness(c, update)
+	case messageQueryAwareness:
+		room.queryAwareness(c)
+		return nil
+	case messageAuth:
+		// Rooms are not access controlled.
+		return nil
+	}
+	return fmt.Errorf("unknown message type %d", kind)
+}
@@ -0,0 +1,324 @@
+package collab
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"bitbucket.org/convin/go_services/rule_engine/internal/yjs"
+	"go.uber.org/zap"
+)
+
+// DefaultCompactAfter is the log size past which a room is compacted.
+const DefaultCompactAfter = 4 << 20
+
+// recordHeader is the length and CRC-32C of the payload that follows.
+const recordHeader = 8
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errCorruptRecord = errors.New("collab: corrupt record")
+)
+
+// FileStore keeps rooms on local disk so they outlive the process. Every
+// update of a room is appended to its log and synced before the next one;
+// compaction replaces the log by a snapshot of the whole document. Records
+// carry a checksum. A log whose last record was cut short or garbled by a
+// crash is truncated to the record before it when the room is loaded; a
+// bad record followed by others is corruption, and the room is not loaded
+// so the log can be inspected.
+//
+// A room named name is kept in <dir>/<base64url(name)>.snap and .log.
+type FileStore struct {
+	dir string
+	// CompactAfter is the log size in bytes past which the room is
+	// compacted after an update. Rooms are also compacted when closed.
+	CompactAfter int64
+
+	mu   sync.Mutex
+	logs map[string]*roomLog
+}
+
+type roomLog struct {
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating room directory: %w", err)
+	}
+	return &FileStore{
+		dir:          dir,
+		CompactAfter: DefaultCompactAfter,
+		logs:         make(map[string]*roomLog),
+	}, nil
+}
+
+// Hooks returns server hooks that load rooms from the store, log their
+// updates and compact them when they close.
+func (s *FileStore) Hooks() Hooks {
+	return Hooks{
+		Load: s.Load,
+		Update: func(room *Room, update []byte, local bool) {
+			if err := s.Append(room, update); err != nil {
+				zap.L().Error("persisting room update", zap.String("room", room.Name()), zap.Error(err))
+			}
+		},
+		Close: s.Close,
+	}
+}
+
+func (s *FileStore) path(room, ext string) string {
+	return filepath.Join(s.dir, base64.RawURLEncoding.EncodeToString([]byte(room))+ext)
+}
+
+// Load applies the room's snapshot and then its log to doc, and opens the
+// log for appending.
+func (s *FileStore) Load(room string, doc *yjs.Doc) error {
+	snapshot, err := os.ReadFile(s.path(room, ".snap"))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("reading snapshot: %w", err)
+	default:
+		payload, n, err := readRecord(snapshot)
+		if err != nil || n != len(snapshot) {
+			return fmt.Errorf("snapshot of room %q is corrupt", room)
+		}
+		if err := doc.ApplyUpdate(payload); err != nil {
+			return fmt.Errorf("applying snapshot: %w", err)
+		}
+	}
+
+	f, err := os.OpenFile(s.path(room, ".log"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening room log: %w", err)
+	}
+	size, err := replay(f, room, doc)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		f.Close()
+		return err
+	}
+	s.mu.Lock()
+	s.logs[room] = &roomLog{f: f, size: size}
+	s.mu.Unlock()
+	return nil
+}
+
+// replay applies the records of a log, leaving the file positioned for
+// appending. A bad last record is the tail of a write cut short by a crash
+// and is truncated; a bad record anywhere else fails the replay before the
+// log or doc is changed.
+func replay(f *os.File, room string, doc *yjs.Doc) (int64, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return 0, fmt.Errorf("reading room log: %w", err)
+	}
+	end := len(data)
+	for offset := 0; offset < len(data); {
+		_, n, err := readRecord(data[offset:])
+		if err == nil {
+			offset += n
+			continue
+		}
+		if errors.Is(err, errCorruptRecord) && offset+n < len(data) {
+			return 0, fmt.Errorf("room log of %q: record at offset %d of %d: %w", room, offset, len(data), err)
+		}
+		zap.L().Warn("truncating room log",
+			zap.String("room", room), zap.Int("offset", offset), zap.Int("dropped", len(data)-offset), zap.Error(err))
+		end = offset
+		break
+	}
+
+	var offset int
+	for offset < end {
+		payload, n, _ := readRecord(data[offset:])
+		if err := doc.ApplyUpdate(payload); err != nil {
+			zap.L().Warn("skipping room log record", zap.String("room", room), zap.Int("offset", offset), zap.Error(err))
+		}
+		offset += n
+	}
+	if end < len(data) {
+		if err := f.Truncate(int64(end)); err != nil {
+			return 0, fmt.Errorf("truncating room log: %w", err)
+		}
+		if err := f.Sync(); err != nil {
+			return 0, fmt.Errorf("syncing room log: %w", err)
+		}
+	}
+	if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+		return 0, fmt.Errorf("seeking room log: %w", err)
+	}
+	return int64(offset), nil
+}
+
+// Append logs an update of room and compacts the room once its log has
+// grown past CompactAfter.
+func (s *FileStore) Append(room *Room, update []byte) error {
+	log, err := s.log(room.Name())
+	if err != nil {
+		return err
+	}
+	log.mu.Lock()
+	if _, err := log.f.Write(appendRecord(nil, update)); err != nil {
+		log.mu.Unlock()
+		return fmt.Errorf("writing room log: %w", err)
+	}
+	log.size += int64(recordHeader + len(update))
+	err = log.f.Sync()
+	compact := log.size > s.CompactAfter
+	log.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("syncing room log: %w", err)
+	}
+	if compact {
+		return s.Compact(room)
+	}
+	return nil
+}
+
+// Compact writes the room's state as its snapshot and empties its log.
+// Rooms holding updates whose dependencies never arrived are not
+// compacted, as the snapshot would lose them, and neither are failed
+// rooms, whose log still holds the updates they were built from.
+func (s *FileStore) Compact(room *Room) error {
+	if room.Failed() {
+		return nil
+	}
+	log, err := s.log(room.Name())
+	if err != nil {
+		return err
+	}
+	// The log lock is held while the state is read so no update applied
+	// after it can be logged and then truncated.
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	var state []byte
+	room.View(func(doc *yjs.Doc) {
+		if !doc.Pending() {
+			state = doc.EncodeStateAsUpdate(nil)
+		}
+	})
+	if state == nil {
+		return nil
+	}
+	if err := s.writeSnapshot(room.Name(), state); err != nil {
+		return err
+	}
+	// A crash before the truncation replays the log over the snapshot,
+	// which applying updates twice allows.
+	if err := log.f.Truncate(0); err != nil {
+		return fmt.Errorf("truncating room log: %w", err)
+	}
+	if _, err := log.f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking room log: %w", err)
+	}
+	log.size = 0
+	if err := log.f.Sync(); err != nil {
+		return fmt.Errorf("syncing room log: %w", err)
+	}
+	return nil
+}
+
+// writeSnapshot replaces the snapshot of room atomically.
+func (s *FileStore) writeSnapshot(room string, state []byte) error {
+	path := s.path(room, ".snap")
+	tmp, err := os.CreateTemp(s.dir, ".snap-*")
+	if err != nil {
+		return fmt.Errorf("creating snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(appendRecord(nil, state)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("syncing snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing snapshot: %w", err)
+	}
+	return syncDir(s.dir)
+}
+
+// Close compacts the room and closes its log.
+func (s *FileStore) Close(room *Room) error {
+	err := s.Compact(room)
+	s.mu.Lock()
+	log := s.logs[room.Name()]
+	delete(s.logs, room.Name())
+	s.mu.Unlock()
+	if log != nil {
+		log.mu.Lock()
+		defer log.mu.Unlock()
+		if cerr := log.f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (s *FileStore) log(room string) (*roomLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	log, ok := s.logs[room]
+	if !ok {
+		return nil, fmt.Errorf("room %q is not loaded", room)
+	}
+	return log, nil
+}
+
+func appendRecord(buf, payload []byte) []byte {
+	var header [recordHeader]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:], crc32.Checksum(payload, crcTable))
+	return append(append(buf, header[:]...), payload...)
+}
+
+// readRecord reads the record at the start of data and returns its
+// payload and length. The length is also returned for a record whose
+// checksum does not match.
+func readRecord(data []byte) ([]byte, int, error) {
+	if len(data) < recordHeader {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	n := binary.BigEndian.Uint32(data[:4])
+	if uint64(n) > uint64(len(data)-recordHeader) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	payload := data[recordHeader : recordHeader+int(n)]
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(data[4:8]) {
+		return nil, recordHeader + int(n), errCorruptRecord
+	}
+	return payload, recordHeader + int(n), nil
+}
+
+// syncDir makes the creation and renaming of files in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("opening room directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("syncing room directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package collab
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"bitbucket.org/convin/go_services/rule_engine/internal/yjs"
+)
+
+// loadRoom loads the room name from store into a fresh room.
+func loadRoom(t *testing.T, store *FileStore, name string) (*Room, error) {
+	t.Helper()
+	room := newRoom(NewServer(Hooks{}), name)
+	return room, store.Load(name, room.doc)
+}
+
+// set sets key of the room's map "m" and logs the update.
+func set(t *testing.T, store *FileStore, room *Room, key, value string) {
+	t.Helper()
+	var update []byte
+	err := room.Edit(func(doc *yjs.Doc) ([]byte, error) {
+		var err error
+		update, err = doc.Map("m").Set(key, value)
+		return update, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append(room, update); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sameKeys fails the test unless the room's map "m" holds the keys want,
+// in sorted order.
+func sameKeys(t *testing.T, room *Room, want ...string) {
+	t.Helper()
+	var got []string
+	room.View(func(doc *yjs.Doc) { got = doc.Map("m").Keys() })
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("keys %v, want %v", got, want)
+	}
+}
+
+// writtenLog writes a log with the records of two updates to room name
+// and returns its path and contents.
+func writtenLog(t *testing.T, store *FileStore, name string) (string, []byte) {
+	t.Helper()
+	room, err := loadRoom(t, store, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set(t, store, room, "a", "1")
+	set(t, store, room, "b", "2")
+	// Unloading without Close keeps the log as it is, as after a crash.
+	store.mu.Lock()
+	store.logs[name].f.Close()
+	delete(store.logs, name)
+	store.mu.Unlock()
+	path := store.path(name, ".log")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func TestFileStoreReload(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, _ := writtenLog(t, store, "room")
+	room, err := loadRoom(t, store, "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameKeys(t, room, "a", "b")
+
+	// Closing compacts the room into its snapshot.
+	set(t, store, room, "c", "3")
+	if err := store.Close(room); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Errorf("log after closing: %v, %v; want it empty", info, err)
+	}
+	room, err = loadRoom(t, store, "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameKeys(t, room, "a", "b", "c")
+}
+
+func TestFileStoreCompactsLargeLogs(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.CompactAfter = 1
+	room, err := loadRoom(t, store, "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set(t, store, room, "a", "1")
+	if info, err := os.Stat(store.path("room", ".log")); err != nil || info.Size() != 0 {
+		t.Errorf("log after compaction: %v, %v; want it empty", info, err)
+	}
+	if _, err := os.Stat(store.path("room", ".snap")); err != nil {
+		t.Errorf("no snapshot after compaction: %v", err)
+	}
+	// The log is appended to after compaction and replayed over the
+	// snapshot.
+	store.CompactAfter = DefaultCompactAfter
+	set(t, store, room, "b", "2")
+	store.mu.Lock()
+	store.logs["room"].f.Close()
+	delete(store.logs, "room")
+	store.mu.Unlock()
+	room, err = loadRoom(t, store, "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameKeys(t, room, "a", "b")
+}
+
+func TestFileStoreTruncatesTornTail(t *testing.T) {
+	tests := []struct {
+		name string
+		tail func(last []byte) []byte
+	}{
+		{"short header", func(last []byte) []byte { return last[:recordHeader-1] }},
+		{"short payload", func(last []byte) []byte { return last[:len(last)-1] }},
+		{"zeroed payload", func(last []byte) []byte {
+			zeroed := append([]byte(nil), last...)
+			for i := recordHeader; i < len(zeroed); i++ {
+				zeroed[i] = 0
+			}
+			return zeroed
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewFileStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			path, data := writtenLog(t, store, "room")
+			_, first, err := readRecord(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, append(data[:first:first], tt.tail(data[first:])...), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			room, err := loadRoom(t, store, "room")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sameKeys(t, room, "a")
+			truncated, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(truncated, data[:first]) {
+				t.Errorf("log holds %d bytes after loading, want the first record's %d", len(truncated), first)
+			}
+			// Appending continues after the last whole record.
+			set(t, store, room, "c", "3")
+			if err := store.Close(room); err != nil {
+				t.Fatal(err)
+			}
+			room, err = loadRoom(t, store, "room")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sameKeys(t, room, "a", "c")
+		})
+	}
+}
+
+func TestFileStoreRefusesCorruptLog(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, data := writtenLog(t, store, "room")
+	corrupt := append([]byte(nil), data...)
+	corrupt[recordHeader] ^= 0xff
+	if err := os.WriteFile(path, corrupt, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadRoom(t, store, "room"); !errors.Is(err, errCorruptRecord) {
+		t.Errorf("Load() error = %v, want %v", err, errCorruptRecord)
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, corrupt) {
+		t.Error("loading changed the corrupt log")
+	}
+}
+
+func TestFileStoreRefusesCorruptSnapshot(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	room, err := loadRoom(t, store, "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set(t, store, room, "a", "1")
+	if err := store.Close(room); err != nil {
+		t.Fatal(err)
+	}
+	path := store.path("room", ".snap")
+	snapshot, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot[len(snapshot)-1] ^= 0xff
+	if err := os.WriteFile(path, snapshot, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadRoom(t, store, "room"); err == nil {
+		t.Error("Load() succeeded with a corrupt snapshot")
+	}
+}
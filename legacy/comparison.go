@@ -0,0 +1,453 @@
+package reactflow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// ComparisonOperator is the normalized operator of a compiled comparison.
+type ComparisonOperator string
+
+const (
+	CompareEq       ComparisonOperator = "eq"
+	CompareNeq      ComparisonOperator = "neq"
+	CompareLt       ComparisonOperator = "lt"
+	CompareGt       ComparisonOperator = "gt"
+	CompareContains ComparisonOperator = "contains"
+	CompareIn       ComparisonOperator = "in"
+)
+
+// operatorAliases maps the operator spellings the editor has produced over
+// time onto the normalized set.
+var operatorAliases = map[string]ComparisonOperator{
+	"eq":           CompareEq,
+	"equals":       CompareEq,
+	"equal":        CompareEq,
+	"==":           CompareEq,
+	"neq":          CompareNeq,
+	"not_equals":   CompareNeq,
+	"notequals":    CompareNeq,
+	"!=":           CompareNeq,
+	"lt":           CompareLt,
+	"less_than":    CompareLt,
+	"before":       CompareLt,
+	"<":            CompareLt,
+	"gt":           CompareGt,
+	"greater_than": CompareGt,
+	"after":        CompareGt,
+	">":            CompareGt,
+	"contains":     CompareContains,
+	"in":           CompareIn,
+}
+
+// OperandKind says where a comparison operand takes its value from.
+type OperandKind string
+
+const (
+	OperandAttributeCategory OperandKind = "attribute_category"
+	OperandEntity            OperandKind = "entity"
+	OperandStatic            OperandKind = "static_info"
+)
+
+// Data types an operand can have.
+const (
+	DataTypeString  = "string"
+	DataTypeNumber  = "number"
+	DataTypeDate    = dateDataType
+	DataTypeBoolean = "boolean"
+)
+
+// Operand is one side of a compiled comparison.
+type Operand struct {
+	Kind                 OperandKind `json:"kind"`
+	AttributeCategory    int32       `json:"attributeCategory,omitempty"`
+	AttributeCategoryKey int32       `json:"attributeCategoryKey,omitempty"`
+	Entity               int32       `json:"entity,omitempty"`
+	DataType             string      `json:"dataType"`
+	// Value holds the typed static value, or the typed list for "in".
+	// Dates are held as written and parsed in the evaluation location.
+	Value interface{} `json:"value,omitempty"`
+}
+
+func (o Operand) String() string {
+	switch o.Kind {
+	case OperandAttributeCategory:
+		return fmt.Sprintf("attribute_category %d", o.AttributeCategoryKey)
+	case OperandEntity:
+		return fmt.Sprintf("entity %d", o.Entity)
+	}
+	return fmt.Sprintf("static %v", o.Value)
+}
+
+// Comparison is the typed form of the validate/validateWith fields of an
+// information-correction node.
+type Comparison struct {
+	Operator ComparisonOperator `json:"operator"`
+	Left     Operand            `json:"left"`
+	Right    Operand            `json:"right"`
+}
+
+// CompileComparison type checks the validate and validateWith sides of the
+// metadata and returns the comparison between them. Nodes that compare
+// against the call date through relativeOperation have no static
+// comparison; for them the result is nil and RelativeDateRuleFromMetadata
+// applies instead.
+func CompileComparison(metadata reactFlowTypes.Metadata) (*Comparison, error) {
+	if metadata.RelativeOperation != "" {
+		return nil, nil
+	}
+	if metadata.Validate == "" {
+		return nil, fmt.Errorf("validate is not set")
+	}
+	operator, ok := operatorAliases[strings.ToLower(strings.TrimSpace(metadata.Operator))]
+	if !ok {
+		return nil, fmt.Errorf("unsupported operator %q", metadata.Operator)
+	}
+	left, err := compileReference(metadata.Validate, metadata.ValidateFields, metadata.DataType)
+	if err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+	if err := checkOperator(operator, left.DataType); err != nil {
+		return nil, err
+	}
+	right, err := compileRightOperand(metadata, operator, left.DataType)
+	if err != nil {
+		return nil, fmt.Errorf("validateWith: %w", err)
+	}
+	if left.Kind != OperandStatic && right.Kind != OperandStatic && left.Kind != right.Kind {
+		return nil, fmt.Errorf("cannot compare %s with %s", left.Kind, right.Kind)
+	}
+	if left.Kind == right.Kind && left.AttributeCategoryKey == right.AttributeCategoryKey && left.Entity == right.Entity {
+		return nil, fmt.Errorf("%s is compared with itself", left)
+	}
+	if left.DataType != right.DataType {
+		return nil, fmt.Errorf("cannot compare %s (%s) with %s (%s)", left, left.DataType, right, right.DataType)
+	}
+	return &Comparison{Operator: operator, Left: left, Right: right}, nil
+}
+
+// RelativeDateReference returns the attribute category or entity that a
+// node with a relativeOperation compares against the call date.
+func RelativeDateReference(metadata reactFlowTypes.Metadata) (Operand, error) {
+	operand, err := compileReference(metadata.Validate, metadata.ValidateFields, metadata.DataType)
+	if err != nil {
+		return operand, fmt.Errorf("validate: %w", err)
+	}
+	if operand.DataType != DataTypeDate {
+		return operand, fmt.Errorf("relativeOperation requires dataType %q, got %q", DataTypeDate, operand.DataType)
+	}
+	return operand, nil
+}
+
+func compileReference(kind string, fields reactFlowTypes.ValidateFields, dataType string) (Operand, error) {
+	operand := Operand{Kind: OperandKind(kind), DataType: fieldsDataType(fields, dataType)}
+	if !knownDataType(operand.DataType) {
+		return operand, fmt.Errorf("unsupported dataType %q", operand.DataType)
+	}
+	switch operand.Kind {
+	case OperandAttributeCategory:
+		if fields.AttributeCategoryKey == 0 {
+			return operand, fmt.Errorf("attributeCategoryKey is not set")
+		}
+		operand.AttributeCategory = fields.AttributeCategory
+		operand.AttributeCategoryKey = fields.AttributeCategoryKey
+	case OperandEntity:
+		if fields.Entity == 0 {
+			return operand, fmt.Errorf("entity is not set")
+		}
+		operand.Entity = fields.Entity
+	case OperandStatic:
+		return operand, fmt.Errorf("static_info can only be compared against")
+	default:
+		return operand, fmt.Errorf("unsupported kind %q", kind)
+	}
+	return operand, nil
+}
+
+func compileRightOperand(metadata reactFlowTypes.Metadata, operator ComparisonOperator, leftType string) (Operand, error) {
+	if OperandKind(metadata.ValidateWith) != OperandStatic {
+		if operator == CompareIn {
+			return Operand{}, fmt.Errorf("operator in needs a static list")
+		}
+		return compileReference(metadata.ValidateWith, metadata.ValidateWithFields, metadata.DataType)
+	}
+	operand := Operand{Kind: OperandStatic, DataType: leftType}
+	if operator == CompareIn {
+		if len(metadata.List) == 0 {
+			return operand, fmt.Errorf("operator in needs a non-empty list")
+		}
+		values := make([]interface{}, 0, len(metadata.List))
+		for _, item := range metadata.List {
+			value, err := staticValue(item, leftType)
+			if err != nil {
+				return operand, err
+			}
+			values = append(values, value)
+		}
+		operand.Value = values
+		return operand, nil
+	}
+	if isEmptyValue(metadata.Value) {
+		return operand, fmt.Errorf("value is not set")
+	}
+	value, err := staticValue(metadata.Value, leftType)
+	if err != nil {
+		return operand, err
+	}
+	operand.Value = value
+	return operand, nil
+}
+
+// staticValue checks a static value against dataType and returns it in the
+// form Holds compares. Dates are kept as written: a date without a zone
+// names a calendar day in the zone of the tenant, which is only known when
+// the comparison is evaluated.
+func staticValue(value interface{}, dataType string) (interface{}, error) {
+	typed, err := CoerceValue(value, dataType, nil)
+	if err != nil {
+		return nil, err
+	}
+	if dataType == DataTypeDate {
+		return value, nil
+	}
+	return typed, nil
+}
+
+func knownDataType(dataType string) bool {
+	switch dataType {
+	case DataTypeString, DataTypeNumber, DataTypeDate, DataTypeBoolean:
+		return true
+	}
+	return false
+}
+
+func checkOperator(operator ComparisonOperator, dataType string) error {
+	switch operator {
+	case CompareLt, CompareGt:
+		if dataType != DataTypeNumber && dataType != DataTypeDate {
+			return fmt.Errorf("operator %s is not defined for dataType %s", operator, dataType)
+		}
+	case CompareContains:
+		if dataType != DataTypeString {
+			return fmt.Errorf("operator %s is not defined for dataType %s", operator, dataType)
+		}
+	}
+	return nil
+}
+
+// CoerceValue converts a raw attribute or static value to the Go type used
+// for dataType: string, float64, time.Time or bool. Dates are returned in
+// loc, so they compare by calendar day there.
+func CoerceValue(value interface{}, dataType string, loc *time.Location) (interface{}, error) {
+	switch dataType {
+	case DataTypeString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprint(value), nil
+	case DataTypeNumber:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case float32:
+			return float64(v), nil
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a number", v)
+			}
+			return f, nil
+		}
+		if i, ok := toInt(value); ok {
+			return float64(i), nil
+		}
+		return nil, fmt.Errorf("%v is not a number", value)
+	case DataTypeDate:
+		if loc == nil {
+			loc = time.UTC
+		}
+		// Dates compare by calendar day in loc, whatever zone they were
+		// written in.
+		date, err := ParseDateValue(value, loc)
+		if err != nil {
+			return nil, err
+		}
+		return date.In(loc), nil
+	case DataTypeBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a boolean", v)
+			}
+			return b, nil
+		}
+		return nil, fmt.Errorf("%v is not a boolean", value)
+	}
+	return nil, fmt.Errorf("unsupported dataType %q", dataType)
+}
+
+// Holds reports whether the comparison is true for the resolved left and
+// right values. Static operands ignore right and use their own value.
+func (c Comparison) Holds(left, right interface{}, loc *time.Location) (bool, error) {
+	l, err := CoerceValue(left, c.Left.DataType, loc)
+	if err != nil {
+		return false, fmt.Errorf("left: %w", err)
+	}
+	if c.Right.Kind == OperandStatic {
+		right = c.Right.Value
+	}
+	switch c.Operator {
+	case CompareIn:
+		list, _ := right.([]interface{})
+		for _, item := range list {
+			r, err := CoerceValue(item, c.Left.DataType, loc)
+			if err != nil {
+				return false, fmt.Errorf("right: %w", err)
+			}
+			if compareTyped(l, r) == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case CompareContains:
+		return strings.Contains(strings.ToLower(l.(string)), strings.ToLower(fmt.Sprint(right))), nil
+	}
+	r, err := CoerceValue(right, c.Right.DataType, loc)
+	if err != nil {
+		return false, fmt.Errorf("right: %w", err)
+	}
+	switch c.Operator {
+	case CompareEq:
+		return compareTyped(l, r) == 0, nil
+	case CompareNeq:
+		return compareTyped(l, r) != 0, nil
+	case CompareLt:
+		return compareTyped(l, r) < 0, nil
+	case CompareGt:
+		return compareTyped(l, r) > 0, nil
+	}
+	return false, fmt.Errorf("unsupported operator %q", c.Operator)
+}
+
+// compareTyped orders two values produced by CoerceValue for the same data
+// type. Strings compare case-insensitively; dates compare by calendar day.
+func compareTyped(a, b interface{}) int {
+	switch x := a.(type) {
+	case string:
+		return strings.Compare(strings.ToLower(x), strings.ToLower(b.(string)))
+	case float64:
+		y := b.(float64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case time.Time:
+		return sign(calendarDaysBetween(b.(time.Time), x, x.Location()))
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+func sign(i int) int {
+	switch {
+	case i < 0:
+		return -1
+	case i > 0:
+		return 1
+	}
+	return 0
+}
+
+// Configuration returns the comparison in the normalized configuration
+// shape stored under the "comparison" key of a validateInfo rule node.
+func (c Comparison) Configuration() types.Configuration {
+	configuration := types.Configuration{
+		"operator": string(c.Operator),
+		"left":     c.Left.configuration(),
+		"right":    c.Right.configuration(),
+	}
+	return configuration
+}
+
+func (o Operand) configuration() map[string]interface{} {
+	configuration := map[string]interface{}{
+		"kind":     string(o.Kind),
+		"dataType": o.DataType,
+	}
+	switch o.Kind {
+	case OperandAttributeCategory:
+		configuration["attributeCategoryKey"] = o.AttributeCategoryKey
+		if o.AttributeCategory != 0 {
+			configuration["attributeCategory"] = o.AttributeCategory
+		}
+	case OperandEntity:
+		configuration["entity"] = o.Entity
+	case OperandStatic:
+		configuration["value"] = normalizedValue(o.Value)
+	}
+	return configuration
+}
+
+func normalizedValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			list = append(list, normalizedValue(item))
+		}
+		return list
+	}
+	return value
+}
+
+// CompileChainComparisons compiles every validateInfo node of a converted
+// chain and stores the result under its "comparison" configuration key.
+// Nodes that fail to compile are left untouched and reported as NodeErrors.
+func CompileChainComparisons(ruleChain *types.RuleChain) error {
+	var errs ErrorList
+	for _, node := range ruleChain.Metadata.Nodes {
+		if node == nil || node.Type != "validateInfo" {
+			continue
+		}
+		comparison, err := CompileComparisonConfiguration(node.Configuration)
+		if err != nil {
+			errs = append(errs, &NodeError{NodeID: node.Id, Err: err})
+			continue
+		}
+		if comparison != nil {
+			node.Configuration["comparison"] = comparison.Configuration()
+		}
+	}
+	return errs.Err()
+}
+
+// CompileComparisonConfiguration is CompileComparison for a converted rule
+// node configuration.
+func CompileComparisonConfiguration(configuration types.Configuration) (*Comparison, error) {
+	metadata, err := reactFlowTypes.ConfigurationToMetadata(configuration)
+	if err != nil {
+		return nil, err
+	}
+	return CompileComparison(metadata)
+}
@@ -0,0 +1,176 @@
+package reactflow
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+func TestCompileComparison(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string
+		wantErr  bool
+		wantNil  bool
+	}{
+		{"static number", `{"validate":"attribute_category","validateFields":{"attributeCategoryKey":3},"validateWith":"static_info","operator":"greater_than","dataType":"number","value":"10"}`, false, false},
+		{"static list", `{"validate":"entity","validateFields":{"entity":7},"validateWith":"static_info","operator":"in","dataType":"string","list":["a","b"]}`, false, false},
+		{"two entities", `{"validate":"entity","validateFields":{"entity":7},"validateWith":"entity","validateWithFields":{"entity":8},"operator":"eq","dataType":"date"}`, false, false},
+		{"relative date", `{"validate":"entity","validateFields":{"entity":7},"relativeOperation":"before","dataType":"date"}`, false, true},
+		{"no validate", `{"operator":"eq","dataType":"string","value":"x"}`, true, false},
+		{"unknown operator", `{"validate":"entity","validateFields":{"entity":7},"validateWith":"static_info","operator":"like","dataType":"string","value":"x"}`, true, false},
+		{"contains on a number", `{"validate":"entity","validateFields":{"entity":7},"validateWith":"static_info","operator":"contains","dataType":"number","value":"1"}`, true, false},
+		{"value of the wrong type", `{"validate":"entity","validateFields":{"entity":7},"validateWith":"static_info","operator":"eq","dataType":"number","value":"ten"}`, true, false},
+		{"compared with itself", `{"validate":"entity","validateFields":{"entity":7},"validateWith":"entity","validateWithFields":{"entity":7},"operator":"eq","dataType":"string"}`, true, false},
+		{"entity with an attribute", `{"validate":"entity","validateFields":{"entity":7},"validateWith":"attribute_category","validateWithFields":{"attributeCategoryKey":3},"operator":"eq","dataType":"string"}`, true, false},
+		{"mixed data types", `{"validate":"entity","validateFields":{"entity":7,"dataType":"date"},"validateWith":"entity","validateWithFields":{"entity":8},"operator":"eq","dataType":"string"}`, true, false},
+		{"in without a list", `{"validate":"entity","validateFields":{"entity":7},"validateWith":"static_info","operator":"in","dataType":"string"}`, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comparison, err := CompileComparison(metadataOf(t, tt.metadata))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CompileComparison() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (comparison == nil) != tt.wantNil {
+				t.Errorf("CompileComparison() = %v, want nil %v", comparison, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestHolds(t *testing.T) {
+	static := func(raw string) Comparison {
+		comparison, err := CompileComparison(metadataOf(t, raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *comparison
+	}
+	tests := []struct {
+		name        string
+		comparison  Comparison
+		left, right interface{}
+		want        bool
+		wantErr     bool
+	}{
+		{"number greater", static(`{"validate":"entity","validateFields":{"entity":7},"validateWith":"static_info","operator":">","dataType":"number","value":10}`), "10.5", nil, true, false},
+		{"number not greater", static(`{"validate":"entity","validateFields":{"entity":7},"validateWith":"static_info","operator":">","dataType":"number","value":10}`), 10, nil, false, false},
+		{"string in list", static(`{"validate":"entity","validateFields":{"entity":7},"validateWith":"static_info","operator":"in","dataType":"string","list":["yes","no"]}`), "no", nil, true, false},
+		{"contains ignores case", static(`{"validate":"entity","validateFields":{"entity":7},"validateWith":"static_info","operator":"contains","dataType":"string","value":"Hold"}`), "put on HOLD", nil, true, false},
+		{"date before", static(`{"validate":"entity","validateFields":{"entity":7},"validateWith":"static_info","operator":"before","dataType":"date","value":"2024-05-10"}`), "2024-05-09", nil, true, false},
+		{"boolean", static(`{"validate":"entity","validateFields":{"entity":7},"validateWith":"static_info","operator":"neq","dataType":"boolean","value":true}`), "false", nil, true, false},
+		{"entities equal", static(`{"validate":"entity","validateFields":{"entity":7},"validateWith":"entity","validateWithFields":{"entity":8},"operator":"eq","dataType":"number"}`), 3, 3.0, true, false},
+		{"not a number", static(`{"validate":"entity","validateFields":{"entity":7},"validateWith":"static_info","operator":"eq","dataType":"number","value":1}`), "one", nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.comparison.Holds(tt.left, tt.right, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Holds() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Holds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelativeDateReference(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string
+		want     Operand
+		wantErr  bool
+	}{
+		{"entity", `{"validate":"entity","validateFields":{"entity":7},"relativeOperation":"after","dataType":"date"}`, Operand{Kind: OperandEntity, Entity: 7, DataType: DataTypeDate}, false},
+		{"field data type wins", `{"validate":"attribute_category","validateFields":{"attributeCategoryKey":3,"dataType":"date"},"relativeOperation":"after","dataType":"string"}`, Operand{Kind: OperandAttributeCategory, AttributeCategoryKey: 3, DataType: DataTypeDate}, false},
+		{"not a date", `{"validate":"entity","validateFields":{"entity":7},"relativeOperation":"after","dataType":"number"}`, Operand{}, true},
+		{"no entity", `{"validate":"entity","relativeOperation":"after","dataType":"date"}`, Operand{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RelativeDateReference(metadataOf(t, tt.metadata))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RelativeDateReference() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("RelativeDateReference() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHoldsInTenantZone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	compile := func(operator, value string) Comparison {
+		comparison, err := CompileComparison(metadataOf(t, `{"validate":"entity","validateFields":{"entity":7},"validateWith":"static_info","dataType":"date","operator":"`+operator+`","value":"`+value+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *comparison
+	}
+	tests := []struct {
+		name       string
+		comparison Comparison
+		left       interface{}
+		want       bool
+	}{
+		{"same day", compile("equals", "2024-05-01"), "2024-05-01", true},
+		{"day before", compile("before", "2024-05-01"), "2024-04-30", true},
+		{"same day is not before", compile("before", "2024-05-01"), "2024-05-01", false},
+		{"day after", compile("after", "2024-05-01"), "2024-05-02", true},
+		// 02:00 UTC on the 2nd is still the 1st in New York.
+		{"instant on the same local day", compile("equals", "2024-05-01"), "2024-05-02T02:00:00Z", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.comparison.Holds(tt.left, nil, newYork)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Holds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCompileChainComparisonsOnExample guards stored rules: conversion
+// fails when a validateInfo node does not compile, so every graph of the
+// example document must.
+func TestCompileChainComparisonsOnExample(t *testing.T) {
+	data, err := os.ReadFile("../tests/example_small.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rule reactFlowTypes.QuestionRule
+	if err := json.Unmarshal(data, &rule); err != nil {
+		t.Fatal(err)
+	}
+	graphs := append(append([]reactFlowTypes.Graph(nil), rule.Config...), rule.DraftConfig...)
+	compiled := 0
+	for i, graph := range graphs {
+		chain, err := ConvertChecked(graph, rule.TenantID)
+		if err != nil {
+			t.Fatalf("graph %d: %v", i, err)
+		}
+		if err := CompileChainComparisons(&chain); err != nil {
+			t.Errorf("graph %d: %v", i, err)
+		}
+		for _, node := range chain.Metadata.Nodes {
+			if node != nil && node.Configuration["comparison"] != nil {
+				compiled++
+			}
+		}
+	}
+	if compiled == 0 {
+		t.Error("no comparison was compiled; the example should exercise some")
+	}
+}
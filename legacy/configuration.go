@@ -0,0 +1,143 @@
+package reactflow
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+)
+
+// The accessors below read values out of a rule node configuration. A
+// configuration built by ConvertFlowToRuleEngineDSL holds concrete Go types
+// ([]string, []map[string]string, int), while one loaded back from JSON or
+// DynamoDB holds []interface{}, map[string]interface{} and float64. The
+// accessors accept both so callers do not care where the chain came from.
+
+// SingleBlockEdge is one entry of a singleBlock "edges" configuration.
+type SingleBlockEdge struct {
+	SourceNode string
+	TargetNode string
+	Operator   string
+}
+
+// ConfigString returns configuration[key] as a string, or "" when it is
+// missing or not a string.
+func ConfigString(configuration types.Configuration, key string) string {
+	s, _ := configuration[key].(string)
+	return s
+}
+
+// ConfigBool returns configuration[key] as a bool, or false when it is
+// missing or not a bool.
+func ConfigBool(configuration types.Configuration, key string) bool {
+	b, _ := configuration[key].(bool)
+	return b
+}
+
+// ConfigInt returns configuration[key] as an int. The second result is
+// false when the key is missing or does not hold a whole number.
+func ConfigInt(configuration types.Configuration, key string) (int, bool) {
+	return toInt(configuration[key])
+}
+
+func toInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), v == math.Trunc(v)
+	case float32:
+		return int(v), float64(v) == math.Trunc(float64(v))
+	case json.Number:
+		i, err := v.Int64()
+		return int(i), err == nil
+	case string:
+		i, err := strconv.Atoi(v)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// ConfigStrings returns configuration[key] as a list of strings, skipping
+// any element that is not a string.
+func ConfigStrings(configuration types.Configuration, key string) []string {
+	switch v := configuration[key].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// ConfigInts returns configuration[key] as a list of ints, skipping any
+// element that is not a number.
+func ConfigInts(configuration types.Configuration, key string) []int {
+	switch v := configuration[key].(type) {
+	case []int:
+		return v
+	case []interface{}:
+		list := make([]int, 0, len(v))
+		for _, item := range v {
+			if i, ok := toInt(item); ok {
+				list = append(list, i)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// ConfigMap returns configuration[key] as a nested configuration, or nil
+// when it is missing or not an object.
+func ConfigMap(configuration types.Configuration, key string) types.Configuration {
+	switch v := configuration[key].(type) {
+	case types.Configuration:
+		return v
+	case map[string]interface{}:
+		return v
+	}
+	return nil
+}
+
+// ConfigEdges returns the "edges" configuration of a singleBlock node.
+func ConfigEdges(configuration types.Configuration) []SingleBlockEdge {
+	var edges []SingleBlockEdge
+	switch v := configuration["edges"].(type) {
+	case []map[string]string:
+		for _, edge := range v {
+			edges = append(edges, SingleBlockEdge{
+				SourceNode: edge["SourceNode"],
+				TargetNode: edge["TargetNode"],
+				Operator:   edge["Operator"],
+			})
+		}
+	case []interface{}:
+		for _, item := range v {
+			edge, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			source, _ := edge["SourceNode"].(string)
+			target, _ := edge["TargetNode"].(string)
+			operator, _ := edge["Operator"].(string)
+			edges = append(edges, SingleBlockEdge{
+				SourceNode: source,
+				TargetNode: target,
+				Operator:   operator,
+			})
+		}
+	}
+	return edges
+}
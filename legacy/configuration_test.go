@@ -0,0 +1,60 @@
+package reactflow
+
+import (
+	"encoding/json"
+	"testing"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+)
+
+func TestConfigInt(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  interface{}
+		want   int
+		wantOK bool
+	}{
+		{"int", 2, 2, true},
+		{"whole float", 2.0, 2, true},
+		{"fractional float", 2.5, 0, false},
+		{"fractional float32", float32(0.5), 0, false},
+		{"json number", json.Number("3"), 3, true},
+		{"fractional json number", json.Number("3.5"), 0, false},
+		{"string", "4", 4, true},
+		{"missing", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ConfigInt(types.Configuration{"n": tt.value}, "n")
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("ConfigInt() = %d, %v; want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRelativeDateRuleFromConfiguration(t *testing.T) {
+	tests := []struct {
+		name    string
+		days    interface{}
+		want    int
+		wantErr bool
+	}{
+		{"whole days", 2, 2, false},
+		{"whole days from JSON", 2.0, 2, false},
+		{"no days", nil, 0, false},
+		{"fractional days", 2.5, 0, true},
+		{"negative days", -1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := RelativeDateRuleFromConfiguration(types.Configuration{"relativeOperation": "after", "relativeDays": tt.days})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RelativeDateRuleFromConfiguration() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && rule.Days != tt.want {
+				t.Errorf("Days = %d, want %d", rule.Days, tt.want)
+			}
+		})
+	}
+}
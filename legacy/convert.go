@@ -7,8 +7,6 @@ import (
 	"strings"
 
 	"bitbucket.org/convin/go_services/rule_engine/api/types"
-	"bitbucket.org/convin/go_services/rule_engine/configs"
-	"bitbucket.org/convin/go_services/rule_engine/internal/repository/dynamodb"
 	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
 	"bitbucket.org/convin/go_services/rule_engine/pkg/model"
 	"go.uber.org/zap"
@@ -421,7 +419,16 @@ func ConvertFlowToRuleEngineDSL(graph reactFlowTypes.Graph, tenantID string) (ty
 	return ruleChain, nil
 }
 
+// GetRuleMetadata computes the dependencies of rule chains, looking the
+// moments they use up in DynamoDB.
 func GetRuleMetadata(ruleChains []types.RuleChain, parameterID int32, tenantID string) (map[string]interface{}, error) {
+	return RuleMetadata(context.TODO(), DynamoMomentStore(), ruleChains, parameterID, tenantID)
+}
+
+// RuleMetadata computes the dependencies of rule chains: the parameters
+// and moments they read, and the moments those moments depend on, looked
+// up in store.
+func RuleMetadata(ctx context.Context, store MomentStore, ruleChains []types.RuleChain, parameterID int32, tenantID string) (map[string]interface{}, error) {
 	// TODO: Optimize this
 	metadata := make(map[string]interface{})
 	dependentParameters := []int{}
@@ -431,13 +438,17 @@ func GetRuleMetadata(ruleChains []types.RuleChain, parameterID int32, tenantID s
 			if node.Type == "attribute" {
 				attributeType, ok := node.Configuration["attribute_type"]
 				if ok && attributeType == "parameter" {
-					dependentParameters = append(dependentParameters, node.Configuration["parameter_id"].(int))
+					parameter, ok := ConfigInt(node.Configuration, "parameter_id")
+					if !ok {
+						return nil, fmt.Errorf("parameter id not found")
+					}
+					dependentParameters = append(dependentParameters, parameter)
 				}
 			}
 			if node.Type == "moment" {
-				momentID, ok := node.Configuration["id"]
+				momentID, ok := node.Configuration["id"].(string)
 				if ok {
-					momentIDs = append(momentIDs, momentID.(string))
+					momentIDs = append(momentIDs, momentID)
 				} else {
 					return nil, fmt.Errorf("moment id not found")
 				}
@@ -449,11 +460,6 @@ func GetRuleMetadata(ruleChains []types.RuleChain, parameterID int32, tenantID s
 	if len(momentIDs) == 0 {
 		return metadata, nil
 	}
-	config := configs.GetAppConfig()
-	repo, err := dynamodb.NewRepository(&config, false)
-	if err != nil {
-		return nil, err
-	}
 	// Do it batches of 90 moments at a time
 	momentBatches := [][]string{}
 	for i := 0; i < len(momentIDs); i += 90 {
@@ -465,8 +471,10 @@ func GetRuleMetadata(ruleChains []types.RuleChain, parameterID int32, tenantID s
 	}
 	var moments []model.Moment
 	for _, momentBatch := range momentBatches {
-
-		momentsBatch, err := repo.BatchGetMoments(context.TODO(), momentBatch, tenantID)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		momentsBatch, err := store.BatchGetMoments(ctx, momentBatch, tenantID)
 		if err != nil {
 			return nil, err
 		}
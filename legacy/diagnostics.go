@@ -0,0 +1,109 @@
+package reactflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/schema"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Diagnostic is one problem found in a graph, in a shape editors can show
+// next to the node (NodeID) or field (Path, a JSON Pointer) it concerns.
+// Code is set on the findings of package lint, e.g. "L001".
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code,omitempty"`
+	NodeID   string `json:"node_id,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Message  string `json:"message"`
+}
+
+// HasErrors reports whether any diagnostic has error severity.
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateGraph runs every check a graph must pass before it can be
+// published: the graph schema, the conversion itself, and the relative date
+// and comparison rules of each validateInfo node.
+func ValidateGraph(graph reactFlowTypes.Graph, tenantID string) []Diagnostic {
+	var diagnostics []Diagnostic
+	payload, err := json.Marshal(graph)
+	if err != nil {
+		return []Diagnostic{{Severity: SeverityError, Message: err.Error()}}
+	}
+	violations, err := schema.ValidateJSON(payload)
+	if err != nil {
+		return []Diagnostic{{Severity: SeverityError, Message: err.Error()}}
+	}
+	for _, violation := range violations {
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: SeverityError,
+			Path:     violation.Path,
+			Message:  violation.Message,
+		})
+	}
+	// The converter switches on the kind of the ID and cannot run without one;
+	// the schema has already reported it.
+	if graph.ID == nil {
+		return diagnostics
+	}
+
+	ruleChain, err := ConvertChecked(graph, tenantID)
+	if err != nil {
+		diagnostics = append(diagnostics, errorDiagnostic(err))
+		return diagnostics
+	}
+	for _, node := range ruleChain.Metadata.Nodes {
+		if node == nil || node.Type != "validateInfo" {
+			continue
+		}
+		metadata, err := reactFlowTypes.ConfigurationToMetadata(node.Configuration)
+		if err != nil {
+			diagnostics = append(diagnostics, errorDiagnostic(&NodeError{NodeID: node.Id, Err: err}))
+			continue
+		}
+		for _, err := range ValidateRelativeDate(metadata) {
+			diagnostics = append(diagnostics, errorDiagnostic(&NodeError{NodeID: node.Id, Err: err}))
+		}
+		if _, err := CompileComparison(metadata); err != nil {
+			diagnostics = append(diagnostics, errorDiagnostic(&NodeError{NodeID: node.Id, Err: err}))
+		}
+	}
+	return diagnostics
+}
+
+// ConvertChecked runs the converter on a graph that may be half-built, as
+// graphs from the live editor are. The converter indexes into the graph
+// without checking its shape, so a panic is reported as an error.
+func ConvertChecked(graph reactFlowTypes.Graph, tenantID string) (ruleChain types.RuleChain, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("graph cannot be converted: %v", r)
+		}
+	}()
+	return ConvertFlowToRuleEngineDSL(graph, tenantID)
+}
+
+func errorDiagnostic(err error) Diagnostic {
+	diagnostic := Diagnostic{Severity: SeverityError, Message: err.Error()}
+	var nodeErr *NodeError
+	if errors.As(err, &nodeErr) {
+		diagnostic.NodeID = nodeErr.NodeID
+		diagnostic.Message = nodeErr.Err.Error()
+	}
+	return diagnostic
+}
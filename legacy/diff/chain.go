@@ -0,0 +1,223 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"go.uber.org/zap"
+)
+
+// unorderedKeys lists the configuration lists whose order the rule engine
+// ignores, by node type ("" applies to every type). The NodeIdList of a
+// container is ordered: it is the order blocks are tried in.
+var unorderedKeys = map[string][]string{
+	"":            {"GroupNodeIDs", "attribute", "list"},
+	"singleBlock": {"NodeIdList"},
+}
+
+// editorKeys are the configuration keys the converter copies from the
+// editor metadata but the rule engine never reads: the display name, and
+// the blocks and member nodes of containers, which reach the engine as
+// NodeIdList, GroupNodeIDs and rule nodes of their own.
+var editorKeys = []string{"name", "blocks", "nodes"}
+
+// NodeChange is one added, removed or modified rule node. Fields are the
+// changed configuration keys, dotted for nested maps, plus "type".
+type NodeChange struct {
+	Op     string        `json:"op"`
+	ID     string        `json:"id"`
+	Type   string        `json:"type,omitempty"`
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// ConnectionChange is one added or removed connection.
+type ConnectionChange struct {
+	Op string `json:"op"`
+	types.NodeConnection
+}
+
+// ChainDiff is the behavioral difference between two rule chains. Names,
+// debug flags and the order of nodes and connections are not reported.
+type ChainDiff struct {
+	// FirstNode is set when the chain starts from a different node.
+	FirstNode   *FieldChange       `json:"first_node,omitempty"`
+	Nodes       []NodeChange       `json:"nodes"`
+	Connections []ConnectionChange `json:"connections"`
+}
+
+// Empty reports whether the chains behave the same.
+func (d *ChainDiff) Empty() bool {
+	return d.FirstNode == nil && len(d.Nodes) == 0 && len(d.Connections) == 0
+}
+
+// Summary renders the diff as text in the style of GraphDiff.Summary.
+func (d *ChainDiff) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d node changes, %d connection changes\n", len(d.Nodes), len(d.Connections))
+	if d.FirstNode != nil {
+		fmt.Fprintf(&b, "~ first node %s\n", d.FirstNode)
+	}
+	for _, node := range d.Nodes {
+		marker := map[string]string{Added: "+", Removed: "-", Modified: "~"}[node.Op]
+		fmt.Fprintf(&b, "%s node %s (%s)\n", marker, node.ID, node.Type)
+		for _, field := range node.Fields {
+			fmt.Fprintf(&b, "    %s\n", field)
+		}
+	}
+	for _, connection := range d.Connections {
+		marker := map[string]string{Added: "+", Removed: "-"}[connection.Op]
+		fmt.Fprintf(&b, "%s connection %s -> %s (%s)\n", marker, connection.FromId, connection.ToId, connection.Type)
+	}
+	return b.String()
+}
+
+// RuleChains compares chain a with chain b. Nodes are matched by ID and
+// their configurations normalized before comparing: numbers compare by
+// value whether they were decoded as floats or ints, unset and zero values
+// are the same, and lists in unorderedKeys compare as sets. Connections
+// compare as sets.
+func RuleChains(a, b types.RuleChain) *ChainDiff {
+	result := &ChainDiff{Nodes: []NodeChange{}, Connections: []ConnectionChange{}}
+	if first, second := firstNodeID(a), firstNodeID(b); first != second {
+		result.FirstNode = &FieldChange{Field: "first_node", Old: first, New: second}
+	}
+
+	before := ruleNodesByID(a)
+	for _, node := range b.Metadata.Nodes {
+		if node == nil {
+			continue
+		}
+		old, ok := before[node.Id]
+		if !ok {
+			result.Nodes = append(result.Nodes, NodeChange{Op: Added, ID: node.Id, Type: node.Type})
+			continue
+		}
+		if fields := diffFields(ruleNodeFields(old), ruleNodeFields(node)); len(fields) > 0 {
+			result.Nodes = append(result.Nodes, NodeChange{Op: Modified, ID: node.Id, Type: node.Type, Fields: fields})
+		}
+	}
+	after := ruleNodesByID(b)
+	for _, node := range a.Metadata.Nodes {
+		if node == nil {
+			continue
+		}
+		if _, ok := after[node.Id]; !ok {
+			result.Nodes = append(result.Nodes, NodeChange{Op: Removed, ID: node.Id, Type: node.Type})
+		}
+	}
+
+	beforeConnections := connectionSet(a.Metadata.Connections)
+	afterConnections := connectionSet(b.Metadata.Connections)
+	for _, connection := range sortedConnections(afterConnections) {
+		if !beforeConnections[connection] {
+			result.Connections = append(result.Connections, ConnectionChange{Op: Added, NodeConnection: connection})
+		}
+	}
+	for _, connection := range sortedConnections(beforeConnections) {
+		if !afterConnections[connection] {
+			result.Connections = append(result.Connections, ConnectionChange{Op: Removed, NodeConnection: connection})
+		}
+	}
+	return result
+}
+
+func firstNodeID(chain types.RuleChain) string {
+	index := chain.Metadata.FirstNodeIndex
+	if index < 0 || index >= len(chain.Metadata.Nodes) || chain.Metadata.Nodes[index] == nil {
+		return ""
+	}
+	return chain.Metadata.Nodes[index].Id
+}
+
+func ruleNodesByID(chain types.RuleChain) map[string]*types.RuleNode {
+	nodes := make(map[string]*types.RuleNode, len(chain.Metadata.Nodes))
+	for _, node := range chain.Metadata.Nodes {
+		if node == nil {
+			continue
+		}
+		if _, ok := nodes[node.Id]; ok {
+			zap.L().Warn("Duplicate rule node ID in chain:", zap.String("chain_id", chain.RuleChain.ID), zap.String("id", node.Id))
+		}
+		nodes[node.Id] = node
+	}
+	return nodes
+}
+
+// ruleNodeFields flattens the normalized configuration of a node, without
+// the editorKeys.
+func ruleNodeFields(node *types.RuleNode) map[string]interface{} {
+	fields := make(map[string]interface{})
+	data, err := json.Marshal(node.Configuration)
+	if err != nil {
+		zap.L().Error("Error marshaling rule node configuration:", zap.String("id", node.Id), zap.Error(err))
+		return fields
+	}
+	var configuration map[string]interface{}
+	if err := json.Unmarshal(data, &configuration); err != nil {
+		zap.L().Error("Error unmarshaling rule node configuration:", zap.String("id", node.Id), zap.Error(err))
+		return fields
+	}
+	for _, key := range editorKeys {
+		delete(configuration, key)
+	}
+	for _, key := range append(unorderedKeys[""], unorderedKeys[node.Type]...) {
+		if list, ok := configuration[key].([]interface{}); ok {
+			sort.Slice(list, func(i, j int) bool { return compactJSON(list[i]) < compactJSON(list[j]) })
+		}
+	}
+	flattenInto(fields, "", configuration)
+	for key, value := range fields {
+		if isZero(value) {
+			delete(fields, key)
+		}
+	}
+	fields["type"] = node.Type
+	return fields
+}
+
+func isZero(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+func connectionSet(connections []types.NodeConnection) map[types.NodeConnection]bool {
+	set := make(map[types.NodeConnection]bool, len(connections))
+	for _, connection := range connections {
+		set[connection] = true
+	}
+	return set
+}
+
+func sortedConnections(set map[types.NodeConnection]bool) []types.NodeConnection {
+	connections := make([]types.NodeConnection, 0, len(set))
+	for connection := range set {
+		connections = append(connections, connection)
+	}
+	sort.Slice(connections, func(i, j int) bool {
+		a, b := connections[i], connections[j]
+		if a.FromId != b.FromId {
+			return a.FromId < b.FromId
+		}
+		if a.ToId != b.ToId {
+			return a.ToId < b.ToId
+		}
+		return a.Type < b.Type
+	})
+	return connections
+}
@@ -0,0 +1,110 @@
+package diff
+
+import (
+	"testing"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+)
+
+func chainOf(nodes ...*types.RuleNode) types.RuleChain {
+	return types.RuleChain{Metadata: types.RuleMetadata{Nodes: nodes}}
+}
+
+func TestRuleChains(t *testing.T) {
+	conditional := func(configuration types.Configuration) *types.RuleNode {
+		return &types.RuleNode{Id: "c", Type: "conditionalBlock", Configuration: configuration}
+	}
+	tests := []struct {
+		name       string
+		a, b       types.RuleChain
+		wantFields []string
+	}{
+		{
+			name:       "renamed",
+			a:          chainOf(conditional(types.Configuration{"name": "old", "NodeIdList": []interface{}{"a"}})),
+			b:          chainOf(conditional(types.Configuration{"name": "new", "NodeIdList": []interface{}{"a"}})),
+			wantFields: nil,
+		},
+		{
+			name: "editor blocks changed",
+			a: chainOf(conditional(types.Configuration{
+				"blocks":     []interface{}{map[string]interface{}{"id": "a", "position": map[string]interface{}{"x": 1}}},
+				"NodeIdList": []interface{}{"a"},
+			})),
+			b: chainOf(conditional(types.Configuration{
+				"blocks":     []interface{}{map[string]interface{}{"id": "a", "position": map[string]interface{}{"x": 2}}},
+				"NodeIdList": []interface{}{"a"},
+			})),
+			wantFields: nil,
+		},
+		{
+			name:       "numbers and zero values",
+			a:          chainOf(conditional(types.Configuration{"parameter": 3, "is_not": false})),
+			b:          chainOf(conditional(types.Configuration{"parameter": 3.0})),
+			wantFields: nil,
+		},
+		{
+			name:       "unordered attribute list",
+			a:          chainOf(conditional(types.Configuration{"attribute": []interface{}{1, 2}})),
+			b:          chainOf(conditional(types.Configuration{"attribute": []interface{}{2, 1}})),
+			wantFields: nil,
+		},
+		{
+			name:       "block order",
+			a:          chainOf(conditional(types.Configuration{"NodeIdList": []interface{}{"a", "b"}})),
+			b:          chainOf(conditional(types.Configuration{"NodeIdList": []interface{}{"b", "a"}})),
+			wantFields: []string{"NodeIdList"},
+		},
+		{
+			name:       "negated",
+			a:          chainOf(conditional(types.Configuration{"is_not": false})),
+			b:          chainOf(conditional(types.Configuration{"is_not": true})),
+			wantFields: []string{"is_not"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := RuleChains(tt.a, tt.b)
+			var fields []string
+			for _, node := range d.Nodes {
+				for _, field := range node.Fields {
+					fields = append(fields, field.Field)
+				}
+			}
+			if len(fields) != len(tt.wantFields) {
+				t.Fatalf("changed fields %v, want %v", fields, tt.wantFields)
+			}
+			for i := range fields {
+				if fields[i] != tt.wantFields[i] {
+					t.Errorf("changed fields %v, want %v", fields, tt.wantFields)
+				}
+			}
+			if d.Empty() != (len(tt.wantFields) == 0) {
+				t.Errorf("Empty() = %v with changes %v", d.Empty(), fields)
+			}
+		})
+	}
+}
+
+func TestRuleChainsNodesAndConnections(t *testing.T) {
+	a := chainOf(&types.RuleNode{Id: "a", Type: "moment"}, &types.RuleNode{Id: "b", Type: "moment"})
+	a.Metadata.Connections = []types.NodeConnection{{FromId: "a", ToId: "b", Type: "True"}}
+	b := chainOf(&types.RuleNode{Id: "b", Type: "moment"}, &types.RuleNode{Id: "c", Type: "moment"})
+	b.Metadata.FirstNodeIndex = 1
+	b.Metadata.Connections = []types.NodeConnection{{FromId: "b", ToId: "c", Type: "True"}}
+
+	d := RuleChains(a, b)
+	if d.FirstNode == nil || d.FirstNode.Old != "a" || d.FirstNode.New != "c" {
+		t.Errorf("FirstNode = %v, want a -> c", d.FirstNode)
+	}
+	var ops []string
+	for _, node := range d.Nodes {
+		ops = append(ops, node.Op+" "+node.ID)
+	}
+	if len(ops) != 2 || ops[0] != Added+" c" || ops[1] != Removed+" a" {
+		t.Errorf("node changes %v, want [%s c %s a]", ops, Added, Removed)
+	}
+	if len(d.Connections) != 2 || d.Connections[0].Op != Added || d.Connections[1].Op != Removed {
+		t.Errorf("connection changes %+v, want one added and one removed", d.Connections)
+	}
+}
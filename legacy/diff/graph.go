@@ -0,0 +1,316 @@
+// Package diff compares rule definitions: Graphs structurally, element by
+// element as the editor shows them, and RuleChains semantically, by the
+// behavior the rule engine would see.
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+	"go.uber.org/zap"
+)
+
+const (
+	Added    = "added"
+	Removed  = "removed"
+	Modified = "modified"
+)
+
+const (
+	KindNode  = "node"
+	KindBlock = "block"
+	KindEdge  = "edge"
+)
+
+// GraphOptions tunes a graph diff.
+type GraphOptions struct {
+	// IncludeLayout also reports position, size and other editor-only
+	// changes. By default only fields that survive storage are compared.
+	IncludeLayout bool
+}
+
+// FieldChange is one changed field of an element. Field is the dotted JSON
+// path inside the element, e.g. "data.metadata.id". Added and Removed tell
+// a field that was added or removed from one set to or from null, which
+// both leave Old or New nil.
+type FieldChange struct {
+	Field   string      `json:"field"`
+	Old     interface{} `json:"old"`
+	New     interface{} `json:"new"`
+	Added   bool        `json:"added,omitempty"`
+	Removed bool        `json:"removed,omitempty"`
+}
+
+// ElementChange is one added, removed or modified node, block or edge.
+// Parent is the ID of the group or container holding the element, empty
+// for the canvas.
+type ElementChange struct {
+	Op     string        `json:"op"`
+	Kind   string        `json:"kind"`
+	ID     string        `json:"id"`
+	Type   string        `json:"type,omitempty"`
+	Parent string        `json:"parent,omitempty"`
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// GraphDiff is the structural difference between two graphs.
+type GraphDiff struct {
+	Changes []ElementChange `json:"changes"`
+}
+
+// Empty reports whether the graphs are equivalent.
+func (d *GraphDiff) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+// Summary renders the diff as text, one line per element and one indented
+// line per field, e.g.
+//
+//	~ block b3 (moment) in c1
+//	    data.is_not: false -> true
+func (d *GraphDiff) Summary() string {
+	counts := make(map[string]int)
+	for _, change := range d.Changes {
+		counts[change.Op]++
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d added, %d removed, %d modified\n", counts[Added], counts[Removed], counts[Modified])
+	for _, change := range d.Changes {
+		b.WriteString(change.String())
+		b.WriteString("\n")
+		for _, field := range change.Fields {
+			fmt.Fprintf(&b, "    %s\n", field)
+		}
+	}
+	return b.String()
+}
+
+func (c ElementChange) String() string {
+	marker := map[string]string{Added: "+", Removed: "-", Modified: "~"}[c.Op]
+	line := fmt.Sprintf("%s %s %s", marker, c.Kind, c.ID)
+	if c.Type != "" {
+		line += " (" + c.Type + ")"
+	}
+	if c.Parent != "" {
+		line += " in " + c.Parent
+	}
+	return line
+}
+
+func (f FieldChange) String() string {
+	switch {
+	case f.Added:
+		return fmt.Sprintf("%s: + %s", f.Field, compactJSON(f.New))
+	case f.Removed:
+		return fmt.Sprintf("%s: - %s", f.Field, compactJSON(f.Old))
+	}
+	return fmt.Sprintf("%s: %s -> %s", f.Field, compactJSON(f.Old), compactJSON(f.New))
+}
+
+// Graphs compares graph a with graph b. Nodes, blocks and edges are matched
+// by ID at any depth, so moving an element between containers shows up as a
+// change of its "parent" field rather than a removal and an addition.
+func Graphs(a, b reactFlowTypes.Graph, opts GraphOptions) *GraphDiff {
+	if !opts.IncludeLayout {
+		// Without the recorded layout, fields that were only spelled
+		// differently, such as an explicit false, compare equal.
+		a = reactFlowTypes.StorageProjection(a).WithoutExtras()
+		b = reactFlowTypes.StorageProjection(b).WithoutExtras()
+	}
+	before := collectElements(a, opts)
+	after := collectElements(b, opts)
+
+	result := &GraphDiff{Changes: []ElementChange{}}
+	for _, key := range after.order {
+		element := after.byKey[key]
+		old, ok := before.byKey[key]
+		if !ok {
+			result.Changes = append(result.Changes, element.change(Added, nil))
+			continue
+		}
+		fields := diffFields(old.fields, element.fields)
+		if len(fields) > 0 {
+			result.Changes = append(result.Changes, element.change(Modified, fields))
+		}
+	}
+	for _, key := range before.order {
+		if _, ok := after.byKey[key]; !ok {
+			result.Changes = append(result.Changes, before.byKey[key].change(Removed, nil))
+		}
+	}
+	return result
+}
+
+// element is a node, block or edge flattened to its own fields; the
+// elements nested inside it are collected separately.
+type element struct {
+	kind   string
+	id     string
+	typ    string
+	parent string
+	fields map[string]interface{}
+}
+
+func (e element) change(op string, fields []FieldChange) ElementChange {
+	return ElementChange{Op: op, Kind: e.kind, ID: e.id, Type: e.typ, Parent: e.parent, Fields: fields}
+}
+
+type elements struct {
+	byKey map[string]element
+	order []string
+}
+
+func collectElements(g reactFlowTypes.Graph, opts GraphOptions) *elements {
+	c := &collector{elements: &elements{byKey: make(map[string]element)}, opts: opts}
+	c.nodes(g.Nodes, "")
+	c.edges(g.Edges, "")
+	return c.elements
+}
+
+type collector struct {
+	*elements
+	opts GraphOptions
+}
+
+// add records an element under its kind and ID. Elements without an ID
+// fall back to their position so they can still be compared.
+func (c *collector) add(e element, index int) {
+	key := e.kind + ":" + e.id
+	if e.id == "" {
+		key = fmt.Sprintf("%s:%s#%d", e.kind, e.parent, index)
+	}
+	if _, ok := c.byKey[key]; ok {
+		zap.L().Warn("Duplicate element ID in graph:", zap.String("kind", e.kind), zap.String("id", e.id))
+		key = fmt.Sprintf("%s#%d", key, len(c.order))
+	}
+	e.fields["parent"] = e.parent
+	c.byKey[key] = e
+	c.order = append(c.order, key)
+}
+
+func (c *collector) nodes(nodes []reactFlowTypes.Node, parent string) {
+	for i, node := range nodes {
+		typ := node.Type
+		if typ == "" {
+			typ = node.Data.Type
+		}
+		c.add(element{kind: KindNode, id: node.ID, typ: typ, parent: parent, fields: c.nodeFields(node)}, i)
+		c.metadata(node.Data.Metadata, node.ID)
+		c.metadata(node.Metadata, node.ID)
+	}
+}
+
+func (c *collector) edges(edges []reactFlowTypes.Edge, parent string) {
+	for i, edge := range edges {
+		c.add(element{kind: KindEdge, id: edge.ID, typ: edge.Type, parent: parent, fields: flatten(edge)}, i)
+	}
+}
+
+func (c *collector) metadata(metadata reactFlowTypes.Metadata, parent string) {
+	c.nodes(metadata.Nodes, parent)
+	c.edges(metadata.Edges, parent)
+	for i, block := range metadata.Blocks {
+		block.NodeData = withoutChildren(block.NodeData)
+		fields := flatten(block)
+		if !c.opts.IncludeLayout {
+			delete(fields, "data.selected")
+		}
+		typ := block.NodeData.Type
+		if typ == "" {
+			typ = block.NodeData.Data.Type
+		}
+		c.add(element{kind: KindBlock, id: block.ID, typ: typ, parent: parent, fields: fields}, i)
+		c.metadata(metadata.Blocks[i].NodeData.Data.Metadata, block.ID)
+		c.metadata(metadata.Blocks[i].NodeData.Metadata, block.ID)
+	}
+}
+
+// nodeFields flattens a node without the elements nested in it. Selected
+// is the editor's selection state and only counts as layout.
+func (c *collector) nodeFields(node reactFlowTypes.Node) map[string]interface{} {
+	fields := flatten(withoutChildren(node))
+	if !c.opts.IncludeLayout {
+		delete(fields, "selected")
+	}
+	return fields
+}
+
+func withoutChildren(node reactFlowTypes.Node) reactFlowTypes.Node {
+	node.Data.Metadata.Nodes = nil
+	node.Data.Metadata.Edges = nil
+	node.Data.Metadata.Blocks = nil
+	node.Metadata.Nodes = nil
+	node.Metadata.Edges = nil
+	node.Metadata.Blocks = nil
+	return node
+}
+
+// flatten encodes v and returns its leaves keyed by dotted path. Arrays are
+// kept whole.
+func flatten(v interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	data, err := json.Marshal(v)
+	if err != nil {
+		zap.L().Error("Error marshaling graph element:", zap.Error(err))
+		return fields
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		zap.L().Error("Error unmarshaling graph element:", zap.Error(err))
+		return fields
+	}
+	flattenInto(fields, "", value)
+	return fields
+}
+
+func flattenInto(fields map[string]interface{}, prefix string, value interface{}) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		fields[prefix] = value
+		return
+	}
+	for key, child := range object {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		flattenInto(fields, path, child)
+	}
+}
+
+func diffFields(before, after map[string]interface{}) []FieldChange {
+	keys := make(map[string]bool)
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	var changes []FieldChange
+	for _, key := range sorted {
+		old, hadOld := before[key]
+		updated, hasNew := after[key]
+		if hadOld != hasNew || !reflect.DeepEqual(old, updated) {
+			changes = append(changes, FieldChange{Field: key, Old: old, New: updated, Added: !hadOld, Removed: !hasNew})
+		}
+	}
+	return changes
+}
+
+func compactJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,137 @@
+package diff
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+func graphOf(t *testing.T, raw string) reactFlowTypes.Graph {
+	t.Helper()
+	var graph reactFlowTypes.Graph
+	if err := json.Unmarshal([]byte(raw), &graph); err != nil {
+		t.Fatal(err)
+	}
+	return graph
+}
+
+func TestGraphs(t *testing.T) {
+	const conditional = `{"id":1,"nodes":[{"id":"c","type":"conditional-node","data":{"metadata":{"blocks":[{"id":"b","data":{"type":"moment"}}]}}},{"id":"d","type":"conditional-node"}]}`
+	tests := []struct {
+		name    string
+		a, b    string
+		opts    GraphOptions
+		want    []ElementChange
+		summary string
+	}{
+		{
+			name: "equal",
+			a:    conditional,
+			b:    conditional,
+			want: []ElementChange{},
+		},
+		{
+			name: "modified field",
+			a:    `{"id":1,"nodes":[{"id":"a","type":"moment","metadata":{"id":"m"}}]}`,
+			b:    `{"id":1,"nodes":[{"id":"a","type":"moment","metadata":{"id":"n"}}]}`,
+			want: []ElementChange{{Op: Modified, Kind: KindNode, ID: "a", Type: "moment", Fields: []FieldChange{
+				{Field: "metadata.id", Old: "m", New: "n"},
+			}}},
+			summary: "0 added, 0 removed, 1 modified\n~ node a (moment)\n    metadata.id: \"m\" -> \"n\"\n",
+		},
+		{
+			name: "added and removed elements",
+			a:    conditional,
+			b:    `{"id":1,"nodes":[{"id":"c","type":"conditional-node","data":{"metadata":{"blocks":[{"id":"b2","data":{"type":"moment"}}]}}},{"id":"d","type":"conditional-node"}],"edges":[{"id":"e","source":"c","target":"d"}]}`,
+			want: []ElementChange{
+				{Op: Added, Kind: KindBlock, ID: "b2", Type: "moment", Parent: "c"},
+				{Op: Added, Kind: KindEdge, ID: "e"},
+				{Op: Removed, Kind: KindBlock, ID: "b", Type: "moment", Parent: "c"},
+			},
+			summary: "2 added, 1 removed, 0 modified\n+ block b2 (moment) in c\n+ edge e\n- block b (moment) in c\n",
+		},
+		{
+			name: "moved block",
+			a:    conditional,
+			b:    `{"id":1,"nodes":[{"id":"c","type":"conditional-node"},{"id":"d","type":"conditional-node","data":{"metadata":{"blocks":[{"id":"b","data":{"type":"moment"}}]}}}]}`,
+			want: []ElementChange{{Op: Modified, Kind: KindBlock, ID: "b", Type: "moment", Parent: "d", Fields: []FieldChange{
+				{Field: "parent", Old: "c", New: "d"},
+			}}},
+		},
+		{
+			name: "layout ignored",
+			a:    `{"id":1,"nodes":[{"id":"a","position":{"x":1,"y":2},"selected":true}]}`,
+			b:    `{"id":1,"nodes":[{"id":"a","position":{"x":3,"y":2}}]}`,
+			want: []ElementChange{},
+		},
+		{
+			name: "layout included",
+			a:    `{"id":1,"nodes":[{"id":"a","position":{"x":1,"y":2}}]}`,
+			b:    `{"id":1,"nodes":[{"id":"a","position":{"x":3,"y":2}}]}`,
+			opts: GraphOptions{IncludeLayout: true},
+			want: []ElementChange{{Op: Modified, Kind: KindNode, ID: "a", Fields: []FieldChange{
+				{Field: "position.x", Old: 1.0, New: 3.0},
+			}}},
+		},
+		{
+			name: "field added",
+			a:    `{"id":1,"nodes":[{"id":"a","type":"moment","metadata":{"id":"m"}}]}`,
+			b:    `{"id":1,"nodes":[{"id":"a","type":"moment","metadata":{"id":"m","is_not":true}}]}`,
+			opts: GraphOptions{IncludeLayout: true},
+			want: []ElementChange{{Op: Modified, Kind: KindNode, ID: "a", Type: "moment", Fields: []FieldChange{
+				{Field: "metadata.is_not", New: true, Added: true},
+			}}},
+			summary: "0 added, 0 removed, 1 modified\n~ node a (moment)\n    metadata.is_not: + true\n",
+		},
+		{
+			// A field removed while it was null still shows up as removed.
+			name: "null field removed",
+			a:    `{"id":1,"nodes":[{"id":"a","dragging":null}]}`,
+			b:    `{"id":1,"nodes":[{"id":"a"}]}`,
+			opts: GraphOptions{IncludeLayout: true},
+			want: []ElementChange{{Op: Modified, Kind: KindNode, ID: "a", Fields: []FieldChange{
+				{Field: "dragging", Removed: true},
+			}}},
+			summary: "0 added, 0 removed, 1 modified\n~ node a\n    dragging: - null\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Graphs(graphOf(t, tt.a), graphOf(t, tt.b), tt.opts)
+			if !reflect.DeepEqual(d.Changes, tt.want) {
+				got, _ := json.Marshal(d.Changes)
+				want, _ := json.Marshal(tt.want)
+				t.Errorf("Graphs() = %s, want %s", got, want)
+			}
+			if d.Empty() != (len(tt.want) == 0) {
+				t.Errorf("Empty() = %v with %d changes", d.Empty(), len(tt.want))
+			}
+			if tt.summary != "" && d.Summary() != tt.summary {
+				t.Errorf("Summary() = %q, want %q", d.Summary(), tt.summary)
+			}
+		})
+	}
+}
+
+func TestFieldChangeJSON(t *testing.T) {
+	tests := []struct {
+		change FieldChange
+		want   string
+	}{
+		{FieldChange{Field: "x", Old: false, New: true}, `{"field":"x","old":false,"new":true}`},
+		{FieldChange{Field: "x", Old: "", New: 0.0}, `{"field":"x","old":"","new":0}`},
+		{FieldChange{Field: "x", New: nil, Added: true}, `{"field":"x","old":null,"new":null,"added":true}`},
+		{FieldChange{Field: "x", Old: nil, Removed: true}, `{"field":"x","old":null,"new":null,"removed":true}`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.change)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("json.Marshal(%+v) = %s, want %s", tt.change, got, tt.want)
+		}
+	}
+}
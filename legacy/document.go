@@ -0,0 +1,125 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+)
+
+// QuestionRule is the stored rule document of one question. DraftConfig is
+// what the editor works on; Config and InternalConfig are the published
+// graph and the rule chains converted from it.
+type QuestionRule struct {
+	ID             string                 `json:"id" dynamodbav:"id"`
+	TenantID       string                 `json:"tenant_id" dynamodbav:"tenant_id"`
+	QuestionID     int32                  `json:"question_id" dynamodbav:"question_id"`
+	TemplateID     int32                  `json:"template_id,omitempty" dynamodbav:"template_id,omitempty"`
+	Config         []Graph                `json:"config,omitempty" dynamodbav:"config,omitempty"`
+	InternalConfig []types.RuleChain      `json:"internal_config,omitempty" dynamodbav:"internal_config,omitempty"`
+	DraftConfig    []Graph                `json:"draft_config,omitempty" dynamodbav:"draft_config,omitempty"`
+	Metadata       map[string]interface{} `json:"metadata,omitempty" dynamodbav:"metadata,omitempty"`
+	CreatedAt      int64                  `json:"created_at,omitempty" dynamodbav:"created_at,omitempty"`
+	UpdatedAt      int64                  `json:"updated_at,omitempty" dynamodbav:"updated_at,omitempty"`
+	PublishedAt    int64                  `json:"published_at,omitempty" dynamodbav:"published_at,omitempty"`
+	// Version is the number of the published QuestionRuleVersion.
+	Version int `json:"version,omitempty" dynamodbav:"version,omitempty"`
+	// SchemaVersion is the version of the document format, upgraded by
+	// package migrate; documents written before it was introduced have none
+	// and are version 0.
+	SchemaVersion int `json:"schema_version,omitempty" dynamodbav:"schema_version,omitempty"`
+}
+
+// UnmarshalJSON accepts documents exported from DynamoDB, where every number
+// is written as a float (e.g. "firstNodeIndex": 0.0), which the rule chain
+// types cannot decode into their int fields.
+func (q *QuestionRule) UnmarshalJSON(data []byte) error {
+	type plain QuestionRule
+	var decoded struct {
+		plain
+		InternalConfig json.RawMessage `json:"internal_config,omitempty"`
+		QuestionID     json.Number     `json:"question_id"`
+		TemplateID     json.Number     `json:"template_id,omitempty"`
+		CreatedAt      json.Number     `json:"created_at,omitempty"`
+		UpdatedAt      json.Number     `json:"updated_at,omitempty"`
+		PublishedAt    json.Number     `json:"published_at,omitempty"`
+		Version        json.Number     `json:"version,omitempty"`
+		SchemaVersion  json.Number     `json:"schema_version,omitempty"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*q = QuestionRule(decoded.plain)
+	q.QuestionID = int32(numberToInt(decoded.QuestionID))
+	q.TemplateID = int32(numberToInt(decoded.TemplateID))
+	q.CreatedAt = numberToInt(decoded.CreatedAt)
+	q.UpdatedAt = numberToInt(decoded.UpdatedAt)
+	q.PublishedAt = numberToInt(decoded.PublishedAt)
+	q.Version = int(numberToInt(decoded.Version))
+	q.SchemaVersion = int(numberToInt(decoded.SchemaVersion))
+	if len(decoded.InternalConfig) == 0 {
+		return nil
+	}
+	internalConfig, err := NormalizeNumbers(decoded.InternalConfig)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(internalConfig, &q.InternalConfig)
+}
+
+func numberToInt(number json.Number) int64 {
+	if number == "" {
+		return 0
+	}
+	if i, err := number.Int64(); err == nil {
+		return i
+	}
+	f, _ := number.Float64()
+	return int64(f)
+}
+
+// NormalizeNumbers rewrites every whole number written as a float, such as
+// 184.0, as an integer so the document decodes into int fields. Object keys
+// come back sorted.
+func NormalizeNumbers(data []byte) ([]byte, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(normalizeNumber(value))
+}
+
+func normalizeNumber(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		return json.Number(integralNumber(json.RawMessage(v)))
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeNumber(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeNumber(item)
+		}
+	}
+	return value
+}
+
+// QuestionRuleVersion is an immutable snapshot of one publish of a question
+// rule. Numbers start at 1 and grow by one per publish or rollback.
+type QuestionRuleVersion struct {
+	TenantID       string                 `json:"tenant_id" dynamodbav:"tenant_id"`
+	QuestionID     int32                  `json:"question_id" dynamodbav:"question_id"`
+	Number         int                    `json:"version" dynamodbav:"version"`
+	Config         []Graph                `json:"config,omitempty" dynamodbav:"config,omitempty"`
+	InternalConfig []types.RuleChain      `json:"internal_config,omitempty" dynamodbav:"internal_config,omitempty"`
+	Metadata       map[string]interface{} `json:"metadata,omitempty" dynamodbav:"metadata,omitempty"`
+	Author         string                 `json:"author,omitempty" dynamodbav:"author,omitempty"`
+	Note           string                 `json:"note,omitempty" dynamodbav:"note,omitempty"`
+	CreatedAt      int64                  `json:"created_at" dynamodbav:"created_at"`
+	// RollbackOf is the version this one restored, when it was created by a
+	// rollback.
+	RollbackOf int `json:"rollback_of,omitempty" dynamodbav:"rollback_of,omitempty"`
+}
@@ -0,0 +1,40 @@
+package reactflow
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NodeError ties a problem to the node it was found on, so editors can
+// point at the offending node instead of showing a bare message.
+type NodeError struct {
+	NodeID string
+	Err    error
+}
+
+func (e *NodeError) Error() string {
+	return fmt.Sprintf("node %s: %v", e.NodeID, e.Err)
+}
+
+func (e *NodeError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorList collects every problem found in one pass.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	messages := make([]string, 0, len(l))
+	for _, err := range l {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Err returns nil for an empty list so callers can return it directly.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
@@ -0,0 +1,263 @@
+// Package evaluator runs a converted RuleChain against a fixed set of call
+// facts, without the rule engine. It follows the engine's node semantics
+// closely enough to author and test rules offline:
+//
+//   - leaf nodes (moment, attribute, function, validateInfo) are true or
+//     false for the call, inverted by is_not;
+//   - a singleBlock folds its members left to right along its edges, using
+//     each edge's operator, and ANDs in members no edge reaches;
+//   - conditionalBlock, conditionalGPTBlock and defaultBlock containers try
+//     their blocks in order and follow the connections of the first block
+//     that holds;
+//   - a response node yields the first of its blocks that holds, falling
+//     back to the untyped default block.
+package evaluator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+)
+
+// Options tunes an evaluation.
+type Options struct {
+	// Locations resolves the tenant time zone used for relative date
+	// operations. When nil every tenant is evaluated in UTC.
+	Locations reactflow.LocationResolver
+}
+
+// Result is the outcome of evaluating a chain.
+type Result struct {
+	// Matched is true when evaluation reached a response, or when a chain
+	// without responses ended on a node that held.
+	Matched bool `json:"matched"`
+	// Response is the ID of the response block that was selected.
+	Response string `json:"response,omitempty"`
+	// Path lists the routed nodes in the order they were visited.
+	Path []string `json:"path"`
+	// Values holds the truth value of every node that was evaluated.
+	Values map[string]bool `json:"values"`
+}
+
+type evaluation struct {
+	nodes    map[string]*types.RuleNode
+	outgoing map[string][]string
+	facts    Facts
+	location *time.Location
+	result   *Result
+	routed   map[string]bool
+	pending  map[string]bool
+}
+
+// Evaluate runs chain against facts starting from its first node.
+func Evaluate(chain types.RuleChain, facts Facts, opts Options) (*Result, error) {
+	nodes := chain.Metadata.Nodes
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("rule chain %s has no nodes", chain.RuleChain.ID)
+	}
+	first := chain.Metadata.FirstNodeIndex
+	if first < 0 || first >= len(nodes) {
+		return nil, fmt.Errorf("first node index %d out of range", first)
+	}
+	e := &evaluation{
+		nodes:    make(map[string]*types.RuleNode, len(nodes)),
+		outgoing: make(map[string][]string),
+		facts:    facts,
+		location: time.UTC,
+		result:   &Result{Values: make(map[string]bool)},
+		routed:   make(map[string]bool),
+		pending:  make(map[string]bool),
+	}
+	if opts.Locations != nil {
+		e.location = opts.Locations.Location(chain.RuleChain.TenantID)
+	}
+	for _, node := range nodes {
+		if node != nil {
+			e.nodes[node.Id] = node
+		}
+	}
+	for _, connection := range chain.Metadata.Connections {
+		e.outgoing[connection.FromId] = append(e.outgoing[connection.FromId], connection.ToId)
+	}
+	if err := e.route(nodes[first].Id); err != nil {
+		return e.result, err
+	}
+	return e.result, nil
+}
+
+// route visits a node in the flow of the chain and follows its outgoing
+// connections until a response is reached or nothing more holds.
+func (e *evaluation) route(id string) error {
+	if e.routed[id] {
+		return fmt.Errorf("cycle detected while routing through node %s", id)
+	}
+	e.routed[id] = true
+	node, ok := e.nodes[id]
+	if !ok {
+		return fmt.Errorf("node %s not found", id)
+	}
+	e.result.Path = append(e.result.Path, id)
+
+	switch node.Type {
+	case "response":
+		for _, blockID := range reactflow.ConfigStrings(node.Configuration, "NodeIdList") {
+			if _, ok := e.nodes[blockID]; !ok {
+				// Untyped response blocks are not emitted as rule nodes;
+				// they are the default answer.
+				e.result.Matched = true
+				e.result.Response = blockID
+				return nil
+			}
+			holds, err := e.truth(blockID)
+			if err != nil {
+				return err
+			}
+			if holds {
+				e.result.Matched = true
+				e.result.Response = blockID
+				return nil
+			}
+		}
+		return nil
+	case "conditionalBlock", "conditionalGPTBlock", "defaultBlock":
+		for _, blockID := range reactflow.ConfigStrings(node.Configuration, "NodeIdList") {
+			holds, err := e.truth(blockID)
+			if err != nil {
+				return err
+			}
+			if !holds {
+				continue
+			}
+			next := e.outgoing[blockID]
+			if len(next) == 0 {
+				next = e.outgoing[id]
+			}
+			if len(next) == 0 {
+				e.result.Matched = true
+				return nil
+			}
+			return e.route(next[0])
+		}
+		return nil
+	}
+
+	holds, err := e.truth(id)
+	if err != nil {
+		return err
+	}
+	if !holds {
+		return nil
+	}
+	if next := e.outgoing[id]; len(next) > 0 {
+		return e.route(next[0])
+	}
+	e.result.Matched = true
+	return nil
+}
+
+// truth evaluates a node as a condition, applying its is_not flag.
+func (e *evaluation) truth(id string) (bool, error) {
+	if value, ok := e.result.Values[id]; ok {
+		return value, nil
+	}
+	if e.pending[id] {
+		return false, fmt.Errorf("cycle detected while evaluating node %s", id)
+	}
+	node, ok := e.nodes[id]
+	if !ok {
+		return false, fmt.Errorf("node %s not found", id)
+	}
+	e.pending[id] = true
+	defer delete(e.pending, id)
+
+	holds, err := e.evaluateNode(node)
+	if err != nil {
+		return false, err
+	}
+	if reactflow.ConfigBool(node.Configuration, "is_not") {
+		holds = !holds
+	}
+	e.result.Values[id] = holds
+	return holds, nil
+}
+
+func (e *evaluation) evaluateNode(node *types.RuleNode) (bool, error) {
+	configuration := node.Configuration
+	switch node.Type {
+	case "moment":
+		return e.facts.hasMoment(reactflow.ConfigString(configuration, "id")), nil
+	case "attribute":
+		if reactflow.ConfigString(configuration, "attribute_type") != "parameter" {
+			return false, fmt.Errorf("node %s: unsupported attribute type %q", node.Id, reactflow.ConfigString(configuration, "attribute_type"))
+		}
+		parameterID, _ := reactflow.ConfigInt(configuration, "parameter_id")
+		response, ok := e.facts.Parameters[parameterID]
+		if !ok {
+			return false, nil
+		}
+		for _, expected := range reactflow.ConfigInts(configuration, "attribute") {
+			if expected == response {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "function":
+		return e.facts.Functions[reactflow.ConfigString(configuration, "function_name")], nil
+	case "validateInfo":
+		return e.validateInfo(node)
+	case "singleBlock":
+		return e.singleBlock(node)
+	case "conditionalBlock", "defaultBlock":
+		for _, blockID := range reactflow.ConfigStrings(configuration, "NodeIdList") {
+			holds, err := e.truth(blockID)
+			if err != nil || holds {
+				return holds, err
+			}
+		}
+		return false, nil
+	case "conditionalGPTBlock":
+		return e.facts.GPT[node.Id], nil
+	}
+	return false, fmt.Errorf("node %s: unsupported node type %q", node.Id, node.Type)
+}
+
+func (e *evaluation) singleBlock(node *types.RuleNode) (bool, error) {
+	members := reactflow.ConfigStrings(node.Configuration, "NodeIdList")
+	edges := reactflow.ConfigEdges(node.Configuration)
+	covered := make(map[string]bool)
+	result := len(members) > 0 || len(edges) > 0
+	for i, edge := range edges {
+		if i == 0 {
+			holds, err := e.truth(edge.SourceNode)
+			if err != nil {
+				return false, err
+			}
+			result = holds
+			covered[edge.SourceNode] = true
+		}
+		holds, err := e.truth(edge.TargetNode)
+		if err != nil {
+			return false, err
+		}
+		covered[edge.TargetNode] = true
+		if strings.EqualFold(edge.Operator, "or") {
+			result = result || holds
+		} else {
+			result = result && holds
+		}
+	}
+	for _, member := range members {
+		if covered[member] {
+			continue
+		}
+		holds, err := e.truth(member)
+		if err != nil {
+			return false, err
+		}
+		result = result && holds
+	}
+	return result, nil
+}
@@ -0,0 +1,220 @@
+package evaluator
+
+import (
+	"testing"
+	"time"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+)
+
+func node(id, nodeType string, configuration types.Configuration) *types.RuleNode {
+	if configuration == nil {
+		configuration = types.Configuration{}
+	}
+	return &types.RuleNode{Id: id, Type: nodeType, Configuration: configuration}
+}
+
+func chainOf(connections []types.NodeConnection, nodes ...*types.RuleNode) types.RuleChain {
+	return types.RuleChain{Metadata: types.RuleMetadata{Nodes: nodes, Connections: connections}}
+}
+
+func moment(id, momentID string) *types.RuleNode {
+	return node(id, "moment", types.Configuration{"id": momentID})
+}
+
+func block(id string, members []string, edges ...map[string]string) *types.RuleNode {
+	configuration := types.Configuration{"NodeIdList": members}
+	if edges != nil {
+		configuration["edges"] = edges
+	}
+	return node(id, "singleBlock", configuration)
+}
+
+func edge(source, target, operator string) map[string]string {
+	return map[string]string{"SourceNode": source, "TargetNode": target, "Operator": operator}
+}
+
+// responseChain routes through a conditional block holding when moment m1
+// was detected to a response that answers "late" when m2 was detected too,
+// and the untyped default block "default" otherwise.
+func responseChain() types.RuleChain {
+	return chainOf(
+		[]types.NodeConnection{{FromId: "when", ToId: "answer", Type: "True"}},
+		node("start", "conditionalBlock", types.Configuration{"NodeIdList": []string{"when"}}),
+		block("when", []string{"m1"}),
+		moment("m1", "m1"),
+		node("answer", "response", types.Configuration{"NodeIdList": []string{"late", "default"}}),
+		block("late", []string{"m2"}),
+		moment("m2", "m2"),
+	)
+}
+
+func TestEvaluate(t *testing.T) {
+	callTime := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	relative := func(kind string, fields types.Configuration) types.RuleChain {
+		return chainOf(nil, node("v", "validateInfo", types.Configuration{
+			"relativeOperation": "before", "relativeDays": 2, "dataType": "date",
+			"validate": kind, "validateFields": fields,
+		}))
+	}
+	tests := []struct {
+		name         string
+		chain        types.RuleChain
+		facts        Facts
+		wantMatched  bool
+		wantResponse string
+	}{
+		{"response block", responseChain(), Facts{Moments: []string{"m1", "m2"}}, true, "late"},
+		{"default response", responseChain(), Facts{Moments: []string{"m1"}}, true, "default"},
+		{"no block holds", responseChain(), Facts{Moments: []string{"m2"}}, false, ""},
+		{
+			name: "or edge",
+			chain: chainOf(nil,
+				block("b", []string{"x", "y"}, edge("x", "y", "OR")),
+				moment("x", "x"), moment("y", "y")),
+			facts:       Facts{Moments: []string{"y"}},
+			wantMatched: true,
+		},
+		{
+			name: "and edge",
+			chain: chainOf(nil,
+				block("b", []string{"x", "y"}, edge("x", "y", "and")),
+				moment("x", "x"), moment("y", "y")),
+			facts:       Facts{Moments: []string{"y"}},
+			wantMatched: false,
+		},
+		{
+			name: "members no edge reaches are and-ed",
+			chain: chainOf(nil,
+				block("b", []string{"x", "y", "z"}, edge("x", "y", "or")),
+				moment("x", "x"), moment("y", "y"), moment("z", "z")),
+			facts:       Facts{Moments: []string{"x"}},
+			wantMatched: false,
+		},
+		{"empty block", chainOf(nil, block("b", nil)), Facts{}, false, ""},
+		{
+			name:        "is_not",
+			chain:       chainOf(nil, node("m", "moment", types.Configuration{"id": "m", "is_not": true})),
+			facts:       Facts{},
+			wantMatched: true,
+		},
+		{
+			name: "parameter response",
+			chain: chainOf(nil, node("p", "attribute", types.Configuration{
+				"attribute_type": "parameter", "parameter_id": 4, "attribute": []int{1, 2},
+			})),
+			facts:       Facts{Parameters: map[int]int{4: 2}},
+			wantMatched: true,
+		},
+		{
+			name:        "function",
+			chain:       chainOf(nil, node("f", "function", types.Configuration{"function_name": "is_weekend"})),
+			facts:       Facts{Functions: map[string]bool{"is_weekend": true}},
+			wantMatched: true,
+		},
+		{
+			name:        "gpt verdict",
+			chain:       chainOf(nil, node("g", "conditionalGPTBlock", types.Configuration{"NodeIdList": []string{}})),
+			facts:       Facts{GPT: map[string]bool{"g": true}},
+			wantMatched: false,
+		},
+		{
+			name: "static comparison",
+			chain: chainOf(nil, node("v", "validateInfo", types.Configuration{
+				"validate": "attribute_category", "validateFields": types.Configuration{"attributeCategoryKey": 3},
+				"validateWith": "static_info", "operator": "gt", "dataType": "number", "value": "10",
+			})),
+			facts:       Facts{AttributeCategories: map[int]interface{}{3: 12.5}},
+			wantMatched: true,
+		},
+		{
+			name:        "relative date of an attribute",
+			chain:       relative("attribute_category", types.Configuration{"attributeCategoryKey": 3}),
+			facts:       Facts{CallTime: callTime, AttributeCategories: map[int]interface{}{3: "2024-05-08"}},
+			wantMatched: true,
+		},
+		{
+			name:        "relative date of an entity",
+			chain:       relative("entity", types.Configuration{"entity": 7}),
+			facts:       Facts{CallTime: callTime, Entities: map[int]interface{}{7: "2024-05-08"}},
+			wantMatched: true,
+		},
+		{
+			name:        "relative date too close",
+			chain:       relative("entity", types.Configuration{"entity": 7}),
+			facts:       Facts{CallTime: callTime, Entities: map[int]interface{}{7: "2024-05-09"}},
+			wantMatched: false,
+		},
+		{
+			name:        "relative date missing",
+			chain:       relative("entity", types.Configuration{"entity": 7}),
+			facts:       Facts{CallTime: callTime, AttributeCategories: map[int]interface{}{7: "2024-05-01"}},
+			wantMatched: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Evaluate(tt.chain, tt.facts, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Matched != tt.wantMatched || result.Response != tt.wantResponse {
+				t.Errorf("Evaluate() = matched %v, response %q; want %v, %q (path %v)",
+					result.Matched, result.Response, tt.wantMatched, tt.wantResponse, result.Path)
+			}
+		})
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		chain types.RuleChain
+	}{
+		{"no nodes", chainOf(nil)},
+		{"unsupported node type", chainOf(nil, node("n", "mystery", nil))},
+		{"missing member", chainOf(nil, block("b", []string{"gone"}))},
+		{
+			name: "routing cycle",
+			chain: chainOf([]types.NodeConnection{{FromId: "a", ToId: "b"}, {FromId: "b", ToId: "a"}},
+				node("a", "moment", types.Configuration{"id": "m"}), node("b", "moment", types.Configuration{"id": "m"})),
+		},
+		{
+			name: "relative date on a number",
+			chain: chainOf(nil, node("v", "validateInfo", types.Configuration{
+				"relativeOperation": "after", "dataType": "number",
+				"validate": "entity", "validateFields": types.Configuration{"entity": 7},
+			})),
+		},
+	}
+	facts := Facts{Moments: []string{"m"}, Entities: map[int]interface{}{7: 1}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Evaluate(tt.chain, facts, Options{}); err == nil {
+				t.Error("Evaluate() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestEvaluateStaticDateInTenantZone(t *testing.T) {
+	chain := chainOf(nil, node("v", "validateInfo", types.Configuration{
+		"validate": "attribute_category", "validateFields": types.Configuration{"attributeCategoryKey": 3},
+		"validateWith": "static_info", "operator": "equals", "dataType": "date", "value": "2024-05-01",
+	}))
+	chain.RuleChain.TenantID = "acme"
+	facts := Facts{AttributeCategories: map[int]interface{}{3: "2024-05-01"}}
+	for _, zone := range []string{"UTC", "America/New_York", "Asia/Kolkata"} {
+		t.Run(zone, func(t *testing.T) {
+			opts := Options{Locations: &reactflow.TenantLocations{Zones: map[string]string{"acme": zone}}}
+			result, err := Evaluate(chain, facts, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Matched {
+				t.Error("the attribute date does not equal the same static date")
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package evaluator
+
+import "time"
+
+// Facts is everything the evaluator knows about a single call. It stands in
+// for the detections and extractions the rule engine would normally read.
+type Facts struct {
+	// CallTime is the reference point for relative date operations.
+	CallTime time.Time `json:"call_time"`
+	// Moments lists the moment IDs detected on the call.
+	Moments []string `json:"moments,omitempty"`
+	// Parameters maps a parameter ID to the response it was scored with.
+	Parameters map[int]int `json:"parameters,omitempty"`
+	// AttributeCategories maps an attributeCategoryKey to its extracted value.
+	AttributeCategories map[int]interface{} `json:"attribute_categories,omitempty"`
+	// Entities maps an entity ID to its extracted value.
+	Entities map[int]interface{} `json:"entities,omitempty"`
+	// Functions maps a function name to its result.
+	Functions map[string]bool `json:"functions,omitempty"`
+	// GPT maps a conditionalGPTBlock node ID to the verdict of its prompt.
+	GPT map[string]bool `json:"gpt,omitempty"`
+}
+
+func (f Facts) hasMoment(id string) bool {
+	for _, moment := range f.Moments {
+		if moment == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// validateInfo evaluates an information-correction node. Nodes with a
+// relative operation compare their attribute or entity against the call
+// date; the rest run the comparison compiled from their
+// validate/validateWith fields.
+func (e *evaluation) validateInfo(node *types.RuleNode) (bool, error) {
+	rule, err := reactflow.RelativeDateRuleFromConfiguration(node.Configuration)
+	if err != nil {
+		return false, &reactflow.NodeError{NodeID: node.Id, Err: err}
+	}
+	if rule != nil {
+		metadata, err := reactFlowTypes.ConfigurationToMetadata(node.Configuration)
+		if err != nil {
+			return false, &reactflow.NodeError{NodeID: node.Id, Err: err}
+		}
+		reference, err := reactflow.RelativeDateReference(metadata)
+		if err != nil {
+			return false, &reactflow.NodeError{NodeID: node.Id, Err: err}
+		}
+		value, ok := e.resolve(reference)
+		if !ok {
+			return false, nil
+		}
+		date, err := reactflow.ParseDateValue(value, e.location)
+		if err != nil {
+			return false, &reactflow.NodeError{NodeID: node.Id, Err: err}
+		}
+		return rule.Matches(date, e.facts.CallTime, e.location), nil
+	}
+
+	comparison, err := reactflow.CompileComparisonConfiguration(node.Configuration)
+	if err != nil {
+		return false, &reactflow.NodeError{NodeID: node.Id, Err: err}
+	}
+	left, ok := e.resolve(comparison.Left)
+	if !ok {
+		return false, nil
+	}
+	right, ok := e.resolve(comparison.Right)
+	if !ok {
+		return false, nil
+	}
+	holds, err := comparison.Holds(left, right, e.location)
+	if err != nil {
+		return false, &reactflow.NodeError{NodeID: node.Id, Err: fmt.Errorf("%s: %w", comparison.Operator, err)}
+	}
+	return holds, nil
+}
+
+// resolve looks up the value of a comparison operand in the facts. Static
+// operands carry their own value.
+func (e *evaluation) resolve(operand reactflow.Operand) (interface{}, bool) {
+	switch operand.Kind {
+	case reactflow.OperandAttributeCategory:
+		value, ok := e.facts.AttributeCategories[int(operand.AttributeCategoryKey)]
+		return value, ok
+	case reactflow.OperandEntity:
+		value, ok := e.facts.Entities[int(operand.Entity)]
+		return value, ok
+	}
+	return operand.Value, true
+}
@@ -0,0 +1,115 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// GraphRequest is the body of /convert and /validate.
+type GraphRequest struct {
+	Graph    *reactFlowTypes.Graph `json:"graph"`
+	TenantID string                `json:"tenant_id"`
+}
+
+// ConvertResponse is the answer of /convert. Diagnostics holds the
+// warnings of a graph that converted.
+type ConvertResponse struct {
+	RuleChain   types.RuleChain        `json:"rule_chain"`
+	Diagnostics []reactflow.Diagnostic `json:"diagnostics"`
+}
+
+// ValidateResponse is the answer of /validate.
+type ValidateResponse struct {
+	Valid       bool                   `json:"valid"`
+	Diagnostics []reactflow.Diagnostic `json:"diagnostics"`
+}
+
+// MetadataRequest is the body of /metadata.
+type MetadataRequest struct {
+	RuleChains  []types.RuleChain `json:"rule_chains"`
+	ParameterID int32             `json:"parameter_id"`
+	TenantID    string            `json:"tenant_id"`
+}
+
+// MetadataResponse is the answer of /metadata.
+type MetadataResponse struct {
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+func newGraphRequest() interface{} { return &GraphRequest{} }
+
+func newMetadataRequest() interface{} { return &MetadataRequest{} }
+
+func graphRequest(body interface{}) (*GraphRequest, error) {
+	req := body.(*GraphRequest)
+	if req.Graph == nil {
+		return nil, &Error{Status: http.StatusBadRequest, Code: CodeBadRequest, Message: "graph is required"}
+	}
+	return req, nil
+}
+
+// validateGraph runs the checks of ValidateGraph and never returns nil, so
+// the response holds an empty list rather than null.
+func validateGraph(req *GraphRequest) []reactflow.Diagnostic {
+	diagnostics := reactflow.ValidateGraph(*req.Graph, req.TenantID)
+	if diagnostics == nil {
+		diagnostics = []reactflow.Diagnostic{}
+	}
+	return diagnostics
+}
+
+// convert answers with the rule chain of a graph, or with the diagnostics
+// locating what keeps it from converting. The conversion is skipped once
+// the request has timed out.
+func (s *Server) convert(ctx context.Context, body interface{}) (interface{}, error) {
+	req, err := graphRequest(body)
+	if err != nil {
+		return nil, err
+	}
+	diagnostics := validateGraph(req)
+	if reactflow.HasErrors(diagnostics) {
+		return nil, &Error{Status: http.StatusUnprocessableEntity, Code: CodeInvalidGraph,
+			Message: "graph cannot be converted", Diagnostics: diagnostics}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ruleChain, err := reactflow.ConvertChecked(*req.Graph, req.TenantID)
+	if err != nil {
+		return nil, &Error{Status: http.StatusUnprocessableEntity, Code: CodeInvalidGraph, Message: err.Error()}
+	}
+	if err := reactflow.CompileChainComparisons(&ruleChain); err != nil {
+		return nil, &Error{Status: http.StatusUnprocessableEntity, Code: CodeInvalidGraph, Message: err.Error()}
+	}
+	return &ConvertResponse{RuleChain: ruleChain, Diagnostics: diagnostics}, nil
+}
+
+func (s *Server) validate(ctx context.Context, body interface{}) (interface{}, error) {
+	req, err := graphRequest(body)
+	if err != nil {
+		return nil, err
+	}
+	diagnostics := validateGraph(req)
+	return &ValidateResponse{Valid: !reactflow.HasErrors(diagnostics), Diagnostics: diagnostics}, nil
+}
+
+func (s *Server) metadata(ctx context.Context, body interface{}) (interface{}, error) {
+	req := body.(*MetadataRequest)
+	if req.TenantID == "" {
+		return nil, &Error{Status: http.StatusBadRequest, Code: CodeBadRequest, Message: "tenant_id is required"}
+	}
+	metadata, err := reactflow.RuleMetadata(ctx, s.moments, req.RuleChains, req.ParameterID, req.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("computing rule metadata: %w", err)
+	}
+	return &MetadataResponse{Metadata: metadata}, nil
+}
+
+func (s *Server) healthz(ctx context.Context, body interface{}) (interface{}, error) {
+	return map[string]string{"status": "ok"}, nil
+}
@@ -0,0 +1,186 @@
+// Package httpapi serves the rule converter over HTTP, so services convert
+// and validate graphs and compute rule metadata without importing reactflow
+// and wiring DynamoDB themselves.
+//
+//	POST /convert   {"graph": ..., "tenant_id": ...}  -> {"rule_chain": ..., "diagnostics": [...]}
+//	POST /validate  {"graph": ..., "tenant_id": ...}  -> {"valid": ..., "diagnostics": [...]}
+//	POST /metadata  {"rule_chains": [...], "parameter_id": ..., "tenant_id": ...} -> {"metadata": ...}
+//	GET  /healthz                                     -> {"status": "ok"}
+//
+// Failures are answered with an error envelope:
+//
+//	{"error": {"code": "invalid_graph", "message": "...", "diagnostics": [...]}}
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultMaxBodyBytes = 4 << 20
+	DefaultTimeout      = 10 * time.Second
+)
+
+// Error codes of the envelope.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeTooLarge         = "payload_too_large"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeInvalidGraph     = "invalid_graph"
+	CodeTimeout          = "timeout"
+	CodeInternal         = "internal"
+)
+
+// Options bounds the requests the server accepts.
+type Options struct {
+	// MaxBodyBytes caps request bodies; DefaultMaxBodyBytes when zero.
+	MaxBodyBytes int64
+	// Timeout caps the time spent handling a request; DefaultTimeout when
+	// zero. It starts once the body is read, so the http.Server should set
+	// ReadTimeout to bound slow uploads.
+	Timeout time.Duration
+}
+
+// Server is the HTTP handler of the service.
+type Server struct {
+	moments reactflow.MomentStore
+	opts    Options
+	mux     *http.ServeMux
+}
+
+// NewServer returns a server looking moments up in moments.
+func NewServer(moments reactflow.MomentStore, opts Options) *Server {
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	s := &Server{moments: moments, opts: opts, mux: http.NewServeMux()}
+	s.mux.Handle("/convert", s.endpoint(http.MethodPost, newGraphRequest, s.convert))
+	s.mux.Handle("/validate", s.endpoint(http.MethodPost, newGraphRequest, s.validate))
+	s.mux.Handle("/metadata", s.endpoint(http.MethodPost, newMetadataRequest, s.metadata))
+	s.mux.Handle("/healthz", s.endpoint(http.MethodGet, nil, s.healthz))
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, &Error{Status: http.StatusNotFound, Code: CodeNotFound, Message: "no such endpoint"})
+	})
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Error is a failed request, written as the error envelope.
+type Error struct {
+	Status      int                    `json:"-"`
+	Code        string                 `json:"code"`
+	Message     string                 `json:"message"`
+	Diagnostics []reactflow.Diagnostic `json:"diagnostics,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// handlerFunc answers a request from its decoded body, req, under a context
+// carrying the deadline. It runs on its own goroutine and never sees the
+// http.Request, so it cannot touch the connection after a timeout.
+type handlerFunc func(ctx context.Context, req interface{}) (interface{}, error)
+
+// endpoint wraps h with the method check, the body limit and the timeout.
+// newRequest returns the value the body is decoded into, or is nil for
+// requests without a body. The body is read before h starts; h then runs on
+// its own goroutine so a slow conversion is answered with a timeout, and
+// its result is dropped.
+func (s *Server) endpoint(method string, newRequest func() interface{}, h handlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeError(w, &Error{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Message: fmt.Sprintf("use %s", method)})
+			return
+		}
+		var req interface{}
+		if newRequest != nil {
+			req = newRequest()
+			r.Body = http.MaxBytesReader(w, r.Body, s.opts.MaxBodyBytes)
+			if err := decode(r, req); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), s.opts.Timeout)
+		defer cancel()
+
+		type result struct {
+			body interface{}
+			err  error
+		}
+		done := make(chan result, 1)
+		path := r.URL.Path
+		go func() {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					zap.L().Error("handler panicked", zap.String("path", path), zap.Any("panic", recovered))
+					done <- result{err: fmt.Errorf("panic: %v", recovered)}
+				}
+			}()
+			body, err := h(ctx, req)
+			done <- result{body: body, err: err}
+		}()
+
+		select {
+		case res := <-done:
+			if res.err != nil {
+				writeError(w, res.err)
+				return
+			}
+			writeJSON(w, http.StatusOK, res.body)
+		case <-ctx.Done():
+			writeError(w, &Error{Status: http.StatusServiceUnavailable, Code: CodeTimeout, Message: "request timed out"})
+		}
+	})
+}
+
+// decode reads the JSON body of r into v.
+func decode(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &Error{Status: http.StatusRequestEntityTooLarge, Code: CodeTooLarge,
+				Message: fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit)}
+		}
+		return &Error{Status: http.StatusBadRequest, Code: CodeBadRequest, Message: "decoding request: " + err.Error()}
+	}
+	return nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		zap.L().Error("request failed", zap.Error(err))
+		apiErr = &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: err.Error()}
+	}
+	writeJSON(w, apiErr.Status, map[string]interface{}{"error": apiErr})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		zap.L().Error("encoding response", zap.Error(err))
+		status = http.StatusInternalServerError
+		data = []byte(`{"error":{"code":"internal","message":"encoding response"}}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,81 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+)
+
+func TestServer(t *testing.T) {
+	const moment = `{"id":1,"nodes":[{"id":"a","type":"moment","metadata":{"id":"m"},"data":{"type":"moment","metadata":{"id":"m"}}}]}`
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"health", http.MethodGet, "/healthz", "", http.StatusOK, ""},
+		{"unknown endpoint", http.MethodGet, "/nope", "", http.StatusNotFound, CodeNotFound},
+		{"wrong method", http.MethodGet, "/convert", "", http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+		{"malformed body", http.MethodPost, "/validate", `{"graph":`, http.StatusBadRequest, CodeBadRequest},
+		{"body too large", http.MethodPost, "/validate", `{"graph":{"id":1},"tenant_id":"` + strings.Repeat("x", 256) + `"}`, http.StatusRequestEntityTooLarge, CodeTooLarge},
+		{"missing graph", http.MethodPost, "/convert", `{"tenant_id":"t"}`, http.StatusBadRequest, CodeBadRequest},
+		{"validate", http.MethodPost, "/validate", `{"graph":` + moment + `,"tenant_id":"t"}`, http.StatusOK, ""},
+		{"convert", http.MethodPost, "/convert", `{"graph":` + moment + `,"tenant_id":"t"}`, http.StatusOK, ""},
+		{"convert an invalid graph", http.MethodPost, "/convert", `{"graph":{"nodes":[]},"tenant_id":"t"}`, http.StatusUnprocessableEntity, CodeInvalidGraph},
+		{"metadata without a tenant", http.MethodPost, "/metadata", `{"rule_chains":[]}`, http.StatusBadRequest, CodeBadRequest},
+		{"metadata", http.MethodPost, "/metadata", `{"rule_chains":[],"tenant_id":"t"}`, http.StatusOK, ""},
+	}
+	server := NewServer(reactflow.NewMemoryMomentStore(), Options{MaxBodyBytes: 200})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			var envelope struct {
+				Error *Error `json:"error"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &envelope); err != nil {
+				t.Fatalf("response is not JSON: %v", err)
+			}
+			code := ""
+			if envelope.Error != nil {
+				code = envelope.Error.Code
+			}
+			if code != tt.wantCode {
+				t.Errorf("error code %q, want %q", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestEndpointTimesOut(t *testing.T) {
+	server := NewServer(nil, Options{Timeout: 10 * time.Millisecond})
+	release := make(chan struct{})
+	defer close(release)
+	received := make(chan interface{}, 1)
+	handler := server.endpoint(http.MethodPost, newGraphRequest, func(ctx context.Context, req interface{}) (interface{}, error) {
+		received <- req
+		<-release
+		return nil, nil
+	})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/convert", strings.NewReader(`{"graph":{"id":1}}`)))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+	if req, ok := (<-received).(*GraphRequest); !ok || req.Graph == nil {
+		t.Errorf("handler got %#v, want the decoded request", req)
+	}
+}
@@ -0,0 +1,315 @@
+package layout
+
+import "sort"
+
+// sweeps is the number of ordering passes run to reduce edge crossings.
+const sweeps = 24
+
+// size is the extent of an item to place.
+type size struct {
+	w, h float32
+}
+
+// point is the top-left corner of a placed item.
+type point struct {
+	x, y float32
+}
+
+// layered places items joined by links, each a pair of item indexes, in
+// layers from left to right, in the manner of Sugiyama: cycles are broken,
+// items are assigned to layers by their longest path from a source, links
+// spanning several layers are routed through dummy items, items are
+// ordered within layers by barycenter sweeps to reduce crossings, and each
+// item is then moved towards its neighbours. The result only depends on
+// the order of items and links. It returns the corner of every item and
+// the size of the whole layout.
+func layered(items []size, links [][2]int, layerGap, nodeGap float32) ([]point, size) {
+	n := len(items)
+	if n == 0 {
+		return nil, size{}
+	}
+	links = acyclic(n, links)
+
+	// Longest path layering, in the order of a topological sort that
+	// prefers earlier items.
+	layer := make([]int, n)
+	for _, v := range topological(n, links) {
+		for _, l := range links {
+			if l[0] == v && layer[l[1]] < layer[v]+1 {
+				layer[l[1]] = layer[v] + 1
+			}
+		}
+	}
+
+	// Dummy items break links that span more than one layer, so ordering
+	// sees every link between adjacent layers.
+	sizes := append([]size(nil), items...)
+	var short [][2]int
+	for _, l := range links {
+		from := l[0]
+		for k := layer[l[0]] + 1; k < layer[l[1]]; k++ {
+			sizes = append(sizes, size{})
+			layer = append(layer, k)
+			short = append(short, [2]int{from, len(sizes) - 1})
+			from = len(sizes) - 1
+		}
+		short = append(short, [2]int{from, l[1]})
+	}
+	preds := make([][]int, len(sizes))
+	succs := make([][]int, len(sizes))
+	for _, l := range short {
+		succs[l[0]] = append(succs[l[0]], l[1])
+		preds[l[1]] = append(preds[l[1]], l[0])
+	}
+
+	var layers [][]int
+	for v, k := range layer {
+		for len(layers) <= k {
+			layers = append(layers, nil)
+		}
+		layers[k] = append(layers[k], v)
+	}
+	layers = order(layers, preds, succs)
+
+	// Layers are columns as wide as their widest item.
+	xs := make([]float32, len(layers))
+	var width float32
+	for k, items := range layers {
+		xs[k] = width
+		var w float32
+		for _, v := range items {
+			if sizes[v].w > w {
+				w = sizes[v].w
+			}
+		}
+		width += w
+		if k < len(layers)-1 {
+			width += layerGap
+		}
+	}
+
+	ys := make([]float32, len(sizes))
+	for _, items := range layers {
+		var y float32
+		for _, v := range items {
+			ys[v] = y
+			y += sizes[v].h + nodeGap
+		}
+	}
+	for pass := 0; pass < 4; pass++ {
+		neighbours, sequence := preds, layers
+		if pass%2 == 1 {
+			neighbours, sequence = succs, reversed(layers)
+		}
+		for _, items := range sequence {
+			align(items, neighbours, sizes, ys, nodeGap)
+		}
+	}
+
+	var top, bottom float32
+	for v := range sizes {
+		if v == 0 || ys[v] < top {
+			top = ys[v]
+		}
+		if v == 0 || ys[v]+sizes[v].h > bottom {
+			bottom = ys[v] + sizes[v].h
+		}
+	}
+	points := make([]point, n)
+	for v := range points {
+		points[v] = point{x: xs[layer[v]], y: ys[v] - top}
+	}
+	return points, size{w: width, h: bottom - top}
+}
+
+// acyclic drops self links and duplicates, and reverses the links that
+// close a cycle in a depth-first search from the items in order.
+func acyclic(n int, links [][2]int) [][2]int {
+	out := make([][]int, n)
+	seen := make(map[[2]int]bool)
+	for _, l := range links {
+		if l[0] == l[1] || seen[l] {
+			continue
+		}
+		seen[l] = true
+		out[l[0]] = append(out[l[0]], l[1])
+	}
+
+	const (
+		unvisited = iota
+		active
+		done
+	)
+	state := make([]int, n)
+	back := make(map[[2]int]bool)
+	var visit func(v int)
+	visit = func(v int) {
+		state[v] = active
+		for _, w := range out[v] {
+			switch state[w] {
+			case active:
+				back[[2]int{v, w}] = true
+			case unvisited:
+				visit(w)
+			}
+		}
+		state[v] = done
+	}
+	for v := 0; v < n; v++ {
+		if state[v] == unvisited {
+			visit(v)
+		}
+	}
+
+	var result [][2]int
+	added := make(map[[2]int]bool)
+	for v := 0; v < n; v++ {
+		for _, w := range out[v] {
+			l := [2]int{v, w}
+			if back[l] {
+				l = [2]int{w, v}
+			}
+			if !added[l] {
+				added[l] = true
+				result = append(result, l)
+			}
+		}
+	}
+	return result
+}
+
+// topological sorts the items of an acyclic set of links, taking the
+// earliest ready item first.
+func topological(n int, links [][2]int) []int {
+	indegree := make([]int, n)
+	for _, l := range links {
+		indegree[l[1]]++
+	}
+	done := make([]bool, n)
+	var sorted []int
+	for len(sorted) < n {
+		for v := 0; v < n; v++ {
+			if done[v] || indegree[v] > 0 {
+				continue
+			}
+			done[v] = true
+			sorted = append(sorted, v)
+			for _, l := range links {
+				if l[0] == v {
+					indegree[l[1]]--
+				}
+			}
+			break
+		}
+	}
+	return sorted
+}
+
+// order reorders the items of each layer by the barycenter of their
+// neighbours, sweeping down and up, and keeps the ordering with the fewest
+// crossings.
+func order(layers [][]int, preds, succs [][]int) [][]int {
+	best := copyLayers(layers)
+	fewest := crossings(layers, succs)
+	for sweep := 0; sweep < sweeps && fewest > 0; sweep++ {
+		if sweep%2 == 0 {
+			for k := 1; k < len(layers); k++ {
+				sortByBarycenter(layers[k], layers[k-1], preds)
+			}
+		} else {
+			for k := len(layers) - 2; k >= 0; k-- {
+				sortByBarycenter(layers[k], layers[k+1], succs)
+			}
+		}
+		if c := crossings(layers, succs); c < fewest {
+			fewest = c
+			best = copyLayers(layers)
+		}
+	}
+	return best
+}
+
+func sortByBarycenter(items, fixed []int, neighbours [][]int) {
+	index := make(map[int]float64, len(fixed))
+	for i, v := range fixed {
+		index[v] = float64(i)
+	}
+	barycenter := make(map[int]float64, len(items))
+	for i, v := range items {
+		// Items without neighbours keep their place.
+		barycenter[v] = float64(i)
+		if len(neighbours[v]) == 0 {
+			continue
+		}
+		var sum float64
+		for _, w := range neighbours[v] {
+			sum += index[w]
+		}
+		barycenter[v] = sum / float64(len(neighbours[v]))
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return barycenter[items[i]] < barycenter[items[j]]
+	})
+}
+
+// crossings counts the pairs of links between adjacent layers that cross.
+func crossings(layers [][]int, succs [][]int) int {
+	count := 0
+	for k := 0; k+1 < len(layers); k++ {
+		index := make(map[int]int, len(layers[k+1]))
+		for i, v := range layers[k+1] {
+			index[v] = i
+		}
+		var links [][2]int
+		for i, v := range layers[k] {
+			for _, w := range succs[v] {
+				links = append(links, [2]int{i, index[w]})
+			}
+		}
+		for a := range links {
+			for b := a + 1; b < len(links); b++ {
+				if (links[a][0]-links[b][0])*(links[a][1]-links[b][1]) < 0 {
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
+
+// align moves the items of a layer towards the middle of their neighbours,
+// keeping their order and the gap between them.
+func align(items []int, neighbours [][]int, sizes []size, ys []float32, gap float32) {
+	var bottom float32
+	for i, v := range items {
+		y := ys[v]
+		if len(neighbours[v]) > 0 {
+			var sum float32
+			for _, w := range neighbours[v] {
+				sum += ys[w] + sizes[w].h/2
+			}
+			y = sum/float32(len(neighbours[v])) - sizes[v].h/2
+		}
+		if i > 0 && y < bottom {
+			y = bottom
+		}
+		ys[v] = y
+		bottom = y + sizes[v].h + gap
+	}
+}
+
+func copyLayers(layers [][]int) [][]int {
+	copied := make([][]int, len(layers))
+	for k, items := range layers {
+		copied[k] = append([]int(nil), items...)
+	}
+	return copied
+}
+
+func reversed(layers [][]int) [][]int {
+	r := make([][]int, len(layers))
+	for k, items := range layers {
+		r[len(layers)-1-k] = items
+	}
+	return r
+}
@@ -0,0 +1,206 @@
+// Package layout places the nodes of graphs that have no positions, such
+// as graphs read from YAML or compiled from rule expressions, so they open
+// readable in the editor instead of as a pile in the corner.
+//
+// Nodes are laid out in layers from left to right along the edges, the way
+// rules read. Blocks are stacked in their container in the order they are
+// tried, and the members of groups are laid out inside their group like
+// the nodes of a graph. Positions of children are relative to their parent,
+// as React Flow stores them; PositionAbsolute holds the position on the
+// canvas. Sizes come from Width and Height where the editor measured them,
+// and from the options otherwise; containers are sized by their content.
+package layout
+
+import (
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// Options are the sizes and gaps a layout works with, in canvas pixels.
+type Options struct {
+	// NodeWidth and NodeHeight size the nodes without a Width or Height.
+	NodeWidth  int
+	NodeHeight int
+	// BlockHeight is the height of blocks without one.
+	BlockHeight int
+	// LayerGap separates layers, NodeGap the nodes of a layer and
+	// BlockGap the blocks of a container.
+	LayerGap int
+	NodeGap  int
+	BlockGap int
+	// Padding is the space around the content of a container, and Header
+	// the space above it for the title of the container.
+	Padding int
+	Header  int
+}
+
+// DefaultOptions match the sizes of the editor.
+var DefaultOptions = Options{
+	NodeWidth:   250,
+	NodeHeight:  60,
+	BlockHeight: 40,
+	LayerGap:    120,
+	NodeGap:     40,
+	BlockGap:    8,
+	Padding:     16,
+	Header:      48,
+}
+
+// Apply returns a copy of graph with every node, block and group member
+// placed. Existing positions are replaced. Zero Options stand for
+// DefaultOptions.
+func Apply(graph reactFlowTypes.Graph, opts Options) reactFlowTypes.Graph {
+	if opts == (Options{}) {
+		opts = DefaultOptions
+	}
+	l := layouter{opts: opts}
+	graph.Nodes, _ = l.place(graph.Nodes, graph.Edges, point{})
+	for i := range graph.Nodes {
+		absolute(&graph.Nodes[i], reactFlowTypes.Position{})
+	}
+	return graph
+}
+
+// Fill lays out graph with Apply when it has no positions yet, and
+// returns it unchanged otherwise, so layouts made in the editor are kept.
+func Fill(graph reactFlowTypes.Graph, opts Options) reactFlowTypes.Graph {
+	if !Missing(graph) {
+		return graph
+	}
+	return Apply(graph, opts)
+}
+
+// Missing reports whether no top-level node of graph has a position, the
+// state of graphs that were never opened in the editor.
+func Missing(graph reactFlowTypes.Graph) bool {
+	for _, node := range graph.Nodes {
+		if node.Position.X != 0 || node.Position.Y != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type layouter struct {
+	opts Options
+}
+
+// place lays out a copy of nodes joined by edges, with the layout's
+// top-left corner at origin, and returns the size of the layout.
+func (l layouter) place(nodes []reactFlowTypes.Node, edges []reactFlowTypes.Edge, origin point) ([]reactFlowTypes.Node, size) {
+	if nodes == nil {
+		return nil, size{}
+	}
+	nodes = append([]reactFlowTypes.Node(nil), nodes...)
+	sizes := make([]size, len(nodes))
+	index := make(map[string]int, len(nodes))
+	for i := range nodes {
+		sizes[i] = l.node(&nodes[i])
+		index[nodes[i].ID] = i
+	}
+	// Edges leaving a block leave its container, whose ID is the source;
+	// edges to nodes of other levels are left out.
+	var links [][2]int
+	for _, edge := range edges {
+		from, ok := index[edge.Source]
+		to, found := index[edge.Target]
+		if ok && found {
+			links = append(links, [2]int{from, to})
+		}
+	}
+	points, extent := layered(sizes, links, float32(l.opts.LayerGap), float32(l.opts.NodeGap))
+	for i, p := range points {
+		nodes[i].Position = at(nodes[i].Position, point{x: origin.x + p.x, y: origin.y + p.y})
+	}
+	return nodes, extent
+}
+
+// node lays out the content of a node and returns its size.
+func (l layouter) node(node *reactFlowTypes.Node) size {
+	metadata := &node.Data.Metadata
+	var content size
+	switch {
+	case node.Type == "group-block-node":
+		metadata.Nodes, content = l.group(metadata.Nodes, metadata.Edges)
+	case len(metadata.Blocks) > 0:
+		metadata.Blocks, content = l.blocks(metadata.Blocks)
+	default:
+		return measured(node, size{w: float32(l.opts.NodeWidth), h: float32(l.opts.NodeHeight)})
+	}
+	return measured(node, l.frame(content))
+}
+
+// blocks stacks a copy of the blocks of a container and returns the size
+// of the stack.
+func (l layouter) blocks(blocks []reactFlowTypes.BlockNode) ([]reactFlowTypes.BlockNode, size) {
+	blocks = append([]reactFlowTypes.BlockNode(nil), blocks...)
+	inner := float32(l.opts.NodeWidth - 2*l.opts.Padding)
+	var stack size
+	for i := range blocks {
+		data := &blocks[i].NodeData
+		s := size{w: inner, h: float32(l.opts.BlockHeight)}
+		if data.Type == "group_block" {
+			var content size
+			data.Metadata.Nodes, content = l.group(data.Metadata.Nodes, data.Metadata.Edges)
+			s = l.frame(content)
+		}
+		s = measured(data, s)
+		if i > 0 {
+			stack.h += float32(l.opts.BlockGap)
+		}
+		data.Position = at(data.Position, point{x: float32(l.opts.Padding), y: float32(l.opts.Header) + stack.h})
+		stack.h += s.h
+		if s.w > stack.w {
+			stack.w = s.w
+		}
+	}
+	return blocks, stack
+}
+
+// group lays out a copy of the members of a group below its header and
+// returns the size they take.
+func (l layouter) group(nodes []reactFlowTypes.Node, edges []reactFlowTypes.Edge) ([]reactFlowTypes.Node, size) {
+	return l.place(nodes, edges, point{x: float32(l.opts.Padding), y: float32(l.opts.Header)})
+}
+
+// frame is the size of a container around content.
+func (l layouter) frame(content size) size {
+	return size{
+		w: content.w + float32(2*l.opts.Padding),
+		h: content.h + float32(l.opts.Header+l.opts.Padding),
+	}
+}
+
+// measured returns the size the editor measured for a node, falling back
+// to fallback for a dimension it has not measured.
+func measured(node *reactFlowTypes.Node, fallback size) size {
+	if node.Width > 0 {
+		fallback.w = float32(node.Width)
+	}
+	if node.Height > 0 {
+		fallback.h = float32(node.Height)
+	}
+	return fallback
+}
+
+// at moves position to p, keeping its unmodelled fields.
+func at(position reactFlowTypes.Position, p point) reactFlowTypes.Position {
+	position.X, position.Y = p.x, p.y
+	return position
+}
+
+// absolute sets the canvas position of a node and its children from the
+// position of its parent.
+func absolute(node *reactFlowTypes.Node, parent reactFlowTypes.Position) {
+	node.PositionAbsolute = at(node.PositionAbsolute, point{x: parent.X + node.Position.X, y: parent.Y + node.Position.Y})
+	metadata := &node.Data.Metadata
+	for i := range metadata.Nodes {
+		absolute(&metadata.Nodes[i], node.PositionAbsolute)
+	}
+	for i := range metadata.Blocks {
+		absolute(&metadata.Blocks[i].NodeData, node.PositionAbsolute)
+	}
+	// Group blocks keep their members in the metadata of the block data.
+	for i := range node.Metadata.Nodes {
+		absolute(&node.Metadata.Nodes[i], node.PositionAbsolute)
+	}
+}
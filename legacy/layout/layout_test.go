@@ -0,0 +1,93 @@
+package layout
+
+import (
+	"encoding/json"
+	"testing"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+func graph(t *testing.T, raw string) reactFlowTypes.Graph {
+	t.Helper()
+	var g reactFlowTypes.Graph
+	if err := json.Unmarshal([]byte(raw), &g); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// unplaced is a conditional node with two blocks leading to a response,
+// the shape rule expressions compile to.
+const unplaced = `{"id":"multiple","nodes":[` +
+	`{"id":"c","type":"conditional-node","data":{"metadata":{"blocks":[{"id":"a","data":{"type":"moment"}},{"id":"b","data":{"type":"moment"}}]}}},` +
+	`{"id":"r","type":"response-node","data":{"metadata":{"blocks":[{"id":"0","data":{}}]}}}],` +
+	`"edges":[{"source":"c","sourceHandle":"a_right","target":"r"},{"source":"c","sourceHandle":"b_right","target":"r"}]}`
+
+func TestMissing(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph string
+		want  bool
+	}{
+		{"empty", `{"id":1}`, true},
+		{"unplaced", unplaced, true},
+		{"one node placed", `{"id":1,"nodes":[{"id":"a"},{"id":"b","position":{"x":10}}]}`, false},
+		{"only a block placed", `{"id":1,"nodes":[{"id":"a","data":{"metadata":{"blocks":[{"id":"b","data":{"position":{"y":5}}}]}}}]}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Missing(graph(t, tt.graph)); got != tt.want {
+				t.Errorf("Missing() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	g := graph(t, unplaced)
+	placed := Apply(g, Options{})
+	if Missing(placed) {
+		t.Fatal("Apply() left the graph without positions")
+	}
+	if !Missing(g) {
+		t.Error("Apply() modified its argument")
+	}
+
+	condition, response := placed.Nodes[0], placed.Nodes[1]
+	if response.Position.X < condition.Position.X+float32(DefaultOptions.NodeWidth) {
+		t.Errorf("response at x %v is not right of the conditional node at x %v", response.Position.X, condition.Position.X)
+	}
+	blocks := condition.Data.Metadata.Blocks
+	if blocks[1].NodeData.Position.Y <= blocks[0].NodeData.Position.Y {
+		t.Errorf("blocks at y %v and %v are not stacked in order", blocks[0].NodeData.Position.Y, blocks[1].NodeData.Position.Y)
+	}
+	for _, block := range blocks {
+		want := reactFlowTypes.Position{
+			X: condition.PositionAbsolute.X + block.NodeData.Position.X,
+			Y: condition.PositionAbsolute.Y + block.NodeData.Position.Y,
+		}
+		got := block.NodeData.PositionAbsolute
+		if got.X != want.X || got.Y != want.Y {
+			t.Errorf("block %s at absolute %v,%v, want %v,%v", block.ID, got.X, got.Y, want.X, want.Y)
+		}
+	}
+
+	again, err := json.Marshal(Apply(placed, Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first, _ := json.Marshal(placed); string(again) != string(first) {
+		t.Errorf("layout is not stable:\n got %s\nwant %s", again, first)
+	}
+}
+
+func TestFillKeepsEditorLayout(t *testing.T) {
+	const raw = `{"id":1,"nodes":[{"id":"a","position":{"x":300,"y":20}},{"id":"b"}],"edges":[{"source":"a","target":"b"}]}`
+	filled := Fill(graph(t, raw), Options{})
+	if got, _ := json.Marshal(filled); string(got) != raw {
+		t.Errorf("Fill() = %s, want the graph unchanged", got)
+	}
+	if Missing(Fill(graph(t, unplaced), Options{})) {
+		t.Error("Fill() left an unplaced graph without positions")
+	}
+}
@@ -0,0 +1,152 @@
+package lifecycle
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// FileRepository is a Repository keeping documents and versions as JSON
+// files, for servers and tools that run without DynamoDB. The document of
+// a question is <dir>/<base64url(tenant)>/<question>.json and its versions
+// are in the directory <question>.versions next to it, one file per
+// number. Documents are upgraded to the latest schema version as they are
+// read.
+type FileRepository struct {
+	dir string
+}
+
+func NewFileRepository(dir string) (*FileRepository, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating question rule directory: %w", err)
+	}
+	return &FileRepository{dir: dir}, nil
+}
+
+func (r *FileRepository) path(key Key) string {
+	return filepath.Join(r.dir, base64.RawURLEncoding.EncodeToString([]byte(key.TenantID)), strconv.Itoa(int(key.QuestionID)))
+}
+
+func (r *FileRepository) versionPath(key Key, number int) string {
+	return filepath.Join(r.path(key)+".versions", strconv.Itoa(number)+".json")
+}
+
+func (r *FileRepository) Get(ctx context.Context, key Key) (*reactFlowTypes.QuestionRule, error) {
+	data, err := os.ReadFile(r.path(key) + ".json")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading question rule %s: %w", key, err)
+	}
+	return decodeRule(key, data)
+}
+
+// Put replaces the document atomically, so a crash leaves either the old
+// or the new one.
+func (r *FileRepository) Put(ctx context.Context, rule *reactFlowTypes.QuestionRule) error {
+	key := KeyOf(rule)
+	data, err := encodeRule(rule)
+	if err != nil {
+		return err
+	}
+	path := r.path(key) + ".json"
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("writing question rule %s: %w", key, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".rule-*")
+	if err != nil {
+		return fmt.Errorf("writing question rule %s: %w", key, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing question rule %s: %w", key, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("syncing question rule %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing question rule %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing question rule %s: %w", key, err)
+	}
+	return nil
+}
+
+// AppendVersion creates the version's file, which fails when the number is
+// taken; version files are never replaced.
+func (r *FileRepository) AppendVersion(ctx context.Context, version *reactFlowTypes.QuestionRuleVersion) error {
+	key := versionKey(version)
+	data, err := json.Marshal(version)
+	if err != nil {
+		return fmt.Errorf("encoding question rule %s version %d: %w", key, version.Number, err)
+	}
+	if version.Number < 1 {
+		return fmt.Errorf("question rule %s: version numbers start at 1, got %d", key, version.Number)
+	}
+	if version.Number > 1 {
+		if _, err := os.Stat(r.versionPath(key, version.Number-1)); err != nil {
+			return fmt.Errorf("question rule %s: version %d is not the next one: %w", key, version.Number, err)
+		}
+	}
+	path := r.versionPath(key, version.Number)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("writing question rule %s version %d: %w", key, version.Number, err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return ErrVersionExists
+	}
+	if err != nil {
+		return fmt.Errorf("writing question rule %s version %d: %w", key, version.Number, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("writing question rule %s version %d: %w", key, version.Number, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("syncing question rule %s version %d: %w", key, version.Number, err)
+	}
+	return f.Close()
+}
+
+func (r *FileRepository) GetVersion(ctx context.Context, key Key, number int) (*reactFlowTypes.QuestionRuleVersion, error) {
+	if number < 1 {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(r.versionPath(key, number))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading question rule %s version %d: %w", key, number, err)
+	}
+	return decodeVersion(key, data)
+}
+
+func (r *FileRepository) ListVersions(ctx context.Context, key Key) ([]reactFlowTypes.QuestionRuleVersion, error) {
+	list := make([]reactFlowTypes.QuestionRuleVersion, 0)
+	for number := 1; ; number++ {
+		version, err := r.GetVersion(ctx, key, number)
+		if errors.Is(err, ErrNotFound) {
+			return list, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *version)
+	}
+}
@@ -0,0 +1,76 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+func TestFileRepository(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo, err := NewFileRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := Key{TenantID: "acme/west", QuestionID: 7}
+	if _, err := repo.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, ErrNotFound)
+	}
+	if versions, err := repo.ListVersions(ctx, key); err != nil || len(versions) != 0 {
+		t.Errorf("ListVersions() = %v, %v; want none", versions, err)
+	}
+
+	service := NewService(repo, noMetadata)
+	if _, err := service.SaveDraft(ctx, key, exampleDraft(t)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := service.Publish(ctx, key, "author", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A repository opened on the same directory sees what was stored.
+	reopened, err := NewFileRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, err := reopened.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Version != 2 || len(rule.DraftConfig) != len(exampleDraft(t)) {
+		t.Errorf("stored document at version %d with %d draft graphs", rule.Version, len(rule.DraftConfig))
+	}
+	versions, err := reopened.ListVersions(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Number != 1 || versions[1].Number != 2 {
+		t.Errorf("versions %+v, want 1 and 2", versions)
+	}
+	if _, err := reopened.GetVersion(ctx, key, 3); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetVersion(3) error = %v, want %v", err, ErrNotFound)
+	}
+
+	tests := []struct {
+		name    string
+		number  int
+		wantErr error
+	}{
+		{"taken", 2, ErrVersionExists},
+		{"gap", 4, nil},
+		{"zero", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := reopened.AppendVersion(ctx, &reactFlowTypes.QuestionRuleVersion{TenantID: key.TenantID, QuestionID: key.QuestionID, Number: tt.number})
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("AppendVersion(%d) error = %v, want %v", tt.number, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+// Package lifecycle owns the draft/publish lifecycle of question rule
+// documents: editors save drafts, and publishing validates the draft,
+// converts it into rule chains and promotes it to the live config.
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"bitbucket.org/convin/go_services/rule_engine/internal/migrate"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrNotFound is returned when no rule document or version exists.
+	ErrNotFound = errors.New("question rule not found")
+	// ErrVersionExists is returned when appending a version whose number is
+	// already taken; versions are never overwritten.
+	ErrVersionExists = errors.New("question rule version already exists")
+)
+
+// Key identifies the rule document of one question.
+type Key struct {
+	TenantID   string
+	QuestionID int32
+}
+
+func (k Key) String() string {
+	return fmt.Sprintf("%s/%d", k.TenantID, k.QuestionID)
+}
+
+// KeyOf returns the key of a stored document.
+func KeyOf(rule *reactFlowTypes.QuestionRule) Key {
+	return Key{TenantID: rule.TenantID, QuestionID: rule.QuestionID}
+}
+
+// Repository stores question rule documents and their published versions.
+// Implementations return ErrNotFound for missing documents and versions and
+// must not share memory with the values they are given or return. Get
+// upgrades documents stored at an older schema version to the latest one,
+// and Put stores documents at the latest version; decodeRule and
+// encodeRule do both.
+type Repository interface {
+	Get(ctx context.Context, key Key) (*reactFlowTypes.QuestionRule, error)
+	Put(ctx context.Context, rule *reactFlowTypes.QuestionRule) error
+	// AppendVersion stores a new version, failing with ErrVersionExists when
+	// its number is taken.
+	AppendVersion(ctx context.Context, version *reactFlowTypes.QuestionRuleVersion) error
+	GetVersion(ctx context.Context, key Key, number int) (*reactFlowTypes.QuestionRuleVersion, error)
+	// ListVersions returns the versions of a question, oldest first.
+	ListVersions(ctx context.Context, key Key) ([]reactFlowTypes.QuestionRuleVersion, error)
+}
+
+// MemoryRepository is a Repository kept in process memory, for tests and
+// tools that run without DynamoDB.
+type MemoryRepository struct {
+	mu       sync.RWMutex
+	rules    map[Key][]byte
+	versions map[Key][][]byte
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		rules:    make(map[Key][]byte),
+		versions: make(map[Key][][]byte),
+	}
+}
+
+// Documents are kept encoded, so callers never share memory with the store
+// and see the same number handling as a real round trip. They are upgraded
+// to the latest schema version as they are read.
+func (r *MemoryRepository) Get(ctx context.Context, key Key) (*reactFlowTypes.QuestionRule, error) {
+	r.mu.RLock()
+	data, ok := r.rules[key]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return decodeRule(key, data)
+}
+
+// decodeRule decodes a stored document, upgrading it to the latest schema
+// version.
+func decodeRule(key Key, data []byte) (*reactFlowTypes.QuestionRule, error) {
+	rule, steps, err := migrate.Default.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding question rule %s: %w", key, err)
+	}
+	for _, step := range steps {
+		zap.L().Info("Migrated question rule", zap.String("tenant_id", key.TenantID), zap.Int32("question_id", key.QuestionID), zap.String("step", step.String()))
+	}
+	return rule, nil
+}
+
+// encodeRule encodes a document at the latest schema version. Documents
+// are upgraded as they are read, so anything written is at that version;
+// stored at 0, a new document would be migrated again on its next read.
+func encodeRule(rule *reactFlowTypes.QuestionRule) ([]byte, error) {
+	stored := *rule
+	stored.SchemaVersion = migrate.Default.Latest()
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return nil, fmt.Errorf("encoding question rule %s: %w", KeyOf(rule), err)
+	}
+	return data, nil
+}
+
+func (r *MemoryRepository) Put(ctx context.Context, rule *reactFlowTypes.QuestionRule) error {
+	data, err := encodeRule(rule)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.rules[KeyOf(rule)] = data
+	r.mu.Unlock()
+	return nil
+}
+
+func versionKey(version *reactFlowTypes.QuestionRuleVersion) Key {
+	return Key{TenantID: version.TenantID, QuestionID: version.QuestionID}
+}
+
+func (r *MemoryRepository) AppendVersion(ctx context.Context, version *reactFlowTypes.QuestionRuleVersion) error {
+	key := versionKey(version)
+	data, err := json.Marshal(version)
+	if err != nil {
+		return fmt.Errorf("encoding question rule %s version %d: %w", key, version.Number, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Versions are numbered from 1 without gaps, so the slice index is the
+	// number minus one.
+	if version.Number != len(r.versions[key])+1 {
+		if version.Number >= 1 && version.Number <= len(r.versions[key]) {
+			return ErrVersionExists
+		}
+		return fmt.Errorf("question rule %s: next version is %d, got %d", key, len(r.versions[key])+1, version.Number)
+	}
+	r.versions[key] = append(r.versions[key], data)
+	return nil
+}
+
+func (r *MemoryRepository) GetVersion(ctx context.Context, key Key, number int) (*reactFlowTypes.QuestionRuleVersion, error) {
+	r.mu.RLock()
+	versions := r.versions[key]
+	r.mu.RUnlock()
+	if number < 1 || number > len(versions) {
+		return nil, ErrNotFound
+	}
+	return decodeVersion(key, versions[number-1])
+}
+
+func (r *MemoryRepository) ListVersions(ctx context.Context, key Key) ([]reactFlowTypes.QuestionRuleVersion, error) {
+	r.mu.RLock()
+	versions := r.versions[key]
+	r.mu.RUnlock()
+	list := make([]reactFlowTypes.QuestionRuleVersion, 0, len(versions))
+	for _, data := range versions {
+		version, err := decodeVersion(key, data)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *version)
+	}
+	return list, nil
+}
+
+func decodeVersion(key Key, data []byte) (*reactFlowTypes.QuestionRuleVersion, error) {
+	var version reactFlowTypes.QuestionRuleVersion
+	if err := json.Unmarshal(data, &version); err != nil {
+		return nil, fmt.Errorf("decoding question rule %s version: %w", key, err)
+	}
+	return &version, nil
+}
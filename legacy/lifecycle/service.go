@@ -0,0 +1,282 @@
+package lifecycle
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/migrate"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+	"go.uber.org/zap"
+)
+
+// ErrNoDraft is returned when publishing a document without a draft.
+var ErrNoDraft = errors.New("question rule has no draft")
+
+// ValidationError is returned by Publish when the draft has errors.
+type ValidationError struct {
+	Diagnostics []reactflow.Diagnostic
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Diagnostics))
+	for _, diagnostic := range e.Diagnostics {
+		if diagnostic.Severity != reactflow.SeverityError {
+			continue
+		}
+		switch {
+		case diagnostic.NodeID != "":
+			messages = append(messages, fmt.Sprintf("node %s: %s", diagnostic.NodeID, diagnostic.Message))
+		case diagnostic.Path != "":
+			messages = append(messages, fmt.Sprintf("%s: %s", diagnostic.Path, diagnostic.Message))
+		default:
+			messages = append(messages, diagnostic.Message)
+		}
+	}
+	return "draft is invalid: " + strings.Join(messages, "; ")
+}
+
+// MetadataFunc computes the metadata stored with published rule chains;
+// reactflow.GetRuleMetadata is the production implementation.
+type MetadataFunc func(ruleChains []types.RuleChain, parameterID int32, tenantID string) (map[string]interface{}, error)
+
+// Service applies the lifecycle rules on top of a Repository. Operations on
+// the same question are serialized so read-modify-write cycles do not race;
+// operations on different questions run concurrently.
+type Service struct {
+	repo     Repository
+	metadata MetadataFunc
+	now      func() time.Time
+	locks    keyLocks
+}
+
+// keyLocks holds a mutex per question while it is in use.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[Key]*keyLock
+}
+
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock locks key and returns the function that unlocks it.
+func (l *keyLocks) lock(key Key) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[Key]*keyLock)
+	}
+	lock := l.locks[key]
+	if lock == nil {
+		lock = &keyLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		l.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// NewService returns a Service storing documents in repo. A nil metadata
+// function defaults to reactflow.GetRuleMetadata.
+func NewService(repo Repository, metadata MetadataFunc) *Service {
+	if metadata == nil {
+		metadata = reactflow.GetRuleMetadata
+	}
+	return &Service{repo: repo, metadata: metadata, now: time.Now}
+}
+
+// Get returns the stored document of a question.
+func (s *Service) Get(ctx context.Context, key Key) (*reactFlowTypes.QuestionRule, error) {
+	return s.repo.Get(ctx, key)
+}
+
+// SaveDraft replaces the draft of a question, creating the document when
+// the question has none yet. The published config is left untouched.
+func (s *Service) SaveDraft(ctx context.Context, key Key, draft []reactFlowTypes.Graph) (*reactFlowTypes.QuestionRule, error) {
+	defer s.locks.lock(key)()
+	now := s.now().Unix()
+	rule, err := s.repo.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		id, err := newID()
+		if err != nil {
+			return nil, err
+		}
+		rule = &reactFlowTypes.QuestionRule{
+			ID:            id,
+			TenantID:      key.TenantID,
+			QuestionID:    key.QuestionID,
+			SchemaVersion: migrate.Default.Latest(),
+			CreatedAt:     now,
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	rule.DraftConfig = draft
+	rule.UpdatedAt = now
+	if err := s.repo.Put(ctx, rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// ValidateDraft runs the publish checks on the stored draft without
+// publishing it.
+func (s *Service) ValidateDraft(ctx context.Context, key Key) ([]reactflow.Diagnostic, error) {
+	rule, err := s.repo.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(rule.DraftConfig) == 0 {
+		return nil, ErrNoDraft
+	}
+	return ValidateGraphs(rule.DraftConfig, key.TenantID), nil
+}
+
+// ValidateGraphs validates every graph of a config. The messages of graphs
+// after the first are prefixed with their index, since node IDs only need
+// to be unique within one graph.
+func ValidateGraphs(graphs []reactFlowTypes.Graph, tenantID string) []reactflow.Diagnostic {
+	var diagnostics []reactflow.Diagnostic
+	for i, graph := range graphs {
+		for _, diagnostic := range reactflow.ValidateGraph(graph, tenantID) {
+			if len(graphs) > 1 {
+				diagnostic.Message = fmt.Sprintf("graph %d: %s", i, diagnostic.Message)
+			}
+			diagnostics = append(diagnostics, diagnostic)
+		}
+	}
+	return diagnostics
+}
+
+// Publish promotes the draft of a question: it validates the draft,
+// converts every graph into a rule chain, computes the rule metadata, records
+// the result as a new version and copies the draft into config. The draft is
+// kept so editing continues from what was published. Invalid drafts are
+// rejected with a *ValidationError and leave the document unchanged.
+func (s *Service) Publish(ctx context.Context, key Key, author, note string) (*reactFlowTypes.QuestionRule, error) {
+	defer s.locks.lock(key)()
+	rule, err := s.repo.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(rule.DraftConfig) == 0 {
+		return nil, ErrNoDraft
+	}
+	diagnostics := ValidateGraphs(rule.DraftConfig, key.TenantID)
+	if reactflow.HasErrors(diagnostics) {
+		return nil, &ValidationError{Diagnostics: diagnostics}
+	}
+	ruleChains, err := ConvertGraphs(rule.DraftConfig, key.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := s.metadata(ruleChains, key.QuestionID, key.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("computing rule metadata: %w", err)
+	}
+
+	version := &reactFlowTypes.QuestionRuleVersion{
+		TenantID:       key.TenantID,
+		QuestionID:     key.QuestionID,
+		Config:         rule.DraftConfig,
+		InternalConfig: ruleChains,
+		Metadata:       metadata,
+		Author:         author,
+		Note:           note,
+		CreatedAt:      s.now().Unix(),
+	}
+	if err := s.promote(ctx, rule, version); err != nil {
+		return nil, err
+	}
+	zap.L().Info("Published question rule", zap.String("tenant_id", key.TenantID), zap.Int32("question_id", key.QuestionID), zap.Int("version", version.Number))
+	return rule, nil
+}
+
+// promote numbers version after the latest stored one, appends it and
+// makes it the published config of rule. The version is written first, so
+// the document never points at a version that does not exist. A failed
+// document write leaves an orphan version that was never live; numbering
+// from the stored versions rather than from rule.Version lets the next
+// publish go ahead after it.
+func (s *Service) promote(ctx context.Context, rule *reactFlowTypes.QuestionRule, version *reactFlowTypes.QuestionRuleVersion) error {
+	versions, err := s.repo.ListVersions(ctx, KeyOf(rule))
+	if err != nil {
+		return err
+	}
+	version.Number = rule.Version + 1
+	if n := len(versions); n > 0 && versions[n-1].Number >= version.Number {
+		version.Number = versions[n-1].Number + 1
+	}
+	if err := s.repo.AppendVersion(ctx, version); err != nil {
+		return err
+	}
+	rule.Config = version.Config
+	rule.InternalConfig = version.InternalConfig
+	rule.Metadata = version.Metadata
+	rule.Version = version.Number
+	rule.PublishedAt = version.CreatedAt
+	rule.UpdatedAt = version.CreatedAt
+	return s.repo.Put(ctx, rule)
+}
+
+// ConvertGraphs converts every graph of a config into its rule chain, with
+// the compiled comparison stored on each validateInfo node. A graph the
+// converter cannot handle is an error, never a panic.
+func ConvertGraphs(graphs []reactFlowTypes.Graph, tenantID string) ([]types.RuleChain, error) {
+	ruleChains := make([]types.RuleChain, 0, len(graphs))
+	for i, graph := range graphs {
+		ruleChain, err := reactflow.ConvertChecked(graph, tenantID)
+		if err != nil {
+			return nil, fmt.Errorf("converting graph %d: %w", i, err)
+		}
+		if err := reactflow.CompileChainComparisons(&ruleChain); err != nil {
+			return nil, fmt.Errorf("converting graph %d: %w", i, err)
+		}
+		ruleChains = append(ruleChains, ruleChain)
+	}
+	return ruleChains, nil
+}
+
+// DiscardDraft resets the draft to the published config, or clears it when
+// the question was never published.
+func (s *Service) DiscardDraft(ctx context.Context, key Key) (*reactFlowTypes.QuestionRule, error) {
+	defer s.locks.lock(key)()
+	rule, err := s.repo.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	rule.DraftConfig = rule.Config
+	rule.UpdatedAt = s.now().Unix()
+	if err := s.repo.Put(ctx, rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// newID returns a random (version 4) UUID, the format of stored document IDs.
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generating document id: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,198 @@
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/migrate"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+func noMetadata(ruleChains []types.RuleChain, parameterID int32, tenantID string) (map[string]interface{}, error) {
+	return map[string]interface{}{}, nil
+}
+
+// exampleDraft returns the draft config of the example document.
+func exampleDraft(t *testing.T) []reactFlowTypes.Graph {
+	t.Helper()
+	data, err := os.ReadFile("../../tests/example_small.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rule reactFlowTypes.QuestionRule
+	if err := json.Unmarshal(data, &rule); err != nil {
+		t.Fatal(err)
+	}
+	return rule.DraftConfig
+}
+
+// failingPuts is a repository whose next Put calls fail.
+type failingPuts struct {
+	*MemoryRepository
+	failures int
+}
+
+func (r *failingPuts) Put(ctx context.Context, rule *reactFlowTypes.QuestionRule) error {
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("write failed")
+	}
+	return r.MemoryRepository.Put(ctx, rule)
+}
+
+func TestSaveDraftStoresLatestSchemaVersion(t *testing.T) {
+	files, err := NewFileRepository(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := NewMemoryRepository()
+	key := Key{TenantID: "acme", QuestionID: 7}
+	tests := []struct {
+		name string
+		repo Repository
+		// stored returns the document as the repository wrote it.
+		stored func() ([]byte, error)
+	}{
+		{"memory", memory, func() ([]byte, error) { return memory.rules[key], nil }},
+		{"files", files, func() ([]byte, error) { return os.ReadFile(files.path(key) + ".json") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := NewService(tt.repo, noMetadata).SaveDraft(context.Background(), key, exampleDraft(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rule.SchemaVersion != migrate.Default.Latest() {
+				t.Errorf("new document at schema version %d, want %d", rule.SchemaVersion, migrate.Default.Latest())
+			}
+			data, err := tt.stored()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stored struct {
+				SchemaVersion int `json:"schema_version"`
+			}
+			if err := json.Unmarshal(data, &stored); err != nil {
+				t.Fatal(err)
+			}
+			if stored.SchemaVersion != migrate.Default.Latest() {
+				t.Errorf("stored at schema version %d, want %d", stored.SchemaVersion, migrate.Default.Latest())
+			}
+		})
+	}
+}
+
+func TestPublishNumbersVersions(t *testing.T) {
+	ctx := context.Background()
+	key := Key{TenantID: "flipkartdemo", QuestionID: 33}
+	tests := []struct {
+		name        string
+		failures    []int
+		wantVersion int
+		wantStored  int
+	}{
+		{"first publish", []int{0}, 1, 1},
+		{"republish", []int{0, 0}, 2, 2},
+		// The failed publish leaves version 1 behind without the document
+		// pointing at it; the next one becomes version 2.
+		{"after a failed document write", []int{1, 0}, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &failingPuts{MemoryRepository: NewMemoryRepository()}
+			service := NewService(repo, noMetadata)
+			if _, err := service.SaveDraft(ctx, key, exampleDraft(t)); err != nil {
+				t.Fatal(err)
+			}
+			var rule *reactFlowTypes.QuestionRule
+			var err error
+			for _, failures := range tt.failures {
+				repo.failures = failures
+				rule, err = service.Publish(ctx, key, "author", "")
+				if (err != nil) != (failures > 0) {
+					t.Fatalf("Publish() error = %v with %d failing writes", err, failures)
+				}
+			}
+			if rule.Version != tt.wantVersion {
+				t.Errorf("published version %d, want %d", rule.Version, tt.wantVersion)
+			}
+			versions, err := service.ListVersions(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(versions) != tt.wantStored {
+				t.Errorf("%d versions stored, want %d", len(versions), tt.wantStored)
+			}
+			stored, err := service.Get(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Version != tt.wantVersion {
+				t.Errorf("stored document is at version %d, want %d", stored.Version, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestPublishRejectsUnconvertibleGraphs(t *testing.T) {
+	ctx := context.Background()
+	key := Key{TenantID: "tenant", QuestionID: 1}
+	// The converter dereferences the conditional node of a "multiple" graph
+	// without checking that there is one.
+	const raw = `{"id":"multiple","nodes":[{"id":"a","type":"moment","data":{"type":"moment","metadata":{"id":"m"}}}]}`
+	var graph reactFlowTypes.Graph
+	if err := json.Unmarshal([]byte(raw), &graph); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConvertGraphs([]reactFlowTypes.Graph{graph}, key.TenantID); err == nil {
+		t.Error("ConvertGraphs() succeeded, want an error")
+	}
+	service := NewService(NewMemoryRepository(), noMetadata)
+	if _, err := service.SaveDraft(ctx, key, []reactFlowTypes.Graph{graph}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Publish(ctx, key, "author", ""); err == nil {
+		t.Error("Publish() succeeded, want an error")
+	}
+}
+
+func TestPublishLocksPerQuestion(t *testing.T) {
+	ctx := context.Background()
+	slow, fast := Key{TenantID: "tenant", QuestionID: 1}, Key{TenantID: "tenant", QuestionID: 2}
+	entered, release := make(chan struct{}), make(chan struct{})
+	metadata := func(ruleChains []types.RuleChain, parameterID int32, tenantID string) (map[string]interface{}, error) {
+		if parameterID == slow.QuestionID {
+			close(entered)
+			<-release
+		}
+		return map[string]interface{}{}, nil
+	}
+	service := NewService(NewMemoryRepository(), metadata)
+	for _, key := range []Key{slow, fast} {
+		if _, err := service.SaveDraft(ctx, key, exampleDraft(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := service.Publish(ctx, slow, "author", "")
+		done <- err
+	}()
+	<-entered
+	// The slow publish holds its question; the other one is not held up.
+	if _, err := service.SaveDraft(ctx, fast, exampleDraft(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Publish(ctx, fast, "author", ""); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
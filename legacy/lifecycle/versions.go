@@ -0,0 +1,126 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/diff"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+	"go.uber.org/zap"
+)
+
+// ListVersions returns the published versions of a question, oldest first.
+func (s *Service) ListVersions(ctx context.Context, key Key) ([]reactFlowTypes.QuestionRuleVersion, error) {
+	return s.repo.ListVersions(ctx, key)
+}
+
+// GetVersion returns one published version of a question.
+func (s *Service) GetVersion(ctx context.Context, key Key, number int) (*reactFlowTypes.QuestionRuleVersion, error) {
+	return s.repo.GetVersion(ctx, key, number)
+}
+
+// VersionDiff lists what changed between two versions, one entry per
+// graph of the config, paired by position.
+type VersionDiff struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+	// Config is the structural diff of the graphs and InternalConfig the
+	// semantic diff of the rule chains converted from them.
+	Config         []*diff.GraphDiff `json:"config"`
+	InternalConfig []*diff.ChainDiff `json:"internal_config"`
+}
+
+// DiffVersions compares version from with version to.
+func (s *Service) DiffVersions(ctx context.Context, key Key, from, to int, opts diff.GraphOptions) (*VersionDiff, error) {
+	fromVersion, err := s.repo.GetVersion(ctx, key, from)
+	if err != nil {
+		return nil, fmt.Errorf("version %d: %w", from, err)
+	}
+	toVersion, err := s.repo.GetVersion(ctx, key, to)
+	if err != nil {
+		return nil, fmt.Errorf("version %d: %w", to, err)
+	}
+	return &VersionDiff{
+		From:           from,
+		To:             to,
+		Config:         diffGraphLists(fromVersion.Config, toVersion.Config, opts),
+		InternalConfig: diffChainLists(fromVersion.InternalConfig, toVersion.InternalConfig),
+	}, nil
+}
+
+// Rollback republishes an earlier version as a new version, so history is
+// never rewritten. The stored rule chains and metadata of the old version
+// are reused as they are, and the draft is reset to its graphs.
+func (s *Service) Rollback(ctx context.Context, key Key, number int, author, note string) (*reactFlowTypes.QuestionRule, error) {
+	defer s.locks.lock(key)()
+	rule, err := s.repo.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	target, err := s.repo.GetVersion(ctx, key, number)
+	if err != nil {
+		return nil, fmt.Errorf("version %d: %w", number, err)
+	}
+	if note == "" {
+		note = fmt.Sprintf("rollback to version %d", number)
+	}
+	version := &reactFlowTypes.QuestionRuleVersion{
+		TenantID:       key.TenantID,
+		QuestionID:     key.QuestionID,
+		Config:         target.Config,
+		InternalConfig: target.InternalConfig,
+		Metadata:       target.Metadata,
+		Author:         author,
+		Note:           note,
+		CreatedAt:      s.now().Unix(),
+		RollbackOf:     number,
+	}
+	rule.DraftConfig = target.Config
+	if err := s.promote(ctx, rule, version); err != nil {
+		return nil, err
+	}
+	zap.L().Info("Rolled back question rule", zap.String("tenant_id", key.TenantID), zap.Int32("question_id", key.QuestionID), zap.Int("version", version.Number), zap.Int("rollback_of", number))
+	return rule, nil
+}
+
+// DiffDraft compares the published config of a question with its draft.
+func (s *Service) DiffDraft(ctx context.Context, key Key, opts diff.GraphOptions) ([]*diff.GraphDiff, error) {
+	rule, err := s.repo.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return diffGraphLists(rule.Config, rule.DraftConfig, opts), nil
+}
+
+// diffGraphLists pairs graphs by position. A graph only present on one side
+// is compared with an empty graph.
+func diffGraphLists(a, b []reactFlowTypes.Graph, opts diff.GraphOptions) []*diff.GraphDiff {
+	var diffs []*diff.GraphDiff
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var before, after reactFlowTypes.Graph
+		if i < len(a) {
+			before = a[i]
+		}
+		if i < len(b) {
+			after = b[i]
+		}
+		diffs = append(diffs, diff.Graphs(before, after, opts))
+	}
+	return diffs
+}
+
+func diffChainLists(a, b []types.RuleChain) []*diff.ChainDiff {
+	var diffs []*diff.ChainDiff
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var before, after types.RuleChain
+		if i < len(a) {
+			before = a[i]
+		}
+		if i < len(b) {
+			after = b[i]
+		}
+		diffs = append(diffs, diff.RuleChains(before, after))
+	}
+	return diffs
+}
@@ -0,0 +1,124 @@
+package lint
+
+import (
+	"sort"
+	"strings"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+)
+
+func (l *linter) chain(chain types.RuleChain) {
+	l.unreachable(chain)
+	for _, node := range chain.Metadata.Nodes {
+		if node != nil && node.Type == "singleBlock" {
+			l.contradictions(chain, node)
+		}
+	}
+}
+
+// unreachable reports the nodes that no path from the first node reaches,
+// following connections and the members of containers. Members of an
+// unreachable container are not reported on their own.
+func (l *linter) unreachable(chain types.RuleChain) {
+	nodes := make(map[string]*types.RuleNode)
+	members := make(map[string]bool)
+	for _, node := range chain.Metadata.Nodes {
+		if node == nil {
+			continue
+		}
+		nodes[node.Id] = node
+		for _, id := range reactflow.ConfigStrings(node.Configuration, "NodeIdList") {
+			members[id] = true
+		}
+	}
+	outgoing := make(map[string][]string)
+	// dangling maps a node to the unknown IDs its connections come from.
+	dangling := make(map[string][]string)
+	for _, connection := range chain.Metadata.Connections {
+		outgoing[connection.FromId] = append(outgoing[connection.FromId], connection.ToId)
+		if nodes[connection.FromId] == nil {
+			dangling[connection.ToId] = append(dangling[connection.ToId], connection.FromId)
+		}
+	}
+
+	index := chain.Metadata.FirstNodeIndex
+	if index < 0 || index >= len(chain.Metadata.Nodes) || chain.Metadata.Nodes[index] == nil {
+		return
+	}
+	root := chain.Metadata.Nodes[index].Id
+	reached := map[string]bool{root: true}
+	queue := []string{root}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		next := outgoing[id]
+		if node := nodes[id]; node != nil {
+			next = append(reactflow.ConfigStrings(node.Configuration, "NodeIdList"), next...)
+		}
+		for _, to := range next {
+			if !reached[to] {
+				reached[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+
+	for _, node := range chain.Metadata.Nodes {
+		if node == nil || reached[node.Id] || members[node.Id] {
+			continue
+		}
+		if from := dangling[node.Id]; len(from) > 0 {
+			l.report(CodeUnreachable, node.Id, "",
+				"node %s (%s) cannot be reached from the first node %s: it is connected from %s, which is not a node; reconnect it or remove it",
+				node.Id, node.Type, root, strings.Join(from, ", "))
+			continue
+		}
+		l.report(CodeUnreachable, node.Id, "",
+			"node %s (%s) cannot be reached from the first node %s; connect it or remove it", node.Id, node.Type, root)
+	}
+}
+
+// contradictions reports a group whose members are all ANDed and require
+// a moment both detected and not.
+func (l *linter) contradictions(chain types.RuleChain, group *types.RuleNode) {
+	for _, edge := range reactflow.ConfigEdges(group.Configuration) {
+		if strings.EqualFold(edge.Operator, "or") {
+			return
+		}
+	}
+	nodes := make(map[string]*types.RuleNode)
+	for _, node := range chain.Metadata.Nodes {
+		if node != nil {
+			nodes[node.Id] = node
+		}
+	}
+	// polarity records, per moment, whether it is required and whether
+	// its negation is.
+	polarity := make(map[string][2]bool)
+	for _, id := range reactflow.ConfigStrings(group.Configuration, "NodeIdList") {
+		member := nodes[id]
+		if member == nil || member.Type != "moment" {
+			continue
+		}
+		moment := reactflow.ConfigString(member.Configuration, "id")
+		p := polarity[moment]
+		if reactflow.ConfigBool(member.Configuration, "is_not") {
+			p[1] = true
+		} else {
+			p[0] = true
+		}
+		polarity[moment] = p
+	}
+	var contradicted []string
+	for moment, p := range polarity {
+		if p[0] && p[1] {
+			contradicted = append(contradicted, moment)
+		}
+	}
+	sort.Strings(contradicted)
+	for _, moment := range contradicted {
+		l.report(CodeContradiction, group.Id, "",
+			"group %s requires moment %s and its negation together, so it never holds; use OR or drop one of them", group.Id, moment)
+	}
+}
@@ -0,0 +1,152 @@
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// blockContainers are the node types whose blocks are conditions, as
+// opposed to the answers of a response node.
+var blockContainers = map[string]bool{
+	"conditional-node":     true,
+	"conditional-gpt-node": true,
+	"default-block-node":   true,
+}
+
+func (l *linter) graph(graph reactFlowTypes.Graph) {
+	for i, node := range graph.Nodes {
+		path := fmt.Sprintf("/nodes/%d", i)
+		l.paths[node.ID] = path
+		for j, member := range node.Data.Metadata.Nodes {
+			l.paths[member.ID] = fmt.Sprintf("%s/data/metadata/nodes/%d", path, j)
+		}
+		blocks := node.Data.Metadata.Blocks
+		for j, block := range blocks {
+			l.paths[block.ID] = fmt.Sprintf("%s/data/metadata/blocks/%d", path, j)
+			for k, member := range block.NodeData.Metadata.Nodes {
+				l.paths[member.ID] = fmt.Sprintf("%s/data/metadata/blocks/%d/data/metadata/nodes/%d", path, j, k)
+			}
+		}
+
+		switch {
+		case blockContainers[node.Type]:
+			for j, block := range blocks {
+				if block.NodeData.Type == "" && (node.Type != "default-block-node" || block.IsSelected) {
+					l.report(CodeUntypedBlock, block.ID, fmt.Sprintf("%s/data/metadata/blocks/%d", path, j),
+						"block %s of %s has no type and is skipped by the converter; give it a type or remove it", block.ID, node.ID)
+				}
+			}
+			l.duplicates(node, path)
+		case node.Type == "response-node":
+			hasDefault := false
+			for _, block := range blocks {
+				if block.NodeData.Type == "" {
+					hasDefault = true
+				}
+			}
+			if !hasDefault {
+				l.report(CodeNoDefault, node.ID, path,
+					"response node %s has no default block, so calls matching none of its blocks get no answer", node.ID)
+			}
+			l.duplicates(node, path)
+		}
+
+		if node.Type == "conditional-node" && len(blocks) == 1 {
+			l.report(CodeSingleBlock, node.ID, path,
+				"conditional node %s has a single block; add the other outcomes or connect its block directly", node.ID)
+		}
+		if node.Type == "conditional-gpt-node" && strings.TrimSpace(node.Data.Metadata.Prompt) == "" {
+			l.report(CodeEmptyPrompt, node.ID, path+"/data/metadata/prompt",
+				"GPT node %s has an empty prompt; write the question the model should answer", node.ID)
+		}
+	}
+}
+
+// duplicates reports the blocks of a node that repeat an earlier block:
+// same type, negation and metadata, whatever their name. The second block
+// can never be the first to hold.
+func (l *linter) duplicates(node reactFlowTypes.Node, path string) {
+	seen := make(map[string]string)
+	for j, block := range node.Data.Metadata.Blocks {
+		data := block.NodeData
+		if data.Type == "" || data.Type == "group_block" {
+			// Defaults are reported as missing, not repeated, and groups
+			// differ by the IDs of their members.
+			continue
+		}
+		if node.Type == "default-block-node" && !block.IsSelected {
+			continue
+		}
+		key, err := blockKey(data)
+		if err != nil {
+			continue
+		}
+		if first, ok := seen[key]; ok {
+			l.report(CodeDuplicate, block.ID, fmt.Sprintf("%s/data/metadata/blocks/%d", path, j),
+				"block %s of %s checks the same as block %s; remove one of them", block.ID, node.ID, first)
+			continue
+		}
+		seen[key] = block.ID
+	}
+}
+
+// blockKey encodes what a block checks with sorted keys and without empty
+// values, so equal checks give equal keys however they were written.
+func blockKey(data reactFlowTypes.Node) (string, error) {
+	encoded, err := json.Marshal(struct {
+		Type     string                  `json:"type"`
+		IsNot    bool                    `json:"is_not"`
+		Metadata reactFlowTypes.Metadata `json:"metadata"`
+	}{data.Type, data.IsNot, data.Metadata})
+	if err != nil {
+		return "", err
+	}
+	var generic map[string]interface{}
+	if err := json.Unmarshal(encoded, &generic); err != nil {
+		return "", err
+	}
+	if metadata, ok := generic["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "name")
+	}
+	canonical, err := json.Marshal(withoutEmpty(generic))
+	return string(canonical), err
+}
+
+// withoutEmpty drops the null, false, zero and empty values of decoded
+// JSON, which the editor writes or leaves out at will.
+func withoutEmpty(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		pruned := make(map[string]interface{})
+		for key, field := range value {
+			if field = withoutEmpty(field); field != nil {
+				pruned[key] = field
+			}
+		}
+		if len(pruned) == 0 {
+			return nil
+		}
+		return pruned
+	case []interface{}:
+		if len(value) == 0 {
+			return nil
+		}
+		return value
+	case string:
+		if value == "" {
+			return nil
+		}
+	case bool:
+		if !value {
+			return nil
+		}
+	case float64:
+		if value == 0 {
+			return nil
+		}
+	}
+	return value
+}
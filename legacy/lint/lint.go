@@ -0,0 +1,148 @@
+// Package lint finds rules that are valid but likely not what their author
+// meant, such as blocks the converter skips or groups that can never hold,
+// and says how to fix them. Findings are reactflow diagnostics carrying the
+// stable code of their rule, so editors and CI can filter on them, and
+// every rule can be turned off or given another severity.
+package lint
+
+import (
+	"fmt"
+	"sort"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// SeverityOff turns a rule off in a Config.
+const SeverityOff = "off"
+
+// The codes of the rules. Codes are never reused or renumbered.
+const (
+	CodeUnreachable   = "L001"
+	CodeUntypedBlock  = "L002"
+	CodeSingleBlock   = "L003"
+	CodeContradiction = "L004"
+	CodeDuplicate     = "L005"
+	CodeNoDefault     = "L006"
+	CodeEmptyPrompt   = "L007"
+)
+
+// Rule is one check of the linter.
+type Rule struct {
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+}
+
+var rules = []Rule{
+	{CodeUnreachable, "unreachable-node", reactflow.SeverityWarning, "a node cannot be reached from the first node of the rule chain"},
+	{CodeUntypedBlock, "untyped-block", reactflow.SeverityWarning, "a block has no type, so the converter skips it"},
+	{CodeSingleBlock, "single-block", reactflow.SeverityWarning, "a conditional node has a single block"},
+	{CodeContradiction, "moment-contradiction", reactflow.SeverityWarning, "a moment and its negation are ANDed, so the group never holds"},
+	{CodeDuplicate, "duplicate-block", reactflow.SeverityWarning, "two blocks of a node check the same thing"},
+	{CodeNoDefault, "response-without-default", reactflow.SeverityWarning, "a response node has no default block"},
+	{CodeEmptyPrompt, "empty-gpt-prompt", reactflow.SeverityWarning, "a GPT node has no prompt"},
+}
+
+// Rules returns the rules of the linter in code order.
+func Rules() []Rule {
+	return append([]Rule(nil), rules...)
+}
+
+// Config adjusts the rules of the linter.
+type Config struct {
+	// Severity maps the code or name of a rule to "error", "warning" or
+	// "off". Rules not listed keep their default severity.
+	Severity map[string]string `json:"severity,omitempty"`
+}
+
+// Validate checks that the config names known rules and severities.
+func (c Config) Validate() error {
+	keys := make([]string, 0, len(c.Severity))
+	for key := range c.Severity {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, ok := findRule(key); !ok {
+			return fmt.Errorf("unknown lint rule %q", key)
+		}
+		switch c.Severity[key] {
+		case reactflow.SeverityError, reactflow.SeverityWarning, SeverityOff:
+		default:
+			return fmt.Errorf("lint rule %s: unknown severity %q", key, c.Severity[key])
+		}
+	}
+	return nil
+}
+
+func findRule(key string) (Rule, bool) {
+	for _, rule := range rules {
+		if rule.Code == key || rule.Name == key {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// severity returns the severity of the rule with code, its code winning
+// over its name when the config has both.
+func (c Config) severity(code string) string {
+	rule, _ := findRule(code)
+	if severity, ok := c.Severity[rule.Code]; ok {
+		return severity
+	}
+	if severity, ok := c.Severity[rule.Name]; ok {
+		return severity
+	}
+	return rule.Severity
+}
+
+// Graph lints a graph. The rules on the flow of the rule chain run when the
+// graph converts; conversion errors are left to ValidateGraph.
+func Graph(graph reactFlowTypes.Graph, tenantID string, config Config) []reactflow.Diagnostic {
+	l := &linter{config: config, paths: make(map[string]string)}
+	l.graph(graph)
+	if graph.ID == nil {
+		return l.diagnostics
+	}
+	if chain, err := reactflow.ConvertChecked(graph, tenantID); err == nil {
+		l.chain(chain)
+	}
+	return l.diagnostics
+}
+
+// Chain lints a rule chain on its own, with the rules that do not need the
+// graph it was converted from.
+func Chain(chain types.RuleChain, config Config) []reactflow.Diagnostic {
+	l := &linter{config: config, paths: make(map[string]string)}
+	l.chain(chain)
+	return l.diagnostics
+}
+
+type linter struct {
+	config      Config
+	diagnostics []reactflow.Diagnostic
+	// paths locates the nodes and blocks of the graph by ID, for findings
+	// on the rule chain.
+	paths map[string]string
+}
+
+func (l *linter) report(code, nodeID, path, format string, args ...interface{}) {
+	severity := l.config.severity(code)
+	if severity == SeverityOff {
+		return
+	}
+	if path == "" {
+		path = l.paths[nodeID]
+	}
+	l.diagnostics = append(l.diagnostics, reactflow.Diagnostic{
+		Severity: severity,
+		Code:     code,
+		NodeID:   nodeID,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
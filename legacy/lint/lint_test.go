@@ -0,0 +1,168 @@
+package lint
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+func codes(diagnostics []reactflow.Diagnostic) []string {
+	var codes []string
+	for _, diagnostic := range diagnostics {
+		codes = append(codes, diagnostic.Code)
+	}
+	return codes
+}
+
+func TestGraph(t *testing.T) {
+	// The graphs have no ID, so only the rules on the graph itself run.
+	tests := []struct {
+		name      string
+		graph     string
+		wantCodes []string
+		wantPaths []string
+	}{
+		{
+			name:      "untyped block",
+			graph:     `{"nodes":[{"id":"c","type":"conditional-node","data":{"metadata":{"blocks":[{"id":"a","data":{"type":"moment"}},{"id":"b","data":{}}]}}}]}`,
+			wantCodes: []string{CodeUntypedBlock},
+			wantPaths: []string{"/nodes/0/data/metadata/blocks/1"},
+		},
+		{
+			name:      "unselected untyped default block",
+			graph:     `{"nodes":[{"id":"d","type":"default-block-node","data":{"metadata":{"blocks":[{"id":"a","data":{}}]}}}]}`,
+			wantCodes: nil,
+			wantPaths: nil,
+		},
+		{
+			name:      "single block",
+			graph:     `{"nodes":[{"id":"c","type":"conditional-node","data":{"metadata":{"blocks":[{"id":"a","data":{"type":"moment"}}]}}}]}`,
+			wantCodes: []string{CodeSingleBlock},
+			wantPaths: []string{"/nodes/0"},
+		},
+		{
+			name:      "duplicate blocks under other names",
+			graph:     `{"nodes":[{"id":"c","type":"conditional-node","data":{"metadata":{"blocks":[{"id":"a","data":{"type":"moment","metadata":{"id":"m","name":"x"}}},{"id":"b","data":{"type":"moment","metadata":{"id":"m","name":"y","is_not":false}}}]}}}]}`,
+			wantCodes: []string{CodeDuplicate},
+			wantPaths: []string{"/nodes/0/data/metadata/blocks/1"},
+		},
+		{
+			name:      "response without default",
+			graph:     `{"nodes":[{"id":"r","type":"response-node","data":{"metadata":{"blocks":[{"id":"a","data":{"type":"moment","metadata":{"id":"m"}}}]}}}]}`,
+			wantCodes: []string{CodeNoDefault},
+			wantPaths: []string{"/nodes/0"},
+		},
+		{
+			name:      "empty prompt",
+			graph:     `{"nodes":[{"id":"g","type":"conditional-gpt-node","data":{"metadata":{"prompt":"  ","blocks":[{"id":"a","data":{"type":"moment"}},{"id":"b","data":{"type":"function"}}]}}}]}`,
+			wantCodes: []string{CodeEmptyPrompt},
+			wantPaths: []string{"/nodes/0/data/metadata/prompt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var graph reactFlowTypes.Graph
+			if err := json.Unmarshal([]byte(tt.graph), &graph); err != nil {
+				t.Fatal(err)
+			}
+			diagnostics := Graph(graph, "tenant", Config{})
+			if got := codes(diagnostics); !reflect.DeepEqual(got, tt.wantCodes) {
+				t.Errorf("codes %v, want %v", got, tt.wantCodes)
+			}
+			var paths []string
+			for _, diagnostic := range diagnostics {
+				paths = append(paths, diagnostic.Path)
+			}
+			if !reflect.DeepEqual(paths, tt.wantPaths) {
+				t.Errorf("paths %v, want %v", paths, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	moment := func(id, momentID string, not bool) *types.RuleNode {
+		return &types.RuleNode{Id: id, Type: "moment", Configuration: types.Configuration{"id": momentID, "is_not": not}}
+	}
+	group := func(operator string) *types.RuleNode {
+		configuration := types.Configuration{"NodeIdList": []string{"x", "y"}}
+		if operator != "" {
+			configuration["edges"] = []map[string]string{{"SourceNode": "x", "TargetNode": "y", "Operator": operator}}
+		}
+		return &types.RuleNode{Id: "g", Type: "singleBlock", Configuration: configuration}
+	}
+	tests := []struct {
+		name        string
+		nodes       []*types.RuleNode
+		connections []types.NodeConnection
+		wantCodes   []string
+	}{
+		{"reachable", []*types.RuleNode{moment("a", "m", false), moment("b", "m", false)}, []types.NodeConnection{{FromId: "a", ToId: "b"}}, nil},
+		{"unreachable", []*types.RuleNode{moment("a", "m", false), moment("b", "m", false)}, nil, []string{CodeUnreachable}},
+		{"connected from a missing node", []*types.RuleNode{moment("a", "m", false), moment("b", "m", false)}, []types.NodeConnection{{FromId: "gone", ToId: "b"}}, []string{CodeUnreachable}},
+		{"contradiction", []*types.RuleNode{group("AND"), moment("x", "m", false), moment("y", "m", true)}, nil, []string{CodeContradiction}},
+		{"contradiction without edges", []*types.RuleNode{group(""), moment("x", "m", false), moment("y", "m", true)}, nil, []string{CodeContradiction}},
+		{"or is not a contradiction", []*types.RuleNode{group("or"), moment("x", "m", false), moment("y", "m", true)}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := types.RuleChain{Metadata: types.RuleMetadata{Nodes: tt.nodes, Connections: tt.connections}}
+			if got := codes(Chain(chain, Config{})); !reflect.DeepEqual(got, tt.wantCodes) {
+				t.Errorf("codes %v, want %v", got, tt.wantCodes)
+			}
+		})
+	}
+}
+
+func TestConfig(t *testing.T) {
+	const graph = `{"nodes":[{"id":"c","type":"conditional-node","data":{"metadata":{"blocks":[{"id":"a","data":{"type":"moment"}}]}}}]}`
+	tests := []struct {
+		name         string
+		config       Config
+		wantValid    bool
+		wantSeverity []string
+	}{
+		{"default", Config{}, true, []string{reactflow.SeverityWarning}},
+		{"by code", Config{Severity: map[string]string{CodeSingleBlock: reactflow.SeverityError}}, true, []string{reactflow.SeverityError}},
+		{"by name", Config{Severity: map[string]string{"single-block": SeverityOff}}, true, nil},
+		{"code wins over name", Config{Severity: map[string]string{"single-block": SeverityOff, CodeSingleBlock: reactflow.SeverityError}}, true, []string{reactflow.SeverityError}},
+		{"unknown rule", Config{Severity: map[string]string{"L999": SeverityOff}}, false, nil},
+		{"unknown severity", Config{Severity: map[string]string{CodeSingleBlock: "loud"}}, false, nil},
+	}
+	var g reactFlowTypes.Graph
+	if err := json.Unmarshal([]byte(graph), &g); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err == nil) != tt.wantValid {
+				t.Fatalf("Validate() error = %v, want valid %v", err, tt.wantValid)
+			}
+			if !tt.wantValid {
+				return
+			}
+			var severities []string
+			for _, diagnostic := range Graph(g, "tenant", tt.config) {
+				severities = append(severities, diagnostic.Severity)
+			}
+			if !reflect.DeepEqual(severities, tt.wantSeverity) {
+				t.Errorf("severities %v, want %v", severities, tt.wantSeverity)
+			}
+		})
+	}
+}
+
+func TestRulesHaveStableCodes(t *testing.T) {
+	want := []string{CodeUnreachable, CodeUntypedBlock, CodeSingleBlock, CodeContradiction, CodeDuplicate, CodeNoDefault, CodeEmptyPrompt}
+	var got []string
+	for _, rule := range Rules() {
+		got = append(got, rule.Code)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rule codes %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,627 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Extras holds the JSON fields of an object that have no Go field, such as
+// the editor-only "dragging", "isNew" or "matchType". It also remembers how
+// the known fields were written, so re-encoding an unchanged field gives
+// back its original bytes (184.0 stays 184.0, an explicit null or false is
+// not dropped) and every field goes back to its original position. The zero
+// value holds nothing and encodes exactly like a plain struct.
+type Extras struct {
+	order  []string
+	fields map[string]json.RawMessage
+	// read is the number of leading order keys that were decoded; the keys
+	// after them were added with Set.
+	read int
+	// original and canonical hold each known field as it was read and as
+	// the Go types encode the decoded value; a field whose encoding still
+	// matches canonical is unchanged and is written back as original.
+	original  map[string]json.RawMessage
+	canonical map[string]json.RawMessage
+}
+
+// Keys returns the unknown field names in the order they were read.
+func (e Extras) Keys() []string {
+	var keys []string
+	for _, key := range e.order {
+		if _, ok := e.fields[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Len returns the number of unknown fields.
+func (e Extras) Len() int {
+	return len(e.fields)
+}
+
+// Get returns the raw JSON of an unknown field.
+func (e Extras) Get(key string) (json.RawMessage, bool) {
+	raw, ok := e.fields[key]
+	return raw, ok
+}
+
+// Set stores an unknown field. New fields are written after the known ones.
+// Copies of an Extras stay independent: Set and Delete on one never change
+// what another holds.
+func (e *Extras) Set(key string, raw json.RawMessage) {
+	if _, ok := e.fields[key]; !ok && !e.ordered(key) {
+		// Copies share order; never append in place.
+		e.order = append(e.order[:len(e.order):len(e.order)], key)
+	}
+	fields := e.copyFields()
+	fields[key] = raw
+	e.fields = fields
+}
+
+func (e Extras) ordered(key string) bool {
+	for _, k := range e.order {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes an unknown field.
+func (e *Extras) Delete(key string) {
+	if _, ok := e.fields[key]; !ok {
+		return
+	}
+	fields := e.copyFields()
+	delete(fields, key)
+	e.fields = fields
+}
+
+// copyFields returns a copy of the unknown fields for writing, as copies
+// of an Extras share the map.
+func (e Extras) copyFields() map[string]json.RawMessage {
+	fields := make(map[string]json.RawMessage, len(e.fields)+1)
+	for key, raw := range e.fields {
+		fields[key] = raw
+	}
+	return fields
+}
+
+// withoutFields returns a copy of the extras without the unknown fields. It
+// keeps what was recorded about the known fields, so they still encode in
+// their original layout.
+func (e Extras) withoutFields() Extras {
+	e.fields = nil
+	return e
+}
+
+type rawField struct {
+	key   string
+	value json.RawMessage
+}
+
+var knownFieldsCache sync.Map
+
+// knownFields returns the JSON names of the fields of struct type t, with
+// the kind each one decodes into.
+func knownFields(t reflect.Type) map[string]reflect.Kind {
+	if cached, ok := knownFieldsCache.Load(t); ok {
+		return cached.(map[string]reflect.Kind)
+	}
+	fields := make(map[string]reflect.Kind)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if field.PkgPath != "" || tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type.Kind()
+	}
+	knownFieldsCache.Store(t, fields)
+	return fields
+}
+
+// readObject splits a JSON object into its fields, keeping their order.
+func readObject(data []byte) ([]rawField, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("expected a JSON object, got %v", token)
+	}
+	var fields []rawField
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected an object key, got %v", token)
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		fields = append(fields, rawField{key: key, value: value})
+	}
+	return fields, nil
+}
+
+func writeObject(fields []rawField) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(field.value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// integralNumber rewrites a number such as 184.0, which DynamoDB exports
+// write for whole numbers, into a form that decodes into an int field.
+func integralNumber(raw json.RawMessage) json.RawMessage {
+	text := string(bytes.TrimSpace(raw))
+	if !strings.ContainsAny(text, ".eE") {
+		return raw
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil || f != math.Trunc(f) || math.Abs(f) > 1<<53 {
+		return raw
+	}
+	return json.RawMessage(strconv.FormatInt(int64(f), 10))
+}
+
+// decodeLossless decodes the known fields of data into target, a pointer
+// to a struct without its own UnmarshalJSON, and returns everything else.
+func decodeLossless(data []byte, target interface{}) (Extras, error) {
+	var extras Extras
+	if string(bytes.TrimSpace(data)) == "null" {
+		return extras, nil
+	}
+	fields, err := readObject(data)
+	if err != nil {
+		return extras, err
+	}
+	known := knownFields(reflect.TypeOf(target).Elem())
+	knownValues := make([]rawField, 0, len(fields))
+	extras.original = make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		kind, ok := known[field.key]
+		if !ok {
+			if extras.fields == nil {
+				extras.fields = make(map[string]json.RawMessage)
+			}
+			if _, seen := extras.fields[field.key]; !seen {
+				extras.order = append(extras.order, field.key)
+			}
+			extras.fields[field.key] = field.value
+			continue
+		}
+		extras.order = append(extras.order, field.key)
+		extras.original[field.key] = field.value
+		if isIntKind(kind) {
+			field.value = integralNumber(field.value)
+		}
+		knownValues = append(knownValues, field)
+	}
+	extras.read = len(extras.order)
+	if err := json.Unmarshal(writeObject(knownValues), target); err != nil {
+		return extras, err
+	}
+	encoded, err := json.Marshal(target)
+	if err != nil {
+		return extras, err
+	}
+	canonical, err := readObject(encoded)
+	if err != nil {
+		return extras, err
+	}
+	extras.canonical = make(map[string]json.RawMessage, len(canonical))
+	for _, field := range canonical {
+		extras.canonical[field.key] = field.value
+	}
+	return extras, nil
+}
+
+// encodeLossless encodes plain, a struct without its own MarshalJSON, and
+// restores the fields and layout recorded in extras.
+func encodeLossless(plain interface{}, extras Extras) ([]byte, error) {
+	data, err := json.Marshal(plain)
+	if err != nil || len(extras.order) == 0 {
+		return data, err
+	}
+	fields, err := readObject(data)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		current[field.key] = field.value
+	}
+	unchanged := func(key string) bool {
+		value, ok := current[key]
+		canonical, canonicalOK := extras.canonical[key]
+		if ok != canonicalOK {
+			return false
+		}
+		return !ok || bytes.Equal(value, canonical)
+	}
+
+	merged := make([]rawField, 0, len(extras.order)+len(fields))
+	written := make(map[string]bool, len(extras.order)+len(fields))
+	for _, key := range extras.order[:extras.read] {
+		if written[key] {
+			continue
+		}
+		written[key] = true
+		if value, ok := extras.fields[key]; ok {
+			merged = append(merged, rawField{key: key, value: value})
+			continue
+		}
+		original, wasRead := extras.original[key]
+		switch {
+		case wasRead && unchanged(key):
+			merged = append(merged, rawField{key: key, value: original})
+		case current[key] != nil:
+			merged = append(merged, rawField{key: key, value: current[key]})
+		}
+	}
+	for _, field := range fields {
+		if written[field.key] {
+			continue
+		}
+		if _, wasRead := extras.original[field.key]; !wasRead && unchanged(field.key) {
+			// Absent when read and still at its decoded value, such as an
+			// empty struct the Go types always emit.
+			continue
+		}
+		merged = append(merged, field)
+	}
+	for _, key := range extras.order[extras.read:] {
+		if value, ok := extras.fields[key]; ok && !written[key] {
+			written[key] = true
+			merged = append(merged, rawField{key: key, value: value})
+		}
+	}
+	return writeObject(merged), nil
+}
+
+func (p Position) MarshalJSON() ([]byte, error) {
+	type plain Position
+	return encodeLossless(plain(p), p.Extras)
+}
+
+func (p *Position) UnmarshalJSON(data []byte) error {
+	type plain Position
+	var decoded plain
+	extras, err := decodeLossless(data, &decoded)
+	if err != nil {
+		return err
+	}
+	*p = Position(decoded)
+	p.Extras = extras
+	return nil
+}
+
+func (d Data) MarshalJSON() ([]byte, error) {
+	type plain Data
+	return encodeLossless(plain(d), d.Extras)
+}
+
+func (d *Data) UnmarshalJSON(data []byte) error {
+	type plain Data
+	var decoded plain
+	extras, err := decodeLossless(data, &decoded)
+	if err != nil {
+		return err
+	}
+	*d = Data(decoded)
+	d.Extras = extras
+	return nil
+}
+
+func (v ValidateFields) MarshalJSON() ([]byte, error) {
+	type plain ValidateFields
+	return encodeLossless(plain(v), v.Extras)
+}
+
+func (v *ValidateFields) UnmarshalJSON(data []byte) error {
+	type plain ValidateFields
+	var decoded plain
+	extras, err := decodeLossless(data, &decoded)
+	if err != nil {
+		return err
+	}
+	*v = ValidateFields(decoded)
+	v.Extras = extras
+	return nil
+}
+
+func (m Metadata) MarshalJSON() ([]byte, error) {
+	type plain Metadata
+	return encodeLossless(plain(m), m.Extras)
+}
+
+func (m *Metadata) UnmarshalJSON(data []byte) error {
+	type plain Metadata
+	var decoded plain
+	extras, err := decodeLossless(data, &decoded)
+	if err != nil {
+		return err
+	}
+	*m = Metadata(decoded)
+	m.Extras = extras
+	return nil
+}
+
+func (n Node) MarshalJSON() ([]byte, error) {
+	type plain Node
+	return encodeLossless(plain(n), n.Extras)
+}
+
+func (n *Node) UnmarshalJSON(data []byte) error {
+	type plain Node
+	var decoded plain
+	extras, err := decodeLossless(data, &decoded)
+	if err != nil {
+		return err
+	}
+	*n = Node(decoded)
+	n.Extras = extras
+	return nil
+}
+
+func (b BlockNode) MarshalJSON() ([]byte, error) {
+	type plain BlockNode
+	return encodeLossless(plain(b), b.Extras)
+}
+
+func (b *BlockNode) UnmarshalJSON(data []byte) error {
+	type plain BlockNode
+	var decoded plain
+	extras, err := decodeLossless(data, &decoded)
+	if err != nil {
+		return err
+	}
+	*b = BlockNode(decoded)
+	b.Extras = extras
+	return nil
+}
+
+func (e Edge) MarshalJSON() ([]byte, error) {
+	type plain Edge
+	return encodeLossless(plain(e), e.Extras)
+}
+
+func (e *Edge) UnmarshalJSON(data []byte) error {
+	type plain Edge
+	var decoded plain
+	extras, err := decodeLossless(data, &decoded)
+	if err != nil {
+		return err
+	}
+	*e = Edge(decoded)
+	e.Extras = extras
+	return nil
+}
+
+func (g Graph) MarshalJSON() ([]byte, error) {
+	type plain Graph
+	return encodeLossless(plain(g), g.Extras)
+}
+
+func (g *Graph) UnmarshalJSON(data []byte) error {
+	type plain Graph
+	var decoded plain
+	extras, err := decodeLossless(data, &decoded)
+	if err != nil {
+		return err
+	}
+	*g = Graph(decoded)
+	g.Extras = extras
+	return nil
+}
+
+// DecodeOptions controls DecodeGraph.
+type DecodeOptions struct {
+	// Strict reports every field the Go types do not model as a warning.
+	// The fields are still kept and re-encoded.
+	Strict bool
+}
+
+// DecodeWarning points at a field the Go types do not know about.
+type DecodeWarning struct {
+	Path  string `json:"path"`
+	Field string `json:"field"`
+}
+
+func (w DecodeWarning) String() string {
+	if w.Path == "" {
+		return fmt.Sprintf("unknown field %q", w.Field)
+	}
+	return fmt.Sprintf("%s: unknown field %q", w.Path, w.Field)
+}
+
+// DecodeGraph decodes a graph, keeping fields the Go types do not model so
+// that json.Marshal of the result reproduces them. In strict mode it also
+// returns a warning per unknown field, which makes drift between the editor
+// and the Go types visible.
+func DecodeGraph(data []byte, opts DecodeOptions) (Graph, []DecodeWarning, error) {
+	var graph Graph
+	if err := json.Unmarshal(data, &graph); err != nil {
+		return graph, nil, err
+	}
+	if !opts.Strict {
+		return graph, nil, nil
+	}
+	return graph, graph.UnknownFields(), nil
+}
+
+// UnknownFields lists every field held in an Extras bag anywhere in the
+// graph, with the path of the object it belongs to.
+func (g Graph) UnknownFields() []DecodeWarning {
+	var warnings []DecodeWarning
+	addExtras(&warnings, "", g.Extras)
+	for i, node := range g.Nodes {
+		collectNode(&warnings, fmt.Sprintf("nodes[%d]", i), node)
+	}
+	for i, edge := range g.Edges {
+		collectEdge(&warnings, fmt.Sprintf("edges[%d]", i), edge)
+	}
+	return warnings
+}
+
+func addExtras(warnings *[]DecodeWarning, path string, extras Extras) {
+	for _, key := range extras.Keys() {
+		*warnings = append(*warnings, DecodeWarning{Path: path, Field: key})
+	}
+}
+
+func collectNode(warnings *[]DecodeWarning, path string, node Node) {
+	addExtras(warnings, path, node.Extras)
+	addExtras(warnings, path+".position", node.Position.Extras)
+	addExtras(warnings, path+".positionAbsolute", node.PositionAbsolute.Extras)
+	collectData(warnings, path+".data", node.Data)
+	collectMetadata(warnings, path+".metadata", node.Metadata)
+}
+
+func collectData(warnings *[]DecodeWarning, path string, data Data) {
+	addExtras(warnings, path, data.Extras)
+	collectMetadata(warnings, path+".metadata", data.Metadata)
+}
+
+func collectEdge(warnings *[]DecodeWarning, path string, edge Edge) {
+	addExtras(warnings, path, edge.Extras)
+	collectData(warnings, path+".data", edge.Data)
+}
+
+func collectMetadata(warnings *[]DecodeWarning, path string, metadata Metadata) {
+	addExtras(warnings, path, metadata.Extras)
+	addExtras(warnings, path+".validateFields", metadata.ValidateFields.Extras)
+	addExtras(warnings, path+".validateWithFields", metadata.ValidateWithFields.Extras)
+	for i, node := range metadata.Nodes {
+		collectNode(warnings, fmt.Sprintf("%s.nodes[%d]", path, i), node)
+	}
+	for i, edge := range metadata.Edges {
+		collectEdge(warnings, fmt.Sprintf("%s.edges[%d]", path, i), edge)
+	}
+	for i, block := range metadata.Blocks {
+		blockPath := fmt.Sprintf("%s.blocks[%d]", path, i)
+		addExtras(warnings, blockPath, block.Extras)
+		collectNode(warnings, blockPath+".data", block.NodeData)
+	}
+}
+
+// IsZero reports whether the metadata holds nothing, however it was
+// decoded: empty lists and unchanged layout count as nothing.
+func (m Metadata) IsZero() bool {
+	data, err := json.Marshal(m.withoutExtras())
+	if err != nil {
+		return false
+	}
+	zero, _ := json.Marshal(Metadata{})
+	return bytes.Equal(data, zero)
+}
+
+// withoutExtras returns a copy of the metadata with every Extras bag
+// cleared, recursively, so it encodes the way the Go types alone would.
+func (m Metadata) withoutExtras() Metadata {
+	m.Extras = Extras{}
+	m.ValidateFields.Extras = Extras{}
+	m.ValidateWithFields.Extras = Extras{}
+	if m.Nodes != nil {
+		nodes := make([]Node, len(m.Nodes))
+		for i, node := range m.Nodes {
+			nodes[i] = node.withoutExtras()
+		}
+		m.Nodes = nodes
+	}
+	if m.Edges != nil {
+		edges := make([]Edge, len(m.Edges))
+		for i, edge := range m.Edges {
+			edges[i] = edge.withoutExtras()
+		}
+		m.Edges = edges
+	}
+	if m.Blocks != nil {
+		blocks := make([]BlockNode, len(m.Blocks))
+		for i, block := range m.Blocks {
+			block.Extras = Extras{}
+			block.NodeData = block.NodeData.withoutExtras()
+			blocks[i] = block
+		}
+		m.Blocks = blocks
+	}
+	return m
+}
+
+func (n Node) withoutExtras() Node {
+	n.Extras = Extras{}
+	n.Position.Extras = Extras{}
+	n.PositionAbsolute.Extras = Extras{}
+	n.Data = n.Data.withoutExtras()
+	n.Metadata = n.Metadata.withoutExtras()
+	return n
+}
+
+func (d Data) withoutExtras() Data {
+	d.Extras = Extras{}
+	d.Metadata = d.Metadata.withoutExtras()
+	return d
+}
+
+func (e Edge) withoutExtras() Edge {
+	e.Extras = Extras{}
+	e.Data = e.Data.withoutExtras()
+	return e
+}
+
+// WithoutExtras returns a copy of the graph that encodes the way the Go
+// types alone would, dropping every field kept only for round-tripping.
+func (g Graph) WithoutExtras() Graph {
+	g.Extras = Extras{}
+	if g.Nodes != nil {
+		nodes := make([]Node, len(g.Nodes))
+		for i, node := range g.Nodes {
+			nodes[i] = node.withoutExtras()
+		}
+		g.Nodes = nodes
+	}
+	if g.Edges != nil {
+		edges := make([]Edge, len(g.Edges))
+		for i, edge := range g.Edges {
+			edges[i] = edge.withoutExtras()
+		}
+		g.Edges = edges
+	}
+	return g
+}
@@ -0,0 +1,132 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLosslessRoundTrip(t *testing.T) {
+	tests := map[string]json.RawMessage{
+		"float and null kept":  json.RawMessage(`{"id":1,"nodes":[{"id":"a","width":184.0,"height":null,"type":"moment"}]}`),
+		"field order kept":     json.RawMessage(`{"nodes":[],"id":2,"edges":[]}`),
+		"unknown fields kept":  json.RawMessage(`{"id":3,"nodes":[{"id":"a","dragging":false,"position":{"x":1,"y":2,"z":3}}],"viewport":{"zoom":1}}`),
+		"explicit false kept":  json.RawMessage(`{"id":4,"nodes":[{"id":"a","data":{"metadata":{"is_not":false}}}]}`),
+		"nested unknown field": json.RawMessage(`{"id":5,"edges":[{"id":"e","source":"a","target":"b","data":{"matchType":"any"}}]}`),
+	}
+	for name, raw := range exampleGraphs(t) {
+		tests["example "+name] = raw
+	}
+	for name, raw := range tests {
+		t.Run(name, func(t *testing.T) {
+			var want bytes.Buffer
+			if err := json.Compact(&want, raw); err != nil {
+				t.Fatal(err)
+			}
+			var graph Graph
+			if err := json.Unmarshal(raw, &graph); err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(graph)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want.Bytes()) {
+				t.Errorf("re-encoded graph differs:\n got %s\nwant %s", got, want.Bytes())
+			}
+		})
+	}
+}
+
+func TestLosslessChangedField(t *testing.T) {
+	var graph Graph
+	if err := json.Unmarshal([]byte(`{"id":1,"nodes":[{"id":"a","width":184.0,"dragging":true}]}`), &graph); err != nil {
+		t.Fatal(err)
+	}
+	graph.Nodes[0].Width = 200
+	graph.Nodes[0].Extras.Delete("dragging")
+	graph.Extras.Set("viewport", json.RawMessage(`{"zoom":1}`))
+	got, err := json.Marshal(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"id":1,"nodes":[{"id":"a","width":200}],"viewport":{"zoom":1}}`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestDecodeGraph(t *testing.T) {
+	const raw = `{"id":1,"viewport":{},"nodes":[{"id":"a","dragging":true,"data":{"metadata":{"extra":1}}}],"edges":[{"id":"e","animated":true}]}`
+	tests := []struct {
+		name string
+		opts DecodeOptions
+		want []string
+	}{
+		{"lenient", DecodeOptions{}, nil},
+		{"strict", DecodeOptions{Strict: true}, []string{
+			`unknown field "viewport"`,
+			`nodes[0]: unknown field "dragging"`,
+			`nodes[0].data.metadata: unknown field "extra"`,
+			`edges[0]: unknown field "animated"`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graph, warnings, err := DecodeGraph([]byte(raw), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, warning := range warnings {
+				got = append(got, warning.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("warnings %q, want %q", got, tt.want)
+			}
+			encoded, err := json.Marshal(graph)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(encoded) != raw {
+				t.Errorf("re-encoded graph %s, want %s", encoded, raw)
+			}
+		})
+	}
+}
+
+func TestExtrasCopiesAreIndependent(t *testing.T) {
+	var node Node
+	if err := json.Unmarshal([]byte(`{"id":"a","dragging":true,"matchType":"any"}`), &node); err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"id":"a","dragging":true,"matchType":"any"}`
+	tests := []struct {
+		name   string
+		change func(extras *Extras)
+	}{
+		{"set an existing field", func(extras *Extras) { extras.Set("dragging", json.RawMessage(`false`)) }},
+		{"set a new field", func(extras *Extras) { extras.Set("isNew", json.RawMessage(`true`)) }},
+		{"delete a field", func(extras *Extras) { extras.Delete("matchType") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copied := node
+			tt.change(&copied.Extras)
+			if raw, _ := node.Extras.Get("dragging"); string(raw) != "true" {
+				t.Errorf("original dragging = %s, want true", raw)
+			}
+			if _, ok := node.Extras.Get("matchType"); !ok {
+				t.Error("original lost matchType")
+			}
+			got, err := json.Marshal(node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want {
+				t.Errorf("original encodes as %s, want %s", got, want)
+			}
+		})
+	}
+}
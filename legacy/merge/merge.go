@@ -0,0 +1,551 @@
+// Package merge combines concurrent edits of a graph made outside the live
+// editor room. Given the common base and two edited copies, it applies
+// every change only one side made and reports the rest as conflicts for the
+// caller to resolve.
+package merge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+const (
+	// ConflictField is the same field changed to different values.
+	ConflictField = "field"
+	// ConflictDeleteModify is an element deleted by one side and changed by
+	// the other. The changed element is kept.
+	ConflictDeleteModify = "delete_modify"
+	// ConflictDanglingEdge is an edge of the merged graph whose source or
+	// target no longer exists, typically an edge added to a node the other
+	// side deleted.
+	ConflictDanglingEdge = "dangling_edge"
+)
+
+// Conflict is one change that could not be merged automatically. Element
+// is the kind ("graph", "node", "block" or "edge") and ElementID the ID of
+// the innermost element holding it; Field is the dotted path inside that
+// element. Base, Ours and Theirs are unset where the value was absent.
+type Conflict struct {
+	Kind      string      `json:"kind"`
+	Element   string      `json:"element"`
+	ElementID string      `json:"element_id,omitempty"`
+	Field     string      `json:"field,omitempty"`
+	Base      interface{} `json:"base,omitempty"`
+	Ours      interface{} `json:"ours,omitempty"`
+	Theirs    interface{} `json:"theirs,omitempty"`
+	Message   string      `json:"message"`
+}
+
+func (c Conflict) String() string {
+	return c.Message
+}
+
+// Result is a merged graph and what could not be merged. Field conflicts
+// are resolved to ours in Graph, so it is usable as is once the caller has
+// accepted or overridden each conflict.
+type Result struct {
+	Graph     reactFlowTypes.Graph `json:"graph"`
+	Conflicts []Conflict           `json:"conflicts"`
+}
+
+// elementKinds maps the arrays whose items are matched by ID to the kind of
+// element they hold.
+var elementKinds = map[string]string{
+	"nodes":  "node",
+	"edges":  "edge",
+	"blocks": "block",
+}
+
+// Graphs merges ours and theirs, two edits of base. Nodes, blocks and edges
+// are matched by ID at any depth and merged field by field; merged arrays
+// and objects keep the order of ours, with the elements and fields only
+// theirs added placed after their predecessor in theirs. Fields unknown to
+// the Go types are merged too.
+func Graphs(base, ours, theirs reactFlowTypes.Graph) (*Result, error) {
+	values := make([]interface{}, 3)
+	for i, graph := range []reactFlowTypes.Graph{base, ours, theirs} {
+		value, err := toJSONValue(graph)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+
+	m := &merger{}
+	merged, _ := m.merge(scope{element: "graph"}, values[0], true, values[1], true, values[2], true)
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("encoding merged graph: %w", err)
+	}
+	result := &Result{Conflicts: m.conflicts}
+	if err := json.Unmarshal(data, &result.Graph); err != nil {
+		return nil, fmt.Errorf("decoding merged graph: %w", err)
+	}
+	result.Conflicts = append(result.Conflicts, danglingEdges(result.Graph, base, ours, theirs)...)
+	if result.Conflicts == nil {
+		result.Conflicts = []Conflict{}
+	}
+	return result, nil
+}
+
+// scope locates a value for conflict reports: the innermost element and
+// the field path inside it.
+type scope struct {
+	element string
+	id      string
+	field   []string
+}
+
+func (s scope) child(key string) scope {
+	field := make([]string, len(s.field), len(s.field)+1)
+	copy(field, s.field)
+	s.field = append(field, key)
+	return s
+}
+
+func (s scope) describe() string {
+	field := strings.Join(s.field, ".")
+	switch {
+	case s.id == "" && field == "":
+		return s.element
+	case s.id == "":
+		return fmt.Sprintf("%s field %s", s.element, field)
+	case field == "":
+		return fmt.Sprintf("%s %s", s.element, s.id)
+	}
+	return fmt.Sprintf("%s %s field %s", s.element, s.id, field)
+}
+
+type merger struct {
+	conflicts []Conflict
+}
+
+func (m *merger) conflict(kind string, at scope, base, ours, theirs interface{}, message string) {
+	m.conflicts = append(m.conflicts, Conflict{
+		Kind:      kind,
+		Element:   at.element,
+		ElementID: at.id,
+		Field:     strings.Join(at.field, "."),
+		Base:      base,
+		Ours:      ours,
+		Theirs:    theirs,
+		Message:   message,
+	})
+}
+
+// merge merges one value; the ok flags tell whether it was present on each
+// side, since an absent field differs from a null one.
+func (m *merger) merge(at scope, base interface{}, hasBase bool, ours interface{}, hasOurs bool, theirs interface{}, hasTheirs bool) (interface{}, bool) {
+	switch {
+	case hasOurs == hasTheirs && equal(ours, theirs):
+		return ours, hasOurs
+	case hasBase == hasOurs && equal(base, ours):
+		return theirs, hasTheirs
+	case hasBase == hasTheirs && equal(base, theirs):
+		return ours, hasOurs
+	}
+
+	// Both sides changed the value. Objects and element lists can still be
+	// merged inside; anything else is a conflict.
+	oursObject, oursIsObject := ours.(*object)
+	theirsObject, theirsIsObject := theirs.(*object)
+	if oursIsObject && theirsIsObject {
+		baseObject, _ := base.(*object)
+		return m.mergeObjects(at, baseObject, oursObject, theirsObject), true
+	}
+	if kind, ok := elementKinds[lastField(at)]; ok {
+		oursList, oursIsList := elementList(ours)
+		theirsList, theirsIsList := elementList(theirs)
+		baseList, baseIsList := elementList(base)
+		if oursIsList && theirsIsList && (baseIsList || !hasBase || base == nil) {
+			return m.mergeElements(kind, baseList, oursList, theirsList), true
+		}
+	}
+	m.conflict(ConflictField, at, base, ours, theirs,
+		fmt.Sprintf("%s changed on both sides: ours %s, theirs %s", at.describe(), describeValue(ours, hasOurs), describeValue(theirs, hasTheirs)))
+	return ours, hasOurs
+}
+
+// mergeObjects merges the fields of ours and theirs in the order mergeOrder
+// gives them, so the merged object keeps the field order of ours, as the
+// lossless types do when it is decoded, and conflicts are reported in the
+// same order on every run. Fields only base has were removed by both sides.
+func (m *merger) mergeObjects(at scope, base, ours, theirs *object) *object {
+	merged := newObject()
+	for _, key := range mergeOrder(ours.keys, theirs.keys) {
+		baseValue, hasBase := base.get(key)
+		oursValue, hasOurs := ours.get(key)
+		theirsValue, hasTheirs := theirs.get(key)
+		if value, ok := m.merge(at.child(key), baseValue, hasBase, oursValue, hasOurs, theirsValue, hasTheirs); ok {
+			merged.set(key, value)
+		}
+	}
+	return merged
+}
+
+// element is an item of an ID-matched array.
+type element struct {
+	id    string
+	value *object
+}
+
+// elementList returns the items of v when it is an array of objects with
+// unique, non-empty string IDs. A null array counts as empty.
+func elementList(v interface{}) ([]element, bool) {
+	if v == nil {
+		return nil, true
+	}
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	seen := make(map[string]bool, len(items))
+	list := make([]element, 0, len(items))
+	for _, item := range items {
+		value, ok := item.(*object)
+		if !ok {
+			return nil, false
+		}
+		id, ok := value.values["id"].(string)
+		if !ok || id == "" || seen[id] {
+			return nil, false
+		}
+		seen[id] = true
+		list = append(list, element{id: id, value: value})
+	}
+	return list, true
+}
+
+func indexElements(list []element) map[string]*object {
+	index := make(map[string]*object, len(list))
+	for _, item := range list {
+		index[item.id] = item.value
+	}
+	return index
+}
+
+func (m *merger) mergeElements(kind string, base, ours, theirs []element) []interface{} {
+	baseIndex, oursIndex, theirsIndex := indexElements(base), indexElements(ours), indexElements(theirs)
+
+	kept := make(map[string]interface{})
+	for _, list := range [][]element{ours, theirs} {
+		for _, item := range list {
+			if _, done := kept[item.id]; done {
+				continue
+			}
+			at := scope{element: kind, id: item.id}
+			baseValue, inBase := baseIndex[item.id]
+			oursValue, inOurs := oursIndex[item.id]
+			theirsValue, inTheirs := theirsIndex[item.id]
+			switch {
+			case inOurs && inTheirs:
+				value, _ := m.merge(at, mapOrNil(baseValue, inBase), inBase, oursValue, true, theirsValue, true)
+				kept[item.id] = value
+			case !inBase:
+				// Added by one side only.
+				kept[item.id] = item.value
+			case equal(baseValue, item.value):
+				// Deleted by the other side and untouched here.
+				kept[item.id] = nil
+			default:
+				var oursLog, theirsLog interface{} = oursValue, theirsValue
+				side := "theirs"
+				if !inOurs {
+					oursLog, side = nil, "ours"
+				} else {
+					theirsLog = nil
+				}
+				m.conflict(ConflictDeleteModify, at, baseValue, oursLog, theirsLog,
+					fmt.Sprintf("%s was deleted by %s but changed by the other side; the changed %s is kept", at.describe(), side, kind))
+				kept[item.id] = item.value
+			}
+		}
+	}
+
+	order := mergeOrder(elementIDs(ours), elementIDs(theirs))
+	merged := make([]interface{}, 0, len(order))
+	for _, id := range order {
+		if value := kept[id]; value != nil {
+			merged = append(merged, value)
+		}
+	}
+	return merged
+}
+
+func elementIDs(list []element) []string {
+	ids := make([]string, len(list))
+	for i, item := range list {
+		ids[i] = item.id
+	}
+	return ids
+}
+
+// mergeOrder lists the IDs or keys of ours, then inserts each one only
+// theirs has after its nearest predecessor in theirs.
+func mergeOrder(ours, theirs []string) []string {
+	order := make([]string, 0, len(ours)+len(theirs))
+	position := make(map[string]bool)
+	for _, id := range ours {
+		order = append(order, id)
+		position[id] = true
+	}
+	previous := ""
+	for _, id := range theirs {
+		if !position[id] {
+			at := 0
+			if previous != "" {
+				for i, id := range order {
+					if id == previous {
+						at = i + 1
+						break
+					}
+				}
+			}
+			order = append(order, "")
+			copy(order[at+1:], order[at:])
+			order[at] = id
+			position[id] = true
+		}
+		previous = id
+	}
+	return order
+}
+
+func mapOrNil(value *object, ok bool) interface{} {
+	if !ok {
+		return nil
+	}
+	return value
+}
+
+func lastField(at scope) string {
+	if len(at.field) == 0 {
+		return ""
+	}
+	return at.field[len(at.field)-1]
+}
+
+// danglingEdges reports the edges of the merged graph, at any depth, whose
+// source, target or source handle block was a node or block of one of the
+// inputs but is no longer in the merged graph. Edges that were already
+// dangling in every input are left alone.
+func danglingEdges(merged reactFlowTypes.Graph, inputs ...reactFlowTypes.Graph) []Conflict {
+	ids, edges := graphElements(merged)
+	known := make(map[string]bool)
+	for _, input := range inputs {
+		inputIDs, _ := graphElements(input)
+		for id := range inputIDs {
+			known[id] = true
+		}
+	}
+
+	var conflicts []Conflict
+	for _, edge := range edges {
+		ends := []struct{ field, id string }{{"source", edge.Source}, {"target", edge.Target}}
+		if block := edge.SourceBlock(); block != "" && block != edge.Source {
+			ends = append(ends, struct{ field, id string }{"sourceHandle", block})
+		}
+		for _, end := range ends {
+			if ids[end.id] || !known[end.id] {
+				continue
+			}
+			conflicts = append(conflicts, Conflict{
+				Kind:      ConflictDanglingEdge,
+				Element:   "edge",
+				ElementID: edge.ID,
+				Field:     end.field,
+				Ours:      end.id,
+				Message:   fmt.Sprintf("edge %s %s %s was deleted", edge.ID, end.field, end.id),
+			})
+		}
+	}
+	return conflicts
+}
+
+// graphElements returns the IDs of the nodes and blocks of g and all of its
+// edges, at any depth.
+func graphElements(g reactFlowTypes.Graph) (map[string]bool, []reactFlowTypes.Edge) {
+	ids := make(map[string]bool)
+	var edges []reactFlowTypes.Edge
+	var walkNodes func(nodes []reactFlowTypes.Node)
+	var walkMetadata func(metadata reactFlowTypes.Metadata)
+	walkNodes = func(nodes []reactFlowTypes.Node) {
+		for _, node := range nodes {
+			ids[node.ID] = true
+			walkMetadata(node.Data.Metadata)
+			walkMetadata(node.Metadata)
+		}
+	}
+	walkMetadata = func(metadata reactFlowTypes.Metadata) {
+		walkNodes(metadata.Nodes)
+		edges = append(edges, metadata.Edges...)
+		for _, block := range metadata.Blocks {
+			ids[block.ID] = true
+			walkMetadata(block.NodeData.Data.Metadata)
+			walkMetadata(block.NodeData.Metadata)
+		}
+	}
+	walkNodes(g.Nodes)
+	edges = append(edges, g.Edges...)
+	delete(ids, "")
+	return ids, edges
+}
+
+// object is a decoded JSON object that keeps the order of its fields.
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newObject() *object {
+	return &object{values: make(map[string]interface{})}
+}
+
+// get returns a field; it is safe on a nil object, which has none.
+func (o *object) get(key string) (interface{}, bool) {
+	if o == nil {
+		return nil, false
+	}
+	value, ok := o.values[key]
+	return value, ok
+}
+
+func (o *object) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// toJSONValue encodes v and decodes it into json.Number, string, bool,
+// nil, []interface{} and *object values.
+func toJSONValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decodeValue(decoder)
+}
+
+func decodeValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		value := newObject()
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			field, err := decodeValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			value.set(key.(string), field)
+		}
+		_, err := decoder.Token()
+		return value, err
+	case json.Delim('['):
+		items := []interface{}{}
+		for decoder.More() {
+			item, err := decodeValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		_, err := decoder.Token()
+		return items, err
+	}
+	return token, nil
+}
+
+// equal compares decoded JSON values, numbers by value so 184 and 184.0
+// are the same.
+func equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if x == y {
+			return true
+		}
+		fx, errX := x.Float64()
+		fy, errY := y.Float64()
+		return errX == nil && errY == nil && fx == fy
+	case *object:
+		// Objects compare by their fields, whatever order they are in.
+		y, ok := b.(*object)
+		if !ok || len(x.values) != len(y.values) {
+			return false
+		}
+		for key, value := range x.values {
+			other, ok := y.values[key]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func describeValue(v interface{}, ok bool) string {
+	if !ok {
+		return "removed it"
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	if len(data) > 80 {
+		return string(data[:77]) + "..."
+	}
+	return string(data)
+}
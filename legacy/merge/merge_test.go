@@ -0,0 +1,158 @@
+package merge
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+func graph(t *testing.T, raw string) reactFlowTypes.Graph {
+	t.Helper()
+	var g reactFlowTypes.Graph
+	if err := json.Unmarshal([]byte(raw), &g); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestGraphs(t *testing.T) {
+	const base = `{"id":1,"nodes":[{"id":"a","type":"moment","data":{"metadata":{"id":"m1"}}},{"id":"b","type":"moment"}]}`
+	tests := []struct {
+		name          string
+		ours, theirs  string
+		wantNodes     []string
+		wantMoment    string
+		wantConflicts []string
+	}{
+		{
+			name:       "one side changes a field",
+			ours:       base,
+			theirs:     `{"id":1,"nodes":[{"id":"a","type":"moment","data":{"metadata":{"id":"m2"}}},{"id":"b","type":"moment"}]}`,
+			wantNodes:  []string{"a", "b"},
+			wantMoment: "m2",
+		},
+		{
+			name:          "both sides change a field",
+			ours:          `{"id":1,"nodes":[{"id":"a","type":"moment","data":{"metadata":{"id":"m3"}}},{"id":"b","type":"moment"}]}`,
+			theirs:        `{"id":1,"nodes":[{"id":"a","type":"moment","data":{"metadata":{"id":"m2"}}},{"id":"b","type":"moment"}]}`,
+			wantNodes:     []string{"a", "b"},
+			wantMoment:    "m3",
+			wantConflicts: []string{ConflictField},
+		},
+		{
+			name:       "both sides add nodes",
+			ours:       `{"id":1,"nodes":[{"id":"a","type":"moment","data":{"metadata":{"id":"m1"}}},{"id":"c"},{"id":"b","type":"moment"}]}`,
+			theirs:     `{"id":1,"nodes":[{"id":"a","type":"moment","data":{"metadata":{"id":"m1"}}},{"id":"b","type":"moment"},{"id":"d"}]}`,
+			wantNodes:  []string{"a", "c", "b", "d"},
+			wantMoment: "m1",
+		},
+		{
+			name:          "deleted and changed",
+			ours:          `{"id":1,"nodes":[{"id":"b","type":"moment"}]}`,
+			theirs:        `{"id":1,"nodes":[{"id":"a","type":"moment","data":{"metadata":{"id":"m2"}}},{"id":"b","type":"moment"}]}`,
+			wantNodes:     []string{"a", "b"},
+			wantMoment:    "m2",
+			wantConflicts: []string{ConflictDeleteModify},
+		},
+		{
+			name:          "edge to a deleted node",
+			ours:          `{"id":1,"nodes":[{"id":"a","type":"moment","data":{"metadata":{"id":"m1"}}}]}`,
+			theirs:        `{"id":1,"nodes":[{"id":"a","type":"moment","data":{"metadata":{"id":"m1"}}},{"id":"b","type":"moment"}],"edges":[{"id":"e","source":"a","target":"b"}]}`,
+			wantNodes:     []string{"a"},
+			wantMoment:    "m1",
+			wantConflicts: []string{ConflictDanglingEdge},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Graphs(graph(t, base), graph(t, tt.ours), graph(t, tt.theirs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var nodes []string
+			moment := ""
+			for _, node := range result.Graph.Nodes {
+				nodes = append(nodes, node.ID)
+				if node.ID == "a" {
+					moment = node.Data.Metadata.ID
+				}
+			}
+			if !reflect.DeepEqual(nodes, tt.wantNodes) {
+				t.Errorf("nodes %v, want %v", nodes, tt.wantNodes)
+			}
+			if moment != tt.wantMoment {
+				t.Errorf("moment of a = %q, want %q", moment, tt.wantMoment)
+			}
+			var kinds []string
+			for _, conflict := range result.Conflicts {
+				kinds = append(kinds, conflict.Kind)
+			}
+			if !reflect.DeepEqual(kinds, tt.wantConflicts) {
+				t.Errorf("conflicts %v, want %v", result.Conflicts, tt.wantConflicts)
+			}
+		})
+	}
+}
+
+func TestGraphsReportsEdgesFromDeletedBlocks(t *testing.T) {
+	base := graph(t, `{"id":1,"nodes":[{"id":"c","type":"conditional-node","data":{"metadata":{"blocks":[{"id":"x_1","data":{}},{"id":"y","data":{}}]}}},{"id":"r"}]}`)
+	ours := graph(t, `{"id":1,"nodes":[{"id":"c","type":"conditional-node","data":{"metadata":{"blocks":[{"id":"y","data":{}}]}}},{"id":"r"}]}`)
+	theirs := graph(t, `{"id":1,"nodes":[{"id":"c","type":"conditional-node","data":{"metadata":{"blocks":[{"id":"x_1","data":{}},{"id":"y","data":{}}]}}},{"id":"r"}],`+
+		`"edges":[{"id":"e","source":"c","sourceHandle":"x_1_right","target":"r"},{"id":"f","source":"c","sourceHandle":"y_right","target":"r"}]}`)
+	result, err := Graphs(base, ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Conflict{{
+		Kind:      ConflictDanglingEdge,
+		Element:   "edge",
+		ElementID: "e",
+		Field:     "sourceHandle",
+		Ours:      "x_1",
+		Message:   "edge e sourceHandle x_1 was deleted",
+	}}
+	if !reflect.DeepEqual(result.Conflicts, want) {
+		t.Errorf("conflicts %+v, want %+v", result.Conflicts, want)
+	}
+}
+
+func TestGraphsReportsConflictsInStableOrder(t *testing.T) {
+	base := graph(t, `{"id":1,"nodes":[{"id":"a","width":1,"height":1,"type":"x","block_name":"x","data":{"type":"x"}}]}`)
+	ours := graph(t, `{"id":1,"nodes":[{"id":"a","width":2,"height":2,"type":"y","block_name":"y","data":{"type":"y"}}]}`)
+	theirs := graph(t, `{"id":1,"nodes":[{"id":"a","width":3,"height":3,"type":"z","block_name":"z","data":{"type":"z"}}]}`)
+	// Conflicts follow the field order of ours.
+	want := []string{"width", "height", "type", "block_name", "data.type"}
+	for run := 0; run < 20; run++ {
+		result, err := Graphs(base, ours, theirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields []string
+		for _, conflict := range result.Conflicts {
+			fields = append(fields, conflict.Field)
+		}
+		if !reflect.DeepEqual(fields, want) {
+			t.Fatalf("run %d: conflicts on %v, want %v", run, fields, want)
+		}
+	}
+}
+
+func TestGraphsKeepsFieldOrder(t *testing.T) {
+	base := graph(t, `{"id":1,"nodes":[{"type":"moment","id":"a","dragging":false}]}`)
+	ours := graph(t, `{"id":1,"nodes":[{"type":"moment","id":"a","dragging":true}]}`)
+	theirs := graph(t, `{"id":1,"nodes":[{"type":"moment","id":"a","matchType":"any","dragging":false}]}`)
+	result, err := Graphs(base, ours, theirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(result.Graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"id":1,"nodes":[{"type":"moment","id":"a","matchType":"any","dragging":true}]}`
+	if string(data) != want {
+		t.Errorf("merged graph %s, want %s", data, want)
+	}
+}
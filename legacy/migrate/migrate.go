@@ -0,0 +1,193 @@
+// Package migrate versions the format of stored question rule documents.
+// Every document carries a schema_version, missing on documents written
+// before it existed, which count as version 0. A Registry holds the
+// migrations between consecutive versions. They work on the raw JSON, so a
+// migration does not depend on the Go types of either version. Documents
+// are upgraded to the latest version when they are loaded, can be
+// downgraded where every migration on the way can be reversed, and a
+// corpus can be checked with a dry run before a migration ships.
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// VersionKey is the field of a document holding its schema version.
+const VersionKey = "schema_version"
+
+// Document is a question rule document as generic JSON, with its numbers
+// kept as json.Number so they come back written as they were.
+type Document map[string]interface{}
+
+// Migration turns documents of version From into version From+1 with Up,
+// and back with Down. Down is nil when the migration drops information it
+// cannot restore. Neither has to set the version.
+type Migration struct {
+	From        int
+	Description string
+	Up          func(doc Document) error
+	Down        func(doc Document) error
+}
+
+// Step is one migration applied to a document.
+type Step struct {
+	From        int    `json:"from"`
+	To          int    `json:"to"`
+	Description string `json:"description"`
+}
+
+func (s Step) String() string {
+	return fmt.Sprintf("v%d -> v%d: %s", s.From, s.To, s.Description)
+}
+
+// Registry holds the migrations of the document format, in order.
+type Registry struct {
+	migrations []Migration
+}
+
+// NewRegistry returns a registry of migrations, which must start at
+// version 0 and follow each other without gaps.
+func NewRegistry(migrations ...Migration) (*Registry, error) {
+	for i, migration := range migrations {
+		if migration.From != i {
+			return nil, fmt.Errorf("migration %d starts at version %d, want %d", i, migration.From, i)
+		}
+		if migration.Up == nil {
+			return nil, fmt.Errorf("migration from version %d has no Up", migration.From)
+		}
+	}
+	return &Registry{migrations: append([]Migration(nil), migrations...)}, nil
+}
+
+// Latest is the version documents are upgraded to.
+func (r *Registry) Latest() int {
+	return len(r.migrations)
+}
+
+// Version returns the schema version of doc.
+func Version(doc Document) (int, error) {
+	value, ok := doc[VersionKey]
+	if !ok || value == nil {
+		return 0, nil
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("%s must be a number, got %v", VersionKey, value)
+	}
+	version, err := number.Int64()
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("%s must be a whole number, got %s", VersionKey, number)
+	}
+	return int(version), nil
+}
+
+// Migrate brings doc from its version to target, upgrading or downgrading
+// it in place, and returns the steps it took. On error doc is left part of
+// the way and should be dropped.
+func (r *Registry) Migrate(doc Document, target int) ([]Step, error) {
+	current, err := Version(doc)
+	if err != nil {
+		return nil, err
+	}
+	if current > r.Latest() {
+		return nil, fmt.Errorf("schema version %d is newer than the latest known, %d", current, r.Latest())
+	}
+	if target < 0 || target > r.Latest() {
+		return nil, fmt.Errorf("no schema version %d; versions go from 0 to %d", target, r.Latest())
+	}
+
+	var steps []Step
+	for current < target {
+		migration := r.migrations[current]
+		if err := migration.Up(doc); err != nil {
+			return steps, fmt.Errorf("upgrading from version %d (%s): %w", current, migration.Description, err)
+		}
+		steps = append(steps, Step{From: current, To: current + 1, Description: migration.Description})
+		current++
+		setVersion(doc, current)
+	}
+	for current > target {
+		migration := r.migrations[current-1]
+		if migration.Down == nil {
+			return steps, fmt.Errorf("version %d cannot be downgraded: %s is not reversible", current, migration.Description)
+		}
+		if err := migration.Down(doc); err != nil {
+			return steps, fmt.Errorf("downgrading from version %d (%s): %w", current, migration.Description, err)
+		}
+		steps = append(steps, Step{From: current, To: current - 1, Description: migration.Description})
+		current--
+		setVersion(doc, current)
+	}
+	return steps, nil
+}
+
+// Version 0 is written as no version, the way those documents were stored.
+func setVersion(doc Document, version int) {
+	if version == 0 {
+		delete(doc, VersionKey)
+		return
+	}
+	doc[VersionKey] = json.Number(fmt.Sprint(version))
+}
+
+// MigrateJSON migrates an encoded document to target. A document already
+// at target comes back as it was given.
+func (r *Registry) MigrateJSON(data []byte, target int) ([]byte, []Step, error) {
+	doc, err := decodeDocument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	steps, err := r.Migrate(doc, target)
+	if err != nil || len(steps) == 0 {
+		return data, steps, err
+	}
+	migrated, err := json.Marshal(doc)
+	return migrated, steps, err
+}
+
+// Upgrade migrates an encoded document to the latest version.
+func (r *Registry) Upgrade(data []byte) ([]byte, []Step, error) {
+	return r.MigrateJSON(data, r.Latest())
+}
+
+// Decode upgrades an encoded document to the latest version and decodes
+// it, for loading stored documents.
+func (r *Registry) Decode(data []byte) (*reactFlowTypes.QuestionRule, []Step, error) {
+	upgraded, steps, err := r.Upgrade(data)
+	if err != nil {
+		return nil, steps, err
+	}
+	var rule reactFlowTypes.QuestionRule
+	if err := json.Unmarshal(upgraded, &rule); err != nil {
+		return nil, steps, err
+	}
+	return &rule, steps, nil
+}
+
+func decodeDocument(data []byte) (Document, error) {
+	var doc Document
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decoding document: %w", err)
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("decoding document: not an object")
+	}
+	return doc, nil
+}
+
+// convert moves a value between its generic JSON form and a Go type.
+func convert(from, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(to)
+}
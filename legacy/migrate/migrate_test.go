@@ -0,0 +1,188 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+// renameKey moves a top-level field, reversibly.
+func renameKey(from, to string) func(doc Document) error {
+	return func(doc Document) error {
+		if value, ok := doc[from]; ok {
+			doc[to] = value
+			delete(doc, from)
+		}
+		return nil
+	}
+}
+
+func testRegistry(t *testing.T) *Registry {
+	t.Helper()
+	registry, err := NewRegistry(
+		Migration{From: 0, Description: "rename name", Up: renameKey("name", "title"), Down: renameKey("title", "name")},
+		Migration{From: 1, Description: "rename tenant", Up: renameKey("tenant", "tenant_id"), Down: renameKey("tenant_id", "tenant")},
+		Migration{From: 2, Description: "drop notes", Up: func(doc Document) error {
+			delete(doc, "notes")
+			return nil
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	const v0 = `{"name":"q","tenant":"t","weight":1.50}`
+	tests := []struct {
+		name      string
+		doc       string
+		target    int
+		want      string
+		wantSteps int
+	}{
+		{"up one", v0, 1, `{"schema_version":1,"tenant":"t","title":"q","weight":1.50}`, 1},
+		{"up two", v0, 2, `{"schema_version":2,"tenant_id":"t","title":"q","weight":1.50}`, 2},
+		{"already there", v0, 0, v0, 0},
+		{"down to zero", `{"schema_version":2,"tenant_id":"t","title":"q"}`, 0, `{"name":"q","tenant":"t"}`, 2},
+	}
+	registry := testRegistry(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, steps, err := registry.MigrateJSON([]byte(tt.doc), tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MigrateJSON() = %s, want %s", got, tt.want)
+			}
+			if len(steps) != tt.wantSteps {
+				t.Errorf("%d steps %v, want %d", len(steps), steps, tt.wantSteps)
+			}
+
+			// Migrating back gives the document as it was.
+			from, err := Version(mustDecode(t, []byte(tt.doc)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			back, _, err := registry.MigrateJSON(got, from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(back) != tt.doc {
+				t.Errorf("migrated back to %s, want %s", back, tt.doc)
+			}
+		})
+	}
+}
+
+func mustDecode(t *testing.T, data []byte) Document {
+	t.Helper()
+	doc, err := decodeDocument(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestMigrateErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    string
+		target int
+	}{
+		{"irreversible", `{"schema_version":3}`, 2},
+		{"newer than latest", `{"schema_version":4}`, 3},
+		{"unknown target", `{}`, 4},
+		{"negative target", `{}`, -1},
+		{"version not a number", `{"schema_version":"1"}`, 3},
+		{"fractional version", `{"schema_version":1.5}`, 3},
+		{"not an object", `[]`, 3},
+	}
+	registry := testRegistry(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := registry.MigrateJSON([]byte(tt.doc), tt.target); err == nil {
+				t.Error("MigrateJSON() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestMigrateStopsOnFailure(t *testing.T) {
+	failure := errors.New("broken")
+	registry, err := NewRegistry(
+		Migration{From: 0, Description: "first", Up: renameKey("a", "b")},
+		Migration{From: 1, Description: "second", Up: func(doc Document) error { return failure }},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := mustDecode(t, []byte(`{"a":1}`))
+	steps, err := registry.Migrate(doc, 2)
+	if !errors.Is(err, failure) {
+		t.Errorf("Migrate() error = %v, want %v", err, failure)
+	}
+	if len(steps) != 1 {
+		t.Errorf("steps %v, want the first migration only", steps)
+	}
+}
+
+func TestNewRegistryRejectsGaps(t *testing.T) {
+	noop := func(doc Document) error { return nil }
+	tests := []struct {
+		name       string
+		migrations []Migration
+	}{
+		{"not from zero", []Migration{{From: 1, Up: noop}}},
+		{"gap", []Migration{{From: 0, Up: noop}, {From: 2, Up: noop}}},
+		{"no up", []Migration{{From: 0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRegistry(tt.migrations...); err == nil {
+				t.Error("NewRegistry() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestDefaultUpgradesExample(t *testing.T) {
+	data, err := os.ReadFile("../../tests/example_small.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgraded, steps, err := Default.Upgrade(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != Default.Latest() {
+		t.Errorf("steps %v, want one per migration", steps)
+	}
+	if version, _ := Version(mustDecode(t, upgraded)); version != Default.Latest() {
+		t.Errorf("upgraded to version %d, want %d", version, Default.Latest())
+	}
+	again, steps, err := Default.Upgrade(upgraded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 0 || !bytes.Equal(again, upgraded) {
+		t.Errorf("upgrading an upgraded document took %v", steps)
+	}
+	rule, _, err := Default.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rule.DraftConfig) == 0 {
+		t.Error("decoded document has no draft config")
+	}
+
+	report := Default.DryRun([]Entry{{Name: "example", Data: data}, {Name: "broken", Data: []byte("{")}}, Default.Latest())
+	if report.Failed() != 1 {
+		encoded, _ := json.Marshal(report)
+		t.Errorf("dry run failed %d documents, want 1: %s", report.Failed(), encoded)
+	}
+}
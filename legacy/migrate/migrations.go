@@ -0,0 +1,47 @@
+package migrate
+
+import (
+	"fmt"
+
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// Default holds the migrations of the stored document format. New
+// migrations go at the end, starting from the version of the last one.
+var Default = mustRegistry(
+	Migration{From: 0, Description: "canonical node shapes", Up: canonicalNodeShapes},
+)
+
+func mustRegistry(migrations ...Migration) *Registry {
+	registry, err := NewRegistry(migrations...)
+	if err != nil {
+		panic(err)
+	}
+	return registry
+}
+
+// canonicalNodeShapes rewrites config and draft_config into the node shape
+// of reactflow.NormalizeGraph. The legacy shapes and the duplicates it
+// drops are not kept, so it cannot be reversed.
+func canonicalNodeShapes(doc Document) error {
+	for _, key := range []string{"config", "draft_config"} {
+		value, ok := doc[key]
+		if !ok || value == nil {
+			continue
+		}
+		var graphs []reactFlowTypes.Graph
+		if err := convert(value, &graphs); err != nil {
+			return fmt.Errorf("decoding %s: %w", key, err)
+		}
+		for i := range graphs {
+			graphs[i], _ = reactflow.NormalizeGraph(graphs[i])
+		}
+		var normalized interface{}
+		if err := convert(graphs, &normalized); err != nil {
+			return fmt.Errorf("encoding %s: %w", key, err)
+		}
+		doc[key] = normalized
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// Entry is one document of a corpus, named for the report, e.g. by its
+// file or key.
+type Entry struct {
+	Name string
+	Data []byte
+}
+
+// Result is what migrating one document would do.
+type Result struct {
+	Name  string `json:"name"`
+	From  int    `json:"from"`
+	To    int    `json:"to"`
+	Steps []Step `json:"steps,omitempty"`
+	// Changed is set when the migration changes more than the version.
+	Changed bool   `json:"changed"`
+	Error   string `json:"error,omitempty"`
+	// Migrated is the migrated document, when it migrated.
+	Migrated []byte `json:"-"`
+}
+
+// Report is the outcome of a dry run over a corpus.
+type Report struct {
+	Target  int      `json:"target"`
+	Results []Result `json:"results"`
+}
+
+// Failed counts the documents that cannot be migrated.
+func (r Report) Failed() int {
+	failed := 0
+	for _, result := range r.Results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Summary describes the report in a few lines, one per document that
+// moves or fails.
+func (r Report) Summary() string {
+	var b strings.Builder
+	migrated, changed := 0, 0
+	for _, result := range r.Results {
+		switch {
+		case result.Error != "":
+			fmt.Fprintf(&b, "%s: %s\n", result.Name, result.Error)
+		case len(result.Steps) > 0:
+			migrated++
+			note := "version only"
+			if result.Changed {
+				changed++
+				note = "content changes"
+			}
+			fmt.Fprintf(&b, "%s: v%d -> v%d, %s\n", result.Name, result.From, result.To, note)
+		}
+	}
+	fmt.Fprintf(&b, "%d documents: %d migrate to v%d (%d with content changes), %d fail\n",
+		len(r.Results), migrated, r.Target, changed, r.Failed())
+	return b.String()
+}
+
+// DryRun migrates every document of a corpus to target without storing
+// anything, and reports what would happen to each. A migrated document
+// must still decode as a question rule.
+func (r *Registry) DryRun(corpus []Entry, target int) Report {
+	report := Report{Target: target}
+	for _, entry := range corpus {
+		report.Results = append(report.Results, r.dryRun(entry, target))
+	}
+	return report
+}
+
+func (r *Registry) dryRun(entry Entry, target int) Result {
+	result := Result{Name: entry.Name, To: target}
+	doc, err := decodeDocument(entry.Data)
+	if err == nil {
+		result.From, err = Version(doc)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	before, err := withoutVersion(doc)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Steps, err = r.Migrate(doc, target)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if result.Migrated, err = json.Marshal(doc); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	var rule reactFlowTypes.QuestionRule
+	if err := json.Unmarshal(result.Migrated, &rule); err != nil {
+		result.Error = fmt.Sprintf("migrated document does not decode: %v", err)
+		return result
+	}
+	after, err := withoutVersion(doc)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Changed = !bytes.Equal(before, after)
+	return result
+}
+
+// withoutVersion encodes doc without its version, with sorted keys, so
+// documents compare by content.
+func withoutVersion(doc Document) ([]byte, error) {
+	copied := make(Document, len(doc))
+	for key, value := range doc {
+		if key != VersionKey {
+			copied[key] = value
+		}
+	}
+	return json.Marshal(copied)
+}
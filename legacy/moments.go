@@ -0,0 +1,103 @@
+package reactflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"bitbucket.org/convin/go_services/rule_engine/configs"
+	"bitbucket.org/convin/go_services/rule_engine/internal/repository/dynamodb"
+	"bitbucket.org/convin/go_services/rule_engine/pkg/model"
+)
+
+// MomentStore looks up the moments rule chains refer to. The DynamoDB
+// repository implements it.
+type MomentStore interface {
+	BatchGetMoments(ctx context.Context, ids []string, tenantID string) ([]*model.Moment, error)
+}
+
+// DynamoMomentStore returns the store GetRuleMetadata uses: the DynamoDB
+// repository of the app config, connected on first use so rule chains
+// without moments need no connection.
+func DynamoMomentStore() MomentStore {
+	return &dynamoMomentStore{}
+}
+
+type dynamoMomentStore struct {
+	mu   sync.Mutex
+	repo *dynamodb.Repository
+}
+
+func (s *dynamoMomentStore) BatchGetMoments(ctx context.Context, ids []string, tenantID string) ([]*model.Moment, error) {
+	s.mu.Lock()
+	if s.repo == nil {
+		config := configs.GetAppConfig()
+		repo, err := dynamodb.NewRepository(&config, false)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		s.repo = repo
+	}
+	repo := s.repo
+	s.mu.Unlock()
+	return repo.BatchGetMoments(ctx, ids, tenantID)
+}
+
+// MemoryMomentStore keeps moments in memory, for local runs and tools that
+// have no DynamoDB access. Unknown IDs are left out of results, as
+// BatchGetItem does.
+type MemoryMomentStore struct {
+	mu      sync.RWMutex
+	moments map[string]map[string]model.Moment
+}
+
+func NewMemoryMomentStore() *MemoryMomentStore {
+	return &MemoryMomentStore{moments: make(map[string]map[string]model.Moment)}
+}
+
+// LoadMomentStore reads a JSON file of moments per tenant, such as
+// {"tenant": [{"id": "...", "moment_id": "..."}]}, into a MemoryMomentStore.
+func LoadMomentStore(path string) (*MemoryMomentStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tenants map[string][]model.Moment
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	store := NewMemoryMomentStore()
+	for tenantID, moments := range tenants {
+		store.Put(tenantID, moments...)
+	}
+	return store, nil
+}
+
+// Put adds or replaces moments of a tenant.
+func (s *MemoryMomentStore) Put(tenantID string, moments ...model.Moment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tenant, ok := s.moments[tenantID]
+	if !ok {
+		tenant = make(map[string]model.Moment)
+		s.moments[tenantID] = tenant
+	}
+	for _, moment := range moments {
+		tenant[moment.ID] = moment
+	}
+}
+
+func (s *MemoryMomentStore) BatchGetMoments(ctx context.Context, ids []string, tenantID string) ([]*model.Moment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	moments := make([]*model.Moment, 0, len(ids))
+	for _, id := range ids {
+		if moment, ok := s.moments[tenantID][id]; ok {
+			moments = append(moments, &moment)
+		}
+	}
+	return moments, nil
+}
@@ -0,0 +1,37 @@
+package reactflow
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMomentStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "moments.json")
+	const data = `{"acme": [{"id": "a", "moment_id": "m1"}, {"id": "b", "moment_id": "m2"}], "other": [{"id": "c"}]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := LoadMomentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moments, err := store.BatchGetMoments(context.Background(), []string{"a", "c", "b"}, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moments) != 2 || moments[0].MomentID != "m1" || moments[1].MomentID != "m2" {
+		t.Errorf("BatchGetMoments() = %v, want the moments a and b of acme", moments)
+	}
+
+	malformed := filepath.Join(t.TempDir(), "malformed.json")
+	if err := os.WriteFile(malformed, []byte(`["a"]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{malformed, filepath.Join(t.TempDir(), "missing.json")} {
+		if _, err := LoadMomentStore(path); err == nil {
+			t.Errorf("LoadMomentStore(%s) succeeded", filepath.Base(path))
+		}
+	}
+}
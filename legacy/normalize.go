@@ -0,0 +1,203 @@
+package reactflow
+
+import (
+	"fmt"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// The kinds of rewrite NormalizeGraph applies.
+const (
+	// RewriteGroupType renames a group to the type of its level:
+	// group-block-node on the canvas and in groups, group_block in blocks.
+	RewriteGroupType = "group_type"
+	// RewriteParameterType moves a parameter kind to where the other
+	// kinds of its level are: data.type for nodes, type for block data.
+	RewriteParameterType = "parameter_type"
+	// RewriteMetadata moves metadata to data.metadata for nodes, or to
+	// metadata for block data.
+	RewriteMetadata = "metadata"
+	// RewriteIsNot moves is_not the same way.
+	RewriteIsNot = "is_not"
+	// RewriteDropped removes a duplicate the converter never read.
+	RewriteDropped = "dropped"
+)
+
+// Rewrite is one change made by NormalizeGraph, at the path of the node it
+// concerns, e.g. nodes[0].data.metadata.blocks[1].data.
+type Rewrite struct {
+	Kind    string `json:"kind"`
+	NodeID  string `json:"node_id,omitempty"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (r Rewrite) String() string {
+	return fmt.Sprintf("%s: %s", r.Path, r.Message)
+}
+
+// NormalizeGraph returns a copy of graph in the canonical shape, with the
+// rewrites that got it there. Over time the editor saved the same node in
+// several shapes, and graphNodeToRuleNode has a branch for each; in the
+// canonical shape
+//
+//   - nodes on the canvas and in groups keep their kind in data.type, their
+//     metadata in data.metadata and their negation in data.is_not, with type
+//     left for the canvas type;
+//   - block data keeps them in type, metadata and is_not, and data is empty;
+//   - groups are group-block-node nodes and group_block blocks.
+//
+// The rewrites move what the converter reads to its canonical place and
+// drop the duplicates it ignores, so a normalized graph converts to the
+// same rule chain. Groups of the wrong level are the exception: the
+// converter leaves out the members of a group_block node or a
+// group-block-node block, and converts them once they are renamed.
+func NormalizeGraph(graph reactFlowTypes.Graph) (reactFlowTypes.Graph, []Rewrite) {
+	var n normalizer
+	graph.Nodes = n.nodes("nodes", graph.Nodes)
+	return graph, n.rewrites
+}
+
+// NormalizeDocument normalizes the config and draft_config of a question
+// rule in place, for migrating stored documents in bulk. The paths of the
+// rewrites start at the document, e.g. draft_config[0].nodes[2]; the
+// rule chains of internal_config are left as they are.
+func NormalizeDocument(rule *reactFlowTypes.QuestionRule) []Rewrite {
+	var rewrites []Rewrite
+	for _, config := range []struct {
+		name   string
+		graphs []reactFlowTypes.Graph
+	}{
+		{"config", rule.Config},
+		{"draft_config", rule.DraftConfig},
+	} {
+		for i := range config.graphs {
+			var n normalizer
+			config.graphs[i].Nodes = n.nodes(fmt.Sprintf("%s[%d].nodes", config.name, i), config.graphs[i].Nodes)
+			rewrites = append(rewrites, n.rewrites...)
+		}
+	}
+	return rewrites
+}
+
+type normalizer struct {
+	rewrites []Rewrite
+}
+
+func (n *normalizer) record(kind string, node *reactFlowTypes.Node, path, format string, args ...interface{}) {
+	n.rewrites = append(n.rewrites, Rewrite{
+		Kind:    kind,
+		NodeID:  node.ID,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// nodes normalizes a copy of the nodes of a graph or a group.
+func (n *normalizer) nodes(path string, nodes []reactFlowTypes.Node) []reactFlowTypes.Node {
+	if nodes == nil {
+		return nil
+	}
+	nodes = append([]reactFlowTypes.Node(nil), nodes...)
+	for i := range nodes {
+		n.node(fmt.Sprintf("%s[%d]", path, i), &nodes[i])
+	}
+	return nodes
+}
+
+func (n *normalizer) node(path string, node *reactFlowTypes.Node) {
+	switch node.Type {
+	case "group_block":
+		// The converter reads the group from metadata, and is_not from data.
+		node.Type = "group-block-node"
+		n.record(RewriteGroupType, node, path, "group_block node is now a group-block-node")
+		n.moveMetadata(path, node, &node.Metadata, &node.Data.Metadata, "metadata", "data.metadata")
+	case "parameter":
+		// The converter reads a parameter typed on the node from the node.
+		node.Type = ""
+		node.Data.Type = "parameter"
+		n.record(RewriteParameterType, node, path, "parameter kind moved from type to data.type")
+		n.moveMetadata(path, node, &node.Metadata, &node.Data.Metadata, "metadata", "data.metadata")
+		n.moveIsNot(path, node, &node.IsNot, &node.Data.IsNot, "is_not", "data.is_not")
+	}
+	if !node.Metadata.IsZero() {
+		node.Metadata = reactFlowTypes.Metadata{}
+		n.record(RewriteDropped, node, path, "dropped metadata, which the converter does not read on nodes")
+	}
+	if node.IsNot {
+		node.IsNot = false
+		n.record(RewriteDropped, node, path, "dropped is_not, which the converter does not read on nodes")
+	}
+
+	metadata := &node.Data.Metadata
+	metadata.Nodes = n.nodes(path+".data.metadata.nodes", metadata.Nodes)
+	if metadata.Blocks != nil {
+		metadata.Blocks = append([]reactFlowTypes.BlockNode(nil), metadata.Blocks...)
+		for i := range metadata.Blocks {
+			n.block(fmt.Sprintf("%s.data.metadata.blocks[%d].data", path, i), &metadata.Blocks[i].NodeData)
+		}
+	}
+}
+
+// block normalizes the data of a block.
+func (n *normalizer) block(path string, data *reactFlowTypes.Node) {
+	switch {
+	case data.Type == "group-block-node":
+		// The converter reads the group from data.metadata, and is_not
+		// from the block data.
+		data.Type = "group_block"
+		n.record(RewriteGroupType, data, path, "group-block-node block is now a group_block")
+		n.moveMetadata(path, data, &data.Data.Metadata, &data.Metadata, "data.metadata", "metadata")
+	case data.Type != "" && data.Data.Type == "parameter":
+		// A parameter kind in data takes over a typed block, with the
+		// metadata and is_not of data.
+		data.Type = "parameter"
+		data.Data.Type = ""
+		n.record(RewriteParameterType, data, path, "parameter kind moved from data.type to type")
+		n.moveMetadata(path, data, &data.Data.Metadata, &data.Metadata, "data.metadata", "metadata")
+		n.moveIsNot(path, data, &data.Data.IsNot, &data.IsNot, "data.is_not", "is_not")
+	}
+	// Untyped blocks are skipped by the converter whatever their data.
+	if data.Type != "" {
+		if data.Data.Type != "" {
+			data.Data.Type = ""
+			n.record(RewriteDropped, data, path, "dropped data.type, which the converter does not read on block data")
+		}
+		if !data.Data.Metadata.IsZero() {
+			data.Data.Metadata = reactFlowTypes.Metadata{}
+			n.record(RewriteDropped, data, path, "dropped data.metadata, which the converter does not read on block data")
+		}
+		if data.Data.IsNot {
+			data.Data.IsNot = false
+			n.record(RewriteDropped, data, path, "dropped data.is_not, which the converter does not read on block data")
+		}
+	}
+
+	if data.Type == "group_block" {
+		data.Metadata.Nodes = n.nodes(path+".metadata.nodes", data.Metadata.Nodes)
+	}
+}
+
+// moveMetadata moves the metadata the converter reads, from, over the
+// metadata it ignores, to.
+func (n *normalizer) moveMetadata(path string, node *reactFlowTypes.Node, from, to *reactFlowTypes.Metadata, fromName, toName string) {
+	if !to.IsZero() {
+		n.record(RewriteDropped, node, path, "dropped %s, which the converter does not read here", toName)
+	}
+	if !from.IsZero() {
+		n.record(RewriteMetadata, node, path, "moved %s to %s", fromName, toName)
+	}
+	*to, *from = *from, reactFlowTypes.Metadata{}
+}
+
+// moveIsNot moves is_not like moveMetadata moves metadata.
+func (n *normalizer) moveIsNot(path string, node *reactFlowTypes.Node, from, to *bool, fromName, toName string) {
+	if *to != *from {
+		if *from {
+			n.record(RewriteIsNot, node, path, "moved %s to %s", fromName, toName)
+		} else {
+			n.record(RewriteDropped, node, path, "dropped %s, which the converter does not read here", toName)
+		}
+	}
+	*to, *from = *from, false
+}
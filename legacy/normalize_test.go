@@ -0,0 +1,97 @@
+package reactflow
+
+import (
+	"encoding/json"
+	"testing"
+
+	"bitbucket.org/convin/go_services/rule_engine/internal/diff"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// conditional wraps blocks in the conditional node "c" of a graph.
+func conditional(blocks string) string {
+	return `{"id":1,"nodes":[{"id":"c","type":"conditional-node","data":{"metadata":{"name":"C","blocks":[` + blocks + `]}}}]}`
+}
+
+func TestNormalizeGraphKeepsRuleChain(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph string
+		// added lists the rule nodes only the normalized graph converts
+		// to, the members of groups of the wrong level; their connections
+		// are added with them.
+		added []string
+	}{
+		{
+			name: "group_block node",
+			graph: `{"id":1,"nodes":[{"id":"g","type":"group_block","data":{"is_not":true},"metadata":{"name":"G","nodes":[` +
+				`{"id":"m","data":{"type":"moment","metadata":{"id":"x"}}},{"id":"n","data":{"type":"moment","metadata":{"id":"y"}}}],` +
+				`"edges":[{"source":"m","target":"n"}]}}]}`,
+			added: []string{"m", "n"},
+		},
+		{
+			name: "parameter on node type",
+			graph: `{"id":1,"nodes":[{"id":"p","type":"parameter","is_not":true,` +
+				`"metadata":{"name":"P","parameter":5,"response":2},"data":{"metadata":{"name":"ignored"}}}]}`,
+		},
+		{
+			name: "parameter in block data type",
+			graph: conditional(`{"id":"p","data":{"type":"moment","metadata":{"id":"ignored"},` +
+				`"data":{"type":"parameter","is_not":true,"metadata":{"name":"P","parameter":5,"response":2}}}}`),
+		},
+		{
+			name:  "is_not on node and data",
+			graph: `{"id":1,"nodes":[{"id":"n","type":"moment","is_not":true,"data":{"type":"moment","metadata":{"id":"x"}}}]}`,
+		},
+		{
+			name: "is_not on block and block data",
+			graph: conditional(`{"id":"b","data":{"type":"moment","is_not":true,"metadata":{"id":"x"}}},` +
+				`{"id":"d","data":{"type":"moment","metadata":{"id":"y"},"data":{"is_not":true}}}`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var graph reactFlowTypes.Graph
+			if err := json.Unmarshal([]byte(tt.graph), &graph); err != nil {
+				t.Fatal(err)
+			}
+			normalized, rewrites := NormalizeGraph(graph)
+			if len(rewrites) == 0 {
+				t.Fatal("nothing was rewritten")
+			}
+			if _, again := NormalizeGraph(normalized); len(again) > 0 {
+				t.Errorf("normalizing again rewrote %v", again)
+			}
+
+			before, err := ConvertFlowToRuleEngineDSL(graph, "tenant")
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := ConvertFlowToRuleEngineDSL(normalized, "tenant")
+			if err != nil {
+				t.Fatal(err)
+			}
+			changes := diff.RuleChains(before, after)
+			added := make(map[string]bool)
+			for _, id := range tt.added {
+				added[id] = true
+			}
+			for _, node := range changes.Nodes {
+				if node.Op != diff.Added || !added[node.ID] {
+					t.Errorf("rule node %s %s: %v", node.Op, node.ID, node.Fields)
+				}
+			}
+			if len(changes.Nodes) != len(tt.added) {
+				t.Errorf("%d rule nodes changed, want %d added", len(changes.Nodes), len(tt.added))
+			}
+			for _, connection := range changes.Connections {
+				if connection.Op != diff.Added || !added[connection.FromId] || !added[connection.ToId] {
+					t.Errorf("connection %s %s -> %s", connection.Op, connection.FromId, connection.ToId)
+				}
+			}
+			if changes.FirstNode != nil {
+				t.Errorf("first node changed: %s", changes.FirstNode)
+			}
+		})
+	}
+}
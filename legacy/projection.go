@@ -0,0 +1,329 @@
+package types
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// A graph document mixes two kinds of state: the rule logic the converter
+// reads, and the layout the editor needs to draw it. DynamoDB attribute
+// marshalling keeps only the logic (the layout fields are tagged
+// dynamodbav:"-"), so a graph stored that way opens without positions.
+// StorageProjection and LayoutProjection split the two explicitly so the
+// layout can be stored on its own, and MergeProjections puts them back
+// together into the editor graph.
+
+// NodeLayout is the editor state of one node or block.
+type NodeLayout struct {
+	Position         Position `json:"position,omitempty" dynamodbav:"position,omitempty"`
+	PositionAbsolute Position `json:"positionAbsolute,omitempty" dynamodbav:"positionAbsolute,omitempty"`
+	Width            int      `json:"width,omitempty" dynamodbav:"width,omitempty"`
+	Height           int      `json:"height,omitempty" dynamodbav:"height,omitempty"`
+	Draggable        bool     `json:"draggable,omitempty" dynamodbav:"draggable,omitempty"`
+	Connectable      bool     `json:"connectable,omitempty" dynamodbav:"connectable,omitempty"`
+	TargetPosition   string   `json:"targetPosition,omitempty" dynamodbav:"targetPosition,omitempty"`
+	SourcePosition   string   `json:"sourcePosition,omitempty" dynamodbav:"sourcePosition,omitempty"`
+	// Editor holds the fields the Go types do not model, by their path in
+	// the node, e.g. "dragging" or "data.metadata.matchType".
+	Editor map[string]interface{} `json:"editor,omitempty" dynamodbav:"editor,omitempty"`
+	// BlockEditor holds the unmodelled fields of the block wrapping a node
+	// inside a container, e.g. "isNew" or "parent".
+	BlockEditor map[string]interface{} `json:"blockEditor,omitempty" dynamodbav:"blockEditor,omitempty"`
+}
+
+// EdgeLayout is the editor state of one edge. Editor is keyed like
+// NodeLayout.Editor.
+type EdgeLayout struct {
+	Style  map[string]interface{} `json:"style,omitempty" dynamodbav:"style,omitempty"`
+	Editor map[string]interface{} `json:"editor,omitempty" dynamodbav:"editor,omitempty"`
+}
+
+// Layout is the layout projection of a graph. Nodes and blocks are keyed by
+// their ID, at any depth, and so are edges.
+type Layout struct {
+	Nodes map[string]NodeLayout `json:"nodes,omitempty" dynamodbav:"nodes,omitempty"`
+	Edges map[string]EdgeLayout `json:"edges,omitempty" dynamodbav:"edges,omitempty"`
+	// Editor holds the unmodelled fields of the graph itself, e.g. "viewport".
+	Editor map[string]interface{} `json:"editor,omitempty" dynamodbav:"editor,omitempty"`
+}
+
+// graphVisitor walks a copy of a graph, calling node for every node
+// (top-level, group members and block data) and edge for every edge.
+// block is set when the node is the data of a container block.
+type graphVisitor struct {
+	node func(node *Node, block *BlockNode)
+	edge func(edge *Edge)
+}
+
+func (v graphVisitor) graph(g Graph) Graph {
+	g.Nodes = v.nodes(g.Nodes)
+	g.Edges = v.edges(g.Edges)
+	return g
+}
+
+func (v graphVisitor) nodes(nodes []Node) []Node {
+	if nodes == nil {
+		return nil
+	}
+	visited := make([]Node, len(nodes))
+	for i, node := range nodes {
+		visited[i] = v.visitNode(node, nil)
+	}
+	return visited
+}
+
+func (v graphVisitor) edges(edges []Edge) []Edge {
+	if edges == nil {
+		return nil
+	}
+	visited := make([]Edge, len(edges))
+	for i, edge := range edges {
+		if v.edge != nil {
+			v.edge(&edge)
+		}
+		visited[i] = edge
+	}
+	return visited
+}
+
+func (v graphVisitor) visitNode(node Node, block *BlockNode) Node {
+	if v.node != nil {
+		v.node(&node, block)
+	}
+	node.Data.Metadata = v.metadata(node.Data.Metadata)
+	node.Metadata = v.metadata(node.Metadata)
+	return node
+}
+
+func (v graphVisitor) metadata(metadata Metadata) Metadata {
+	metadata.Nodes = v.nodes(metadata.Nodes)
+	metadata.Edges = v.edges(metadata.Edges)
+	if metadata.Blocks != nil {
+		blocks := make([]BlockNode, len(metadata.Blocks))
+		for i, block := range metadata.Blocks {
+			block.NodeData = v.visitNode(block.NodeData, &block)
+			blocks[i] = block
+		}
+		metadata.Blocks = blocks
+	}
+	return metadata
+}
+
+// StorageProjection returns the logic of the graph: the copy DynamoDB
+// attribute marshalling would keep, with every layout and editor-only field
+// cleared. The fields that are kept encode as they were read, so merging
+// the layout back gives the original graph.
+func StorageProjection(g Graph) Graph {
+	g.Extras = g.Extras.withoutFields()
+	return graphVisitor{
+		node: func(node *Node, block *BlockNode) {
+			clearExtras(node, nodeExtras)
+			if block != nil {
+				block.Extras = block.Extras.withoutFields()
+			}
+			node.Position = Position{}
+			node.PositionAbsolute = Position{}
+			node.Width = 0
+			node.Height = 0
+			node.Draggable = false
+			node.Connectable = false
+			node.TargetPosition = ""
+			node.SourcePosition = ""
+		},
+		edge: func(edge *Edge) {
+			edge.Style = nil
+			clearExtras(edge, edgeExtras)
+		},
+	}.graph(g)
+}
+
+// LayoutProjection returns the layout of the graph keyed by node and edge
+// ID. Nodes and edges without an ID cannot be matched back and are skipped.
+func LayoutProjection(g Graph) Layout {
+	layout := Layout{
+		Nodes:  make(map[string]NodeLayout),
+		Edges:  make(map[string]EdgeLayout),
+		Editor: extrasToMap(g.Extras),
+	}
+	graphVisitor{
+		node: func(node *Node, block *BlockNode) {
+			id := node.ID
+			if block != nil {
+				id = block.ID
+			}
+			if id == "" {
+				return
+			}
+			nodeLayout := NodeLayout{
+				Position:         node.Position,
+				PositionAbsolute: node.PositionAbsolute,
+				Width:            node.Width,
+				Height:           node.Height,
+				Draggable:        node.Draggable,
+				Connectable:      node.Connectable,
+				TargetPosition:   node.TargetPosition,
+				SourcePosition:   node.SourcePosition,
+				Editor:           collectExtras(node, nodeExtras),
+			}
+			if block != nil {
+				nodeLayout.BlockEditor = extrasToMap(block.Extras)
+			}
+			layout.Nodes[id] = nodeLayout
+		},
+		edge: func(edge *Edge) {
+			if edge.ID == "" {
+				return
+			}
+			layout.Edges[edge.ID] = EdgeLayout{
+				Style:  edge.Style,
+				Editor: collectExtras(edge, edgeExtras),
+			}
+		},
+	}.graph(g)
+	return layout
+}
+
+// MergeProjections rebuilds the editor graph from a storage projection and
+// the layout stored next to it. Nodes and edges missing from the layout are
+// returned without one.
+func MergeProjections(storage Graph, layout Layout) Graph {
+	setExtras(&storage.Extras, layout.Editor)
+	return graphVisitor{
+		node: func(node *Node, block *BlockNode) {
+			id := node.ID
+			if block != nil {
+				id = block.ID
+			}
+			nodeLayout, ok := layout.Nodes[id]
+			if !ok || id == "" {
+				return
+			}
+			node.Position = nodeLayout.Position
+			node.PositionAbsolute = nodeLayout.PositionAbsolute
+			node.Width = nodeLayout.Width
+			node.Height = nodeLayout.Height
+			node.Draggable = nodeLayout.Draggable
+			node.Connectable = nodeLayout.Connectable
+			node.TargetPosition = nodeLayout.TargetPosition
+			node.SourcePosition = nodeLayout.SourcePosition
+			applyExtras(node, nodeExtras, nodeLayout.Editor)
+			if block != nil {
+				setExtras(&block.Extras, nodeLayout.BlockEditor)
+			}
+		},
+		edge: func(edge *Edge) {
+			edgeLayout, ok := layout.Edges[edge.ID]
+			if !ok || edge.ID == "" {
+				return
+			}
+			edge.Style = edgeLayout.Style
+			applyExtras(edge, edgeExtras, edgeLayout.Editor)
+		},
+	}.graph(storage)
+}
+
+// extrasPath locates one Extras bag inside a node or edge.
+type extrasPath struct {
+	prefix string
+	extras func(value interface{}) *Extras
+}
+
+var nodeExtras = []extrasPath{
+	{"", func(v interface{}) *Extras { return &v.(*Node).Extras }},
+	{"position.", func(v interface{}) *Extras { return &v.(*Node).Position.Extras }},
+	{"positionAbsolute.", func(v interface{}) *Extras { return &v.(*Node).PositionAbsolute.Extras }},
+	{"data.", func(v interface{}) *Extras { return &v.(*Node).Data.Extras }},
+	{"data.metadata.", func(v interface{}) *Extras { return &v.(*Node).Data.Metadata.Extras }},
+	{"data.metadata.validateFields.", func(v interface{}) *Extras { return &v.(*Node).Data.Metadata.ValidateFields.Extras }},
+	{"data.metadata.validateWithFields.", func(v interface{}) *Extras { return &v.(*Node).Data.Metadata.ValidateWithFields.Extras }},
+	{"metadata.", func(v interface{}) *Extras { return &v.(*Node).Metadata.Extras }},
+	{"metadata.validateFields.", func(v interface{}) *Extras { return &v.(*Node).Metadata.ValidateFields.Extras }},
+	{"metadata.validateWithFields.", func(v interface{}) *Extras { return &v.(*Node).Metadata.ValidateWithFields.Extras }},
+}
+
+var edgeExtras = []extrasPath{
+	{"", func(v interface{}) *Extras { return &v.(*Edge).Extras }},
+	{"data.", func(v interface{}) *Extras { return &v.(*Edge).Data.Extras }},
+	{"data.metadata.", func(v interface{}) *Extras { return &v.(*Edge).Data.Metadata.Extras }},
+	{"data.metadata.validateFields.", func(v interface{}) *Extras { return &v.(*Edge).Data.Metadata.ValidateFields.Extras }},
+	{"data.metadata.validateWithFields.", func(v interface{}) *Extras { return &v.(*Edge).Data.Metadata.ValidateWithFields.Extras }},
+}
+
+func clearExtras(value interface{}, paths []extrasPath) {
+	for _, path := range paths {
+		extras := path.extras(value)
+		*extras = extras.withoutFields()
+	}
+}
+
+func collectExtras(value interface{}, paths []extrasPath) map[string]interface{} {
+	var fields map[string]interface{}
+	for _, path := range paths {
+		for key, field := range extrasToMap(*path.extras(value)) {
+			if fields == nil {
+				fields = make(map[string]interface{})
+			}
+			fields[path.prefix+key] = field
+		}
+	}
+	return fields
+}
+
+// applyExtras puts fields collected by collectExtras back into the bag
+// with the longest matching prefix.
+func applyExtras(value interface{}, paths []extrasPath, fields map[string]interface{}) {
+	grouped := make(map[int]map[string]interface{})
+	for key, field := range fields {
+		best := 0
+		for i, path := range paths {
+			if strings.HasPrefix(key, path.prefix) && len(path.prefix) > len(paths[best].prefix) &&
+				!strings.Contains(strings.TrimPrefix(key, path.prefix), ".") {
+				best = i
+			}
+		}
+		if grouped[best] == nil {
+			grouped[best] = make(map[string]interface{})
+		}
+		grouped[best][strings.TrimPrefix(key, paths[best].prefix)] = field
+	}
+	for i, group := range grouped {
+		setExtras(paths[i].extras(value), group)
+	}
+}
+
+func extrasToMap(extras Extras) map[string]interface{} {
+	keys := extras.Keys()
+	if len(keys) == 0 {
+		return nil
+	}
+	values := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		raw, _ := extras.Get(key)
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			zap.L().Error("Error unmarshaling editor field:", zap.String("field", key), zap.Error(err))
+			continue
+		}
+		values[key] = value
+	}
+	return values
+}
+
+func setExtras(extras *Extras, values map[string]interface{}) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		raw, err := json.Marshal(values[key])
+		if err != nil {
+			zap.L().Error("Error marshaling editor field:", zap.String("field", key), zap.Error(err))
+			continue
+		}
+		extras.Set(key, raw)
+	}
+}
@@ -0,0 +1,136 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// exampleGraphs returns the graphs of the config and draft config of the
+// example document, as they are stored.
+func exampleGraphs(t *testing.T) map[string]json.RawMessage {
+	t.Helper()
+	data, err := os.ReadFile("../tests/example_small.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document struct {
+		Config      []json.RawMessage `json:"config"`
+		DraftConfig []json.RawMessage `json:"draft_config"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatal(err)
+	}
+	graphs := make(map[string]json.RawMessage)
+	for i, raw := range document.Config {
+		graphs["config/"+strconv.Itoa(i)] = raw
+	}
+	for i, raw := range document.DraftConfig {
+		graphs["draft_config/"+strconv.Itoa(i)] = raw
+	}
+	if len(graphs) == 0 {
+		t.Fatal("the example document has no graphs")
+	}
+	return graphs
+}
+
+func TestProjectionsRoundTrip(t *testing.T) {
+	for name, raw := range exampleGraphs(t) {
+		t.Run(name, func(t *testing.T) {
+			var graph Graph
+			if err := json.Unmarshal(raw, &graph); err != nil {
+				t.Fatal(err)
+			}
+			want, err := json.Marshal(graph)
+			if err != nil {
+				t.Fatal(err)
+			}
+			merged := MergeProjections(StorageProjection(graph), LayoutProjection(graph))
+			got, err := json.Marshal(merged)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("merged projections differ from the graph:\n got %s\nwant %s", got, want)
+			}
+
+			// The layout must survive being stored on its own.
+			stored, err := json.Marshal(LayoutProjection(graph))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var layout Layout
+			if err := json.Unmarshal(stored, &layout); err != nil {
+				t.Fatal(err)
+			}
+			got, err = json.Marshal(MergeProjections(StorageProjection(graph), layout))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("merging a stored layout differs from the graph:\n got %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestMergeProjectionsRestoresGraphEditorFields(t *testing.T) {
+	const raw = `{"viewport":{"x":0,"zoom":1.5},"id":1,"nodes":[{"id":"a","dragging":false}]}`
+	var graph Graph
+	if err := json.Unmarshal([]byte(raw), &graph); err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(MergeProjections(StorageProjection(graph), LayoutProjection(graph)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != raw {
+		t.Errorf("merged = %s, want %s", got, raw)
+	}
+}
+
+func TestStorageProjectionDropsLayout(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph string
+		want  string
+	}{
+		{
+			name:  "node layout and editor fields",
+			graph: `{"id":1,"nodes":[{"id":"a","type":"moment","position":{"x":1.5},"width":218.0,"dragging":false,"data":{"type":"moment","metadata":{"id":"m"}}}]}`,
+			want:  `{"id":1,"nodes":[{"id":"a","type":"moment","position":{},"data":{"type":"moment","metadata":{"id":"m"}}}]}`,
+		},
+		{
+			name:  "edge style and editor fields",
+			graph: `{"id":1,"edges":[{"id":"e","source":"a","target":"b","style":{"stroke":"red"},"animated":true}]}`,
+			want:  `{"id":1,"edges":[{"id":"e","source":"a","target":"b"}]}`,
+		},
+		{
+			name:  "graph editor fields",
+			graph: `{"viewport":{"x":0,"zoom":1.5},"id":1}`,
+			want:  `{"id":1}`,
+		},
+		{
+			name:  "explicit zero values are kept",
+			graph: `{"id":1,"nodes":[{"id":"a","data":{"is_not":false,"metadata":{"name":"","list":[]}}}]}`,
+			want:  `{"id":1,"nodes":[{"id":"a","data":{"is_not":false,"metadata":{"name":"","list":[]}}}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var graph Graph
+			if err := json.Unmarshal([]byte(tt.graph), &graph); err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(StorageProjection(graph))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("StorageProjection() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
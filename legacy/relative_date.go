@@ -0,0 +1,258 @@
+package reactflow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// RelativeOperation is the comparison an information-correction node makes
+// between a date attribute and the date of the call.
+type RelativeOperation string
+
+const (
+	// RelativeBefore matches dates at least RelativeDays calendar days
+	// before the call date. With RelativeDays == 0 the call date itself matches.
+	RelativeBefore RelativeOperation = "before"
+	// RelativeAfter matches dates at least RelativeDays calendar days after
+	// the call date. With RelativeDays == 0 the call date itself matches.
+	RelativeAfter RelativeOperation = "after"
+	// RelativeWithin matches dates no more than RelativeDays calendar days
+	// away from the call date, in either direction.
+	RelativeWithin RelativeOperation = "within"
+)
+
+const dateDataType = "date"
+
+// RelativeDateRule is the parsed form of the relativeOperation/relativeDays
+// pair found on information-correction node metadata.
+type RelativeDateRule struct {
+	Operation RelativeOperation
+	Days      int
+}
+
+// LocationResolver returns the time zone a tenant's calls are evaluated in.
+// Calendar days are counted in this zone, so a call at 23:30 IST and a
+// delivery at 00:30 IST the next morning are one day apart.
+type LocationResolver interface {
+	Location(tenantID string) *time.Location
+}
+
+// TenantLocations is a LocationResolver backed by a static map of tenant ID
+// to IANA zone name. Tenants that are missing, or whose zone cannot be
+// loaded, fall back to Default (UTC when Default is nil).
+type TenantLocations struct {
+	Default *time.Location
+	Zones   map[string]string
+
+	mu     sync.Mutex
+	loaded map[string]*time.Location
+}
+
+func (t *TenantLocations) Location(tenantID string) *time.Location {
+	fallback := t.Default
+	if fallback == nil {
+		fallback = time.UTC
+	}
+	name, ok := t.Zones[tenantID]
+	if !ok || name == "" {
+		return fallback
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if loc, ok := t.loaded[name]; ok {
+		return loc
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = fallback
+	}
+	if t.loaded == nil {
+		t.loaded = make(map[string]*time.Location)
+	}
+	t.loaded[name] = loc
+	return loc
+}
+
+func parseRelativeOperation(op string) (RelativeOperation, error) {
+	switch RelativeOperation(strings.ToLower(strings.TrimSpace(op))) {
+	case RelativeBefore:
+		return RelativeBefore, nil
+	case RelativeAfter:
+		return RelativeAfter, nil
+	case RelativeWithin:
+		return RelativeWithin, nil
+	}
+	return "", fmt.Errorf("unsupported relative operation %q", op)
+}
+
+// RelativeDateRuleFromMetadata returns the relative date rule configured on
+// the metadata, or nil when the node does not use a relative operation.
+func RelativeDateRuleFromMetadata(metadata reactFlowTypes.Metadata) (*RelativeDateRule, error) {
+	if metadata.RelativeOperation == "" {
+		return nil, nil
+	}
+	op, err := parseRelativeOperation(metadata.RelativeOperation)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.RelativeDays < 0 {
+		return nil, fmt.Errorf("relativeDays must not be negative, got %d", metadata.RelativeDays)
+	}
+	return &RelativeDateRule{Operation: op, Days: metadata.RelativeDays}, nil
+}
+
+// RelativeDateRuleFromConfiguration is RelativeDateRuleFromMetadata for a
+// converted rule node, whose metadata has already been flattened into a
+// configuration map.
+func RelativeDateRuleFromConfiguration(configuration types.Configuration) (*RelativeDateRule, error) {
+	opValue := ConfigString(configuration, "relativeOperation")
+	if opValue == "" {
+		return nil, nil
+	}
+	op, err := parseRelativeOperation(opValue)
+	if err != nil {
+		return nil, err
+	}
+	days, ok := ConfigInt(configuration, "relativeDays")
+	if !ok && configuration["relativeDays"] != nil {
+		return nil, fmt.Errorf("relativeDays must be a whole number of days, got %v", configuration["relativeDays"])
+	}
+	if days < 0 {
+		return nil, fmt.Errorf("relativeDays must not be negative, got %d", days)
+	}
+	return &RelativeDateRule{Operation: op, Days: days}, nil
+}
+
+// ValidateRelativeDate checks that the relative date fields on the metadata
+// are used in a combination the rule engine can evaluate. It returns every
+// problem found rather than stopping at the first.
+func ValidateRelativeDate(metadata reactFlowTypes.Metadata) []error {
+	var errs []error
+	if metadata.RelativeOperation == "" {
+		if metadata.RelativeDays != 0 {
+			errs = append(errs, fmt.Errorf("relativeDays is set without a relativeOperation"))
+		}
+		return errs
+	}
+	if _, err := parseRelativeOperation(metadata.RelativeOperation); err != nil {
+		errs = append(errs, err)
+	}
+	if metadata.RelativeDays < 0 {
+		errs = append(errs, fmt.Errorf("relativeDays must not be negative, got %d", metadata.RelativeDays))
+	}
+	if dataType := ValidateDataType(metadata); dataType != dateDataType {
+		errs = append(errs, fmt.Errorf("relativeOperation requires dataType %q, got %q", dateDataType, dataType))
+	}
+	if !isEmptyValue(metadata.Value) {
+		errs = append(errs, fmt.Errorf("value cannot be combined with relativeOperation, the call date is the comparison value"))
+	}
+	if len(metadata.List) > 0 {
+		errs = append(errs, fmt.Errorf("list cannot be combined with relativeOperation"))
+	}
+	if metadata.Min != 0 || metadata.Max != 0 {
+		errs = append(errs, fmt.Errorf("min/max cannot be combined with relativeOperation"))
+	}
+	return errs
+}
+
+// ValidateDataType returns the data type of the validate side of an
+// information-correction node. A dataType on validateFields overrides the
+// one on the node.
+func ValidateDataType(metadata reactFlowTypes.Metadata) string {
+	return fieldsDataType(metadata.ValidateFields, metadata.DataType)
+}
+
+// fieldsDataType returns the data type of the attribute or entity named by
+// fields, falling back to the node-level dataType.
+func fieldsDataType(fields reactFlowTypes.ValidateFields, dataType string) string {
+	if fields.DataType != "" {
+		return fields.DataType
+	}
+	return dataType
+}
+
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	}
+	return false
+}
+
+// Matches reports whether date satisfies the rule for a call that happened
+// at callTime. Both instants are reduced to calendar days in loc before they
+// are compared; a nil loc means UTC.
+func (r RelativeDateRule) Matches(date, callTime time.Time, loc *time.Location) bool {
+	diff := calendarDaysBetween(callTime, date, loc)
+	switch r.Operation {
+	case RelativeBefore:
+		return diff <= -r.Days
+	case RelativeAfter:
+		return diff >= r.Days
+	case RelativeWithin:
+		return diff >= -r.Days && diff <= r.Days
+	}
+	return false
+}
+
+// calendarDaysBetween returns the number of calendar days from a to b in loc.
+func calendarDaysBetween(a, b time.Time, loc *time.Location) int {
+	if loc == nil {
+		loc = time.UTC
+	}
+	a = a.In(loc)
+	b = b.In(loc)
+	// Day arithmetic is done on UTC midnights so DST transitions in loc
+	// cannot make a day 23 or 25 hours long.
+	dayA := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	dayB := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(dayB.Sub(dayA).Hours() / 24)
+}
+
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"02-01-2006",
+	"02/01/2006",
+}
+
+// ParseDateValue interprets an attribute value as a point in time. Strings
+// are tried against the layouts the extraction pipeline produces; layouts
+// without a zone are read in loc. Numbers are Unix timestamps in seconds.
+func ParseDateValue(value interface{}, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case float64:
+		return time.Unix(int64(v), 0).In(loc), nil
+	case int:
+		return time.Unix(int64(v), 0).In(loc), nil
+	case int64:
+		return time.Unix(v, 0).In(loc), nil
+	case string:
+		s := strings.TrimSpace(v)
+		for _, layout := range dateLayouts {
+			if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+				return t, nil
+			}
+		}
+		if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.Unix(secs, 0).In(loc), nil
+		}
+		return time.Time{}, fmt.Errorf("unrecognised date %q", v)
+	}
+	return time.Time{}, fmt.Errorf("unsupported date value of type %T", value)
+}
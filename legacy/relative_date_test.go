@@ -0,0 +1,99 @@
+package reactflow
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+func metadataOf(t *testing.T, raw string) reactFlowTypes.Metadata {
+	t.Helper()
+	var metadata reactFlowTypes.Metadata
+	if err := json.Unmarshal([]byte(raw), &metadata); err != nil {
+		t.Fatal(err)
+	}
+	return metadata
+}
+
+func TestRelativeDateRuleMatches(t *testing.T) {
+	kolkata := time.FixedZone("IST", 5*3600+1800)
+	callTime := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	day := func(d, hour int) time.Time { return time.Date(2024, 5, d, hour, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name string
+		rule RelativeDateRule
+		date time.Time
+		loc  *time.Location
+		want bool
+	}{
+		{"before by enough days", RelativeDateRule{Operation: RelativeBefore, Days: 2}, day(8, 23), nil, true},
+		{"before by too few days", RelativeDateRule{Operation: RelativeBefore, Days: 2}, day(9, 0), nil, false},
+		{"after", RelativeDateRule{Operation: RelativeAfter, Days: 1}, day(11, 0), nil, true},
+		{"same day is after by zero days", RelativeDateRule{Operation: RelativeAfter}, day(10, 1), nil, true},
+		{"within", RelativeDateRule{Operation: RelativeWithin, Days: 3}, day(7, 0), nil, true},
+		{"outside", RelativeDateRule{Operation: RelativeWithin, Days: 3}, day(14, 0), nil, false},
+		// 20:00 UTC on the 9th is already the 10th in Kolkata.
+		{"days counted in the location", RelativeDateRule{Operation: RelativeBefore, Days: 1}, day(9, 20), kolkata, false},
+		{"days counted in UTC", RelativeDateRule{Operation: RelativeBefore, Days: 1}, day(9, 20), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Matches(tt.date, callTime, tt.loc); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDateValue(t *testing.T) {
+	want := time.Date(2024, 5, 8, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		value   interface{}
+		wantErr bool
+	}{
+		{"iso date", "2024-05-08", false},
+		{"rfc 3339", "2024-05-08T00:00:00Z", false},
+		{"day first", "08/05/2024", false},
+		{"unix seconds", float64(want.Unix()), false},
+		{"unix seconds as a string", "1715126400", false},
+		{"not a date", "next tuesday", true},
+		{"wrong type", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDateValue(tt.value, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDateValue() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !got.Equal(want) {
+				t.Errorf("ParseDateValue() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestValidateRelativeDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string
+		want     int
+	}{
+		{"valid", `{"validate":"entity","validateFields":{"entity":7},"relativeOperation":"within","relativeDays":3,"dataType":"date"}`, 0},
+		{"date type on the fields", `{"validate":"entity","validateFields":{"entity":7,"dataType":"date"},"relativeOperation":"after","dataType":"string"}`, 0},
+		{"not relative", `{"dataType":"string"}`, 0},
+		{"days without an operation", `{"relativeDays":2}`, 1},
+		{"unknown operation", `{"relativeOperation":"around","dataType":"date"}`, 1},
+		{"negative days and a value", `{"relativeOperation":"after","relativeDays":-1,"dataType":"date","value":"2024-05-08"}`, 2},
+		{"not a date", `{"relativeOperation":"after","dataType":"number","list":[1]}`, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if errs := ValidateRelativeDate(metadataOf(t, tt.metadata)); len(errs) != tt.want {
+				t.Errorf("ValidateRelativeDate() = %v, want %d errors", errs, tt.want)
+			}
+		})
+	}
+}
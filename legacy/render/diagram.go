@@ -0,0 +1,225 @@
+package render
+
+import (
+	"fmt"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// diagram is what the diagram formats draw: boxes, some of which contain
+// others, and labeled links between them. Graphs and rule chains are both
+// mapped onto it so each format is written once.
+type diagram struct {
+	title  string
+	shapes []*shape
+	links  []link
+}
+
+type shape struct {
+	id string
+	// name is what people call the element, kind its node or block type and
+	// detail what a leaf checks; any of them may be empty.
+	name   string
+	kind   string
+	detail string
+	// negated is set for elements with is_not, first for the node
+	// evaluation starts from.
+	negated bool
+	first   bool
+	// container is set for elements holding blocks, even when they hold
+	// none.
+	container bool
+	children  []*shape
+}
+
+type link struct {
+	from, to, label string
+}
+
+// lines returns the label of s, one entry per line.
+func (s *shape) lines() []string {
+	title := s.name
+	if title == "" {
+		title = s.id
+	}
+	if s.negated {
+		title = "NOT " + title
+	}
+	lines := []string{title}
+	if s.kind != "" {
+		lines = append(lines, s.kind)
+	}
+	if s.detail != "" {
+		lines = append(lines, s.detail)
+	}
+	return lines
+}
+
+// walk calls fn for every shape of d, containers before their children.
+func (d *diagram) walk(fn func(s *shape)) {
+	var visit func(shapes []*shape)
+	visit = func(shapes []*shape) {
+		for _, s := range shapes {
+			fn(s)
+			visit(s.children)
+		}
+	}
+	visit(d.shapes)
+}
+
+// graphContainers are the node types of a graph that hold blocks.
+var graphContainers = map[string]bool{
+	"conditional-node":     true,
+	"conditional-gpt-node": true,
+	"default-block-node":   true,
+	"response-node":        true,
+}
+
+// graphDiagram maps a graph onto a diagram. Conditional, default and
+// response nodes contain their blocks, group nodes and group blocks their
+// nodes; blocks of a default node that are not selected are left out, as
+// the converter leaves them out.
+func graphDiagram(graph reactFlowTypes.Graph) *diagram {
+	d := &diagram{title: fmt.Sprintf("graph %v", graph.ID)}
+	ids := make(map[string]bool)
+	for _, node := range graph.Nodes {
+		s := &shape{id: node.ID, name: node.Data.Metadata.Name, kind: node.Type, negated: node.Data.IsNot}
+		switch {
+		case graphContainers[node.Type]:
+			s.container = true
+			for _, block := range node.Data.Metadata.Blocks {
+				if node.Type == "default-block-node" && !block.IsSelected {
+					continue
+				}
+				s.children = append(s.children, d.graphBlock(block, node.Type))
+			}
+		case node.Type == "group-block-node":
+			d.graphGroup(s, node.Data.Metadata)
+		}
+		d.shapes = append(d.shapes, s)
+	}
+	d.walk(func(s *shape) { ids[s.id] = true })
+
+	for _, edge := range graph.Edges {
+		// Edges leave the block named by their handle.
+		from := edge.Source
+		if block := edge.SourceBlock(); ids[block] {
+			from = block
+		}
+		d.links = append(d.links, link{from: from, to: edge.Target, label: edge.Data.Operator})
+	}
+	return d
+}
+
+func (d *diagram) graphBlock(block reactFlowTypes.BlockNode, parentType string) *shape {
+	data := block.NodeData
+	s := &shape{id: block.ID, name: data.Metadata.Name, kind: data.Type, negated: data.IsNot}
+	switch {
+	case data.Type == "group_block":
+		d.graphGroup(s, data.Metadata)
+	case data.Type == "" && parentType == "response-node":
+		s.kind = "default"
+	}
+	return s
+}
+
+// graphGroup fills s with the nodes of a group and links them by the
+// operators of the group's edges.
+func (d *diagram) graphGroup(s *shape, metadata reactFlowTypes.Metadata) {
+	s.container = true
+	for _, node := range metadata.Nodes {
+		name := node.Data.Metadata.Name
+		if name == "" {
+			name = node.Metadata.Name
+		}
+		child := &shape{id: node.ID, name: name, kind: node.Type, negated: node.Data.IsNot || node.IsNot}
+		if node.Type == "group-block-node" || node.Type == "group_block" {
+			metadata := node.Data.Metadata
+			if node.Type == "group_block" {
+				metadata = node.Metadata
+			}
+			d.graphGroup(child, metadata)
+		}
+		s.children = append(s.children, child)
+	}
+	for _, edge := range metadata.Edges {
+		d.links = append(d.links, link{from: edge.Source, to: edge.Target, label: edge.Data.Operator})
+	}
+}
+
+// chainDiagram maps a rule chain onto a diagram. Nodes with a NodeIdList
+// contain the nodes it lists, in order; connections are labeled with their
+// type and the edges of a singleBlock with their operator.
+func chainDiagram(chain types.RuleChain) *diagram {
+	t := newOutline(chain)
+	d := &diagram{title: fmt.Sprintf("rule chain %s", chain.RuleChain.ID)}
+	first := firstNode(chain)
+	var roots []string
+	if first != "" && !t.member[first] {
+		roots = append(roots, first)
+	}
+	for _, node := range chain.Metadata.Nodes {
+		if node != nil && !t.member[node.Id] && node.Id != first {
+			roots = append(roots, node.Id)
+		}
+	}
+	for _, id := range roots {
+		d.shapes = append(d.shapes, d.chainShape(t, id, ""))
+	}
+	for _, node := range chain.Metadata.Nodes {
+		if node != nil && !t.written[node.Id] {
+			d.shapes = append(d.shapes, d.chainShape(t, node.Id, ""))
+		}
+	}
+	d.walk(func(s *shape) { s.first = s.id == first && first != "" })
+
+	for _, connection := range chain.Metadata.Connections {
+		d.links = append(d.links, link{from: connection.FromId, to: connection.ToId, label: connection.Type})
+	}
+	return d
+}
+
+func (d *diagram) chainShape(t *outline, id, parentType string) *shape {
+	node, ok := t.nodes[id]
+	if !ok {
+		// Untyped response blocks have no rule node: they are the default.
+		if parentType == "response" {
+			return &shape{id: id, kind: "default"}
+		}
+		return &shape{id: id, kind: "missing"}
+	}
+	s := &shape{
+		id:      id,
+		name:    node.Name,
+		kind:    node.Type,
+		negated: reactflow.ConfigBool(node.Configuration, "is_not"),
+	}
+	t.written[id] = true
+	_, s.container = node.Configuration["NodeIdList"]
+	if !s.container {
+		s.detail = Summary(node)
+	}
+	for _, member := range reactflow.ConfigStrings(node.Configuration, "NodeIdList") {
+		// A node listed by two containers is drawn in the first; the links
+		// of the second still reach it.
+		if member != id && !t.written[member] {
+			s.children = append(s.children, d.chainShape(t, member, node.Type))
+		}
+	}
+	if node.Type == "singleBlock" {
+		for _, edge := range reactflow.ConfigEdges(node.Configuration) {
+			d.links = append(d.links, link{from: edge.SourceNode, to: edge.TargetNode, label: edge.Operator})
+		}
+	}
+	return s
+}
+
+func firstNode(chain types.RuleChain) string {
+	index := chain.Metadata.FirstNodeIndex
+	if index < 0 || index >= len(chain.Metadata.Nodes) || chain.Metadata.Nodes[index] == nil {
+		return ""
+	}
+	return chain.Metadata.Nodes[index].Id
+}
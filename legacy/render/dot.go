@@ -0,0 +1,110 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// GraphDOT renders a graph in the Graphviz DOT language, for
+// `dot -Tsvg`. Containers are drawn as clusters around their blocks,
+// negated elements dashed and red with a NOT marker, and edges are labeled
+// with their operator.
+func GraphDOT(graph reactFlowTypes.Graph) string {
+	return writeDOT(graphDiagram(graph))
+}
+
+// RuleChainDOT renders a rule chain in the Graphviz DOT language like
+// GraphDOT. Connections are labeled with their type, the edges inside a
+// singleBlock with their operator, and the first node is drawn bold.
+func RuleChainDOT(chain types.RuleChain) string {
+	return writeDOT(chainDiagram(chain))
+}
+
+func writeDOT(d *diagram) string {
+	containers := make(map[string]bool)
+	d.walk(func(s *shape) {
+		if s.container {
+			containers[s.id] = true
+		}
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(d.title))
+	b.WriteString("  compound=true;\n  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=rounded, fontname=\"Helvetica\"];\n")
+	b.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+	for _, s := range d.shapes {
+		writeDOTShape(&b, s, 1)
+	}
+	for _, l := range d.links {
+		var attributes []string
+		if l.label != "" {
+			attributes = append(attributes, "label="+dotQuote(l.label))
+		}
+		// Containers are clusters; links attach to the cluster border
+		// through its anchor node.
+		if containers[l.from] {
+			attributes = append(attributes, "ltail="+dotQuote("cluster_"+l.from))
+		}
+		if containers[l.to] {
+			attributes = append(attributes, "lhead="+dotQuote("cluster_"+l.to))
+		}
+		fmt.Fprintf(&b, "  %s -> %s", dotQuote(l.from), dotQuote(l.to))
+		if len(attributes) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attributes, ", "))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func writeDOTShape(b *strings.Builder, s *shape, depth int) {
+	indent := strings.Repeat("  ", depth)
+	label := dotQuote(strings.Join(s.lines(), "\n"))
+	if !s.container {
+		attributes := []string{"label=" + label}
+		attributes = append(attributes, dotStyle(s)...)
+		fmt.Fprintf(b, "%s%s [%s];\n", indent, dotQuote(s.id), strings.Join(attributes, ", "))
+		return
+	}
+	fmt.Fprintf(b, "%ssubgraph %s {\n", indent, dotQuote("cluster_"+s.id))
+	fmt.Fprintf(b, "%s  label=%s;\n", indent, label)
+	for _, attribute := range dotStyle(s) {
+		fmt.Fprintf(b, "%s  %s;\n", indent, attribute)
+	}
+	// The anchor gives links to the container a node to end on.
+	fmt.Fprintf(b, "%s  %s [shape=point, style=invis];\n", indent, dotQuote(s.id))
+	for _, child := range s.children {
+		writeDOTShape(b, child, depth+1)
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+func dotStyle(s *shape) []string {
+	var attributes []string
+	switch {
+	case s.negated:
+		attributes = append(attributes, `style="rounded,dashed"`, "color=red", "fontcolor=red")
+	case s.kind == "missing":
+		attributes = append(attributes, `style="rounded,dotted"`, "color=gray", "fontcolor=gray")
+	default:
+		attributes = append(attributes, "style=rounded")
+	}
+	if s.first {
+		attributes = append(attributes, "penwidth=2")
+	}
+	return attributes
+}
+
+// dotQuote returns s as a quoted DOT ID; line breaks become centered line
+// breaks of the label.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
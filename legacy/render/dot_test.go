@@ -0,0 +1,111 @@
+package render
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// exampleGraph returns the draft graph of the example document.
+func exampleGraph(t *testing.T) reactFlowTypes.Graph {
+	t.Helper()
+	data, err := os.ReadFile("../../tests/example_small.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rule reactFlowTypes.QuestionRule
+	if err := json.Unmarshal(data, &rule); err != nil {
+		t.Fatal(err)
+	}
+	return rule.DraftConfig[0]
+}
+
+// exampleChain returns the rule chain the example draft graph converts to.
+func exampleChain(t *testing.T) types.RuleChain {
+	t.Helper()
+	chain, err := reactflow.ConvertFlowToRuleEngineDSL(exampleGraph(t), "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+// golden compares got with testdata/name, or rewrites the file with
+// -update.
+func golden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from %s; got:\n%s", name, path, got)
+	}
+}
+
+func TestDOTGolden(t *testing.T) {
+	golden(t, "graph.dot", GraphDOT(exampleGraph(t)))
+	golden(t, "chain.dot", RuleChainDOT(exampleChain(t)))
+}
+
+func TestDOTClusters(t *testing.T) {
+	dot := GraphDOT(exampleGraph(t))
+	for _, want := range []string{
+		// The conditional node is a cluster holding its negated block.
+		"subgraph \"cluster_vw15KBZ1sF\" {\n    label=\"OFD Status\\nconditional-node\";",
+		"    \"63un8PKf--\" [label=\"NOT No\\nvalidateInfo\", style=\"rounded,dashed\", color=red, fontcolor=red];",
+		// The edge from a block leaves the block, into the next cluster.
+		"\"uSywzhkQSQ\" -> \"QgY-AHn0Uf\" [lhead=\"cluster_QgY-AHn0Uf\"];",
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("GraphDOT() does not contain %q", want)
+		}
+	}
+}
+
+func TestEdgesLeaveBlocksWithUnderscores(t *testing.T) {
+	var graph reactFlowTypes.Graph
+	const raw = `{"id":1,"nodes":[` +
+		`{"id":"c","type":"conditional-node","data":{"metadata":{"blocks":[{"id":"a_b","data":{"type":"moment"}}]}}},` +
+		`{"id":"r","type":"response-node"}],` +
+		`"edges":[{"id":"e","source":"c","sourceHandle":"a_b_right","target":"r"}]}`
+	if err := json.Unmarshal([]byte(raw), &graph); err != nil {
+		t.Fatal(err)
+	}
+	d := graphDiagram(graph)
+	if len(d.links) != 1 || d.links[0].from != "a_b" {
+		t.Errorf("links %+v, want one from a_b", d.links)
+	}
+}
+
+func TestDOTQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", `"plain"`},
+		{`say "yes"`, `"say \"yes\""`},
+		{`a\b`, `"a\\b"`},
+		{"two\nlines", `"two\nlines"`},
+	}
+	for _, tt := range tests {
+		if got := dotQuote(tt.in); got != tt.want {
+			t.Errorf("dotQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
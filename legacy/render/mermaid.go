@@ -0,0 +1,109 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// GraphMermaid renders a graph as a Mermaid flowchart, to embed in docs
+// and tickets. Group, conditional and response containers are subgraphs
+// around their blocks, negated elements are marked NOT and styled, and
+// edges are labeled with their operator.
+func GraphMermaid(graph reactFlowTypes.Graph) string {
+	return writeMermaid(graphDiagram(graph))
+}
+
+// RuleChainMermaid renders a rule chain as a Mermaid flowchart like
+// GraphMermaid. Connections are labeled with their type, the edges inside
+// a singleBlock with their operator, and the first node is drawn bold.
+func RuleChainMermaid(chain types.RuleChain) string {
+	return writeMermaid(chainDiagram(chain))
+}
+
+func writeMermaid(d *diagram) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "---\ntitle: \"%s\"\n---\n", mermaidText(d.title))
+	b.WriteString("flowchart LR\n")
+	classes := make(map[string][]string)
+	for _, s := range d.shapes {
+		writeMermaidShape(&b, s, 1, classes)
+	}
+	for _, l := range d.links {
+		if l.label == "" {
+			fmt.Fprintf(&b, "  %s --> %s\n", mermaidID(l.from), mermaidID(l.to))
+			continue
+		}
+		fmt.Fprintf(&b, "  %s -->|\"%s\"| %s\n", mermaidID(l.from), mermaidText(l.label), mermaidID(l.to))
+	}
+	for _, class := range []string{"negated", "missing", "first"} {
+		if ids := classes[class]; len(ids) > 0 {
+			fmt.Fprintf(&b, "  class %s %s\n", strings.Join(ids, ","), class)
+		}
+	}
+	b.WriteString("  classDef negated stroke:#d33,stroke-dasharray:5 5,color:#d33\n")
+	b.WriteString("  classDef missing stroke:#999,stroke-dasharray:2 2,color:#999\n")
+	b.WriteString("  classDef first stroke-width:3px\n")
+	return b.String()
+}
+
+func writeMermaidShape(b *strings.Builder, s *shape, depth int, classes map[string][]string) {
+	indent := strings.Repeat("  ", depth)
+	id := mermaidID(s.id)
+	lines := s.lines()
+	for i, line := range lines {
+		lines[i] = mermaidText(line)
+	}
+	label := strings.Join(lines, "<br/>")
+	if s.container {
+		fmt.Fprintf(b, "%ssubgraph %s[\"%s\"]\n", indent, id, label)
+		for _, child := range s.children {
+			writeMermaidShape(b, child, depth+1, classes)
+		}
+		fmt.Fprintf(b, "%send\n", indent)
+	} else {
+		fmt.Fprintf(b, "%s%s[\"%s\"]\n", indent, id, label)
+	}
+	switch {
+	case s.negated:
+		classes["negated"] = append(classes["negated"], id)
+	case s.kind == "missing":
+		classes["missing"] = append(classes["missing"], id)
+	}
+	if s.first {
+		classes["first"] = append(classes["first"], id)
+	}
+}
+
+// mermaidID turns an element ID into a Mermaid node ID. Letters and digits
+// are kept and every other byte is written as _ and its hex code, so
+// "63un8PKf--" becomes "n_63un8PKf_2d_2d"; the prefix keeps IDs clear of
+// keywords such as "end" and of leading digits.
+func mermaidID(id string) string {
+	var b strings.Builder
+	b.WriteString("n_")
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return b.String()
+}
+
+var mermaidEntities = strings.NewReplacer(
+	"#", "#35;",
+	`"`, "#quot;",
+	"<", "#lt;",
+	">", "#gt;",
+	"\n", " ",
+)
+
+// mermaidText escapes text for a quoted Mermaid label with entity codes.
+func mermaidText(s string) string {
+	return mermaidEntities.Replace(s)
+}
@@ -0,0 +1,45 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMermaidGolden(t *testing.T) {
+	golden(t, "graph.mmd", GraphMermaid(exampleGraph(t)))
+	golden(t, "chain.mmd", RuleChainMermaid(exampleChain(t)))
+}
+
+func TestMermaidSubgraphs(t *testing.T) {
+	mermaid := GraphMermaid(exampleGraph(t))
+	for _, want := range []string{
+		"  subgraph n_vw15KBZ1sF[\"OFD Status<br/>conditional-node\"]\n    n_uSywzhkQSQ[",
+		"    n_63un8PKf_2d_2d[\"NOT No<br/>validateInfo\"]\n",
+		"  n_uSywzhkQSQ --> n_QgY_2dAHn0Uf\n",
+		"  class n_63un8PKf_2d_2d,n_8pz13Q5lnk negated\n",
+	} {
+		if !strings.Contains(mermaid, want) {
+			t.Errorf("GraphMermaid() does not contain %q", want)
+		}
+	}
+}
+
+func TestMermaidEscaping(t *testing.T) {
+	ids := []struct {
+		in, want string
+	}{
+		{"abc123", "n_abc123"},
+		{"end", "n_end"},
+		{"63un8PKf--", "n_63un8PKf_2d_2d"},
+		{"a_b", "n_a_5fb"},
+		{"a b.c", "n_a_20b_2ec"},
+	}
+	for _, tt := range ids {
+		if got := mermaidID(tt.in); got != tt.want {
+			t.Errorf("mermaidID(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+	if got, want := mermaidText(`<b>"#1"</b>`+"\nx"), "#lt;b#gt;#quot;#35;1#quot;#lt;/b#gt; x"; got != want {
+		t.Errorf("mermaidText() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,197 @@
+// Package render draws graphs and rule chains for people: rule chains as an
+// indented text outline to read in a terminal, and both as Graphviz DOT
+// diagrams for design reviews and postmortems or as Mermaid flowcharts to
+// embed in docs and tickets.
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+)
+
+// Text renders a rule chain as an outline, starting from its first node.
+// Containers list their blocks indented below them in the order they are
+// tried, blocks with is_not set are marked NOT, and connections are drawn
+// under the node they leave, e.g.
+//
+//	rule chain multiple "test" (tenant t)
+//	* vw15KBZ1sF conditionalBlock "OFD Status"
+//	    uSywzhkQSQ validateInfo "Yes": attribute_category equals "yes"
+//	      -> QgY-AHn0Uf (True)
+//	    NOT 63un8PKf-- validateInfo "No": attribute_category equals "yes"
+//
+// The first node is marked with a star. Connections leaving IDs that are
+// not nodes of the chain, such as untyped blocks, are listed at the end.
+func Text(chain types.RuleChain) string {
+	t := newOutline(chain)
+	var b strings.Builder
+	fmt.Fprintf(&b, "rule chain %s %q", chain.RuleChain.ID, chain.RuleChain.Name)
+	if chain.RuleChain.TenantID != "" {
+		fmt.Fprintf(&b, " (tenant %s)", chain.RuleChain.TenantID)
+	}
+	b.WriteString("\n")
+
+	first := firstNode(chain)
+	// Top-level nodes are the ones no container lists; the first node
+	// comes before them.
+	var roots []string
+	if first != "" && !t.member[first] {
+		roots = append(roots, first)
+	}
+	for _, node := range chain.Metadata.Nodes {
+		if node != nil && !t.member[node.Id] && node.Id != first {
+			roots = append(roots, node.Id)
+		}
+	}
+	for _, id := range roots {
+		t.write(&b, id, "", 0, id == first)
+	}
+	// Members of a cycle of containers are never reached from the roots.
+	for _, node := range chain.Metadata.Nodes {
+		if node != nil && !t.written[node.Id] {
+			t.write(&b, node.Id, "", 0, node.Id == first)
+		}
+	}
+
+	var dangling []types.NodeConnection
+	for _, connection := range chain.Metadata.Connections {
+		if _, ok := t.nodes[connection.FromId]; !ok {
+			dangling = append(dangling, connection)
+		}
+	}
+	if len(dangling) > 0 {
+		b.WriteString("connections from outside the chain\n")
+		for _, connection := range dangling {
+			fmt.Fprintf(&b, "  %s -> %s (%s)\n", connection.FromId, connection.ToId, connection.Type)
+		}
+	}
+	return b.String()
+}
+
+type outline struct {
+	nodes    map[string]*types.RuleNode
+	outgoing map[string][]types.NodeConnection
+	// member marks the nodes some container lists as a block.
+	member  map[string]bool
+	written map[string]bool
+}
+
+func newOutline(chain types.RuleChain) *outline {
+	t := &outline{
+		nodes:    make(map[string]*types.RuleNode),
+		outgoing: make(map[string][]types.NodeConnection),
+		member:   make(map[string]bool),
+		written:  make(map[string]bool),
+	}
+	for _, node := range chain.Metadata.Nodes {
+		if node == nil {
+			continue
+		}
+		t.nodes[node.Id] = node
+		for _, id := range reactflow.ConfigStrings(node.Configuration, "NodeIdList") {
+			if id != node.Id {
+				t.member[id] = true
+			}
+		}
+	}
+	for _, connection := range chain.Metadata.Connections {
+		t.outgoing[connection.FromId] = append(t.outgoing[connection.FromId], connection)
+	}
+	return t
+}
+
+// write draws node id, the block of a parent of type parentType if any.
+func (t *outline) write(b *strings.Builder, id, parentType string, depth int, first bool) {
+	indent := strings.Repeat("  ", depth)
+	marker := ""
+	if first {
+		marker = "* "
+	}
+	node, ok := t.nodes[id]
+	if !ok {
+		// Untyped response blocks have no rule node: they are the default.
+		if parentType == "response" {
+			fmt.Fprintf(b, "%s%s%s default\n", indent, marker, id)
+		} else {
+			fmt.Fprintf(b, "%s%s%s (missing)\n", indent, marker, id)
+		}
+		return
+	}
+	if t.written[id] {
+		fmt.Fprintf(b, "%s%s%s (see above)\n", indent, marker, id)
+		return
+	}
+	t.written[id] = true
+
+	if reactflow.ConfigBool(node.Configuration, "is_not") {
+		marker += "NOT "
+	}
+	line := fmt.Sprintf("%s%s%s %s", indent, marker, id, node.Type)
+	if node.Name != "" {
+		line += fmt.Sprintf(" %q", node.Name)
+	}
+	if summary := Summary(node); summary != "" {
+		line += ": " + summary
+	}
+	b.WriteString(line + "\n")
+
+	for _, connection := range t.outgoing[id] {
+		fmt.Fprintf(b, "%s  -> %s (%s)\n", indent, connection.ToId, connection.Type)
+	}
+	for _, member := range reactflow.ConfigStrings(node.Configuration, "NodeIdList") {
+		if member != id {
+			t.write(b, member, node.Type, depth+2, false)
+		}
+	}
+}
+
+// Summary describes what a leaf node checks in a few words, or returns ""
+// for containers, whose blocks say it. A singleBlock is summarized by the
+// expression its edges fold its members into.
+func Summary(node *types.RuleNode) string {
+	configuration := node.Configuration
+	switch node.Type {
+	case "moment":
+		return "moment " + reactflow.ConfigString(configuration, "id")
+	case "attribute":
+		parameterID, _ := reactflow.ConfigInt(configuration, "parameter_id")
+		return fmt.Sprintf("%s %d in %v", reactflow.ConfigString(configuration, "attribute_type"),
+			parameterID, reactflow.ConfigInts(configuration, "attribute"))
+	case "function":
+		return "function " + reactflow.ConfigString(configuration, "function_name")
+	case "validateInfo":
+		summary := fmt.Sprintf("%s %s", reactflow.ConfigString(configuration, "validate"),
+			reactflow.ConfigString(configuration, "operator"))
+		if value, ok := configuration["value"]; ok {
+			summary += " " + compactJSON(value)
+		}
+		return summary
+	case "singleBlock":
+		edges := reactflow.ConfigEdges(configuration)
+		if len(edges) == 0 {
+			return ""
+		}
+		expression := edges[0].SourceNode
+		for _, edge := range edges {
+			operator := strings.ToUpper(edge.Operator)
+			if operator == "" {
+				operator = "AND"
+			}
+			expression += fmt.Sprintf(" %s %s", operator, edge.TargetNode)
+		}
+		return expression
+	}
+	return ""
+}
+
+func compactJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
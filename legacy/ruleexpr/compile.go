@@ -0,0 +1,214 @@
+package ruleexpr
+
+import (
+	"fmt"
+	"strings"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// maxTerms bounds the blocks one rule expands to, so a rule with many
+// nested ORs under AND fails clearly instead of flooding the canvas.
+const maxTerms = 64
+
+// Options shape the graph Compile builds.
+type Options struct {
+	// ID is the ID of the graph, "multiple" when empty.
+	ID string
+	// Name names the conditional node that holds the rules.
+	Name string
+}
+
+// Compile builds a graph from a source, in the shape the canvas saves, so
+// it validates and converts like one drawn by hand. The rules become the
+// blocks of a single conditional node, in order: an expression is brought
+// to a disjunction of conjunctions, each conjunction is a block (a
+// group_block joined by "and" edges when it has several checks), and each
+// block is connected to a response node holding the answer as its default
+// block. Blocks are named after the checks they hold.
+func Compile(source string, opts Options) (reactFlowTypes.Graph, error) {
+	var graph reactFlowTypes.Graph
+	rules, err := parse(source)
+	if err != nil {
+		return graph, err
+	}
+	graph.ID = opts.ID
+	if opts.ID == "" {
+		graph.ID = "multiple"
+	}
+
+	const conditionID = "rules"
+	condition := reactFlowTypes.Node{
+		ID:   conditionID,
+		Type: "conditional-node",
+		Data: reactFlowTypes.Data{Type: "condition", Metadata: reactFlowTypes.Metadata{Name: opts.Name}},
+	}
+	ids := map[string]bool{conditionID: true}
+	var responses []reactFlowTypes.Node
+	for i, r := range rules {
+		terms, err := expand(r.condition, false)
+		if err != nil {
+			return graph, err
+		}
+		responseID := "response-" + r.response
+		if !ids[responseID] {
+			if ids[r.response] {
+				return graph, errorf(r.pos, "response %q clashes with a generated ID", r.response)
+			}
+			ids[responseID], ids[r.response] = true, true
+			responses = append(responses, responseNode(responseID, r.response))
+		}
+		for j, t := range terms {
+			blockID := fmt.Sprintf("r%dt%d", i+1, j+1)
+			if ids[blockID] {
+				return graph, errorf(r.pos, "response clashes with the generated ID %q", blockID)
+			}
+			ids[blockID] = true
+			condition.Data.Metadata.Blocks = append(condition.Data.Metadata.Blocks, t.block(blockID))
+			handle := blockID + "_right"
+			graph.Edges = append(graph.Edges, reactFlowTypes.Edge{
+				ID:           "reactflow__edge-" + conditionID + handle + "-" + responseID + responseID,
+				Source:       conditionID,
+				SourceHandle: handle,
+				Target:       responseID,
+				TargetHandle: responseID,
+				Type:         "delete-edge",
+			})
+		}
+	}
+	graph.Nodes = append([]reactFlowTypes.Node{condition}, responses...)
+	return graph, nil
+}
+
+func responseNode(id, answer string) reactFlowTypes.Node {
+	return reactFlowTypes.Node{
+		ID:   id,
+		Type: "response-node",
+		Data: reactFlowTypes.Data{
+			Type: "response",
+			Metadata: reactFlowTypes.Metadata{
+				Name:   "response " + answer,
+				Blocks: []reactFlowTypes.BlockNode{{ID: answer}},
+			},
+		},
+	}
+}
+
+// atom is a check of a term, possibly negated.
+type atom struct {
+	check   *check
+	negated bool
+}
+
+func (a atom) String() string {
+	if a.negated {
+		return "NOT " + a.check.text
+	}
+	return a.check.text
+}
+
+// term is a conjunction of atoms.
+type term []atom
+
+// expand brings an expression, negated when negated is set, to a
+// disjunction of terms, pushing negations down to the checks.
+func expand(e expr, negated bool) ([]term, error) {
+	switch e := e.(type) {
+	case *check:
+		return []term{{{check: e, negated: negated}}}, nil
+	case *notExpr:
+		return expand(e.x, !negated)
+	case *binaryExpr:
+		x, err := expand(e.x, negated)
+		if err != nil {
+			return nil, err
+		}
+		y, err := expand(e.y, negated)
+		if err != nil {
+			return nil, err
+		}
+		var terms []term
+		// Under a negation AND turns into OR and OR into AND.
+		if e.and != negated {
+			for _, a := range x {
+				for _, b := range y {
+					terms = append(terms, append(append(term{}, a...), b...))
+				}
+			}
+		} else {
+			terms = append(x, y...)
+		}
+		if len(terms) > maxTerms {
+			return nil, errorf(e.pos, "expression expands to more than %d alternatives; split the rule", maxTerms)
+		}
+		return terms, nil
+	}
+	return nil, errorf(e.position(), "unexpected expression")
+}
+
+// block builds the block of a term.
+func (t term) block(id string) reactFlowTypes.BlockNode {
+	if len(t) == 1 {
+		a := t[0]
+		return reactFlowTypes.BlockNode{ID: id, NodeData: reactFlowTypes.Node{
+			Type:     a.check.kind,
+			IsNot:    a.negated,
+			Metadata: a.check.metadata(),
+		}}
+	}
+
+	names := make([]string, len(t))
+	metadata := reactFlowTypes.Metadata{}
+	for i, a := range t {
+		names[i] = a.String()
+		member := fmt.Sprintf("%sm%d", id, i+1)
+		metadata.Nodes = append(metadata.Nodes, a.member(member))
+		if i > 0 {
+			previous := metadata.Nodes[i-1].ID
+			metadata.Edges = append(metadata.Edges, reactFlowTypes.Edge{
+				ID:     "reactflow__edge-" + previous + "-" + member,
+				Source: previous,
+				Target: member,
+				Data:   reactFlowTypes.Data{Operator: "and"},
+			})
+		}
+	}
+	metadata.Name = strings.Join(names, " AND ")
+	return reactFlowTypes.BlockNode{ID: id, NodeData: reactFlowTypes.Node{Type: "group_block", Metadata: metadata}}
+}
+
+// member builds the node of an atom inside a group. Parameters keep their
+// fields on the node, the other kinds on its data, as the canvas saves
+// them.
+func (a atom) member(id string) reactFlowTypes.Node {
+	if a.check.kind == "parameter" {
+		return reactFlowTypes.Node{ID: id, Type: "parameter", IsNot: a.negated, Metadata: a.check.metadata()}
+	}
+	return reactFlowTypes.Node{ID: id, Data: reactFlowTypes.Data{
+		Type:     a.check.kind,
+		IsNot:    a.negated,
+		Metadata: a.check.metadata(),
+	}}
+}
+
+func (c *check) metadata() reactFlowTypes.Metadata {
+	metadata := reactFlowTypes.Metadata{Name: c.text}
+	switch c.kind {
+	case "moment":
+		metadata.ID = c.id
+	case "function":
+		metadata.FunctionType = c.id
+	case "parameter":
+		metadata.Parameter = c.parameter
+		metadata.Response = c.response
+	case "validateInfo":
+		metadata.Validate = c.validate
+		metadata.ValidateFields = c.fields
+		metadata.ValidateWith = "static_info"
+		metadata.Operator = c.operator
+		metadata.DataType = c.dataType
+		metadata.Value = c.value
+		metadata.List = c.list
+	}
+	return metadata
+}
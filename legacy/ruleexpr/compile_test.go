@@ -0,0 +1,83 @@
+package ruleexpr
+
+import (
+	"errors"
+	"testing"
+
+	"bitbucket.org/convin/go_services/rule_engine/internal/evaluator"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+)
+
+func TestCompile(t *testing.T) {
+	const source = `# answers 1 for a hold without the greeting, 2 otherwise
+moment("hold") AND NOT moment("greeting") -> response 1
+param(12) == 3 OR function("is_weekend") -> response 2`
+	tests := []struct {
+		name         string
+		facts        evaluator.Facts
+		wantResponse string
+	}{
+		{"first rule", evaluator.Facts{Moments: []string{"hold"}}, "1"},
+		{"negation fails", evaluator.Facts{Moments: []string{"hold", "greeting"}}, ""},
+		{"second rule by parameter", evaluator.Facts{Parameters: map[int]int{12: 3}}, "2"},
+		{"second rule by function", evaluator.Facts{Functions: map[string]bool{"is_weekend": true}}, "2"},
+		{"first rule wins", evaluator.Facts{Moments: []string{"hold"}, Parameters: map[int]int{12: 3}}, "1"},
+		{"nothing holds", evaluator.Facts{Parameters: map[int]int{12: 2}}, ""},
+	}
+	graph, err := Compile(source, Options{Name: "hold"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if graph.ID != "multiple" {
+		t.Errorf("graph ID %v, want multiple", graph.ID)
+	}
+	// One block for the first rule and one per operand of the OR.
+	if blocks := len(graph.Nodes[0].Data.Metadata.Blocks); blocks != 3 {
+		t.Errorf("%d blocks, want 3", blocks)
+	}
+	for _, diagnostic := range reactflow.ValidateGraph(graph, "tenant") {
+		if diagnostic.Severity == reactflow.SeverityError {
+			t.Errorf("compiled graph does not validate: %s", diagnostic.Message)
+		}
+	}
+	chain, err := reactflow.ConvertChecked(graph, "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := evaluator.Evaluate(chain, tt.facts, evaluator.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Response != tt.wantResponse {
+				t.Errorf("response %q, want %q (path %v)", result.Response, tt.wantResponse, result.Path)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantPos Pos
+	}{
+		{"missing response", `moment("a")`, Pos{1, 12}},
+		{"unknown check", `colour("red") -> response 1`, Pos{1, 1}},
+		{"unclosed parenthesis", "moment(\"a\") -> response 1\n(moment(\"b\") -> response 2", Pos{2, 14}},
+		{"parameter without a number", `param(12) == "x" -> response 1`, Pos{1, 14}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.source, Options{})
+			var exprErr *Error
+			if !errors.As(err, &exprErr) {
+				t.Fatalf("Compile() error = %v, want an *Error", err)
+			}
+			if exprErr.Pos != tt.wantPos {
+				t.Errorf("error at %v, want %v: %v", exprErr.Pos, tt.wantPos, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+// Package ruleexpr compiles a compact rule syntax into graphs, so similar
+// rules can be authored in bulk, e.g. from a spreadsheet, and still run
+// through ConvertFlowToRuleEngineDSL:
+//
+//	moment("d3579277-…") AND NOT moment("305b5978-…") OR param(12) == 3 -> response 2
+//	attr(33, 69) == "yes" AND function("hold_check") -> response 1
+//
+// A source holds rules, each an expression and the response it selects.
+// Rules are tried in order and the first that holds gives the response.
+// Expressions combine checks with AND, OR, NOT (or &&, ||, !) and
+// parentheses; AND binds tighter than OR. The checks are
+//
+//	moment("id")                  the moment was detected
+//	function("name")              the function holds
+//	param(id) == n                the parameter was scored n; also != n, in (n, …)
+//	attr(category, key) op value  an attribute category compares to value
+//	entity(id) op value           an entity compares to value
+//
+// where op is ==, !=, <, >, contains or in (value, …), and value is a
+// string, a number or true/false, which also sets the data type compared.
+// Rules may be separated by newlines or semicolons, and # starts a comment.
+package ruleexpr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Pos is a position in a source, counted from 1 in lines and characters.
+type Pos struct {
+	Line   int
+	Column int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+// Error is a problem in a source, at the position it was found.
+type Error struct {
+	Pos     Pos
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Message
+}
+
+func errorf(pos Pos, format string, args ...interface{}) error {
+	return &Error{Pos: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenLParen
+	tokenRParen
+	tokenComma
+	tokenSemicolon
+	tokenArrow
+	tokenEq
+	tokenNeq
+	tokenLt
+	tokenGt
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+var tokenNames = map[tokenKind]string{
+	tokenEOF:       "end of input",
+	tokenIdent:     "name",
+	tokenNumber:    "number",
+	tokenString:    "string",
+	tokenLParen:    "(",
+	tokenRParen:    ")",
+	tokenComma:     ",",
+	tokenSemicolon: ";",
+	tokenArrow:     "->",
+	tokenEq:        "==",
+	tokenNeq:       "!=",
+	tokenLt:        "<",
+	tokenGt:        ">",
+	tokenAnd:       "AND",
+	tokenOr:        "OR",
+	tokenNot:       "NOT",
+}
+
+func (k tokenKind) String() string {
+	return tokenNames[k]
+}
+
+type token struct {
+	kind tokenKind
+	pos  Pos
+	// text is the token as written; for strings it is the unquoted value.
+	text string
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenIdent, tokenNumber:
+		return fmt.Sprintf("%s %q", t.kind, t.text)
+	case tokenString:
+		return "string " + strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.kind.String())
+}
+
+// symbols are the punctuation tokens, longest first.
+var symbols = []struct {
+	text string
+	kind tokenKind
+}{
+	{"->", tokenArrow},
+	{"==", tokenEq},
+	{"!=", tokenNeq},
+	{"&&", tokenAnd},
+	{"||", tokenOr},
+	{"(", tokenLParen},
+	{")", tokenRParen},
+	{",", tokenComma},
+	{";", tokenSemicolon},
+	{"<", tokenLt},
+	{">", tokenGt},
+	{"!", tokenNot},
+}
+
+// lex splits a source into tokens, ending with tokenEOF.
+func lex(source string) ([]token, error) {
+	var tokens []token
+	src := []rune(source)
+	line, column := 1, 1
+	advance := func(n int) {
+		for _, r := range src[:n] {
+			if r == '\n' {
+				line++
+				column = 1
+			} else {
+				column++
+			}
+		}
+		src = src[n:]
+	}
+
+	for len(src) > 0 {
+		r := src[0]
+		pos := Pos{Line: line, Column: column}
+		switch {
+		case unicode.IsSpace(r):
+			advance(1)
+			continue
+		case r == '#':
+			n := 0
+			for n < len(src) && src[n] != '\n' {
+				n++
+			}
+			advance(n)
+			continue
+		case r == '"':
+			n := 1
+			for n < len(src) && src[n] != '"' && src[n] != '\n' {
+				if src[n] == '\\' {
+					n++
+				}
+				n++
+			}
+			if n >= len(src) || src[n] != '"' {
+				return nil, errorf(pos, "unterminated string")
+			}
+			value, err := strconv.Unquote(string(src[:n+1]))
+			if err != nil {
+				return nil, errorf(pos, "invalid string %s", string(src[:n+1]))
+			}
+			tokens = append(tokens, token{kind: tokenString, pos: pos, text: value})
+			advance(n + 1)
+			continue
+		case unicode.IsDigit(r) || r == '-' && len(src) > 1 && unicode.IsDigit(src[1]):
+			n := 1
+			for n < len(src) && (unicode.IsDigit(src[n]) || src[n] == '.') {
+				n++
+			}
+			text := string(src[:n])
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, errorf(pos, "invalid number %s", text)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, pos: pos, text: text})
+			advance(n)
+			continue
+		case unicode.IsLetter(r) || r == '_':
+			n := 1
+			for n < len(src) && (unicode.IsLetter(src[n]) || unicode.IsDigit(src[n]) || src[n] == '_') {
+				n++
+			}
+			text := string(src[:n])
+			kind := tokenIdent
+			switch strings.ToUpper(text) {
+			case "AND":
+				kind = tokenAnd
+			case "OR":
+				kind = tokenOr
+			case "NOT":
+				kind = tokenNot
+			}
+			tokens = append(tokens, token{kind: kind, pos: pos, text: text})
+			advance(n)
+			continue
+		}
+		head := src
+		if len(head) > 2 {
+			head = head[:2]
+		}
+		matched := false
+		for _, symbol := range symbols {
+			if strings.HasPrefix(string(head), symbol.text) {
+				tokens = append(tokens, token{kind: symbol.kind, pos: pos, text: symbol.text})
+				advance(len([]rune(symbol.text)))
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, errorf(pos, "unexpected character %q", r)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: Pos{Line: line, Column: column}}), nil
+}
@@ -0,0 +1,409 @@
+package ruleexpr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// expr is a parsed expression: a check, a negation or a binary operation.
+type expr interface {
+	position() Pos
+}
+
+type notExpr struct {
+	pos Pos
+	x   expr
+}
+
+type binaryExpr struct {
+	pos Pos
+	// and is set for AND, clear for OR.
+	and  bool
+	x, y expr
+}
+
+// check is a leaf of an expression, already in the terms of the block it
+// becomes.
+type check struct {
+	pos Pos
+	// text is the check as it is written back, and names its block.
+	text string
+	// kind is the block type: moment, function, parameter or validateInfo.
+	kind string
+	// id is the moment ID or the function name.
+	id                  string
+	parameter, response int
+	// The fields of a validateInfo comparison against a static value.
+	validate string
+	fields   reactFlowTypes.ValidateFields
+	operator string
+	dataType string
+	value    interface{}
+	list     []interface{}
+}
+
+func (e *notExpr) position() Pos    { return e.pos }
+func (e *binaryExpr) position() Pos { return e.pos }
+func (c *check) position() Pos      { return c.pos }
+
+// rule is one line of a source: a condition and the response it selects.
+type rule struct {
+	pos       Pos
+	condition expr
+	response  string
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+// parse reads the rules of a source.
+func parse(source string) ([]rule, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	var rules []rule
+	for p.peek().kind != tokenEOF {
+		if p.peek().kind == tokenSemicolon {
+			p.next()
+			continue
+		}
+		r, err := p.rule()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	if len(rules) == 0 {
+		return nil, errorf(p.peek().pos, "no rules")
+	}
+	return rules, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokenEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, errorf(t.pos, "expected %q, found %s", kind.String(), t)
+	}
+	return t, nil
+}
+
+func (p *parser) keyword(word string) error {
+	t := p.next()
+	if t.kind != tokenIdent || !strings.EqualFold(t.text, word) {
+		return errorf(t.pos, "expected %q, found %s", word, t)
+	}
+	return nil
+}
+
+// rule = expr "->" "response" answer
+func (p *parser) rule() (rule, error) {
+	r := rule{pos: p.peek().pos}
+	var err error
+	if r.condition, err = p.or(); err != nil {
+		return r, err
+	}
+	if _, err := p.expect(tokenArrow); err != nil {
+		return r, err
+	}
+	if err := p.keyword("response"); err != nil {
+		return r, err
+	}
+	answer := p.next()
+	switch answer.kind {
+	case tokenNumber, tokenString, tokenIdent:
+		r.response = answer.text
+	default:
+		return r, errorf(answer.pos, "expected a response, found %s", answer)
+	}
+	return r, nil
+}
+
+// or = and { "OR" and }
+func (p *parser) or() (expr, error) {
+	x, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOr {
+		pos := p.next().pos
+		y, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{pos: pos, x: x, y: y}
+	}
+	return x, nil
+}
+
+// and = unary { "AND" unary }
+func (p *parser) and() (expr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenAnd {
+		pos := p.next().pos
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{pos: pos, and: true, x: x, y: y}
+	}
+	return x, nil
+}
+
+// unary = "NOT" unary | "(" or ")" | check
+func (p *parser) unary() (expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenNot:
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{pos: t.pos, x: x}, nil
+	case tokenLParen:
+		p.next()
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case tokenIdent:
+		return p.check()
+	}
+	return nil, errorf(t.pos, "expected a check, found %s", t)
+}
+
+func (p *parser) check() (expr, error) {
+	name := p.next()
+	c := &check{pos: name.pos}
+	switch name.text {
+	case "moment", "function", "param", "attr", "entity":
+	default:
+		return nil, errorf(name.pos, "unknown check %q; expected moment, function, param, attr or entity", name.text)
+	}
+	if _, err := p.expect(tokenLParen); err != nil {
+		return nil, err
+	}
+	switch name.text {
+	case "moment", "function":
+		arg, err := p.expect(tokenString)
+		if err != nil {
+			return nil, err
+		}
+		c.kind, c.id = name.text, arg.text
+		c.text = fmt.Sprintf("%s(%s)", name.text, strconv.Quote(arg.text))
+		if _, err := p.expect(tokenRParen); err != nil {
+			return nil, err
+		}
+		return c, nil
+	case "param":
+		return p.param(c)
+	case "attr":
+		category, err := p.integer()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenComma); err != nil {
+			return nil, err
+		}
+		key, err := p.integer()
+		if err != nil {
+			return nil, err
+		}
+		c.validate = "attribute_category"
+		c.fields = reactFlowTypes.ValidateFields{AttributeCategory: int32(category), AttributeCategoryKey: int32(key)}
+		c.text = fmt.Sprintf("attr(%d, %d)", category, key)
+	case "entity":
+		entity, err := p.integer()
+		if err != nil {
+			return nil, err
+		}
+		c.validate = "entity"
+		c.fields = reactFlowTypes.ValidateFields{Entity: int32(entity)}
+		c.text = fmt.Sprintf("entity(%d)", entity)
+	}
+	if _, err := p.expect(tokenRParen); err != nil {
+		return nil, err
+	}
+	c.kind = "validateInfo"
+	return c, p.comparison(c)
+}
+
+// param reads the rest of a parameter check. != and in become a negation
+// and a disjunction of == checks, since a parameter block matches a single
+// response.
+func (p *parser) param(c *check) (expr, error) {
+	id, err := p.integer()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenRParen); err != nil {
+		return nil, err
+	}
+	scored := func(response int) *check {
+		return &check{pos: c.pos, kind: "parameter", parameter: id, response: response,
+			text: fmt.Sprintf("param(%d) == %d", id, response)}
+	}
+	op := p.next()
+	switch {
+	case op.kind == tokenEq || op.kind == tokenNeq:
+		response, err := p.integer()
+		if err != nil {
+			return nil, err
+		}
+		if op.kind == tokenNeq {
+			return &notExpr{pos: op.pos, x: scored(response)}, nil
+		}
+		return scored(response), nil
+	case op.kind == tokenIdent && op.text == "in":
+		values, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		var x expr
+		for _, value := range values {
+			response, ok := value.value.(float64)
+			if !ok || response != float64(int(response)) {
+				return nil, errorf(value.pos, "expected an integer, found %s", value.token)
+			}
+			if x == nil {
+				x = scored(int(response))
+			} else {
+				x = &binaryExpr{pos: op.pos, x: x, y: scored(int(response))}
+			}
+		}
+		return x, nil
+	}
+	return nil, errorf(op.pos, "expected ==, != or in after param(%d), found %s", id, op)
+}
+
+// comparisonOperators maps the comparison tokens onto validateInfo
+// operators.
+var comparisonOperators = map[tokenKind]string{
+	tokenEq:  "equals",
+	tokenNeq: "not_equals",
+	tokenLt:  "lt",
+	tokenGt:  "gt",
+}
+
+func (p *parser) comparison(c *check) error {
+	op := p.next()
+	switch {
+	case comparisonOperators[op.kind] != "":
+		c.operator = comparisonOperators[op.kind]
+	case op.kind == tokenIdent && (op.text == "contains" || op.text == "in"):
+		c.operator = op.text
+	default:
+		return errorf(op.pos, "expected ==, !=, <, >, contains or in after %s, found %s", c.text, op)
+	}
+
+	if c.operator == "in" {
+		values, err := p.list()
+		if err != nil {
+			return err
+		}
+		var texts []string
+		for _, value := range values {
+			if value.dataType != values[0].dataType {
+				return errorf(value.pos, "list mixes %s and %s values", values[0].dataType, value.dataType)
+			}
+			c.list = append(c.list, value.value)
+			texts = append(texts, value.token.source())
+		}
+		c.dataType = values[0].dataType
+		c.text += " in (" + strings.Join(texts, ", ") + ")"
+		return nil
+	}
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	c.value, c.dataType = value.value, value.dataType
+	c.text += " " + op.text + " " + value.token.source()
+	return nil
+}
+
+// literal is a static value with the data type it is compared as.
+type literal struct {
+	token
+	value    interface{}
+	dataType string
+}
+
+func (t token) source() string {
+	if t.kind == tokenString {
+		return strconv.Quote(t.text)
+	}
+	return t.text
+}
+
+func (p *parser) value() (literal, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokenString:
+		return literal{token: t, value: t.text, dataType: "string"}, nil
+	case t.kind == tokenNumber:
+		number, _ := strconv.ParseFloat(t.text, 64)
+		return literal{token: t, value: number, dataType: "number"}, nil
+	case t.kind == tokenIdent && (t.text == "true" || t.text == "false"):
+		return literal{token: t, value: t.text == "true", dataType: "boolean"}, nil
+	}
+	return literal{}, errorf(t.pos, "expected a string, a number, true or false, found %s", t)
+}
+
+// list = "(" value { "," value } ")"
+func (p *parser) list() ([]literal, error) {
+	if _, err := p.expect(tokenLParen); err != nil {
+		return nil, err
+	}
+	var values []literal
+	for {
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		t := p.next()
+		if t.kind == tokenRParen {
+			return values, nil
+		}
+		if t.kind != tokenComma {
+			return nil, errorf(t.pos, "expected \",\" or \")\", found %s", t)
+		}
+	}
+}
+
+func (p *parser) integer() (int, error) {
+	t := p.next()
+	if t.kind == tokenNumber {
+		if n, err := strconv.Atoi(t.text); err == nil {
+			return n, nil
+		}
+	}
+	return 0, errorf(t.pos, "expected an integer, found %s", t)
+}
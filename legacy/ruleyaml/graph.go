@@ -0,0 +1,377 @@
+package ruleyaml
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// containerDataTypes are the container node types and the data type the
+// canvas gives each.
+var containerDataTypes = map[string]string{
+	"conditional-node":     "condition",
+	"conditional-gpt-node": "",
+	"default-block-node":   "",
+	"response-node":        "response",
+	"group-block-node":     "",
+	"group_block":          "",
+}
+
+// handleSide is the side of the handle edges leave blocks from.
+const handleSide = "_right"
+
+// builder turns a document into a graph, checking references as it goes.
+type builder struct {
+	ids map[string]int
+	// blocks maps a node ID to the IDs of its blocks.
+	blocks map[string]map[string]bool
+}
+
+func (d *document) graph() (reactFlowTypes.Graph, error) {
+	var graph reactFlowTypes.Graph
+	switch id := d.ID.(type) {
+	case nil:
+		return graph, errorf(d.line, "id is required")
+	case string:
+		graph.ID = id
+	case int:
+		// Graphs decoded from JSON carry numeric IDs as float64, and the
+		// converter tells single chains apart by that kind.
+		graph.ID = float64(id)
+	case int64:
+		graph.ID = float64(id)
+	case float64:
+		graph.ID = id
+	default:
+		return graph, errorf(d.line, "id must be a string or a number")
+	}
+
+	b := &builder{ids: make(map[string]int), blocks: make(map[string]map[string]bool)}
+	for _, spec := range d.Nodes {
+		node, err := b.node(spec)
+		if err != nil {
+			return graph, err
+		}
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	edges, err := b.edges(d.Edges, true)
+	if err != nil {
+		return graph, err
+	}
+	graph.Edges = edges
+	return graph, nil
+}
+
+func (b *builder) claim(id string, line int) error {
+	if id == "" {
+		return errorf(line, "id is required")
+	}
+	if first, ok := b.ids[id]; ok {
+		return errorf(line, "id %q is already used on line %d", id, first)
+	}
+	b.ids[id] = line
+	return nil
+}
+
+func (b *builder) node(spec nodeSpec) (reactFlowTypes.Node, error) {
+	node := reactFlowTypes.Node{ID: spec.ID}
+	if err := b.claim(spec.ID, spec.line); err != nil {
+		return node, err
+	}
+	if spec.Type == "" {
+		return node, errorf(spec.line, "node %s: type is required", spec.ID)
+	}
+	metadata, err := toMetadata(spec.Name, spec.Metadata, spec.line)
+	if err != nil {
+		return node, err
+	}
+	dataType, container := containerDataTypes[spec.Type]
+	group := spec.Type == "group-block-node" || spec.Type == "group_block"
+	switch {
+	case len(spec.Blocks) > 0 && (!container || group):
+		return node, errorf(spec.line, "node %s: a %s has no blocks", spec.ID, spec.Type)
+	case (len(spec.Nodes) > 0 || len(spec.Edges) > 0) && !group:
+		return node, errorf(spec.line, "node %s: only groups hold nodes and edges", spec.ID)
+	case spec.NodeType != "" && container:
+		return node, errorf(spec.line, "node %s: node_type is for leaf nodes", spec.ID)
+	}
+	if group {
+		if metadata.Nodes, metadata.Edges, err = b.group(spec.Nodes, spec.Edges); err != nil {
+			return node, err
+		}
+	}
+
+	switch {
+	case spec.Type == "group_block":
+		node.Type = spec.Type
+		node.IsNot = spec.Not
+		node.Metadata = metadata
+	case container:
+		if spec.DataType != "" {
+			dataType = spec.DataType
+		}
+		node.Type = spec.Type
+		node.Data = reactFlowTypes.Data{Type: dataType, IsNot: spec.Not}
+		b.blocks[spec.ID] = make(map[string]bool)
+		for _, blockSpec := range spec.Blocks {
+			block, err := b.block(blockSpec, spec)
+			if err != nil {
+				return node, err
+			}
+			b.blocks[spec.ID][block.ID] = true
+			metadata.Blocks = append(metadata.Blocks, block)
+		}
+		node.Data.Metadata = metadata
+	case spec.NodeType == "parameter":
+		// The converter reads parameter nodes from their own metadata
+		// rather than their data.
+		node.Type = spec.NodeType
+		node.IsNot = spec.Not
+		node.Metadata = metadata
+		node.Data = reactFlowTypes.Data{Type: spec.Type}
+	default:
+		node.Type = spec.NodeType
+		node.Data = reactFlowTypes.Data{Type: spec.Type, IsNot: spec.Not, Metadata: metadata}
+	}
+	return node, nil
+}
+
+func (b *builder) block(spec blockSpec, parent nodeSpec) (reactFlowTypes.BlockNode, error) {
+	block := reactFlowTypes.BlockNode{ID: spec.ID, IsSelected: spec.Selected}
+	if err := b.claim(spec.ID, spec.line); err != nil {
+		return block, err
+	}
+	switch {
+	case spec.Type == "" && parent.Type != "response-node":
+		return block, errorf(spec.line, "block %s: type is required outside response nodes", spec.ID)
+	case spec.Selected && parent.Type != "default-block-node":
+		return block, errorf(spec.line, "block %s: only blocks of a default-block-node are selected", spec.ID)
+	case (len(spec.Nodes) > 0 || len(spec.Edges) > 0) && spec.Type != "group_block":
+		return block, errorf(spec.line, "block %s: only group_block blocks hold nodes and edges", spec.ID)
+	}
+	metadata, err := toMetadata(spec.Name, spec.Metadata, spec.line)
+	if err != nil {
+		return block, err
+	}
+	if spec.Type == "group_block" {
+		if metadata.Nodes, metadata.Edges, err = b.group(spec.Nodes, spec.Edges); err != nil {
+			return block, err
+		}
+	}
+	block.NodeData = reactFlowTypes.Node{Type: spec.Type, IsNot: spec.Not, Metadata: metadata}
+	return block, nil
+}
+
+func (b *builder) group(specs []nodeSpec, edgeSpecs []edgeSpec) ([]reactFlowTypes.Node, []reactFlowTypes.Edge, error) {
+	var nodes []reactFlowTypes.Node
+	for _, spec := range specs {
+		node, err := b.node(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	edges, err := b.edges(edgeSpecs, false)
+	return nodes, edges, err
+}
+
+// edges builds edges once the nodes they join are read. Edges between the
+// nodes of the canvas attach to handles as the canvas draws them.
+func (b *builder) edges(specs []edgeSpec, canvas bool) ([]reactFlowTypes.Edge, error) {
+	var edges []reactFlowTypes.Edge
+	for _, spec := range specs {
+		if spec.From == "" || spec.To == "" {
+			return nil, errorf(spec.line, "edge needs from and to")
+		}
+		// Edges may outlive the nodes they join, as on the canvas; a block
+		// is checked when its node is known.
+		if blocks, ok := b.blocks[spec.From]; ok && spec.Block != "" && !blocks[spec.Block] {
+			return nil, errorf(spec.line, "edge leaves %q, which is not a block of %s", spec.Block, spec.From)
+		}
+		if spec.Block != "" && !canvas {
+			return nil, errorf(spec.line, "edges inside groups join nodes, not blocks")
+		}
+		edge := reactFlowTypes.Edge{
+			Source: spec.From,
+			Target: spec.To,
+			Data:   reactFlowTypes.Data{Operator: spec.Operator},
+		}
+		if canvas {
+			handle := spec.From + handleSide
+			if spec.Block != "" {
+				handle = spec.Block + handleSide
+			}
+			edge.SourceHandle = handle
+			edge.TargetHandle = spec.To
+			edge.Type = "delete-edge"
+			edge.ID = "reactflow__edge-" + spec.From + handle + "-" + spec.To + spec.To
+		} else {
+			edge.ID = "reactflow__edge-" + spec.From + "-" + spec.To
+		}
+		edges = append(edges, edge)
+	}
+	return edges, nil
+}
+
+// toMetadata builds the metadata of an element from its name and the
+// metadata mapping of the YAML.
+func toMetadata(name string, fields map[string]interface{}, line int) (reactFlowTypes.Metadata, error) {
+	var metadata reactFlowTypes.Metadata
+	if len(fields) > 0 {
+		for _, key := range []string{"name", "nodes", "edges", "blocks"} {
+			if _, ok := fields[key]; ok {
+				return metadata, errorf(line, "metadata.%s is written as %s of the element", key, key)
+			}
+		}
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return metadata, errorf(line, "metadata: %v", err)
+		}
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return metadata, errorf(line, "metadata: %v", err)
+		}
+	}
+	metadata.Name = name
+	return metadata, nil
+}
+
+func fromGraph(graph reactFlowTypes.Graph) (*document, error) {
+	doc := &document{ID: graph.ID}
+	if id, ok := graph.ID.(float64); ok && id == float64(int64(id)) {
+		doc.ID = int64(id)
+	}
+	for _, node := range graph.Nodes {
+		spec, err := fromNode(node)
+		if err != nil {
+			return nil, err
+		}
+		doc.Nodes = append(doc.Nodes, spec)
+	}
+	for _, edge := range graph.Edges {
+		spec := edgeSpec{From: edge.Source, To: edge.Target, Operator: edge.Data.Operator}
+		// Handles are "<block>_right" or "<block>_left".
+		handle := strings.TrimSuffix(strings.TrimSuffix(edge.SourceHandle, "_right"), "_left")
+		if handle != "" && handle != edge.Source {
+			spec.Block = handle
+		}
+		doc.Edges = append(doc.Edges, spec)
+	}
+	return doc, nil
+}
+
+func fromNode(node reactFlowTypes.Node) (nodeSpec, error) {
+	spec := nodeSpec{ID: node.ID, Type: node.Type}
+	var metadata reactFlowTypes.Metadata
+	dataType, container := containerDataTypes[node.Type]
+	switch {
+	case node.Type == "group_block":
+		spec.Not = node.IsNot
+		metadata = node.Metadata
+	case container:
+		spec.Not = node.Data.IsNot
+		if node.Data.Type != dataType {
+			spec.DataType = node.Data.Type
+		}
+		metadata = node.Data.Metadata
+		for _, block := range metadata.Blocks {
+			blockSpec, err := fromBlock(block)
+			if err != nil {
+				return spec, err
+			}
+			spec.Blocks = append(spec.Blocks, blockSpec)
+		}
+	case node.Type == "parameter":
+		spec.Type = node.Data.Type
+		if spec.Type == "" {
+			spec.Type = "parameter"
+		}
+		spec.NodeType = node.Type
+		spec.Not = node.IsNot
+		metadata = node.Metadata
+	default:
+		spec.Type = node.Data.Type
+		spec.NodeType = node.Type
+		spec.Not = node.Data.IsNot
+		metadata = node.Data.Metadata
+	}
+	if spec.Type == "" {
+		return spec, fmt.Errorf("node %s has no type", node.ID)
+	}
+	var err error
+	if spec.Nodes, spec.Edges, err = fromGroup(metadata); err != nil {
+		return spec, err
+	}
+	spec.Name, spec.Metadata, err = fromMetadata(metadata)
+	return spec, err
+}
+
+func fromBlock(block reactFlowTypes.BlockNode) (blockSpec, error) {
+	data := block.NodeData
+	spec := blockSpec{ID: block.ID, Type: data.Type, Not: data.IsNot, Selected: block.IsSelected}
+	var err error
+	if spec.Nodes, spec.Edges, err = fromGroup(data.Metadata); err != nil {
+		return spec, err
+	}
+	spec.Name, spec.Metadata, err = fromMetadata(data.Metadata)
+	return spec, err
+}
+
+func fromGroup(metadata reactFlowTypes.Metadata) ([]nodeSpec, []edgeSpec, error) {
+	var nodes []nodeSpec
+	for _, node := range metadata.Nodes {
+		spec, err := fromNode(node)
+		if err != nil {
+			return nil, nil, err
+		}
+		nodes = append(nodes, spec)
+	}
+	var edges []edgeSpec
+	for _, edge := range metadata.Edges {
+		edges = append(edges, edgeSpec{From: edge.Source, To: edge.Target, Operator: edge.Data.Operator})
+	}
+	return nodes, edges, nil
+}
+
+// fromMetadata splits metadata into the name and the remaining fields,
+// leaving out the structure the YAML writes as elements and the nulls and
+// empty values the canvas fills in.
+func fromMetadata(metadata reactFlowTypes.Metadata) (string, map[string]interface{}, error) {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return "", nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", nil, err
+	}
+	for _, key := range []string{"name", "nodes", "edges", "blocks"} {
+		delete(fields, key)
+	}
+	prune(fields)
+	if len(fields) == 0 {
+		fields = nil
+	}
+	return metadata.Name, fields, nil
+}
+
+// prune removes nulls, empty lists and empty objects from fields, at any
+// depth.
+func prune(fields map[string]interface{}) {
+	for key, value := range fields {
+		switch v := value.(type) {
+		case nil:
+			delete(fields, key)
+		case []interface{}:
+			if len(v) == 0 {
+				delete(fields, key)
+			}
+		case map[string]interface{}:
+			prune(v)
+			if len(v) == 0 {
+				delete(fields, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Package ruleyaml reads and writes graphs as YAML, so rules can be written
+// and reviewed in git instead of on the canvas. The YAML holds the logical
+// content of a graph and leaves positions to auto-layout:
+//
+//	id: multiple
+//	nodes:
+//	  - id: vw15KBZ1sF
+//	    type: conditional-node
+//	    name: OFD Status
+//	    blocks:
+//	      - id: uSywzhkQSQ
+//	        type: validateInfo
+//	        name: "Yes"
+//	        metadata: {validate: attribute_category, operator: equals, value: "yes"}
+//	      - id: 63un8PKf--
+//	        type: validateInfo
+//	        name: "No"
+//	        not: true
+//	  - id: QgY-AHn0Uf
+//	    type: conditional-node
+//	    blocks:
+//	      - id: m82S6vodx8
+//	        type: moment
+//	        metadata: {id: d3579277-7288-4df6-8c66-27cd97d8c52f}
+//	edges:
+//	  - {from: vw15KBZ1sF, block: uSywzhkQSQ, to: QgY-AHn0Uf}
+//
+// A node's type is its canvas type for containers (conditional-node,
+// conditional-gpt-node, default-block-node, response-node,
+// group-block-node and group_block) and its kind for leaves (moment,
+// parameter, validateInfo, ...). Blocks are listed in the order they are
+// tried; a response block without a type is the default answer, and
+// blocks of a default node are only used when selected. Groups hold nodes
+// and the edges that join them, with their operator. An edge leaves a
+// block of its source node when block is set. Name and not are the name
+// and is_not of an element; metadata holds the rest of its metadata.
+package ruleyaml
+
+import (
+	"bytes"
+	"fmt"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Error is a problem with the YAML, at the line of the element it
+// concerns.
+type Error struct {
+	Line    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+func errorf(line int, format string, args ...interface{}) error {
+	return &Error{Line: line, Message: fmt.Sprintf(format, args...)}
+}
+
+// Parse reads a graph from YAML. Errors locate the element at fault.
+func Parse(data []byte) (reactFlowTypes.Graph, error) {
+	var doc document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return reactFlowTypes.Graph{}, err
+	}
+	if doc.line == 0 {
+		return reactFlowTypes.Graph{}, errorf(1, "empty document")
+	}
+	return doc.graph()
+}
+
+// Marshal writes a graph as YAML.
+func Marshal(graph reactFlowTypes.Graph) ([]byte, error) {
+	doc, err := fromGraph(graph)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+type document struct {
+	ID    interface{} `yaml:"id"`
+	Nodes []nodeSpec  `yaml:"nodes"`
+	Edges []edgeSpec  `yaml:"edges,omitempty"`
+	line  int
+}
+
+type nodeSpec struct {
+	ID   string `yaml:"id"`
+	Type string `yaml:"type"`
+	// NodeType is the canvas type of a leaf node, when it has one.
+	NodeType string `yaml:"node_type,omitempty"`
+	// DataType is the data type of a container, when it is not the one
+	// the canvas gives it.
+	DataType string                 `yaml:"data_type,omitempty"`
+	Name     string                 `yaml:"name,omitempty"`
+	Not      bool                   `yaml:"not,omitempty"`
+	Metadata map[string]interface{} `yaml:"metadata,omitempty"`
+	Blocks   []blockSpec            `yaml:"blocks,omitempty"`
+	Nodes    []nodeSpec             `yaml:"nodes,omitempty"`
+	Edges    []edgeSpec             `yaml:"edges,omitempty"`
+	line     int
+}
+
+type blockSpec struct {
+	ID       string                 `yaml:"id"`
+	Type     string                 `yaml:"type,omitempty"`
+	Name     string                 `yaml:"name,omitempty"`
+	Not      bool                   `yaml:"not,omitempty"`
+	Selected bool                   `yaml:"selected,omitempty"`
+	Metadata map[string]interface{} `yaml:"metadata,omitempty"`
+	// Nodes and Edges are the content of a group_block.
+	Nodes []nodeSpec `yaml:"nodes,omitempty"`
+	Edges []edgeSpec `yaml:"edges,omitempty"`
+	line  int
+}
+
+type edgeSpec struct {
+	From     string `yaml:"from"`
+	Block    string `yaml:"block,omitempty"`
+	To       string `yaml:"to"`
+	Operator string `yaml:"operator,omitempty"`
+	line     int
+}
+
+// The UnmarshalYAML methods record the line of each element and reject
+// keys the format does not have, so typos do not silently drop content.
+
+func (d *document) UnmarshalYAML(value *yaml.Node) error {
+	if err := checkKeys(value, "id", "nodes", "edges"); err != nil {
+		return err
+	}
+	type plain document
+	if err := value.Decode((*plain)(d)); err != nil {
+		return err
+	}
+	d.line = value.Line
+	return nil
+}
+
+func (n *nodeSpec) UnmarshalYAML(value *yaml.Node) error {
+	if err := checkKeys(value, "id", "type", "node_type", "data_type", "name", "not", "metadata", "blocks", "nodes", "edges"); err != nil {
+		return err
+	}
+	type plain nodeSpec
+	if err := value.Decode((*plain)(n)); err != nil {
+		return err
+	}
+	n.line = value.Line
+	return nil
+}
+
+func (b *blockSpec) UnmarshalYAML(value *yaml.Node) error {
+	if err := checkKeys(value, "id", "type", "name", "not", "selected", "metadata", "nodes", "edges"); err != nil {
+		return err
+	}
+	type plain blockSpec
+	if err := value.Decode((*plain)(b)); err != nil {
+		return err
+	}
+	b.line = value.Line
+	return nil
+}
+
+func (e *edgeSpec) UnmarshalYAML(value *yaml.Node) error {
+	if err := checkKeys(value, "from", "block", "to", "operator"); err != nil {
+		return err
+	}
+	type plain edgeSpec
+	if err := value.Decode((*plain)(e)); err != nil {
+		return err
+	}
+	e.line = value.Line
+	return nil
+}
+
+func checkKeys(value *yaml.Node, allowed ...string) error {
+	if value.Kind != yaml.MappingNode {
+		return errorf(value.Line, "expected a mapping")
+	}
+	for i := 0; i < len(value.Content); i += 2 {
+		key := value.Content[i]
+		known := false
+		for _, name := range allowed {
+			if key.Value == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return errorf(key.Line, "unknown field %q", key.Value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package ruleyaml
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+const example = `id: multiple
+nodes:
+  - id: vw15KBZ1sF
+    type: conditional-node
+    name: OFD Status
+    blocks:
+      - id: uSywzhkQSQ
+        type: validateInfo
+        name: "Yes"
+        metadata: {validate: attribute_category, operator: equals, value: "yes"}
+      - id: 63un8PKf--
+        type: validateInfo
+        name: "No"
+        not: true
+  - id: QgY-AHn0Uf
+    type: conditional-node
+    blocks:
+      - id: m82S6vodx8
+        type: moment
+        metadata: {id: d3579277-7288-4df6-8c66-27cd97d8c52f}
+edges:
+  - {from: vw15KBZ1sF, block: uSywzhkQSQ, to: QgY-AHn0Uf}
+`
+
+// exampleGraphs returns the graphs of the example document by name.
+func exampleGraphs(t *testing.T) map[string]reactFlowTypes.Graph {
+	t.Helper()
+	data, err := os.ReadFile("../../tests/example_small.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rule reactFlowTypes.QuestionRule
+	if err := json.Unmarshal(data, &rule); err != nil {
+		t.Fatal(err)
+	}
+	graphs := make(map[string]reactFlowTypes.Graph)
+	for i, graph := range rule.Config {
+		graphs["config/"+strconv.Itoa(i)] = graph
+	}
+	for i, graph := range rule.DraftConfig {
+		graphs["draft_config/"+strconv.Itoa(i)] = graph
+	}
+	return graphs
+}
+
+// storage encodes the logical content of a graph, which is what YAML keeps.
+func storage(t *testing.T, graph reactFlowTypes.Graph) []byte {
+	t.Helper()
+	data, err := json.Marshal(reactFlowTypes.StorageProjection(graph).WithoutExtras())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, graph := range exampleGraphs(t) {
+		t.Run(name, func(t *testing.T) {
+			first, err := Marshal(graph)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reparsed, err := Parse(first)
+			if err != nil {
+				t.Fatalf("Parse() of marshalled YAML: %v\n%s", err, first)
+			}
+			second, err := Marshal(reparsed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(first, second) {
+				t.Errorf("YAML changed on a round trip:\n got %s\nwant %s", second, first)
+			}
+		})
+	}
+}
+
+// TestParseRoundTrip checks that a graph read from YAML comes back
+// unchanged; graphs drawn on the canvas also carry handle sides, which the
+// YAML leaves to the editor.
+func TestParseRoundTrip(t *testing.T) {
+	graph, err := Parse([]byte(example))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Marshal(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reparsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() of marshalled YAML: %v\n%s", err, data)
+	}
+	if got, want := storage(t, reparsed), storage(t, graph); !bytes.Equal(got, want) {
+		t.Errorf("graph changed on a round trip:\n got %s\nwant %s", got, want)
+	}
+	if len(graph.Nodes) != 2 || len(graph.Edges) != 1 {
+		t.Errorf("parsed %d nodes and %d edges, want 2 and 1", len(graph.Nodes), len(graph.Edges))
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		yaml     string
+		wantLine int
+	}{
+		{"empty", "", 1},
+		{"missing id", "nodes: []\n", 1},
+		{"unknown field", "id: g\ncolour: red\n", 2},
+		{"node without type", "id: g\nnodes:\n  - id: a\n", 3},
+		{"duplicate id", "id: g\nnodes:\n  - {id: a, type: moment}\n  - {id: a, type: moment}\n", 4},
+		{"edge without target", "id: g\nnodes:\n  - {id: a, type: moment}\nedges:\n  - {from: a}\n", 5},
+		{"block outside its node", "id: g\nnodes:\n  - {id: a, type: conditional-node, blocks: [{id: y, type: moment}]}\n  - {id: b, type: moment}\nedges:\n  - {from: a, block: x, to: b}\n", 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.yaml))
+			var yamlErr *Error
+			if !errors.As(err, &yamlErr) {
+				t.Fatalf("Parse() error = %v, want an *Error", err)
+			}
+			if yamlErr.Line != tt.wantLine {
+				t.Errorf("error on line %d, want %d: %v", yamlErr.Line, tt.wantLine, err)
+			}
+		})
+	}
+}
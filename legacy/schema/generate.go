@@ -0,0 +1,176 @@
+package schema
+
+import (
+	"reflect"
+	"strings"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// ID is the $id of the generated graph schema.
+const ID = "urn:convin:rule-engine:graph"
+
+// typeConstraint makes a field required when a node's "type" has a given
+// value, e.g. conditional nodes must carry their blocks.
+type typeConstraint struct {
+	Type string
+	Path string
+	Leaf func() *Schema
+}
+
+func nonEmptyArray() *Schema {
+	return &Schema{Type: TypeList{"array"}, MinItems: intPtr(1)}
+}
+
+func nonEmptyString() *Schema {
+	return &Schema{Type: TypeList{"string"}, MinLength: intPtr(1)}
+}
+
+func integer() *Schema {
+	return &Schema{Type: TypeList{"integer"}}
+}
+
+// nodeTypeConstraints apply to Node objects, both top-level canvas nodes
+// and the node data nested inside a block.
+var nodeTypeConstraints = []typeConstraint{
+	{Type: "conditional-node", Path: "data.metadata.blocks", Leaf: nonEmptyArray},
+	{Type: "conditional-gpt-node", Path: "data.metadata.blocks", Leaf: nonEmptyArray},
+	{Type: "default-block-node", Path: "data.metadata.blocks", Leaf: nonEmptyArray},
+	{Type: "response-node", Path: "data.metadata.blocks", Leaf: nonEmptyArray},
+	{Type: "group-block-node", Path: "data.metadata.nodes", Leaf: nonEmptyArray},
+	{Type: "group_block", Path: "metadata.nodes", Leaf: nonEmptyArray},
+	{Type: "moment", Path: "metadata.id", Leaf: nonEmptyString},
+	{Type: "parameter", Path: "metadata.parameter", Leaf: integer},
+}
+
+// dataTypeConstraints apply to the Data object of a canvas node, whose
+// "type" names the leaf kind when the node itself is a generic shape.
+var dataTypeConstraints = []typeConstraint{
+	{Type: "moment", Path: "metadata.id", Leaf: nonEmptyString},
+	{Type: "parameter", Path: "metadata.parameter", Leaf: integer},
+}
+
+// fieldOverrides replaces the reflected schema of fields whose Go type is
+// looser than the contract, keyed by "<Type>.<json name>".
+var fieldOverrides = map[string]func() *Schema{
+	// The converter switches on the kind of the graph ID, so it must be
+	// present and either a number (single chain) or a string ("multiple").
+	"Graph.id": func() *Schema { return &Schema{Type: TypeList{"string", "number"}} },
+}
+
+// requiredFields lists the fields each type cannot do without.
+var requiredFields = map[string][]string{
+	"Graph":     {"id"},
+	"Edge":      {"source", "target"},
+	"BlockNode": {"id"},
+}
+
+// Generate builds the JSON Schema for reactFlowTypes.Graph.
+func Generate() *Schema {
+	g := &generator{defs: make(map[string]*Schema)}
+	root := g.schemaFor(reflect.TypeOf(reactFlowTypes.Graph{}))
+	g.defs["Graph"].Properties["nodes"].Items = &Schema{
+		AllOf: []*Schema{g.schemaFor(reflect.TypeOf(reactFlowTypes.Node{})), {Required: []string{"id"}}},
+	}
+	g.defs["Node"].AllOf = typeConstraints(nodeTypeConstraints)
+	g.defs["Data"].AllOf = typeConstraints(dataTypeConstraints)
+	return &Schema{
+		Schema: Draft,
+		ID:     ID,
+		Title:  "Graph",
+		Ref:    root.Ref,
+		Defs:   g.defs,
+	}
+}
+
+type generator struct {
+	defs map[string]*Schema
+}
+
+func (g *generator) schemaFor(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schemaFor(t.Elem())
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := g.defs[name]; !ok {
+			// Register before recursing: Node and Metadata refer to each other.
+			def := &Schema{Type: TypeList{"object", "null"}, Properties: make(map[string]*Schema)}
+			g.defs[name] = def
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				jsonName, ok := jsonFieldName(field)
+				if !ok {
+					continue
+				}
+				if override, ok := fieldOverrides[name+"."+jsonName]; ok {
+					def.Properties[jsonName] = override()
+					continue
+				}
+				def.Properties[jsonName] = g.schemaFor(field.Type)
+			}
+			def.Required = requiredFields[name]
+		}
+		return &Schema{Ref: "#/$defs/" + name}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: TypeList{"array", "null"}, Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: TypeList{"object", "null"}}
+	case reflect.String:
+		return &Schema{Type: TypeList{"string", "null"}}
+	case reflect.Bool:
+		return &Schema{Type: TypeList{"boolean", "null"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: TypeList{"integer", "null"}}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: TypeList{"number", "null"}}
+	}
+	// interface{} accepts any JSON value.
+	return &Schema{}
+}
+
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name := strings.Split(tag, ",")[0]
+	if name == "" {
+		name = field.Name
+	}
+	return name, true
+}
+
+func typeConstraints(constraints []typeConstraint) []*Schema {
+	var allOf []*Schema
+	for _, constraint := range constraints {
+		allOf = append(allOf, &Schema{
+			// Without the type guard a null node or data would match If
+			// vacuously and then fail Then.
+			If: &Schema{
+				Type:       TypeList{"object"},
+				Properties: map[string]*Schema{"type": {Const: constraint.Type}},
+				Required:   []string{"type"},
+			},
+			Then: requirePath(strings.Split(constraint.Path, "."), constraint.Leaf()),
+		})
+	}
+	return allOf
+}
+
+// requirePath builds a schema requiring the nested field at path to be
+// present and to match leaf.
+func requirePath(path []string, leaf *Schema) *Schema {
+	if len(path) == 0 {
+		return leaf
+	}
+	return &Schema{
+		Type:       TypeList{"object"},
+		Required:   []string{path[0]},
+		Properties: map[string]*Schema{path[0]: requirePath(path[1:], leaf)},
+	}
+}
@@ -0,0 +1,70 @@
+// Package schema derives a JSON Schema from the React Flow graph types and
+// validates payloads against it. The Go structs in internal/types are the
+// source of truth; the schema is generated from them, so the React client
+// can check what it sends against the same contract the services decode.
+package schema
+
+import "encoding/json"
+
+// Draft is the JSON Schema dialect the generated schema declares.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is the subset of JSON Schema the generator emits and the validator
+// understands.
+type Schema struct {
+	Schema      string             `json:"$schema,omitempty"`
+	ID          string             `json:"$id,omitempty"`
+	Ref         string             `json:"$ref,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Type        TypeList           `json:"type,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Enum        []interface{}      `json:"enum,omitempty"`
+	Const       interface{}        `json:"const,omitempty"`
+	MinItems    *int               `json:"minItems,omitempty"`
+	MinLength   *int               `json:"minLength,omitempty"`
+	AllOf       []*Schema          `json:"allOf,omitempty"`
+	If          *Schema            `json:"if,omitempty"`
+	Then        *Schema            `json:"then,omitempty"`
+	Defs        map[string]*Schema `json:"$defs,omitempty"`
+}
+
+// TypeList is the "type" keyword. It is written as a bare string when it
+// holds a single type and as an array otherwise.
+type TypeList []string
+
+func (t TypeList) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *TypeList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = TypeList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+func (t TypeList) has(name string) bool {
+	for _, typ := range t {
+		if typ == name {
+			return true
+		}
+	}
+	return false
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -0,0 +1,202 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Violation is a single place where a payload breaks the schema.
+type Violation struct {
+	// Path is the JSON Pointer of the offending value ("" for the root).
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	path := v.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s: %s", path, v.Message)
+}
+
+// ValidateJSON checks a graph payload against the generated graph schema.
+// The error is only set when data is not valid JSON.
+func ValidateJSON(data []byte) ([]Violation, error) {
+	return Generate().ValidateJSON(data)
+}
+
+// ValidateJSON checks a JSON document against s.
+func (s *Schema) ValidateJSON(data []byte) ([]Violation, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var instance interface{}
+	if err := decoder.Decode(&instance); err != nil {
+		return nil, err
+	}
+	return s.Validate(instance), nil
+}
+
+// Validate checks a decoded JSON value against s. Numbers may be float64
+// or json.Number. Every violation is reported, not just the first.
+func (s *Schema) Validate(instance interface{}) []Violation {
+	v := &validator{root: s}
+	v.validate(s, instance, "")
+	return v.violations
+}
+
+type validator struct {
+	root       *Schema
+	violations []Violation
+}
+
+func (v *validator) report(path, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validate(s *Schema, instance interface{}, path string) {
+	if s.Ref != "" {
+		def, err := v.resolve(s.Ref)
+		if err != nil {
+			v.report(path, "%v", err)
+			return
+		}
+		v.validate(def, instance, path)
+	}
+	if len(s.Type) > 0 && !matchesType(s.Type, instance) {
+		v.report(path, "expected %s, got %s", strings.Join(s.Type, " or "), jsonType(instance))
+		return
+	}
+	if s.Const != nil && !jsonEqual(s.Const, instance) {
+		v.report(path, "must be %v", s.Const)
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, option := range s.Enum {
+			if jsonEqual(option, instance) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.report(path, "must be one of %v", s.Enum)
+		}
+	}
+
+	switch value := instance.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				v.report(path, "missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if child, ok := value[name]; ok {
+				v.validate(s.Properties[name], child, path+"/"+escapePointer(name))
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(value) < *s.MinItems {
+			v.report(path, "must have at least %d items, got %d", *s.MinItems, len(value))
+		}
+		if s.Items != nil {
+			for i, item := range value {
+				v.validate(s.Items, item, path+"/"+strconv.Itoa(i))
+			}
+		}
+	case string:
+		if s.MinLength != nil && utf8.RuneCountInString(value) < *s.MinLength {
+			v.report(path, "must be at least %d characters long", *s.MinLength)
+		}
+	}
+
+	for _, sub := range s.AllOf {
+		v.validate(sub, instance, path)
+	}
+	if s.If != nil && s.Then != nil {
+		probe := &validator{root: v.root}
+		probe.validate(s.If, instance, path)
+		if len(probe.violations) == 0 {
+			v.validate(s.Then, instance, path)
+		}
+	}
+}
+
+func (v *validator) resolve(ref string) (*Schema, error) {
+	const prefix = "#/$defs/"
+	if !strings.HasPrefix(ref, prefix) {
+		return nil, fmt.Errorf("unsupported $ref %q", ref)
+	}
+	def, ok := v.root.Defs[strings.TrimPrefix(ref, prefix)]
+	if !ok {
+		return nil, fmt.Errorf("unresolved $ref %q", ref)
+	}
+	return def, nil
+}
+
+func jsonType(instance interface{}) string {
+	switch value := instance.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number:
+		if f, err := value.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", instance)
+}
+
+func matchesType(types TypeList, instance interface{}) bool {
+	actual := jsonType(instance)
+	if types.has(actual) {
+		return true
+	}
+	// Every integer is also a number.
+	return actual == "integer" && types.has("number")
+}
+
+func jsonEqual(a, b interface{}) bool {
+	if n, ok := b.(json.Number); ok {
+		if f, err := n.Float64(); err == nil {
+			b = f
+		}
+	}
+	x, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	y, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(x, y)
+}
+
+func escapePointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
@@ -0,0 +1,102 @@
+package schema
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	generated := Generate()
+	data, err := json.Marshal(generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Schema
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Schema != Draft || decoded.ID != ID || decoded.Ref != "#/$defs/Graph" {
+		t.Errorf("schema header $schema %q, $id %q, $ref %q", decoded.Schema, decoded.ID, decoded.Ref)
+	}
+	for _, def := range []string{"Graph", "Node", "Data", "Metadata", "BlockNode", "Edge", "Position"} {
+		if decoded.Defs[def] == nil {
+			t.Errorf("no definition of %s", def)
+		}
+	}
+	if !reflect.DeepEqual(decoded.Defs["Edge"].Required, []string{"source", "target"}) {
+		t.Errorf("Edge requires %v, want source and target", decoded.Defs["Edge"].Required)
+	}
+	// The decoded schema validates like the generated one.
+	if violations := decoded.Validate(map[string]interface{}{}); len(violations) != 1 {
+		t.Errorf("decoded schema reports %v for a graph without an id", violations)
+	}
+}
+
+func TestValidateExample(t *testing.T) {
+	data, err := os.ReadFile("../../tests/example_small.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document struct {
+		Config      []json.RawMessage `json:"config"`
+		DraftConfig []json.RawMessage `json:"draft_config"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatal(err)
+	}
+	graphs := append(document.Config, document.DraftConfig...)
+	if len(graphs) == 0 {
+		t.Fatal("the example document has no graphs")
+	}
+	for i, graph := range graphs {
+		violations, err := ValidateJSON(graph)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(violations) != 0 {
+			t.Errorf("graph %d: %v", i, violations)
+		}
+	}
+}
+
+func TestValidateJSONRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    []string
+	}{
+		{"not an object", `[]`, []string{"/: expected object or null, got array"}},
+		{"no id", `{"nodes":[]}`, []string{`/: missing required property "id"`}},
+		{"boolean id", `{"id":true}`, []string{"/id: expected string or number, got boolean"}},
+		{"node without id", `{"id":1,"nodes":[{"type":"group-block-node","data":{"metadata":{"nodes":[{}]}}}]}`, []string{`/nodes/0: missing required property "id"`}},
+		{"edge without target", `{"id":1,"edges":[{"source":"a"}]}`, []string{`/edges/0: missing required property "target"`}},
+		{"conditional node without blocks", `{"id":1,"nodes":[{"id":"a","type":"conditional-node","data":{"metadata":{"blocks":[]}}}]}`, []string{"/nodes/0/data/metadata/blocks: must have at least 1 items, got 0"}},
+		{"moment without id", `{"id":1,"nodes":[{"id":"a","type":"moment","metadata":{"id":""}}]}`, []string{"/nodes/0/metadata/id: must be at least 1 characters long"}},
+		{"moment data without id", `{"id":1,"nodes":[{"id":"a","data":{"type":"moment","metadata":{}}}]}`, []string{`/nodes/0/data/metadata: missing required property "id"`}},
+		{"block without id", `{"id":1,"nodes":[{"id":"a","data":{"metadata":{"blocks":[{"data":{}}]}}}]}`, []string{`/nodes/0/data/metadata/blocks/0: missing required property "id"`}},
+		{"string width", `{"id":1,"nodes":[{"id":"a","width":"wide"}]}`, []string{"/nodes/0/width: expected integer or null, got string"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := ValidateJSON([]byte(tt.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, violation := range violations {
+				got = append(got, violation.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("violations %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateJSONMalformed(t *testing.T) {
+	if _, err := ValidateJSON([]byte(`{"id":`)); err == nil {
+		t.Error("ValidateJSON() succeeded on malformed JSON")
+	}
+}
@@ -0,0 +1,535 @@
+// Package simplify folds away the redundancy converted rule chains carry,
+// so the rule engine has fewer nodes to evaluate per call, without
+// changing what a chain answers:
+//
+//   - a group with a single member is replaced by that member, which keeps
+//     the ID of the group; a negated group of a negated member becomes the
+//     plain member;
+//   - a block that repeats an earlier block of its conditional or response
+//     node is dropped, as the earlier one always wins;
+//   - an operand that repeats an earlier one of a group that only ANDs or
+//     only ORs is dropped;
+//   - nodes only those blocks and operands led to are dropped.
+//
+// The blocks of GPT nodes are left alone, as the engine refers to them from
+// the configuration of the node, and so are the copies of the editor
+// metadata that container configurations keep. Verify checks a simplified
+// chain against the original with the offline evaluator.
+package simplify
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+)
+
+// The kinds of Change.
+const (
+	ChangeUnwrap           = "unwrap"
+	ChangeDoubleNegation   = "double_negation"
+	ChangeDuplicateBlock   = "duplicate_block"
+	ChangeDuplicateOperand = "duplicate_operand"
+	ChangeDropped          = "dropped"
+)
+
+// Change is one simplification made to a chain.
+type Change struct {
+	Kind    string `json:"kind"`
+	NodeID  string `json:"node_id"`
+	Message string `json:"message"`
+}
+
+func (c Change) String() string {
+	return c.NodeID + ": " + c.Message
+}
+
+// absorbable are the node types that may take the place of their group.
+// Containers route differently from a group, and a GPT node is answered by
+// its ID.
+var absorbable = map[string]bool{
+	"moment":       true,
+	"attribute":    true,
+	"function":     true,
+	"validateInfo": true,
+	"singleBlock":  true,
+}
+
+// Chain returns a simplified copy of chain and the changes made to it.
+// chain itself is not modified.
+func Chain(chain types.RuleChain) (types.RuleChain, []Change) {
+	before := reachable(chain)
+	s := newSimplifier(chain)
+	for s.pass() {
+	}
+	s.drop(before)
+	return s.chain, s.changes
+}
+
+type simplifier struct {
+	chain   types.RuleChain
+	nodes   map[string]*types.RuleNode
+	changes []Change
+	// absorbed holds the members that took the place of their group.
+	absorbed map[string]bool
+	// reported holds the nodes whose removal is already a change.
+	reported map[string]bool
+}
+
+// newSimplifier copies chain deeply enough for its nodes to be rewritten:
+// configurations are copied, their values are replaced, never modified.
+func newSimplifier(chain types.RuleChain) *simplifier {
+	s := &simplifier{
+		chain:    chain,
+		nodes:    make(map[string]*types.RuleNode),
+		absorbed: make(map[string]bool),
+		reported: make(map[string]bool),
+	}
+	s.chain.Metadata.Nodes = nil
+	for _, node := range chain.Metadata.Nodes {
+		if node == nil {
+			continue
+		}
+		copied := *node
+		copied.Configuration = copyConfiguration(node.Configuration)
+		s.chain.Metadata.Nodes = append(s.chain.Metadata.Nodes, &copied)
+		s.nodes[copied.Id] = &copied
+	}
+	if index := chain.Metadata.FirstNodeIndex; index >= 0 && index < len(chain.Metadata.Nodes) && chain.Metadata.Nodes[index] != nil {
+		s.chain.Metadata.FirstNodeIndex = s.index(chain.Metadata.Nodes[index].Id)
+	}
+	s.chain.Metadata.Connections = append([]types.NodeConnection(nil), chain.Metadata.Connections...)
+	return s
+}
+
+func copyConfiguration(configuration types.Configuration) types.Configuration {
+	if configuration == nil {
+		return nil
+	}
+	copied := make(types.Configuration, len(configuration))
+	for key, value := range configuration {
+		copied[key] = value
+	}
+	return copied
+}
+
+func (s *simplifier) index(id string) int {
+	for i, node := range s.chain.Metadata.Nodes {
+		if node.Id == id {
+			return i
+		}
+	}
+	return 0
+}
+
+func (s *simplifier) note(kind, nodeID, format string, args ...interface{}) {
+	s.changes = append(s.changes, Change{Kind: kind, NodeID: nodeID, Message: fmt.Sprintf(format, args...)})
+}
+
+// pass runs every simplification once and reports whether one applied.
+func (s *simplifier) pass() bool {
+	changed := false
+	keys := &keyer{nodes: s.nodes, memo: make(map[string]string), visiting: make(map[string]bool)}
+	refs := s.references()
+	for _, node := range s.chain.Metadata.Nodes {
+		if s.absorbed[node.Id] {
+			continue
+		}
+		switch {
+		case node.Type == "conditionalBlock" || node.Type == "response":
+			changed = s.duplicateBlocks(node, keys) || changed
+		case node.Type == "singleBlock" && !refs.gpt[node.Id]:
+			changed = s.duplicateOperands(node, keys) || changed
+		}
+	}
+	// Dropping duplicates only lowers the counts, so refs stays on the
+	// safe side.
+	for _, node := range s.chain.Metadata.Nodes {
+		if !s.absorbed[node.Id] && node.Type == "singleBlock" {
+			changed = s.unwrap(node, refs) || changed
+		}
+	}
+	return changed
+}
+
+// duplicateBlocks drops the blocks of a container that repeat an earlier
+// block. The untyped default block of a response node has no rule node
+// and is always kept.
+func (s *simplifier) duplicateBlocks(node *types.RuleNode, keys *keyer) bool {
+	ids := reactflow.ConfigStrings(node.Configuration, "NodeIdList")
+	kept := make([]string, 0, len(ids))
+	seen := make(map[string]string)
+	for _, id := range ids {
+		if key, ok := keys.key(id); ok {
+			if first, repeated := seen[key]; repeated {
+				s.note(ChangeDuplicateBlock, id, "block %s of %s repeats block %s, which always wins", id, node.Id, first)
+				s.reported[id] = true
+				continue
+			}
+			seen[key] = id
+		}
+		kept = append(kept, id)
+	}
+	if len(kept) == len(ids) {
+		return false
+	}
+	node.Configuration["NodeIdList"] = kept
+	return true
+}
+
+// duplicateOperands drops the operands of a group that repeat an earlier
+// operand, when the group only ANDs or only ORs.
+func (s *simplifier) duplicateOperands(node *types.RuleNode, keys *keyer) bool {
+	op, ok := flatten(node)
+	if !ok {
+		return false
+	}
+	kept := make([]string, 0, len(op.operands))
+	seen := make(map[string]string)
+	for _, id := range op.operands {
+		if key, ok := keys.key(id); ok {
+			if first, repeated := seen[key]; repeated {
+				s.note(ChangeDuplicateOperand, node.Id, "operand %s of %s repeats %s", id, node.Id, first)
+				continue
+			}
+			seen[key] = id
+		}
+		kept = append(kept, id)
+	}
+	if len(kept) == len(op.operands) {
+		return false
+	}
+	op.operands = kept
+	op.apply(node)
+	return true
+}
+
+// unwrap puts the single operand of a group in the place of the group. The
+// operand must be referenced by the group alone.
+func (s *simplifier) unwrap(group *types.RuleNode, refs references) bool {
+	op, ok := flatten(group)
+	if !ok || len(op.operands) != 1 || refs.gpt[group.Id] {
+		return false
+	}
+	memberID := op.operands[0]
+	member := s.nodes[memberID]
+	if member == nil || memberID == group.Id || !absorbable[member.Type] ||
+		refs.count[memberID] != 1 || refs.connected[memberID] || memberID == s.first() {
+		return false
+	}
+
+	groupNegated := reactflow.ConfigBool(group.Configuration, "is_not")
+	memberNegated := reactflow.ConfigBool(member.Configuration, "is_not")
+	configuration := copyConfiguration(member.Configuration)
+	if configuration == nil {
+		configuration = make(types.Configuration)
+	}
+	configuration["is_not"] = groupNegated != memberNegated
+	group.Type = member.Type
+	group.Name = member.Name
+	group.Configuration = configuration
+	delete(s.nodes, memberID)
+	s.absorbed[memberID] = true
+
+	if groupNegated && memberNegated {
+		s.note(ChangeDoubleNegation, group.Id, "group %s and its single operand %s are both negated; the plain operand takes its place", group.Id, memberID)
+	} else {
+		s.note(ChangeUnwrap, group.Id, "group %s has the single operand %s, which takes its place", group.Id, memberID)
+	}
+	return true
+}
+
+func (s *simplifier) first() string {
+	if index := s.chain.Metadata.FirstNodeIndex; index >= 0 && index < len(s.chain.Metadata.Nodes) {
+		return s.chain.Metadata.Nodes[index].Id
+	}
+	return ""
+}
+
+// drop takes the absorbed members out of the chain, with the nodes that
+// were reachable before simplifying or dropped as duplicates and are not
+// reachable anymore, and their connections.
+func (s *simplifier) drop(before map[string]bool) {
+	first := s.first()
+	after := reachable(s.chain)
+	dropped := make(map[string]bool)
+	var nodes []*types.RuleNode
+	for _, node := range s.chain.Metadata.Nodes {
+		if s.absorbed[node.Id] {
+			dropped[node.Id] = true
+			continue
+		}
+		if (before[node.Id] || s.reported[node.Id]) && !after[node.Id] {
+			if !s.reported[node.Id] {
+				s.note(ChangeDropped, node.Id, "%s %s is no longer used", node.Type, node.Id)
+			}
+			dropped[node.Id] = true
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	s.chain.Metadata.Nodes = nodes
+	s.chain.Metadata.FirstNodeIndex = s.index(first)
+
+	var connections []types.NodeConnection
+	for _, connection := range s.chain.Metadata.Connections {
+		if !dropped[connection.FromId] && !dropped[connection.ToId] {
+			connections = append(connections, connection)
+		}
+	}
+	s.chain.Metadata.Connections = connections
+}
+
+// operation is a group that only ANDs or only ORs its operands.
+type operation struct {
+	operands []string
+	or       bool
+	// operator is the edge operator as written; edges is set when the
+	// group has edges.
+	operator string
+	edges    bool
+}
+
+// flatten reads a group the way the evaluator folds it: the source of the
+// first edge, the targets of every edge, then the members no edge reached,
+// which are ANDed.
+func flatten(node *types.RuleNode) (operation, bool) {
+	members := reactflow.ConfigStrings(node.Configuration, "NodeIdList")
+	edges := reactflow.ConfigEdges(node.Configuration)
+	var op operation
+	seen := make(map[string]bool)
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			op.operands = append(op.operands, id)
+		}
+	}
+	if len(edges) > 0 {
+		op.edges = true
+		op.operator = edges[0].Operator
+		op.or = strings.EqualFold(op.operator, "or")
+		add(edges[0].SourceNode)
+		for _, edge := range edges {
+			if strings.EqualFold(edge.Operator, "or") != op.or {
+				return operation{}, false
+			}
+			add(edge.TargetNode)
+		}
+	}
+	covered := len(op.operands)
+	for _, member := range members {
+		add(member)
+	}
+	if len(op.operands) == 0 || (op.or && len(op.operands) > covered) {
+		return operation{}, false
+	}
+	return op, true
+}
+
+// apply rewrites the members and edges of node to the operation, chaining
+// the operands with the operator of its edges.
+func (op operation) apply(node *types.RuleNode) {
+	node.Configuration["NodeIdList"] = op.operands
+	if !op.edges {
+		return
+	}
+	var edges []map[string]string
+	for i := 1; i < len(op.operands); i++ {
+		edges = append(edges, map[string]string{
+			"SourceNode": op.operands[i-1],
+			"TargetNode": op.operands[i],
+			"Operator":   op.operator,
+		})
+	}
+	node.Configuration["edges"] = edges
+}
+
+// references counts where the nodes of a chain are used.
+type references struct {
+	// count is the number of nodes referring to a node as a member, block
+	// or edge end.
+	count map[string]int
+	// connected holds the nodes connections leave or enter.
+	connected map[string]bool
+	// gpt holds the blocks of GPT nodes.
+	gpt map[string]bool
+}
+
+func (s *simplifier) references() references {
+	refs := references{count: make(map[string]int), connected: make(map[string]bool), gpt: make(map[string]bool)}
+	for _, node := range s.chain.Metadata.Nodes {
+		if s.absorbed[node.Id] {
+			continue
+		}
+		for id := range operands(node) {
+			refs.count[id]++
+			if node.Type == "conditionalGPTBlock" {
+				refs.gpt[id] = true
+			}
+		}
+	}
+	for _, connection := range s.chain.Metadata.Connections {
+		refs.connected[connection.FromId] = true
+		refs.connected[connection.ToId] = true
+	}
+	return refs
+}
+
+// operands returns the IDs a node refers to in its configuration.
+func operands(node *types.RuleNode) map[string]bool {
+	ids := make(map[string]bool)
+	for _, id := range reactflow.ConfigStrings(node.Configuration, "NodeIdList") {
+		ids[id] = true
+	}
+	for _, edge := range reactflow.ConfigEdges(node.Configuration) {
+		ids[edge.SourceNode] = true
+		ids[edge.TargetNode] = true
+	}
+	return ids
+}
+
+// reachable returns the nodes evaluation may visit from the first node of
+// chain, following members, edges and connections.
+func reachable(chain types.RuleChain) map[string]bool {
+	nodes := make(map[string]*types.RuleNode)
+	for _, node := range chain.Metadata.Nodes {
+		if node != nil {
+			nodes[node.Id] = node
+		}
+	}
+	outgoing := make(map[string][]string)
+	for _, connection := range chain.Metadata.Connections {
+		outgoing[connection.FromId] = append(outgoing[connection.FromId], connection.ToId)
+	}
+	reached := make(map[string]bool)
+	index := chain.Metadata.FirstNodeIndex
+	if index < 0 || index >= len(chain.Metadata.Nodes) || chain.Metadata.Nodes[index] == nil {
+		return reached
+	}
+	queue := []string{chain.Metadata.Nodes[index].Id}
+	reached[queue[0]] = true
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		next := outgoing[id]
+		if node := nodes[id]; node != nil {
+			for operand := range operands(node) {
+				next = append(next, operand)
+			}
+		}
+		for _, to := range next {
+			if !reached[to] {
+				reached[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+	return reached
+}
+
+// structural are the configuration keys that do not decide what a leaf
+// checks: its negation, name and the editor copies of containers.
+var structural = map[string]bool{
+	"is_not":     true,
+	"name":       true,
+	"NodeIdList": true,
+	"edges":      true,
+	"blocks":     true,
+	"nodes":      true,
+}
+
+// keyer gives nodes that always evaluate alike the same key. Nodes it
+// cannot compare get none.
+type keyer struct {
+	nodes    map[string]*types.RuleNode
+	memo     map[string]string
+	visiting map[string]bool
+}
+
+func (k *keyer) key(id string) (string, bool) {
+	if key, ok := k.memo[id]; ok {
+		return key, key != ""
+	}
+	node := k.nodes[id]
+	if node == nil || k.visiting[id] {
+		return "", false
+	}
+	k.visiting[id] = true
+	key, ok := k.compute(node)
+	delete(k.visiting, id)
+	if !ok {
+		key = ""
+	}
+	k.memo[id] = key
+	return key, ok
+}
+
+func (k *keyer) compute(node *types.RuleNode) (string, bool) {
+	var shape interface{}
+	switch node.Type {
+	case "moment", "attribute", "function", "validateInfo":
+		fields := make(map[string]interface{})
+		for key, value := range node.Configuration {
+			if !structural[key] {
+				fields[key] = value
+			}
+		}
+		shape = fields
+	case "singleBlock":
+		// The evaluator folds the members listed first, in order, and the
+		// edges by position.
+		members := reactflow.ConfigStrings(node.Configuration, "NodeIdList")
+		edges := reactflow.ConfigEdges(node.Configuration)
+		ids := append([]string(nil), members...)
+		position := make(map[string]int)
+		for i, id := range ids {
+			if _, ok := position[id]; !ok {
+				position[id] = i
+			}
+		}
+		at := func(id string) int {
+			if i, ok := position[id]; ok {
+				return i
+			}
+			position[id] = len(ids)
+			ids = append(ids, id)
+			return position[id]
+		}
+		var links [][3]interface{}
+		for _, edge := range edges {
+			links = append(links, [3]interface{}{at(edge.SourceNode), at(edge.TargetNode), strings.EqualFold(edge.Operator, "or")})
+		}
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			key, ok := k.key(id)
+			if !ok {
+				return "", false
+			}
+			keys[i] = key
+		}
+		shape = map[string]interface{}{"members": len(members), "keys": keys, "edges": links}
+	case "conditionalBlock", "defaultBlock":
+		var keys []string
+		for _, id := range reactflow.ConfigStrings(node.Configuration, "NodeIdList") {
+			key, ok := k.key(id)
+			if !ok {
+				return "", false
+			}
+			keys = append(keys, key)
+		}
+		shape = keys
+	default:
+		return "", false
+	}
+	encoded, err := json.Marshal(struct {
+		Type  string      `json:"type"`
+		IsNot bool        `json:"is_not"`
+		Shape interface{} `json:"shape"`
+	}{node.Type, reactflow.ConfigBool(node.Configuration, "is_not"), shape})
+	if err != nil {
+		return "", false
+	}
+	return string(encoded), true
+}
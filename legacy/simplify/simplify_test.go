@@ -0,0 +1,250 @@
+package simplify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/evaluator"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// moment is a group member checking that moment momentID was detected.
+func moment(id, momentID string) string {
+	return fmt.Sprintf(`{"id":%q,"data":{"type":"moment","metadata":{"id":%q}}}`, id, momentID)
+}
+
+// group is a group_block block ANDing its members.
+func group(id string, members ...string) string {
+	return fmt.Sprintf(`{"id":%q,"data":{"type":"group_block","metadata":{"nodes":[%s]}}}`, id, strings.Join(members, ","))
+}
+
+// negated sets is_not on a block or member.
+func negated(element string) string {
+	return strings.Replace(element, `"data":{`, `"data":{"is_not":true,`, 1)
+}
+
+// routed converts a graph whose conditional node "start" holds blocks,
+// each leading to the response "answer" that answers "yes" when moment r
+// was detected and "default" otherwise.
+func routed(t *testing.T, blocks ...string) types.RuleChain {
+	t.Helper()
+	var edges []string
+	for _, block := range blocks {
+		var b struct{ ID string }
+		if err := json.Unmarshal([]byte(block), &b); err != nil {
+			t.Fatal(err)
+		}
+		edges = append(edges, fmt.Sprintf(`{"source":"start","sourceHandle":"%s_right","target":"answer"}`, b.ID))
+	}
+	raw := `{"id":"routed","nodes":[` +
+		`{"id":"start","type":"conditional-node","data":{"metadata":{"blocks":[` + strings.Join(blocks, ",") + `]}}},` +
+		`{"id":"answer","type":"response-node","data":{"metadata":{"blocks":[` + moment("yes", "r") + `,{"id":"default","data":{}}]}}}],` +
+		`"edges":[` + strings.Join(edges, ",") + `]}`
+	var graph reactFlowTypes.Graph
+	if err := json.Unmarshal([]byte(raw), &graph); err != nil {
+		t.Fatal(err)
+	}
+	chain, err := reactflow.ConvertFlowToRuleEngineDSL(graph, "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+// example returns the rule chain the draft of the example document
+// converts to.
+func example(t *testing.T) types.RuleChain {
+	t.Helper()
+	data, err := os.ReadFile("../../tests/example_small.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rule reactFlowTypes.QuestionRule
+	if err := json.Unmarshal(data, &rule); err != nil {
+		t.Fatal(err)
+	}
+	chain, err := reactflow.ConvertFlowToRuleEngineDSL(rule.DraftConfig[0], rule.TenantID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+func TestChain(t *testing.T) {
+	tests := []struct {
+		name      string
+		chain     types.RuleChain
+		wantKinds []string
+		wantNodes int
+	}{
+		{
+			name: "nothing to do",
+			chain: routed(t,
+				group("a", moment("x", "x"), moment("y", "y")),
+				group("b", moment("y2", "y"), moment("z", "z"))),
+			wantKinds: nil,
+			wantNodes: 9,
+		},
+		{
+			name:      "single member group",
+			chain:     routed(t, group("a", moment("x", "x"))),
+			wantKinds: []string{ChangeUnwrap},
+			wantNodes: 4,
+		},
+		{
+			name:      "double negation",
+			chain:     routed(t, negated(group("a", negated(moment("x", "x"))))),
+			wantKinds: []string{ChangeDoubleNegation},
+			wantNodes: 4,
+		},
+		{
+			name: "duplicate block",
+			chain: routed(t,
+				group("a", moment("x", "x"), moment("y", "y")),
+				group("b", moment("x2", "x"), moment("y2", "y"))),
+			wantKinds: []string{ChangeDuplicateBlock, ChangeDropped, ChangeDropped},
+			wantNodes: 6,
+		},
+		{
+			name:      "duplicate operand",
+			chain:     routed(t, group("a", moment("x", "x"), moment("y", "x"), moment("z", "z"))),
+			wantKinds: []string{ChangeDuplicateOperand, ChangeDropped},
+			wantNodes: 6,
+		},
+		{
+			name:      "example document",
+			chain:     example(t),
+			wantKinds: nil,
+			wantNodes: 7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := json.Marshal(tt.chain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			simplified, changes := Chain(tt.chain)
+			var kinds []string
+			for _, change := range changes {
+				kinds = append(kinds, change.Kind)
+			}
+			if !reflect.DeepEqual(kinds, tt.wantKinds) {
+				t.Errorf("changes %v, want kinds %v", changes, tt.wantKinds)
+			}
+			if len(simplified.Metadata.Nodes) != tt.wantNodes {
+				t.Errorf("%d nodes left, want %d", len(simplified.Metadata.Nodes), tt.wantNodes)
+			}
+			if after, _ := json.Marshal(tt.chain); string(after) != string(before) {
+				t.Error("Chain() modified its argument")
+			}
+			if err := Verify(tt.chain, simplified, Facts(tt.chain, 0), evaluator.Options{}); err != nil {
+				t.Errorf("Verify() = %v", err)
+			}
+			if _, again := Chain(simplified); len(again) != 0 {
+				t.Errorf("simplifying again changed %v", again)
+			}
+		})
+	}
+}
+
+// without returns a copy of chain without the rule node id, which chains
+// referring to it fail to evaluate.
+func without(chain types.RuleChain, id string) types.RuleChain {
+	var nodes []*types.RuleNode
+	for _, node := range chain.Metadata.Nodes {
+		if node.Id != id {
+			nodes = append(nodes, node)
+		}
+	}
+	chain.Metadata.Nodes = nodes
+	return chain
+}
+
+func TestVerifyFindsMismatches(t *testing.T) {
+	both := routed(t, group("a", moment("x", "x"), moment("y", "y")))
+	// Dropping an operand of an AND changes the answer.
+	one := routed(t, group("a", moment("x", "x")))
+	tests := []struct {
+		name                 string
+		original, simplified types.RuleChain
+		check                func(m *Mismatch) bool
+	}{
+		{
+			name:       "different answers",
+			original:   both,
+			simplified: one,
+			check: func(m *Mismatch) bool {
+				return m.OriginalErr == nil && m.SimplifiedErr == nil && !m.Original.Matched && m.Simplified.Matched
+			},
+		},
+		{
+			name:       "only the original fails",
+			original:   without(both, "y"),
+			simplified: one,
+			check:      func(m *Mismatch) bool { return m.OriginalErr != nil && m.SimplifiedErr == nil },
+		},
+		{
+			name:       "only the simplified chain fails",
+			original:   one,
+			simplified: without(one, "x"),
+			check:      func(m *Mismatch) bool { return m.OriginalErr == nil && m.SimplifiedErr != nil },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.original, tt.simplified, Facts(tt.original, 0), evaluator.Options{})
+			var mismatch *Mismatch
+			if !errors.As(err, &mismatch) {
+				t.Fatalf("Verify() = %v, want a *Mismatch", err)
+			}
+			if !tt.check(mismatch) {
+				t.Errorf("unexpected mismatch %v", mismatch)
+			}
+		})
+	}
+
+	// Facts both chains fail on are not a mismatch.
+	broken := without(both, "y")
+	if err := Verify(broken, broken, Facts(both, 0), evaluator.Options{}); err != nil {
+		t.Errorf("Verify() of chains failing alike = %v", err)
+	}
+}
+
+func TestFacts(t *testing.T) {
+	two := routed(t, group("a", moment("x", "x"), moment("y", "y")))
+	relative := routed(t, group("a", `{"id":"v","data":{"type":"validateInfo","metadata":{`+
+		`"relativeOperation":"before","relativeDays":1,"dataType":"date","validate":"entity","validateFields":{"entity":7}}}}`))
+	tests := []struct {
+		name  string
+		chain types.RuleChain
+		limit int
+		want  int
+	}{
+		{"two moments", two, 0, 8},
+		{"limited", two, 5, 5},
+		{"relative date", relative, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corpus := Facts(tt.chain, tt.limit)
+			if len(corpus) != tt.want {
+				t.Errorf("%d facts, want %d", len(corpus), tt.want)
+			}
+			for _, facts := range corpus {
+				facts.CallTime = time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+				if _, err := evaluator.Evaluate(tt.chain, facts, evaluator.Options{}); err != nil {
+					t.Errorf("Evaluate() = %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package simplify
+
+import (
+	"fmt"
+	"math/rand"
+
+	"bitbucket.org/convin/go_services/rule_engine/api/types"
+	"bitbucket.org/convin/go_services/rule_engine/internal/evaluator"
+	"bitbucket.org/convin/go_services/rule_engine/internal/reactflow"
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// DefaultFacts is the number of facts Facts returns when given no limit.
+const DefaultFacts = 4096
+
+// Mismatch is a call a simplified chain answers differently from the
+// original.
+type Mismatch struct {
+	// Index is the position of the facts in the corpus.
+	Index      int
+	Facts      evaluator.Facts
+	Original   *evaluator.Result
+	Simplified *evaluator.Result
+	// OriginalErr and SimplifiedErr are set when a chain cannot be
+	// evaluated against the facts.
+	OriginalErr   error
+	SimplifiedErr error
+}
+
+func (m *Mismatch) Error() string {
+	return fmt.Sprintf("facts %d: the original chain %s, the simplified one %s",
+		m.Index, outcome(m.Original, m.OriginalErr), outcome(m.Simplified, m.SimplifiedErr))
+}
+
+func (m *Mismatch) Unwrap() error {
+	if m.SimplifiedErr != nil {
+		return m.SimplifiedErr
+	}
+	return m.OriginalErr
+}
+
+func outcome(result *evaluator.Result, err error) string {
+	switch {
+	case err != nil:
+		return "fails: " + err.Error()
+	case result.Response != "":
+		return "answers " + result.Response
+	case result.Matched:
+		return "matches without a response"
+	}
+	return "does not match"
+}
+
+// Verify evaluates original and simplified against every facts of corpus
+// and returns a *Mismatch for the first call they answer differently,
+// including calls one chain fails on and the other does not. Facts both
+// chains fail on are skipped.
+func Verify(original, simplified types.RuleChain, corpus []evaluator.Facts, opts evaluator.Options) error {
+	for i, facts := range corpus {
+		want, wantErr := evaluator.Evaluate(original, facts, opts)
+		got, gotErr := evaluator.Evaluate(simplified, facts, opts)
+		switch {
+		case wantErr != nil && gotErr != nil:
+			continue
+		case wantErr != nil || gotErr != nil || got.Matched != want.Matched || got.Response != want.Response:
+			return &Mismatch{Index: i, Facts: facts, Original: want, Simplified: got, OriginalErr: wantErr, SimplifiedErr: gotErr}
+		}
+	}
+	return nil
+}
+
+// dimension is one thing a call may differ in, such as whether a moment
+// was detected. Choice 0 leaves the facts without it.
+type dimension struct {
+	choices int
+	set     func(facts *evaluator.Facts, choice int)
+}
+
+// Facts returns up to limit call facts exercising the leaves of chain:
+// every combination of the moments, functions and GPT verdicts it tests
+// and of the parameter responses and attribute and entity values it
+// compares against, or a fixed sample of them when there are more. Values
+// are taken from the static sides of comparisons; dates relative to the
+// call are only tried missing. The first facts hold nothing.
+func Facts(chain types.RuleChain, limit int) []evaluator.Facts {
+	if limit <= 0 {
+		limit = DefaultFacts
+	}
+	dimensions := collect(chain)
+
+	total := 1
+	for _, d := range dimensions {
+		total *= d.choices
+		if total > limit {
+			break
+		}
+	}
+	choices := make([]int, len(dimensions))
+	build := func() evaluator.Facts {
+		facts := evaluator.Facts{
+			Parameters:          make(map[int]int),
+			AttributeCategories: make(map[int]interface{}),
+			Entities:            make(map[int]interface{}),
+			Functions:           make(map[string]bool),
+			GPT:                 make(map[string]bool),
+		}
+		for i, d := range dimensions {
+			d.set(&facts, choices[i])
+		}
+		return facts
+	}
+
+	var corpus []evaluator.Facts
+	if total <= limit {
+		for n := 0; n < total; n++ {
+			rest := n
+			for i, d := range dimensions {
+				choices[i] = rest % d.choices
+				rest /= d.choices
+			}
+			corpus = append(corpus, build())
+		}
+		return corpus
+	}
+	corpus = append(corpus, build())
+	random := rand.New(rand.NewSource(1))
+	for len(corpus) < limit {
+		for i, d := range dimensions {
+			choices[i] = random.Intn(d.choices)
+		}
+		corpus = append(corpus, build())
+	}
+	return corpus
+}
+
+// collect finds the dimensions of the leaves of chain, in the order of its
+// nodes.
+func collect(chain types.RuleChain) []dimension {
+	var dimensions []dimension
+	seen := make(map[string]bool)
+	once := func(key string) bool {
+		if seen[key] {
+			return false
+		}
+		seen[key] = true
+		return true
+	}
+	var parameters []int
+	responses := make(map[int][]int)
+	var attributes, entities []int
+	attributeValues := make(map[int][]interface{})
+	entityValues := make(map[int][]interface{})
+
+	for _, node := range chain.Metadata.Nodes {
+		if node == nil {
+			continue
+		}
+		configuration := node.Configuration
+		switch node.Type {
+		case "moment":
+			id := reactflow.ConfigString(configuration, "id")
+			if once("moment " + id) {
+				dimensions = append(dimensions, dimension{2, func(facts *evaluator.Facts, choice int) {
+					if choice == 1 {
+						facts.Moments = append(facts.Moments, id)
+					}
+				}})
+			}
+		case "function":
+			name := reactflow.ConfigString(configuration, "function_name")
+			if once("function " + name) {
+				dimensions = append(dimensions, dimension{2, func(facts *evaluator.Facts, choice int) {
+					facts.Functions[name] = choice == 1
+				}})
+			}
+		case "conditionalGPTBlock":
+			id := node.Id
+			if once("gpt " + id) {
+				dimensions = append(dimensions, dimension{2, func(facts *evaluator.Facts, choice int) {
+					facts.GPT[id] = choice == 1
+				}})
+			}
+		case "attribute":
+			parameterID, _ := reactflow.ConfigInt(configuration, "parameter_id")
+			if once(fmt.Sprintf("parameter %d", parameterID)) {
+				parameters = append(parameters, parameterID)
+			}
+			for _, response := range reactflow.ConfigInts(configuration, "attribute") {
+				if once(fmt.Sprintf("parameter %d response %d", parameterID, response)) {
+					responses[parameterID] = append(responses[parameterID], response)
+				}
+			}
+		case "validateInfo":
+			var pairs [][2]reactflow.Operand
+			comparison, err := reactflow.CompileComparisonConfiguration(configuration)
+			switch {
+			case err != nil:
+				continue
+			case comparison != nil:
+				pairs = [][2]reactflow.Operand{{comparison.Left, comparison.Right}, {comparison.Right, comparison.Left}}
+			default:
+				// A date relative to the call has no static side to take
+				// values from.
+				metadata, err := reactFlowTypes.ConfigurationToMetadata(configuration)
+				if err != nil {
+					continue
+				}
+				reference, err := reactflow.RelativeDateReference(metadata)
+				if err != nil {
+					continue
+				}
+				pairs = [][2]reactflow.Operand{{reference, {}}}
+			}
+			for _, sides := range pairs {
+				reference, value := sides[0], sides[1]
+				var key int
+				var keys *[]int
+				var values map[int][]interface{}
+				switch reference.Kind {
+				case reactflow.OperandAttributeCategory:
+					key, keys, values = int(reference.AttributeCategoryKey), &attributes, attributeValues
+				case reactflow.OperandEntity:
+					key, keys, values = int(reference.Entity), &entities, entityValues
+				default:
+					continue
+				}
+				if once(fmt.Sprintf("%s %d", reference.Kind, key)) {
+					*keys = append(*keys, key)
+				}
+				if value.Kind != reactflow.OperandStatic {
+					continue
+				}
+				candidates := []interface{}{value.Value}
+				if list, ok := value.Value.([]interface{}); ok {
+					candidates = list
+				}
+				for _, candidate := range candidates {
+					if once(fmt.Sprintf("%s %d value %v", reference.Kind, key, candidate)) {
+						values[key] = append(values[key], candidate)
+					}
+				}
+			}
+		}
+	}
+
+	for _, parameterID := range parameters {
+		parameterID, options := parameterID, responses[parameterID]
+		dimensions = append(dimensions, dimension{1 + len(options), func(facts *evaluator.Facts, choice int) {
+			if choice > 0 {
+				facts.Parameters[parameterID] = options[choice-1]
+			}
+		}})
+	}
+	for _, key := range attributes {
+		key, options := key, attributeValues[key]
+		dimensions = append(dimensions, dimension{1 + len(options), func(facts *evaluator.Facts, choice int) {
+			if choice > 0 {
+				facts.AttributeCategories[key] = options[choice-1]
+			}
+		}})
+	}
+	for _, key := range entities {
+		key, options := key, entityValues[key]
+		dimensions = append(dimensions, dimension{1 + len(options), func(facts *evaluator.Facts, choice int) {
+			if choice > 0 {
+				facts.Entities[key] = options[choice-1]
+			}
+		}})
+	}
+	return dimensions
+}
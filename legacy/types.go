@@ -2,14 +2,16 @@ package types
 
 import (
 	"encoding/json"
+	"strings"
 
 	"bitbucket.org/convin/go_services/rule_engine/api/types"
 	"go.uber.org/zap"
 )
 
 type Position struct {
-	X float32 `json:"x,omitempty" dynamodbav:"x,omitempty"`
-	Y float32 `json:"y,omitempty" dynamodbav:"y,omitempty"`
+	X      float32 `json:"x,omitempty" dynamodbav:"x,omitempty"`
+	Y      float32 `json:"y,omitempty" dynamodbav:"y,omitempty"`
+	Extras Extras  `json:"-" dynamodbav:"-"`
 }
 
 type Data struct {
@@ -18,12 +20,15 @@ type Data struct {
 	Metadata Metadata `json:"metadata,omitempty" dynamodbav:"metadata,omitempty"`
 	Operator string   `json:"operator,omitempty" dynamodbav:"operator,omitempty"`
 	Selected int32    `json:"selected,omitempty" dynamodbav:"selected,omitempty"`
+	Extras   Extras   `json:"-" dynamodbav:"-"`
 }
 
 type ValidateFields struct {
+	AttributeCategory    int32  `json:"attributeCategory,omitempty" dynamodbav:"attributeCategory"`
 	AttributeCategoryKey int32  `json:"attributeCategoryKey,omitempty" dynamodbav:"attributeCategoryKey"`
 	Entity               int32  `json:"entity,omitempty" dynamodbav:"entity"`
 	DataType             string `json:"dataType,omitempty" dynamodbav:"dataType"`
+	Extras               Extras `json:"-" dynamodbav:"-"`
 }
 
 type Metadata struct {
@@ -56,6 +61,7 @@ type Metadata struct {
 	ValidateFields     ValidateFields `json:"validateFields,omitempty" dynamodbav:"validateFields"`
 	ValidateWith       string         `json:"validateWith,omitempty" dynamodbav:"validateWith"`
 	ValidateWithFields ValidateFields `json:"validateWithFields,omitempty" dynamodbav:"validateWithFields"`
+	Extras             Extras         `json:"-" dynamodbav:"-"`
 }
 
 type Node struct {
@@ -76,12 +82,14 @@ type Node struct {
 	IsNot            bool     `json:"is_not,omitempty" dynamodbav:"is_not,omitempty"`
 	TenantId         string   `json:"tenant_id,omitempty" dynamodbav:"tenant_id"`
 	Operator         string   `json:"operator,omitempty" dynamodbav:"operator"`
+	Extras           Extras   `json:"-" dynamodbav:"-"`
 }
 
 type BlockNode struct {
 	ID         string `json:"id,omitempty" dynamodbav:"id,omitempty"`
 	NodeData   Node   `json:"data,omitempty" dynamodbav:"data,omitempty"`
 	IsSelected bool   `json:"is_selected,omitempty" dynamodbav:"is_selected,omitempty"`
+	Extras     Extras `json:"-" dynamodbav:"-"`
 }
 
 type Edge struct {
@@ -93,12 +101,21 @@ type Edge struct {
 	Type         string                 `json:"type,omitempty" dynamodbav:"type,omitempty"`
 	Data         Data                   `json:"data,omitempty" dynamodbav:"data,omitempty"`
 	Style        map[string]interface{} `json:"style,omitempty" dynamodbav:"-"`
+	Extras       Extras                 `json:"-" dynamodbav:"-"`
+}
+
+// SourceBlock returns the ID of the block an edge leaves, named by its
+// source handle "<block>_right" or "<block>_left". Block IDs may contain
+// underscores themselves, so only the side suffix is cut off.
+func (e Edge) SourceBlock() string {
+	return strings.TrimSuffix(strings.TrimSuffix(e.SourceHandle, "_right"), "_left")
 }
 
 type Graph struct {
-	Nodes []Node      `json:"nodes,omitempty" dynamodbav:"nodes,omitempty"`
-	Edges []Edge      `json:"edges,omitempty" dynamodbav:"edges,omitempty"`
-	ID    interface{} `json:"id,omitempty" dynamodbav:"id,omitempty"`
+	Nodes  []Node      `json:"nodes,omitempty" dynamodbav:"nodes,omitempty"`
+	Edges  []Edge      `json:"edges,omitempty" dynamodbav:"edges,omitempty"`
+	ID     interface{} `json:"id,omitempty" dynamodbav:"id,omitempty"`
+	Extras Extras      `json:"-" dynamodbav:"-"`
 }
 
 func MetadataToGraph(metadata Metadata) Graph {
@@ -109,7 +126,7 @@ func MetadataToGraph(metadata Metadata) Graph {
 }
 
 func MetadataToConfiguration(metadata Metadata) types.Configuration {
-	jsonData, err := json.Marshal(metadata)
+	jsonData, err := json.Marshal(metadata.withoutExtras())
 	if err != nil {
 		zap.L().Error("Error marshaling JSON:", zap.Error(err))
 		return nil
@@ -123,6 +140,16 @@ func MetadataToConfiguration(metadata Metadata) types.Configuration {
 	return configuration
 }
 
+func ConfigurationToMetadata(configuration types.Configuration) (Metadata, error) {
+	var metadata Metadata
+	jsonData, err := json.Marshal(configuration)
+	if err != nil {
+		return metadata, err
+	}
+	err = json.Unmarshal(jsonData, &metadata)
+	return metadata, err
+}
+
 type PaginationPayload struct {
 	Filters          string `json:"filters"`
 	Offset           int    `json:"offset"`
@@ -0,0 +1,280 @@
+package yjs
+
+import (
+	"encoding/json"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Content refs, the low five bits of an item's info byte.
+const (
+	refGC      = 0
+	refDeleted = 1
+	refJSON    = 2
+	refBinary  = 3
+	refString  = 4
+	refEmbed   = 5
+	refFormat  = 6
+	refType    = 7
+	refAny     = 8
+	refDoc     = 9
+	refSkip    = 10
+)
+
+// TypeRef is the kind of a shared type.
+type TypeRef uint64
+
+const (
+	TypeArray       TypeRef = 0
+	TypeMap         TypeRef = 1
+	TypeText        TypeRef = 2
+	TypeXMLElement  TypeRef = 3
+	TypeXMLFragment TypeRef = 4
+	TypeXMLHook     TypeRef = 5
+	TypeXMLText     TypeRef = 6
+)
+
+// content is the payload of an item. Its length is counted in clock ticks:
+// one per value, or per UTF-16 code unit for strings.
+type content interface {
+	ref() byte
+	length() uint64
+	// values returns what the content contributes to a map or array.
+	values() []interface{}
+	// splice cuts the content at offset, keeping the left part and
+	// returning the right one.
+	splice(offset uint64) content
+	// write encodes the content, leaving out its first offset clock ticks.
+	write(e *encoder, offset uint64)
+}
+
+type contentDeleted struct{ n uint64 }
+
+func (c *contentDeleted) ref() byte             { return refDeleted }
+func (c *contentDeleted) length() uint64        { return c.n }
+func (c *contentDeleted) values() []interface{} { return nil }
+func (c *contentDeleted) splice(offset uint64) content {
+	right := &contentDeleted{n: c.n - offset}
+	c.n = offset
+	return right
+}
+
+// contentJSON is the legacy JSON content; contentAny replaced it. The
+// strings are kept as read so the content is written back unchanged.
+type contentJSON struct {
+	items []interface{}
+	raw   []string
+}
+
+func (c *contentJSON) ref() byte             { return refJSON }
+func (c *contentJSON) length() uint64        { return uint64(len(c.items)) }
+func (c *contentJSON) values() []interface{} { return c.items }
+func (c *contentJSON) splice(offset uint64) content {
+	right := &contentJSON{
+		items: append([]interface{}(nil), c.items[offset:]...),
+		raw:   append([]string(nil), c.raw[offset:]...),
+	}
+	c.items, c.raw = c.items[:offset], c.raw[:offset]
+	return right
+}
+
+// contentAny keeps the encoding of every value: lib0 writes object keys
+// in insertion order, which a Go map does not keep.
+type contentAny struct {
+	items []interface{}
+	raw   [][]byte
+}
+
+func (c *contentAny) ref() byte             { return refAny }
+func (c *contentAny) length() uint64        { return uint64(len(c.items)) }
+func (c *contentAny) values() []interface{} { return c.items }
+func (c *contentAny) splice(offset uint64) content {
+	right := &contentAny{
+		items: append([]interface{}(nil), c.items[offset:]...),
+		raw:   append([][]byte(nil), c.raw[offset:]...),
+	}
+	c.items, c.raw = c.items[:offset], c.raw[:offset]
+	return right
+}
+
+type contentBinary struct{ data []byte }
+
+func (c *contentBinary) ref() byte                    { return refBinary }
+func (c *contentBinary) length() uint64               { return 1 }
+func (c *contentBinary) values() []interface{}        { return []interface{}{c.data} }
+func (c *contentBinary) splice(offset uint64) content { panic("yjs: binary content cannot be split") }
+
+// contentString keeps UTF-16 code units, the unit Yjs counts clocks in.
+type contentString struct{ units []uint16 }
+
+func newContentString(s string) *contentString {
+	return &contentString{units: utf16.Encode([]rune(s))}
+}
+
+func (c *contentString) ref() byte      { return refString }
+func (c *contentString) length() uint64 { return uint64(len(c.units)) }
+func (c *contentString) String() string { return string(utf16.Decode(c.units)) }
+func (c *contentString) values() []interface{} {
+	values := make([]interface{}, 0, len(c.units))
+	for _, r := range utf16.Decode(c.units) {
+		values = append(values, string(r))
+	}
+	return values
+}
+
+// splice replaces a surrogate pair cut in half with U+FFFD, as Yjs does.
+func (c *contentString) splice(offset uint64) content {
+	right := &contentString{units: append([]uint16(nil), c.units[offset:]...)}
+	c.units = c.units[:offset]
+	if offset > 0 && utf16.IsSurrogate(rune(c.units[offset-1])) && len(right.units) > 0 {
+		c.units[offset-1] = 0xfffd
+		right.units[0] = 0xfffd
+	}
+	return right
+}
+
+type contentEmbed struct {
+	value interface{}
+	raw   string
+}
+
+func (c *contentEmbed) ref() byte                    { return refEmbed }
+func (c *contentEmbed) length() uint64               { return 1 }
+func (c *contentEmbed) values() []interface{}        { return []interface{}{c.value} }
+func (c *contentEmbed) splice(offset uint64) content { panic("yjs: embed content cannot be split") }
+
+// contentFormat marks formatting in a text and holds no value.
+type contentFormat struct {
+	key   string
+	value interface{}
+	raw   string
+}
+
+func (c *contentFormat) ref() byte                    { return refFormat }
+func (c *contentFormat) length() uint64               { return 1 }
+func (c *contentFormat) values() []interface{}        { return nil }
+func (c *contentFormat) splice(offset uint64) content { panic("yjs: format content cannot be split") }
+
+type contentType struct{ t *Type }
+
+func (c *contentType) ref() byte                    { return refType }
+func (c *contentType) length() uint64               { return 1 }
+func (c *contentType) values() []interface{}        { return []interface{}{c.t} }
+func (c *contentType) splice(offset uint64) content { panic("yjs: type content cannot be split") }
+
+// contentDoc is a subdocument; only its GUID and options are kept.
+type contentDoc struct {
+	guid string
+	opts []byte
+}
+
+func (c *contentDoc) ref() byte                    { return refDoc }
+func (c *contentDoc) length() uint64               { return 1 }
+func (c *contentDoc) values() []interface{}        { return []interface{}{c.guid} }
+func (c *contentDoc) splice(offset uint64) content { panic("yjs: doc content cannot be split") }
+
+// readContent reads the content of an item whose info byte is info.
+func readContent(d *decoder, doc *Doc, info byte) (content, error) {
+	switch info & 0x1f {
+	case refDeleted:
+		n, err := d.readVarUint()
+		return &contentDeleted{n: n}, err
+	case refJSON:
+		n, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		c := &contentJSON{}
+		for i := uint64(0); i < n; i++ {
+			s, err := d.readVarString()
+			if err != nil {
+				return nil, err
+			}
+			c.raw = append(c.raw, s)
+			if s == "undefined" {
+				c.items = append(c.items, undefinedValue{})
+				continue
+			}
+			var value interface{}
+			if err := json.Unmarshal([]byte(s), &value); err != nil {
+				return nil, fmt.Errorf("yjs: decoding JSON content: %w", err)
+			}
+			c.items = append(c.items, value)
+		}
+		return c, nil
+	case refBinary:
+		data, err := d.readVarBytes()
+		return &contentBinary{data: data}, err
+	case refString:
+		s, err := d.readVarString()
+		return newContentString(s), err
+	case refEmbed:
+		value, raw, err := readJSONString(d)
+		return &contentEmbed{value: value, raw: raw}, err
+	case refFormat:
+		key, err := d.readVarString()
+		if err != nil {
+			return nil, err
+		}
+		value, raw, err := readJSONString(d)
+		return &contentFormat{key: key, value: value, raw: raw}, err
+	case refType:
+		ref, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		t := newType(doc, TypeRef(ref))
+		switch t.kind {
+		case TypeXMLElement, TypeXMLHook:
+			if t.name, err = d.readVarString(); err != nil {
+				return nil, err
+			}
+		case TypeArray, TypeMap, TypeText, TypeXMLFragment, TypeXMLText:
+		default:
+			return nil, fmt.Errorf("yjs: unknown type ref %d", ref)
+		}
+		return &contentType{t: t}, nil
+	case refAny:
+		n, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(d.data)-d.pos) {
+			return nil, ErrUnexpectedEOF
+		}
+		c := &contentAny{items: make([]interface{}, 0, n), raw: make([][]byte, 0, n)}
+		for i := uint64(0); i < n; i++ {
+			start := d.pos
+			value, err := d.readAny()
+			if err != nil {
+				return nil, err
+			}
+			c.items = append(c.items, value)
+			c.raw = append(c.raw, append([]byte(nil), d.data[start:d.pos]...))
+		}
+		return c, nil
+	case refDoc:
+		guid, err := d.readVarString()
+		if err != nil {
+			return nil, err
+		}
+		start := d.pos
+		if _, err := d.readAny(); err != nil {
+			return nil, err
+		}
+		return &contentDoc{guid: guid, opts: append([]byte(nil), d.data[start:d.pos]...)}, nil
+	}
+	return nil, fmt.Errorf("yjs: unknown content ref %d", info&0x1f)
+}
+
+func readJSONString(d *decoder) (interface{}, string, error) {
+	s, err := d.readVarString()
+	if err != nil {
+		return nil, "", err
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(s), &value); err != nil {
+		return nil, "", fmt.Errorf("yjs: decoding JSON content: %w", err)
+	}
+	return value, s, nil
+}
@@ -0,0 +1,204 @@
+package yjs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrUnexpectedEOF is returned for a binary that ends in the middle of a
+// value.
+var ErrUnexpectedEOF = errors.New("yjs: unexpected end of data")
+
+// maxAnyDepth bounds the nesting of decoded values so a hostile payload
+// cannot exhaust the stack.
+const maxAnyDepth = 256
+
+// undefinedValue is JavaScript's undefined. Yjs keeps it apart from null;
+// it is dropped from maps and read as null in arrays.
+type undefinedValue struct{}
+
+// decoder reads the lib0 binary encoding used by Yjs and y-protocols.
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func newDecoder(data []byte) *decoder {
+	return &decoder{data: data}
+}
+
+func (d *decoder) hasContent() bool {
+	return d.pos < len(d.data)
+}
+
+func (d *decoder) readByte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, ErrUnexpectedEOF
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *decoder) readN(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, ErrUnexpectedEOF
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// readVarUint reads an unsigned LEB128 number. lib0 writes at most 53 bits,
+// the safe integer range of JavaScript.
+func (d *decoder) readVarUint() (uint64, error) {
+	var num uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := d.readByte()
+		if err != nil {
+			return 0, err
+		}
+		num |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return num, nil
+		}
+	}
+	return 0, fmt.Errorf("yjs: varuint overflows 64 bits")
+}
+
+// readVarInt reads lib0's signed varint: the first byte holds a
+// continuation bit, a sign bit and six value bits.
+func (d *decoder) readVarInt() (int64, error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	num := int64(b & 0x3f)
+	negative := b&0x40 != 0
+	if b&0x80 != 0 {
+		for shift := uint(6); ; shift += 7 {
+			if shift >= 63 {
+				return 0, fmt.Errorf("yjs: varint overflows 64 bits")
+			}
+			b, err = d.readByte()
+			if err != nil {
+				return 0, err
+			}
+			num |= int64(b&0x7f) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+	}
+	if negative {
+		num = -num
+	}
+	return num, nil
+}
+
+func (d *decoder) readVarBytes() ([]byte, error) {
+	n, err := d.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, ErrUnexpectedEOF
+	}
+	b, err := d.readN(int(n))
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), b...), nil
+}
+
+func (d *decoder) readVarString() (string, error) {
+	b, err := d.readVarBytes()
+	return string(b), err
+}
+
+// readAny reads a value written by lib0's writeAny.
+func (d *decoder) readAny() (interface{}, error) {
+	return d.readAnyDepth(0)
+}
+
+func (d *decoder) readAnyDepth(depth int) (interface{}, error) {
+	if depth > maxAnyDepth {
+		return nil, fmt.Errorf("yjs: value nested deeper than %d", maxAnyDepth)
+	}
+	typ, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case 127:
+		return undefinedValue{}, nil
+	case 126:
+		return nil, nil
+	case 125:
+		return d.readVarInt()
+	case 124:
+		b, err := d.readN(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 123:
+		b, err := d.readN(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case 122:
+		b, err := d.readN(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case 121:
+		return false, nil
+	case 120:
+		return true, nil
+	case 119:
+		return d.readVarString()
+	case 118:
+		n, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		object := make(map[string]interface{})
+		for i := uint64(0); i < n; i++ {
+			key, err := d.readVarString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.readAnyDepth(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			object[key] = value
+		}
+		return object, nil
+	case 117:
+		n, err := d.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(d.data)-d.pos) {
+			return nil, ErrUnexpectedEOF
+		}
+		array := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			value, err := d.readAnyDepth(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		return array, nil
+	case 116:
+		return d.readVarBytes()
+	}
+	return nil, fmt.Errorf("yjs: unknown value type %d", typ)
+}
@@ -0,0 +1,656 @@
+// Package yjs reads the state of a Yjs document from its binary updates so
+// Go services can work on what the live editor holds.
+//
+// It implements the v1 update format written by Y.encodeStateAsUpdate and
+// y-websocket, and integrates concurrent updates with the same conflict
+// resolution as Yjs, so every replica that applied the same updates reads
+// the same values. Snapshots stored as a full state update load the same
+// way, and the document encodes its state back as an update, so it can
+// serve the sync protocol to clients. Maps, arrays and text are readable,
+// and maps take plain values written on this replica; XML types are
+// decoded but only exposed through their children.
+package yjs
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ID identifies the first clock tick of an item: the client that created
+// it and the client's logical clock.
+type ID struct {
+	Client uint64
+	Clock  uint64
+}
+
+// item is a run of content inserted by one client, or a garbage-collected
+// range (gc) whose content is gone.
+type item struct {
+	id          ID
+	n           uint64
+	origin      *ID
+	rightOrigin *ID
+
+	// Parent as read from the update, resolved on integration.
+	parentName string
+	parentID   *ID
+	hasParent  bool
+
+	parent    *Type
+	parentSub string
+	hasSub    bool
+	content   content
+	left      *item
+	right     *item
+	deleted   bool
+	gc        bool
+	// skip is a gap in an update: the sender did not include the range.
+	skip bool
+}
+
+func (it *item) lastID() ID {
+	return ID{Client: it.id.Client, Clock: it.id.Clock + it.n - 1}
+}
+
+func (it *item) delete() {
+	if it.deleted {
+		return
+	}
+	it.deleted = true
+	if c, ok := it.content.(*contentType); ok {
+		for child := c.t.start; child != nil; child = child.right {
+			child.delete()
+		}
+		for _, child := range c.t.entries {
+			child.delete()
+		}
+	}
+}
+
+// Type is a shared type: a root type registered by name, or one nested
+// as the content of an item.
+type Type struct {
+	doc     *Doc
+	kind    TypeRef
+	name    string
+	item    *item
+	start   *item
+	entries map[string]*item
+	keys    []string
+}
+
+func newType(doc *Doc, kind TypeRef) *Type {
+	return &Type{doc: doc, kind: kind, entries: make(map[string]*item)}
+}
+
+// Kind returns the kind of the type. Root types report the kind they were
+// first read as.
+func (t *Type) Kind() TypeRef {
+	return t.kind
+}
+
+// Doc is the state of a Yjs document. It is not safe for concurrent use.
+type Doc struct {
+	// ClientID identifies the changes made on this replica, like
+	// Y.Doc.clientID.
+	ClientID uint64
+
+	clients map[uint64][]*item
+	share   map[string]*Type
+	// pending holds structs whose dependencies have not arrived yet, per
+	// client in clock order, and pendingDeletes the deletions of ranges
+	// not integrated yet.
+	pending        map[uint64][]*item
+	pendingDeletes []deleteRange
+}
+
+type deleteRange struct {
+	client uint64
+	clock  uint64
+	n      uint64
+}
+
+// NewDoc returns an empty document with a random 32-bit client ID, as Yjs
+// assigns them.
+func NewDoc() *Doc {
+	var b [4]byte
+	rand.Read(b[:])
+	return &Doc{
+		ClientID: uint64(binary.BigEndian.Uint32(b[:])),
+		clients:  make(map[uint64][]*item),
+		share:    make(map[string]*Type),
+		pending:  make(map[uint64][]*item),
+	}
+}
+
+// root returns the root type name, creating it on first use.
+func (d *Doc) root(name string, kind TypeRef) *Type {
+	t, ok := d.share[name]
+	if !ok {
+		t = newType(d, kind)
+		t.name = name
+		d.share[name] = t
+	}
+	return t
+}
+
+// StateVector returns the next expected clock of every client.
+func (d *Doc) StateVector() map[uint64]uint64 {
+	sv := make(map[uint64]uint64, len(d.clients))
+	for client := range d.clients {
+		sv[client] = d.state(client)
+	}
+	return sv
+}
+
+// Pending reports whether some applied structs or deletions are waiting
+// for updates that have not been applied yet.
+func (d *Doc) Pending() bool {
+	for _, items := range d.pending {
+		if len(items) > 0 {
+			return true
+		}
+	}
+	return len(d.pendingDeletes) > 0
+}
+
+func (d *Doc) state(client uint64) uint64 {
+	items := d.clients[client]
+	if len(items) == 0 {
+		return 0
+	}
+	last := items[len(items)-1]
+	return last.id.Clock + last.n
+}
+
+// find returns the index of the struct containing id.
+func (d *Doc) find(id ID) (int, bool) {
+	items := d.clients[id.Client]
+	i := sort.Search(len(items), func(i int) bool {
+		return items[i].id.Clock+items[i].n > id.Clock
+	})
+	if i == len(items) || items[i].id.Clock > id.Clock {
+		return 0, false
+	}
+	return i, true
+}
+
+func (d *Doc) getItem(id ID) *item {
+	i, ok := d.find(id)
+	if !ok {
+		return nil
+	}
+	return d.clients[id.Client][i]
+}
+
+// cleanStart returns the item starting at id, splitting the item
+// containing it if needed.
+func (d *Doc) cleanStart(id ID) *item {
+	i, ok := d.find(id)
+	if !ok {
+		return nil
+	}
+	it := d.clients[id.Client][i]
+	if it.id.Clock < id.Clock && !it.gc {
+		return d.split(i, it, id.Clock-it.id.Clock)
+	}
+	return it
+}
+
+// cleanEnd returns the item ending at id, splitting the item containing
+// it if needed.
+func (d *Doc) cleanEnd(id ID) *item {
+	i, ok := d.find(id)
+	if !ok {
+		return nil
+	}
+	it := d.clients[id.Client][i]
+	if id.Clock != it.lastID().Clock && !it.gc {
+		d.split(i, it, id.Clock-it.id.Clock+1)
+	}
+	return it
+}
+
+// split cuts the item at index i of its client after offset clock ticks
+// and returns the new right part.
+func (d *Doc) split(i int, it *item, offset uint64) *item {
+	right := &item{
+		id:          ID{Client: it.id.Client, Clock: it.id.Clock + offset},
+		n:           it.n - offset,
+		origin:      &ID{Client: it.id.Client, Clock: it.id.Clock + offset - 1},
+		rightOrigin: it.rightOrigin,
+		parent:      it.parent,
+		parentSub:   it.parentSub,
+		hasSub:      it.hasSub,
+		content:     it.content.splice(offset),
+		deleted:     it.deleted,
+		left:        it,
+		right:       it.right,
+	}
+	it.n = offset
+	if right.right != nil {
+		right.right.left = right
+	}
+	it.right = right
+	if right.hasSub && right.right == nil {
+		right.parent.entries[right.parentSub] = right
+	}
+	items := d.clients[it.id.Client]
+	items = append(items, nil)
+	copy(items[i+2:], items[i+1:])
+	items[i+1] = right
+	d.clients[it.id.Client] = items
+	return right
+}
+
+// ApplyUpdate applies a v1 update. Structs that depend on updates not
+// applied yet are kept and integrated once those arrive.
+func (d *Doc) ApplyUpdate(update []byte) error {
+	dec := newDecoder(update)
+	structs, err := d.readStructs(dec)
+	if err != nil {
+		return err
+	}
+	deletes, err := readDeleteSet(dec)
+	if err != nil {
+		return err
+	}
+	for client, items := range structs {
+		d.pending[client] = mergePending(d.pending[client], items)
+	}
+	d.integratePending()
+	d.pendingDeletes = d.applyDeletes(append(d.pendingDeletes, deletes...))
+	return nil
+}
+
+// mergePending merges two clock-ordered lists of structs of one client.
+func mergePending(a, b []*item) []*item {
+	merged := append(append([]*item(nil), a...), b...)
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].id.Clock < merged[j].id.Clock })
+	return merged
+}
+
+func (d *Doc) readStructs(dec *decoder) (map[uint64][]*item, error) {
+	structs := make(map[uint64][]*item)
+	clients, err := dec.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	for c := uint64(0); c < clients; c++ {
+		count, err := dec.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		client, err := dec.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		clock, err := dec.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		if count > uint64(len(dec.data)) {
+			return nil, ErrUnexpectedEOF
+		}
+		for i := uint64(0); i < count; i++ {
+			it, err := d.readStruct(dec, ID{Client: client, Clock: clock})
+			if err != nil {
+				return nil, fmt.Errorf("reading struct %d:%d: %w", client, clock, err)
+			}
+			if it.n > math.MaxUint64-clock {
+				return nil, fmt.Errorf("yjs: struct %d:%d overflows the clock", client, clock)
+			}
+			clock += it.n
+			if !it.skip {
+				structs[client] = append(structs[client], it)
+			}
+		}
+	}
+	return structs, nil
+}
+
+func (d *Doc) readStruct(dec *decoder, id ID) (*item, error) {
+	info, err := dec.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch info & 0x1f {
+	case refGC:
+		n, err := dec.readVarUint()
+		return &item{id: id, n: n, gc: true, deleted: true}, err
+	case refSkip:
+		n, err := dec.readVarUint()
+		return &item{id: id, n: n, skip: true}, err
+	}
+	it := &item{id: id}
+	if info&0x80 != 0 {
+		if it.origin, err = readID(dec); err != nil {
+			return nil, err
+		}
+	}
+	if info&0x40 != 0 {
+		if it.rightOrigin, err = readID(dec); err != nil {
+			return nil, err
+		}
+	}
+	// Without origins the parent is written out; otherwise it is copied
+	// from the origin on integration.
+	if info&0xc0 == 0 {
+		isRoot, err := dec.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		it.hasParent = true
+		if isRoot == 1 {
+			if it.parentName, err = dec.readVarString(); err != nil {
+				return nil, err
+			}
+		} else if it.parentID, err = readID(dec); err != nil {
+			return nil, err
+		}
+		if info&0x20 != 0 {
+			if it.parentSub, err = dec.readVarString(); err != nil {
+				return nil, err
+			}
+			it.hasSub = true
+		}
+	}
+	if it.content, err = readContent(dec, d, info); err != nil {
+		return nil, err
+	}
+	it.n = it.content.length()
+	if it.n == 0 {
+		return nil, fmt.Errorf("yjs: empty content")
+	}
+	return it, nil
+}
+
+func readID(dec *decoder) (*ID, error) {
+	client, err := dec.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	clock, err := dec.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	return &ID{Client: client, Clock: clock}, nil
+}
+
+func readDeleteSet(dec *decoder) ([]deleteRange, error) {
+	var ranges []deleteRange
+	if !dec.hasContent() {
+		return nil, nil
+	}
+	clients, err := dec.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	for c := uint64(0); c < clients; c++ {
+		client, err := dec.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		count, err := dec.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		if count > uint64(len(dec.data)) {
+			return nil, ErrUnexpectedEOF
+		}
+		for i := uint64(0); i < count; i++ {
+			clock, err := dec.readVarUint()
+			if err != nil {
+				return nil, err
+			}
+			n, err := dec.readVarUint()
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, deleteRange{client: client, clock: clock, n: n})
+		}
+	}
+	return ranges, nil
+}
+
+// integratePending integrates pending structs until none of the remaining
+// ones has its dependencies available.
+func (d *Doc) integratePending() {
+	for progress := true; progress; {
+		progress = false
+		clients := make([]uint64, 0, len(d.pending))
+		for client := range d.pending {
+			clients = append(clients, client)
+		}
+		sort.Slice(clients, func(i, j int) bool { return clients[i] < clients[j] })
+		for _, client := range clients {
+			queue := d.pending[client]
+			for len(queue) > 0 {
+				it := queue[0]
+				state := d.state(client)
+				if it.id.Clock > state {
+					break
+				}
+				if it.id.Clock+it.n <= state {
+					// Already integrated from another update.
+					queue = queue[1:]
+					progress = true
+					continue
+				}
+				if d.missing(it) {
+					break
+				}
+				d.integrate(it, state-it.id.Clock)
+				queue = queue[1:]
+				progress = true
+			}
+			if len(queue) == 0 {
+				delete(d.pending, client)
+			} else {
+				d.pending[client] = queue
+			}
+		}
+	}
+}
+
+// missing reports whether a struct refers to clock ticks of other clients
+// that have not been integrated yet.
+func (d *Doc) missing(it *item) bool {
+	if it.gc {
+		return false
+	}
+	for _, ref := range []*ID{it.origin, it.rightOrigin, it.parentID} {
+		if ref != nil && ref.Client != it.id.Client && ref.Clock >= d.state(ref.Client) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Doc) integrate(it *item, offset uint64) {
+	if it.gc {
+		// A GC range has no content; like GC.integrate in Yjs, only the
+		// part beyond the known state is kept.
+		it.id.Clock += offset
+		it.n -= offset
+		d.clients[it.id.Client] = append(d.clients[it.id.Client], it)
+		return
+	}
+	if offset > 0 {
+		it.id.Clock += offset
+		it.origin = &ID{Client: it.id.Client, Clock: it.id.Clock - 1}
+		it.content = it.content.splice(offset)
+		it.n -= offset
+	}
+
+	var left, right *item
+	if it.origin != nil {
+		left = d.cleanEnd(*it.origin)
+	}
+	if it.rightOrigin != nil {
+		right = d.cleanStart(*it.rightOrigin)
+	}
+	switch {
+	case (left != nil && left.gc) || (right != nil && right.gc):
+		it.parent = nil
+	case !it.hasParent:
+		if left != nil {
+			it.parent, it.parentSub, it.hasSub = left.parent, left.parentSub, left.hasSub
+		}
+		if right != nil {
+			it.parent, it.parentSub, it.hasSub = right.parent, right.parentSub, right.hasSub
+		}
+	case it.parentID != nil:
+		if parentItem := d.getItem(*it.parentID); parentItem != nil {
+			if c, ok := parentItem.content.(*contentType); ok && !parentItem.gc {
+				it.parent = c.t
+			}
+		}
+	default:
+		it.parent = d.root(it.parentName, TypeMap)
+	}
+	if it.parent == nil {
+		// The parent was garbage collected, so is this struct.
+		d.clients[it.id.Client] = append(d.clients[it.id.Client], &item{id: it.id, n: it.n, gc: true, deleted: true})
+		return
+	}
+	it.left, it.right = left, right
+	d.place(it)
+	d.clients[it.id.Client] = append(d.clients[it.id.Client], it)
+
+	if _, ok := it.content.(*contentDeleted); ok {
+		it.deleted = true
+	}
+	if c, ok := it.content.(*contentType); ok {
+		c.t.item = it
+	}
+	if (it.parent.item != nil && it.parent.item.deleted) || (it.hasSub && it.right != nil) {
+		it.delete()
+	}
+}
+
+// place links it into its parent following the YATA rules: concurrent
+// inserts at the same position are ordered by client ID, the lower first.
+func (d *Doc) place(it *item) {
+	parent := it.parent
+	left, right := it.left, it.right
+	if (left == nil && (right == nil || right.left != nil)) || (left != nil && left.right != right) {
+		var o *item
+		switch {
+		case left != nil:
+			o = left.right
+		case it.hasSub:
+			o = parent.entries[it.parentSub]
+			for o != nil && o.left != nil {
+				o = o.left
+			}
+		default:
+			o = parent.start
+		}
+		conflicting := make(map[*item]bool)
+		beforeOrigin := make(map[*item]bool)
+		for o != nil && o != right {
+			beforeOrigin[o] = true
+			conflicting[o] = true
+			if sameID(it.origin, o.origin) {
+				if o.id.Client < it.id.Client {
+					left = o
+					conflicting = make(map[*item]bool)
+				} else if sameID(it.rightOrigin, o.rightOrigin) {
+					break
+				}
+			} else if originItem := d.itemAt(o.origin); originItem != nil && beforeOrigin[originItem] {
+				if !conflicting[originItem] {
+					left = o
+					conflicting = make(map[*item]bool)
+				}
+			} else {
+				break
+			}
+			o = o.right
+		}
+		it.left = left
+	}
+
+	if it.left != nil {
+		it.right = it.left.right
+		it.left.right = it
+	} else {
+		var r *item
+		if it.hasSub {
+			r = parent.entries[it.parentSub]
+			for r != nil && r.left != nil {
+				r = r.left
+			}
+		} else {
+			r = parent.start
+			parent.start = it
+		}
+		it.right = r
+	}
+	if it.right != nil {
+		it.right.left = it
+	} else if it.hasSub {
+		if _, ok := parent.entries[it.parentSub]; !ok {
+			parent.keys = append(parent.keys, it.parentSub)
+		}
+		parent.entries[it.parentSub] = it
+		if it.left != nil {
+			it.left.delete()
+		}
+	}
+}
+
+func (d *Doc) itemAt(id *ID) *item {
+	if id == nil {
+		return nil
+	}
+	return d.getItem(*id)
+}
+
+func sameID(a, b *ID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// applyDeletes deletes the integrated part of every range and returns the
+// parts beyond the current state.
+func (d *Doc) applyDeletes(ranges []deleteRange) []deleteRange {
+	var unapplied []deleteRange
+	for _, r := range ranges {
+		state := d.state(r.client)
+		end := r.clock + r.n
+		if end > state {
+			start := r.clock
+			if start < state {
+				start = state
+			}
+			unapplied = append(unapplied, deleteRange{client: r.client, clock: start, n: end - start})
+			end = state
+		}
+		if r.clock >= end {
+			continue
+		}
+		it := d.cleanStart(ID{Client: r.client, Clock: r.clock})
+		for it != nil && it.id.Clock < end {
+			i, _ := d.find(it.id)
+			if it.id.Clock+it.n > end && !it.gc {
+				d.split(i, it, end-it.id.Clock)
+			}
+			if !it.gc {
+				it.delete()
+			}
+			next := d.clients[r.client]
+			if i+1 >= len(next) {
+				break
+			}
+			it = next[i+1]
+		}
+	}
+	return unapplied
+}
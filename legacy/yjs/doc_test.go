@@ -0,0 +1,182 @@
+package yjs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Updates written by Yjs 13 for a fresh document with clientID 1. The bytes
+// follow the v1 update format: structs per client, then the delete set.
+var interopVectors = []struct {
+	name   string
+	update string
+	check  func(t *testing.T, d *Doc)
+}{
+	{
+		// ydoc.getMap("m").set("k", "v")
+		name:   "map set",
+		update: "010101002801016d016b01770176" + "00",
+		check: func(t *testing.T, d *Doc) {
+			if value, ok := d.Map("m").Get("k"); !ok || value != "v" {
+				t.Errorf(`m.k = %v, %v; want "v"`, value, ok)
+			}
+		},
+	},
+	{
+		// ydoc.getText("t").insert(0, "abc")
+		name:   "text insert",
+		update: "01010100040101740361626300",
+		check: func(t *testing.T, d *Doc) {
+			if got := d.Text("t").String(); got != "abc" {
+				t.Errorf("t = %q, want %q", got, "abc")
+			}
+		},
+	},
+	{
+		// ydoc.getArray("a").insert(0, [1, true, null])
+		name:   "array insert",
+		update: "0101010008010161037d01787e" + "00",
+		check: func(t *testing.T, d *Doc) {
+			values := d.Array("a").ToJSON()
+			if len(values) != 3 || values[1] != true || values[2] != nil {
+				t.Errorf("a = %v, want [1 true <nil>]", values)
+			}
+		},
+	},
+	{
+		// ydoc.getText("t").insert(0, "abc"); ydoc.getText("t").delete(1, 1)
+		name:   "text delete",
+		update: "010101000401017403616263" + "0101010101",
+		check: func(t *testing.T, d *Doc) {
+			if got := d.Text("t").String(); got != "ac" {
+				t.Errorf("t = %q, want %q", got, "ac")
+			}
+		},
+	},
+}
+
+func TestInteropVectors(t *testing.T) {
+	for _, tt := range interopVectors {
+		t.Run(tt.name, func(t *testing.T) {
+			update, err := hex.DecodeString(tt.update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := NewDoc()
+			if err := d.ApplyUpdate(update); err != nil {
+				t.Fatalf("ApplyUpdate: %v", err)
+			}
+			tt.check(t, d)
+			if d.Pending() {
+				t.Error("update left pending structs")
+			}
+
+			// The state written back must load into an equal replica.
+			replica := NewDoc()
+			if err := replica.ApplyUpdate(d.EncodeStateAsUpdate(nil)); err != nil {
+				t.Fatalf("applying the encoded state: %v", err)
+			}
+			tt.check(t, replica)
+		})
+	}
+}
+
+func TestMapSetEncodesLikeYjs(t *testing.T) {
+	d := NewDoc()
+	d.ClientID = 1
+	update, err := d.Map("m").Set("k", "v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := interopVectors[0].update; hex.EncodeToString(update) != want {
+		t.Errorf("Set wrote %x, want %s", update, want)
+	}
+}
+
+func TestApplyUpdateMalformed(t *testing.T) {
+	valid, _ := hex.DecodeString(interopVectors[0].update)
+	moreClients := append([]byte(nil), valid...)
+	moreClients[0] = 2
+
+	tests := []struct {
+		name   string
+		update []byte
+	}{
+		{"empty", nil},
+		{"truncated", valid[:len(valid)-3]},
+		{"client count too high", moreClients},
+		{"gc overlapping the state", []byte{1, 2, 1, 0, 0, 3, 0, 2, 0}},
+		{"huge struct count", []byte{1, 0xff, 0xff, 0xff, 0x0f, 1, 0}},
+		{"unknown content", []byte{1, 1, 1, 0, 0x1e, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDoc()
+			if err := d.ApplyUpdate(valid); err != nil {
+				t.Fatal(err)
+			}
+			// Malformed input may be rejected or partly applied, but must
+			// not panic and must leave a document that still encodes.
+			_ = d.ApplyUpdate(tt.update)
+			_ = d.EncodeStateAsUpdate(nil)
+		})
+	}
+}
+
+func TestStateVectorRoundTrip(t *testing.T) {
+	sv := map[uint64]uint64{1: 3, 2: 0, 1 << 40: 7}
+	e := &encoder{}
+	writeStateVector(e, sv)
+	decoded, err := DecodeStateVector(e.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(sv) {
+		t.Fatalf("decoded %v, want %v", decoded, sv)
+	}
+	for client, clock := range sv {
+		if decoded[client] != clock {
+			t.Errorf("client %d: clock %d, want %d", client, decoded[client], clock)
+		}
+	}
+}
+
+func FuzzApplyUpdate(f *testing.F) {
+	for _, tt := range interopVectors {
+		update, _ := hex.DecodeString(tt.update)
+		f.Add(update)
+	}
+	f.Add([]byte{1, 2, 1, 0, 0, 3, 0, 2, 0})
+	f.Fuzz(func(t *testing.T, update []byte) {
+		d := NewDoc()
+		if err := d.ApplyUpdate(update); err != nil {
+			return
+		}
+		state := d.EncodeStateAsUpdate(nil)
+		replica := NewDoc()
+		if err := replica.ApplyUpdate(state); err != nil {
+			t.Fatalf("the encoded state of an applied update does not apply: %v", err)
+		}
+		if again := replica.EncodeStateAsUpdate(nil); !bytes.Equal(again, state) {
+			t.Fatalf("state changed on a round trip:\n%x\n%x", state, again)
+		}
+	})
+}
+
+func FuzzDecodeStateVector(f *testing.F) {
+	f.Add([]byte{0})
+	f.Add([]byte{1, 1, 3})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sv, err := DecodeStateVector(data)
+		if err != nil {
+			return
+		}
+		e := &encoder{}
+		writeStateVector(e, sv)
+		again, err := DecodeStateVector(e.bytes())
+		if err != nil || len(again) != len(sv) {
+			t.Fatalf("re-encoded state vector %v decodes to %v, %v", sv, again, err)
+		}
+	})
+}
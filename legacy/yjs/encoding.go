@@ -0,0 +1,353 @@
+package yjs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"unicode/utf16"
+)
+
+// encoder writes the lib0 binary encoding read by decoder.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) bytes() []byte {
+	return e.buf
+}
+
+func (e *encoder) writeByte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *encoder) writeVarUint(num uint64) {
+	for num >= 0x80 {
+		e.buf = append(e.buf, byte(num)|0x80)
+		num >>= 7
+	}
+	e.buf = append(e.buf, byte(num))
+}
+
+func (e *encoder) writeVarBytes(b []byte) {
+	e.writeVarUint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) writeVarString(s string) {
+	e.writeVarUint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// writeVarInt writes lib0's signed varint, the inverse of readVarInt.
+func (e *encoder) writeVarInt(num int64) {
+	var first byte
+	if num < 0 {
+		first = 0x40
+		num = -num
+	}
+	first |= byte(num & 0x3f)
+	num >>= 6
+	if num > 0 {
+		first |= 0x80
+	}
+	e.buf = append(e.buf, first)
+	for num > 0 {
+		b := byte(num & 0x7f)
+		num >>= 7
+		if num > 0 {
+			b |= 0x80
+		}
+		e.buf = append(e.buf, b)
+	}
+}
+
+// writeAny writes a value decoded from JSON the way lib0's writeAny writes
+// the same JavaScript value. Object keys are sorted.
+func (e *encoder) writeAny(value interface{}, depth int) error {
+	if depth > maxAnyDepth {
+		return fmt.Errorf("yjs: value nested deeper than %d", maxAnyDepth)
+	}
+	switch v := value.(type) {
+	case nil:
+		e.writeByte(126)
+	case undefinedValue:
+		e.writeByte(127)
+	case bool:
+		if v {
+			e.writeByte(120)
+		} else {
+			e.writeByte(121)
+		}
+	case string:
+		e.writeByte(119)
+		e.writeVarString(v)
+	case float64:
+		e.writeNumber(v)
+	case int64:
+		e.writeNumber(float64(v))
+	case []byte:
+		e.writeByte(116)
+		e.writeVarBytes(v)
+	case []interface{}:
+		e.writeByte(117)
+		e.writeVarUint(uint64(len(v)))
+		for _, child := range v {
+			if err := e.writeAny(child, depth+1); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		e.writeByte(118)
+		e.writeVarUint(uint64(len(keys)))
+		for _, key := range keys {
+			e.writeVarString(key)
+			if err := e.writeAny(v[key], depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("yjs: cannot encode %T", value)
+	}
+	return nil
+}
+
+// writeNumber picks the smallest encoding lib0 would: a varint for
+// integers within 31 bits, then a float32 if it is exact.
+func (e *encoder) writeNumber(f float64) {
+	switch {
+	case f == math.Trunc(f) && math.Abs(f) <= math.MaxInt32:
+		e.writeByte(125)
+		e.writeVarInt(int64(f))
+	case float64(float32(f)) == f:
+		e.writeByte(124)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(float32(f)))
+	default:
+		e.writeByte(123)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(f))
+	}
+}
+
+func (e *encoder) writeID(id ID) {
+	e.writeVarUint(id.Client)
+	e.writeVarUint(id.Clock)
+}
+
+func (c *contentDeleted) write(e *encoder, offset uint64) {
+	e.writeVarUint(c.n - offset)
+}
+
+func (c *contentJSON) write(e *encoder, offset uint64) {
+	e.writeVarUint(uint64(len(c.raw)) - offset)
+	for _, s := range c.raw[offset:] {
+		e.writeVarString(s)
+	}
+}
+
+func (c *contentAny) write(e *encoder, offset uint64) {
+	e.writeVarUint(uint64(len(c.raw)) - offset)
+	for _, raw := range c.raw[offset:] {
+		e.buf = append(e.buf, raw...)
+	}
+}
+
+func (c *contentBinary) write(e *encoder, offset uint64) {
+	e.writeVarBytes(c.data)
+}
+
+func (c *contentString) write(e *encoder, offset uint64) {
+	e.writeVarString(string(utf16.Decode(c.units[offset:])))
+}
+
+func (c *contentEmbed) write(e *encoder, offset uint64) {
+	e.writeVarString(c.raw)
+}
+
+func (c *contentFormat) write(e *encoder, offset uint64) {
+	e.writeVarString(c.key)
+	e.writeVarString(c.raw)
+}
+
+func (c *contentType) write(e *encoder, offset uint64) {
+	e.writeVarUint(uint64(c.t.kind))
+	if c.t.kind == TypeXMLElement || c.t.kind == TypeXMLHook {
+		e.writeVarString(c.t.name)
+	}
+}
+
+func (c *contentDoc) write(e *encoder, offset uint64) {
+	e.writeVarString(c.guid)
+	e.buf = append(e.buf, c.opts...)
+}
+
+// write encodes the struct without its first offset clock ticks, the way
+// Item.write and GC.write do in Yjs.
+func (it *item) write(e *encoder, offset uint64) {
+	if it.gc {
+		e.writeByte(refGC)
+		e.writeVarUint(it.n - offset)
+		return
+	}
+	origin := it.origin
+	if offset > 0 {
+		origin = &ID{Client: it.id.Client, Clock: it.id.Clock + offset - 1}
+	}
+	info := it.content.ref()
+	if origin != nil {
+		info |= 0x80
+	}
+	if it.rightOrigin != nil {
+		info |= 0x40
+	}
+	if it.hasSub {
+		info |= 0x20
+	}
+	e.writeByte(info)
+	if origin != nil {
+		e.writeID(*origin)
+	}
+	if it.rightOrigin != nil {
+		e.writeID(*it.rightOrigin)
+	}
+	if origin == nil && it.rightOrigin == nil {
+		if it.parent.item == nil {
+			e.writeVarUint(1)
+			e.writeVarString(it.parent.name)
+		} else {
+			e.writeVarUint(0)
+			e.writeID(it.parent.item.id)
+		}
+		if it.hasSub {
+			e.writeVarString(it.parentSub)
+		}
+	}
+	it.content.write(e, offset)
+}
+
+// EncodeStateVector encodes the state vector of the document, the payload
+// of a sync step 1 message.
+func (d *Doc) EncodeStateVector() []byte {
+	e := &encoder{}
+	writeStateVector(e, d.StateVector())
+	return e.bytes()
+}
+
+func writeStateVector(e *encoder, sv map[uint64]uint64) {
+	clients := sortedClients(sv)
+	e.writeVarUint(uint64(len(clients)))
+	for _, client := range clients {
+		e.writeVarUint(client)
+		e.writeVarUint(sv[client])
+	}
+}
+
+// DecodeStateVector decodes a state vector written by EncodeStateVector or
+// Y.encodeStateVector.
+func DecodeStateVector(data []byte) (map[uint64]uint64, error) {
+	dec := newDecoder(data)
+	n, err := dec.readVarUint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(data)) {
+		return nil, ErrUnexpectedEOF
+	}
+	sv := make(map[uint64]uint64, n)
+	for i := uint64(0); i < n; i++ {
+		client, err := dec.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		clock, err := dec.readVarUint()
+		if err != nil {
+			return nil, err
+		}
+		sv[client] = clock
+	}
+	return sv, nil
+}
+
+// EncodeStateAsUpdate encodes, as a v1 update, what the document holds
+// beyond the state vector sv and every deletion it knows of. A nil sv
+// encodes the whole document. Structs still waiting for their
+// dependencies are not included.
+func (d *Doc) EncodeStateAsUpdate(sv map[uint64]uint64) []byte {
+	e := &encoder{}
+	missing := make(map[uint64]uint64)
+	for client := range d.clients {
+		if d.state(client) > sv[client] {
+			missing[client] = sv[client]
+		}
+	}
+	clients := sortedClients(missing)
+	e.writeVarUint(uint64(len(clients)))
+	for _, client := range clients {
+		items := d.clients[client]
+		clock := missing[client]
+		if clock < items[0].id.Clock {
+			clock = items[0].id.Clock
+		}
+		start, _ := d.find(ID{Client: client, Clock: clock})
+		e.writeVarUint(uint64(len(items) - start))
+		e.writeVarUint(client)
+		e.writeVarUint(clock)
+		items[start].write(e, clock-items[start].id.Clock)
+		for _, it := range items[start+1:] {
+			it.write(e, 0)
+		}
+	}
+	d.writeDeleteSet(e)
+	return e.bytes()
+}
+
+// writeDeleteSet writes the deleted ranges of every client, adjacent
+// deleted structs merged into one range.
+func (d *Doc) writeDeleteSet(e *encoder) {
+	ranges := make(map[uint64][]deleteRange)
+	for client, items := range d.clients {
+		var list []deleteRange
+		for _, it := range items {
+			if !it.deleted {
+				continue
+			}
+			if last := len(list) - 1; last >= 0 && list[last].clock+list[last].n == it.id.Clock {
+				list[last].n += it.n
+				continue
+			}
+			list = append(list, deleteRange{client: client, clock: it.id.Clock, n: it.n})
+		}
+		if len(list) > 0 {
+			ranges[client] = list
+		}
+	}
+	clients := make([]uint64, 0, len(ranges))
+	for client := range ranges {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] > clients[j] })
+	e.writeVarUint(uint64(len(clients)))
+	for _, client := range clients {
+		e.writeVarUint(client)
+		e.writeVarUint(uint64(len(ranges[client])))
+		for _, r := range ranges[client] {
+			e.writeVarUint(r.clock)
+			e.writeVarUint(r.n)
+		}
+	}
+}
+
+// sortedClients returns the clients of a state vector, highest first, the
+// order Yjs writes them in.
+func sortedClients(sv map[uint64]uint64) []uint64 {
+	clients := make([]uint64, 0, len(sv))
+	for client := range sv {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] > clients[j] })
+	return clients
+}
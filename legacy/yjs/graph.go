@@ -0,0 +1,98 @@
+package yjs
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	reactFlowTypes "bitbucket.org/convin/go_services/rule_engine/internal/types"
+)
+
+// Names of the shared types the editor keeps the graph in (see
+// client/src/yjsSetup.js).
+const (
+	NodesMap    = "nodes"
+	EdgesMap    = "edges"
+	MetadataMap = "metadata"
+)
+
+// ExtractGraph reads the graph held by an editor document: the nodes and
+// edges maps, keyed by element ID, and the graph ID from the metadata map.
+// Elements come in the order their keys were first set. The client stores
+// negation as "isNot" while graph documents use "is_not"; it is renamed.
+// Sizes the client measured to fractions of a pixel are rounded, as graph
+// documents hold whole numbers. Fields the Go types do not model are kept
+// as extras.
+func ExtractGraph(doc *Doc) (reactFlowTypes.Graph, error) {
+	var graph reactFlowTypes.Graph
+	object := map[string]interface{}{
+		"nodes": elements(doc.Map(NodesMap)),
+		"edges": elements(doc.Map(EdgesMap)),
+	}
+	if id, ok := doc.Map(MetadataMap).Get("id"); ok {
+		object["id"] = toJSON(id)
+	}
+	data, err := json.Marshal(object)
+	if err != nil {
+		return graph, fmt.Errorf("encoding room graph: %w", err)
+	}
+	if err := json.Unmarshal(data, &graph); err != nil {
+		return graph, fmt.Errorf("decoding room graph: %w", err)
+	}
+	return graph, nil
+}
+
+func elements(m *Map) []interface{} {
+	list := make([]interface{}, 0)
+	for _, key := range m.Keys() {
+		value, _ := m.Get(key)
+		element, ok := toJSON(value).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := element["id"]; !ok {
+			element["id"] = key
+		}
+		renameIsNot(element)
+		if data, ok := element["data"].(map[string]interface{}); ok {
+			renameIsNot(data)
+		}
+		roundSizes(element)
+		list = append(list, element)
+	}
+	return list
+}
+
+// roundSizes rounds the fractional widths and heights of a node and of the
+// blocks and nodes nested in it. Styles are CSS and left alone.
+func roundSizes(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			switch key {
+			case "width", "height":
+				if size, ok := child.(float64); ok {
+					v[key] = math.Round(size)
+				}
+			case "style":
+			default:
+				roundSizes(child)
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			roundSizes(child)
+		}
+	}
+}
+
+func renameIsNot(object map[string]interface{}) {
+	value, ok := object["isNot"]
+	if !ok {
+		return
+	}
+	delete(object, "isNot")
+	if _, ok := object["is_not"]; !ok {
+		object["is_not"] = value
+	}
+}
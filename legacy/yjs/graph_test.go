@@ -0,0 +1,55 @@
+package yjs
+
+import "testing"
+
+func TestExtractGraph(t *testing.T) {
+	d := NewDoc()
+	d.ClientID = 1
+	nodes := d.Map(NodesMap)
+	if _, err := nodes.Set("c", map[string]interface{}{
+		"type":   "conditional-node",
+		"width":  240.4,
+		"height": 95.5,
+		"style":  map[string]interface{}{"width": 240.4},
+		"data": map[string]interface{}{"metadata": map[string]interface{}{"blocks": []interface{}{
+			map[string]interface{}{"id": "b", "data": map[string]interface{}{"type": "moment", "width": 199.6}},
+		}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nodes.Set("r", map[string]interface{}{"id": "r", "type": "response-node", "width": 120}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Map(EdgesMap).Set("e", map[string]interface{}{"source": "c", "target": "r"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Map(MetadataMap).Set("id", "multiple"); err != nil {
+		t.Fatal(err)
+	}
+
+	graph, err := ExtractGraph(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if graph.ID != "multiple" {
+		t.Errorf("graph ID %v, want multiple", graph.ID)
+	}
+	if len(graph.Nodes) != 2 || graph.Nodes[0].ID != "c" || graph.Nodes[1].ID != "r" {
+		t.Fatalf("nodes %+v, want c and r", graph.Nodes)
+	}
+	if len(graph.Edges) != 1 || graph.Edges[0].ID != "e" {
+		t.Errorf("edges %+v, want e", graph.Edges)
+	}
+	condition := graph.Nodes[0]
+	if condition.Width != 240 || condition.Height != 96 {
+		t.Errorf("conditional node is %dx%d, want 240x96", condition.Width, condition.Height)
+	}
+	block := condition.Data.Metadata.Blocks[0].NodeData
+	if block.Width != 200 {
+		t.Errorf("block width %d, want 200", block.Width)
+	}
+	// The style is CSS and kept as the client wrote it.
+	if style, _ := condition.Extras.Get("style"); string(style) != `{"width":240.4}` {
+		t.Errorf("style %s, want the width unrounded", style)
+	}
+}
@@ -0,0 +1,223 @@
+package yjs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Map is a view of a Y.Map.
+type Map struct {
+	t *Type
+}
+
+// Map returns the root map name, as ydoc.getMap(name) does in the client.
+func (d *Doc) Map(name string) *Map {
+	return &Map{t: d.root(name, TypeMap)}
+}
+
+// Keys returns the keys that hold a value, in the order they were first
+// set on this replica, which is the iteration order of the client's Y.Map.
+func (m *Map) Keys() []string {
+	keys := make([]string, 0, len(m.t.keys))
+	for _, key := range m.t.keys {
+		if it := m.t.entries[key]; it != nil && !it.deleted {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Get returns the value of key. Nested types are returned as *Map, *Array
+// or *Text.
+func (m *Map) Get(key string) (interface{}, bool) {
+	it := m.t.entries[key]
+	if it == nil || it.deleted {
+		return nil, false
+	}
+	values := it.content.values()
+	if len(values) == 0 {
+		return nil, false
+	}
+	value := values[len(values)-1]
+	if _, ok := value.(undefinedValue); ok {
+		return nil, false
+	}
+	return view(value), true
+}
+
+// Set sets key to value as the document's own client, the way
+// Y.Map.set does with a plain value, and returns the update that carries
+// the change to other replicas. value is stored as encoding/json would
+// encode it.
+func (m *Map) Set(key string, value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("yjs: encoding value of %q: %w", key, err)
+	}
+	var plain interface{}
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return nil, fmt.Errorf("yjs: encoding value of %q: %w", key, err)
+	}
+	raw := &encoder{}
+	if err := raw.writeAny(plain, 0); err != nil {
+		return nil, err
+	}
+
+	d := m.t.doc
+	it := &item{
+		id:        ID{Client: d.ClientID, Clock: d.state(d.ClientID)},
+		n:         1,
+		hasParent: true,
+		parentSub: key,
+		hasSub:    true,
+		content:   &contentAny{items: []interface{}{plain}, raw: [][]byte{raw.bytes()}},
+	}
+	if m.t.item == nil {
+		it.parentName = m.t.name
+	} else {
+		id := m.t.item.id
+		it.parentID = &id
+	}
+	left := m.t.entries[key]
+	if left != nil {
+		origin := left.lastID()
+		it.origin = &origin
+	}
+	replaced := left != nil && !left.deleted
+	d.integrate(it, 0)
+
+	e := &encoder{}
+	e.writeVarUint(1)
+	e.writeVarUint(1)
+	e.writeVarUint(it.id.Client)
+	e.writeVarUint(it.id.Clock)
+	it.write(e, 0)
+	if replaced {
+		e.writeVarUint(1)
+		e.writeVarUint(left.id.Client)
+		e.writeVarUint(1)
+		e.writeVarUint(left.id.Clock)
+		e.writeVarUint(left.n)
+	} else {
+		e.writeVarUint(0)
+	}
+	return e.bytes(), nil
+}
+
+// ToJSON returns the map with nested types converted, the way
+// Y.Map.toJSON does.
+func (m *Map) ToJSON() map[string]interface{} {
+	object := make(map[string]interface{})
+	for _, key := range m.Keys() {
+		if value, ok := m.Get(key); ok {
+			object[key] = toJSON(value)
+		}
+	}
+	return object
+}
+
+// Array is a read view of a Y.Array.
+type Array struct {
+	t *Type
+}
+
+// Array returns the root array name.
+func (d *Doc) Array(name string) *Array {
+	return &Array{t: d.root(name, TypeArray)}
+}
+
+// Values returns the elements of the array. Nested types are returned as
+// *Map, *Array or *Text.
+func (a *Array) Values() []interface{} {
+	var values []interface{}
+	for it := a.t.start; it != nil; it = it.right {
+		if it.deleted {
+			continue
+		}
+		for _, value := range it.content.values() {
+			if _, ok := value.(undefinedValue); ok {
+				value = nil
+			}
+			values = append(values, view(value))
+		}
+	}
+	return values
+}
+
+// Len returns the number of elements.
+func (a *Array) Len() int {
+	return len(a.Values())
+}
+
+func (a *Array) ToJSON() []interface{} {
+	values := a.Values()
+	list := make([]interface{}, len(values))
+	for i, value := range values {
+		list[i] = toJSON(value)
+	}
+	return list
+}
+
+// Text is a read view of a Y.Text. Formatting is ignored.
+type Text struct {
+	t *Type
+}
+
+// Text returns the root text name.
+func (d *Doc) Text(name string) *Text {
+	return &Text{t: d.root(name, TypeText)}
+}
+
+func (t *Text) String() string {
+	var b strings.Builder
+	for it := t.t.start; it != nil; it = it.right {
+		if s, ok := it.content.(*contentString); ok && !it.deleted {
+			b.WriteString(s.String())
+		}
+	}
+	return b.String()
+}
+
+// view wraps nested types for reading.
+func view(value interface{}) interface{} {
+	t, ok := value.(*Type)
+	if !ok {
+		return value
+	}
+	switch t.kind {
+	case TypeMap:
+		return &Map{t: t}
+	case TypeText, TypeXMLText:
+		return &Text{t: t}
+	}
+	return &Array{t: t}
+}
+
+func toJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *Map:
+		return v.ToJSON()
+	case *Array:
+		return v.ToJSON()
+	case *Text:
+		return v.String()
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			if _, ok := child.(undefinedValue); !ok {
+				object[key] = toJSON(child)
+			}
+		}
+		return object
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, child := range v {
+			list[i] = toJSON(child)
+		}
+		return list
+	case undefinedValue:
+		return nil
+	}
+	return value
+}